@@ -5,7 +5,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
 	goruntime "runtime"
 	"sort"
@@ -17,7 +19,10 @@ import (
 	"github.com/grafana/agent/pkg/client/grafanacloud"
 	"github.com/grafana/agent/pkg/config"
 	"github.com/olekukonko/tablewriter"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/version"
+	"github.com/prometheus/prometheus/storage/remote"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
@@ -63,6 +68,8 @@ func main() {
 		walStatsCmd(),
 		targetStatsCmd(),
 		samplesCmd(),
+		walExportCmd(),
+		walReplayCmd(),
 		operatorDetachCmd(),
 		cloudConfigCmd(),
 		templateDryRunCmd(),
@@ -328,6 +335,238 @@ deletion but then comes back at some point).`,
 	}
 }
 
+func walExportCmd() *cobra.Command {
+	var (
+		selector string
+		from, to string
+		format   string
+		output   string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "wal-export [WAL directory]",
+		Short: "Export series and samples from the WAL",
+		Long: `wal-export reads a WAL directory and writes the samples of series matching a
+label selector within a time range. Samples can be written in the OpenMetrics
+text format or as JSON, with one JSON object per series on each line.
+
+The OpenMetrics output can be backfilled into Prometheus with
+"promtool tsdb create-blocks-from openmetrics". Staleness markers are not
+exported.
+
+Examples:
+
+Export all samples in the WAL as OpenMetrics:
+
+$ agentctl wal-export /tmp/wal > wal.om
+
+
+Export samples of the 'up' series from a time range as JSON:
+
+$ agentctl wal-export -s up --from 2022-06-01T00:00:00Z --to 2022-06-01T06:00:00Z -f json /tmp/wal
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			directory := args[0]
+			if _, err := os.Stat(directory); os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "%s does not exist\n", directory)
+				os.Exit(1)
+			} else if err != nil {
+				fmt.Fprintf(os.Stderr, "error getting wal: %v\n", err)
+				os.Exit(1)
+			}
+
+			// Check if ./wal is a subdirectory, use that instead.
+			if _, err := os.Stat(filepath.Join(directory, "wal")); err == nil {
+				directory = filepath.Join(directory, "wal")
+			}
+
+			fromTime, toTime, err := parseTimeRange(from, to)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			out := os.Stdout
+			if output != "" {
+				out, err = os.Create(output)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "failed to create output file: %v\n", err)
+					os.Exit(1)
+				}
+			}
+
+			err = agentctl.ExportWAL(out, directory, agentctl.ExportOptions{
+				Selector: selector,
+				From:     fromTime,
+				To:       toTime,
+				Format:   agentctl.ExportFormat(format),
+			})
+			if closeErr := out.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to export WAL: %v\n", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&selector, "selector", "s", "{}", "label selector of series to export")
+	cmd.Flags().StringVar(&from, "from", "", "RFC3339 timestamp of the oldest sample to export")
+	cmd.Flags().StringVar(&to, "to", "", "RFC3339 timestamp of the newest sample to export")
+	cmd.Flags().StringVarP(&format, "format", "f", string(agentctl.ExportFormatOpenMetrics), "export format, one of openmetrics or json")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "file to write the export to. Defaults to stdout")
+	return cmd
+}
+
+func walReplayCmd() *cobra.Command {
+	var (
+		remoteURL         string
+		timeout           time.Duration
+		headers           map[string]string
+		bearerTokenFile   string
+		basicAuthUsername string
+		basicAuthPassFile string
+
+		selector string
+		from, to string
+		opts     = agentctl.DefaultReplayOptions
+	)
+
+	cmd := &cobra.Command{
+		Use:   "wal-replay [WAL directory]",
+		Short: "Send samples from the WAL to a remote_write endpoint",
+		Long: `wal-replay reads a WAL directory and sends the samples of series matching a
+label selector within a time range to a remote_write endpoint. This can be used
+to recover samples which are still on disk after remote_write gave up on
+sending them, for example after an outage longer than the retry window.
+
+Requests which fail with a recoverable error are retried. The number of
+samples sent per second can be limited with --rate-limit.
+
+When --progress-file is set, the progress of the replay is written to that
+file after every request. Running wal-replay again with the same progress file
+resumes from where the previous run stopped. Samples which were part of a
+partially sent WAL record are sent again.
+
+The Agent should not be running against the WAL directory while it is being
+replayed, as the WAL may be truncated in the meantime.
+
+Examples:
+
+Replay a time range of the WAL, 10000 samples per second at most:
+
+$ agentctl wal-replay --url https://prometheus/api/v1/write --from 2022-06-01T00:00:00Z --to 2022-06-01T06:00:00Z --rate-limit 10000 --progress-file replay.json /tmp/wal
+`,
+		Args: cobra.ExactArgs(1),
+		Run: func(_ *cobra.Command, args []string) {
+			logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+
+			directory := args[0]
+			if _, err := os.Stat(directory); os.IsNotExist(err) {
+				level.Error(logger).Log("msg", "WAL directory does not exist", "dir", directory)
+				os.Exit(1)
+			} else if err != nil {
+				level.Error(logger).Log("msg", "error getting wal", "err", err)
+				os.Exit(1)
+			}
+
+			// Check if ./wal is a subdirectory, use that instead.
+			if _, err := os.Stat(filepath.Join(directory, "wal")); err == nil {
+				directory = filepath.Join(directory, "wal")
+			}
+
+			var err error
+			opts.Selector = selector
+			opts.From, opts.To, err = parseTimeRange(from, to)
+			if err != nil {
+				level.Error(logger).Log("msg", "invalid time range", "err", err)
+				os.Exit(1)
+			}
+
+			u, err := url.Parse(remoteURL)
+			if err != nil {
+				level.Error(logger).Log("msg", "invalid remote_write URL", "err", err)
+				os.Exit(1)
+			}
+
+			httpConfig := config_util.HTTPClientConfig{
+				BearerTokenFile: bearerTokenFile,
+				FollowRedirects: true,
+				EnableHTTP2:     true,
+			}
+			if basicAuthUsername != "" {
+				httpConfig.BasicAuth = &config_util.BasicAuth{
+					Username:     basicAuthUsername,
+					PasswordFile: basicAuthPassFile,
+				}
+			}
+
+			client, err := remote.NewWriteClient("agentctl", &remote.ClientConfig{
+				URL:              &config_util.URL{URL: u},
+				Timeout:          model.Duration(timeout),
+				HTTPClientConfig: httpConfig,
+				Headers:          headers,
+				RetryOnRateLimit: true,
+			})
+			if err != nil {
+				level.Error(logger).Log("msg", "failed to create remote_write client", "err", err)
+				os.Exit(1)
+			}
+
+			// Stop sending on interrupt; the progress made so far is kept.
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+			defer cancel()
+
+			progress, err := agentctl.ReplayWAL(ctx, logger, directory, client, opts)
+			if err != nil {
+				level.Error(logger).Log("msg", "failed to replay WAL", "segment", progress.Segment, "record", progress.Record, "samples", progress.Samples, "err", err)
+				os.Exit(1)
+			}
+			level.Info(logger).Log("msg", "finished replaying WAL", "samples", progress.Samples)
+		},
+	}
+
+	cmd.Flags().StringVar(&remoteURL, "url", "", "remote_write URL to send samples to")
+	cmd.Flags().DurationVar(&timeout, "timeout", 30*time.Second, "timeout of requests to the remote_write endpoint")
+	cmd.Flags().StringToStringVar(&headers, "header", nil, "extra HTTP header to send, as name=value. May be repeated")
+	cmd.Flags().StringVar(&bearerTokenFile, "bearer-token-file", "", "file holding the bearer token to authenticate with")
+	cmd.Flags().StringVar(&basicAuthUsername, "basic-auth-username", "", "username to authenticate with using basic auth")
+	cmd.Flags().StringVar(&basicAuthPassFile, "basic-auth-password-file", "", "file holding the password to authenticate with using basic auth")
+
+	cmd.Flags().StringVarP(&selector, "selector", "s", opts.Selector, "label selector of series to send")
+	cmd.Flags().StringVar(&from, "from", "", "RFC3339 timestamp of the oldest sample to send")
+	cmd.Flags().StringVar(&to, "to", "", "RFC3339 timestamp of the newest sample to send")
+	cmd.Flags().IntVar(&opts.BatchSize, "batch-size", opts.BatchSize, "maximum number of samples to send per request")
+	cmd.Flags().Float64Var(&opts.RateLimit, "rate-limit", 0, "maximum number of samples to send per second. 0 disables rate limiting")
+	cmd.Flags().StringVar(&opts.ProgressFile, "progress-file", "", "file to store progress in, used to resume an interrupted replay")
+	cmd.Flags().IntVar(&opts.Backoff.MaxRetries, "max-retries", opts.Backoff.MaxRetries, "number of times to retry a request which failed with a recoverable error")
+	must(cmd.MarkFlagRequired("url"))
+	return cmd
+}
+
+// parseTimeRange parses the RFC3339 timestamps from and to. Empty strings are
+// returned as zero times.
+func parseTimeRange(from, to string) (fromTime, toTime time.Time, err error) {
+	if from != "" {
+		fromTime, err = time.Parse(time.RFC3339, from)
+		if err != nil {
+			return fromTime, toTime, fmt.Errorf("invalid --from: %w", err)
+		}
+	}
+	if to != "" {
+		toTime, err = time.Parse(time.RFC3339, to)
+		if err != nil {
+			return fromTime, toTime, fmt.Errorf("invalid --to: %w", err)
+		}
+	}
+	if !fromTime.IsZero() && !toTime.IsZero() && toTime.Before(fromTime) {
+		return fromTime, toTime, fmt.Errorf("--to must not be before --from")
+	}
+	return fromTime, toTime, nil
+}
+
 func operatorDetachCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "operator-detach",
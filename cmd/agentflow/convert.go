@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/grafana/agent/pkg/config"
+	"github.com/grafana/agent/pkg/flow/convert"
+	"github.com/hashicorp/hcl/v2"
+)
+
+// runConvert implements the convert command, which converts a static mode
+// config file into a Flow file.
+func runConvert(args []string) error {
+	var (
+		outputFile    string
+		expandEnvVars bool
+	)
+
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s convert [flags] <static mode config file>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&outputFile, "o", outputFile, "file to write the Flow config to. Defaults to stdout")
+	fs.BoolVar(&expandEnvVars, "config.expand-env", expandEnvVars, "expand environment variables in the static mode config file")
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("error parsing flags: %w", err)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected exactly one config file")
+	}
+
+	var c config.Config
+	if err := config.LoadFile(fs.Arg(0), expandEnvVars, &c); err != nil {
+		return fmt.Errorf("reading config file %q: %w", fs.Arg(0), err)
+	}
+
+	f, diags := convert.Metrics(&c.Metrics)
+	if !c.Integrations.IsZero() {
+		diags = append(diags, unsupportedSubsystem("integrations", "Integrations can be run with integrations.<name> components."))
+	}
+	if len(c.Traces.Configs) > 0 {
+		diags = append(diags, unsupportedSubsystem("traces", "Traces pipelines can be built with otelcol components."))
+	}
+	if c.Logs != nil && len(c.Logs.Configs) > 0 {
+		diags = append(diags, unsupportedSubsystem("logs", "Logs pipelines can be built with logs components."))
+	}
+
+	var out io.Writer = os.Stdout
+	if outputFile != "" {
+		of, err := os.Create(outputFile)
+		if err != nil {
+			return err
+		}
+		defer of.Close()
+		out = of
+	}
+	if _, err := f.WriteTo(out); err != nil {
+		return fmt.Errorf("writing Flow config: %w", err)
+	}
+
+	if len(diags) > 0 {
+		dw := hcl.NewDiagnosticTextWriter(os.Stderr, nil, 80, false)
+		_ = dw.WriteDiagnostics(diags)
+	}
+	if diags.HasErrors() {
+		return fmt.Errorf("config was converted with errors; review the reported diagnostics")
+	}
+	return nil
+}
+
+func unsupportedSubsystem(name, detail string) *hcl.Diagnostic {
+	return &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  fmt.Sprintf("converting the %s subsystem is not supported", name),
+		Detail:   detail,
+	}
+}
@@ -9,13 +9,23 @@ import (
 	_ "net/http/pprof" // anonymous import to get the pprof handler registered
 	"os"
 	"os/signal"
+	"reflect"
+	"strconv"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/gorilla/mux"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/grafana/agent/pkg/cluster"
 	"github.com/grafana/agent/pkg/flow"
 	"github.com/grafana/agent/pkg/flow/logging"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rfratto/ckit/clientpool"
+	"github.com/rfratto/ckit/peer"
+	"google.golang.org/grpc"
 
 	// Install components
 	_ "github.com/grafana/agent/component/all"
@@ -29,6 +39,10 @@ func main() {
 }
 
 func run() error {
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		return runConvert(os.Args[2:])
+	}
+
 	var wg sync.WaitGroup
 	defer wg.Wait()
 
@@ -59,70 +73,194 @@ func run() error {
 	if err != nil {
 		return fmt.Errorf("building logger: %w", err)
 	}
+	defer func() { _ = l.Close() }()
+
+	// The config file is read once before creating the Flow controller so
+	// clustering can be set up. Clustering options can't change after startup.
+	initialCfg, err := loadFlowFile(configFile)
+	if err != nil {
+		return fmt.Errorf("reading config file %q: %w", configFile, err)
+	}
+
+	// The listen address from the server block may differ from the flag.
+	// Clustering always uses the address from startup.
+	listenAddr := httpListenAddr
+	if addr := initialCfg.Server.HTTPListenAddress; addr != "" {
+		listenAddr = addr
+	}
+
+	// gRPC traffic for clustering is served on the same port as HTTP.
+	grpcSrv := grpc.NewServer()
+
+	r := mux.NewRouter()
+	srv := newHTTPServer(l, httpListenAddr, grpcSrv, r)
+	defer func() { _ = srv.Shutdown(context.Background()) }()
+
+	var (
+		clusterer  cluster.Node = cluster.NewLocalNode(listenAddr)
+		gossipNode *cluster.GossipNode
+	)
+	if initialCfg.Clustering != nil {
+		// Peers authenticate against each other with the server's own
+		// authentication settings.
+		pool, err := clientpool.New(clientpool.DefaultOptions, grpc.WithInsecure(), grpc.WithPerRPCCredentials(srv.PeerCredentials()))
+		if err != nil {
+			return fmt.Errorf("building gossip client pool: %w", err)
+		}
+		defer func() { _ = pool.Close() }()
+
+		gossipNode, err = newGossipNode(l, grpcSrv, pool, initialCfg.Clustering, listenAddr)
+		if err != nil {
+			return fmt.Errorf("building gossip node: %w", err)
+		}
+		clusterer = gossipNode
+	}
+
+	// Allow metrics received by components to be tapped for debugging.
+	taps := metrics.NewTapRegistry()
 
 	f := flow.New(flow.Options{
-		Logger:   l,
-		DataPath: storagePath,
+		Logger:       l,
+		DataPath:     storagePath,
+		Clusterer:    clusterer,
+		ExportsHooks: []flow.ExportsHook{taps},
 	})
 
-	reload := func() error {
-		flowCfg, err := loadFlowFile(configFile)
+	var loadMut sync.Mutex
+
+	load := func(flowCfg *flow.File) error {
+		loadMut.Lock()
+		defer loadMut.Unlock()
+
+		if !reflect.DeepEqual(flowCfg.Clustering, initialCfg.Clustering) {
+			level.Warn(l).Log("msg", "changes to the clustering block require a restart to take effect")
+		}
+		if initialCfg.Clustering != nil {
+			// Peers connect to each other over plaintext HTTP/2.
+			if flowCfg.Server.TLS != nil {
+				return fmt.Errorf("the tls block in server can't be used with clustering")
+			}
+			if srv.ListenAddr(flowCfg.Server) != listenAddr {
+				level.Warn(l).Log("msg", "changing the listen address while clustering requires a restart for peers to use the new address")
+			}
+		}
+
+		// The server block is only applied once the components loaded, so a
+		// failed load doesn't leave the server half-updated.
+		pending, err := srv.PrepareOptions(flowCfg.Server)
 		if err != nil {
-			return fmt.Errorf("reading config file %q: %w", configFile, err)
+			return fmt.Errorf("applying server block: %w", err)
 		}
 		if err := f.LoadFile(flowCfg); err != nil {
+			pending.Abort()
 			return fmt.Errorf("error during the initial gragent load: %w", err)
 		}
+		pending.Apply()
 		return nil
 	}
 
-	if err := reload(); err != nil {
-		// Exit if the initial load files
-		return err
+	reload := func() error {
+		flowCfg, err := loadFlowFile(configFile)
+		if err != nil {
+			return fmt.Errorf("reading config file %q: %w", configFile, err)
+		}
+		return load(flowCfg)
 	}
 
-	// HTTP server
-	{
-		lis, err := net.Listen("tcp", httpListenAddr)
+	r.Handle("/-/config", f.ConfigHandler())
+	r.Handle("/metrics", promhttp.Handler())
+	r.Handle("/debug/graph", f.GraphHandler())
+	r.Handle("/debug/tap", srv.DisableInReadOnly(taps.Handler(l)))
+	r.Handle("/debug/logs", f.LogsHandler())
+	r.PathPrefix("/debug/pprof").Handler(srv.DisableInReadOnly(http.DefaultServeMux))
+
+	r.HandleFunc("/-/reload", func(w http.ResponseWriter, _ *http.Request) {
+		if srv.ReadOnly() {
+			http.Error(w, "reloading over HTTP is disabled in read-only mode", http.StatusForbidden)
+			return
+		}
+		err := reload()
 		if err != nil {
-			return fmt.Errorf("failed to listen on %s: %w", httpListenAddr, err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
+		fmt.Fprintln(w, "config reloaded")
+	})
 
-		r := mux.NewRouter()
-		r.Handle("/-/config", f.ConfigHandler())
-		r.Handle("/metrics", promhttp.Handler())
-		r.Handle("/debug/graph", f.GraphHandler())
-		r.PathPrefix("/debug/pprof").Handler(http.DefaultServeMux)
+	if err := load(initialCfg); err != nil {
+		// Exit if the initial load files
+		return err
+	}
+
+	// Reload on SIGHUP. SIGHUP works in read-only mode, since it requires
+	// access to the host.
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
 
-		r.HandleFunc("/-/reload", func(w http.ResponseWriter, _ *http.Request) {
-			err := reload()
-			if err != nil {
-				http.Error(w, err.Error(), http.StatusBadRequest)
+		for {
+			select {
+			case <-ctx.Done():
 				return
+			case <-hup:
+				if err := reload(); err != nil {
+					level.Error(l).Log("msg", "failed to reload config", "err", err)
+				} else {
+					level.Info(l).Log("msg", "config reloaded")
+				}
 			}
-			fmt.Fprintln(w, "config reloaded")
-		})
-
-		srv := &http.Server{Handler: r}
+		}
+	}()
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+	if gossipNode != nil {
+		if err := gossipNode.Start(); err != nil {
+			return fmt.Errorf("failed to start gossip node: %w", err)
+		}
+		if err := gossipNode.ChangeState(ctx, peer.StateParticipant); err != nil {
+			return fmt.Errorf("failed to join cluster as participant: %w", err)
+		}
+		defer func() {
+			// Give other nodes a chance to pick up our work before leaving.
+			stateCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
-
-			level.Info(l).Log("msg", "now listening for http traffic", "addr", httpListenAddr)
-			if err := srv.Serve(lis); err != nil {
-				level.Info(l).Log("msg", "http server closed", "err", err)
+			if err := gossipNode.ChangeState(stateCtx, peer.StateTerminating); err != nil {
+				level.Warn(l).Log("msg", "failed to mark node as terminating", "err", err)
+			}
+			if err := gossipNode.Stop(); err != nil {
+				level.Warn(l).Log("msg", "failed to stop gossip node", "err", err)
 			}
 		}()
-
-		defer func() { _ = srv.Shutdown(ctx) }()
 	}
 
 	<-ctx.Done()
 	return f.Close()
 }
 
+// newGossipNode creates an unstarted gossip node from opts which connects to
+// peers using pool. The port of listenAddr is used as the default port for
+// advertised and peer addresses.
+func newGossipNode(l log.Logger, srv *grpc.Server, pool *clientpool.Pool, opts *flow.ClusteringOptions, listenAddr string) (*cluster.GossipNode, error) {
+	_, portStr, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen address %q: %w", listenAddr, err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid listen port %q: %w", portStr, err)
+	}
+
+	gc := opts.GossipConfig()
+	gc.Pool = pool
+	if err := gc.ApplyDefaults(port); err != nil {
+		return nil, err
+	}
+	return cluster.NewGossipNode(l, srv, gc)
+}
+
 func loadFlowFile(filename string) (*flow.File, error) {
 	bb, err := os.ReadFile(filename)
 	if err != nil {
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/pkg/flow"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// httpServer serves HTTP traffic for agentflow. Settings from the server
+// block are applied at runtime through PrepareOptions.
+type httpServer struct {
+	log         log.Logger
+	defaultAddr string
+	srv         *http.Server
+
+	mut       sync.RWMutex
+	lis       net.Listener
+	addr      string
+	readOnly  bool
+	tlsConfig *tls.Config // nil when TLS is disabled.
+	auth      *authSettings
+}
+
+type authSettings struct {
+	username, password string
+	bearerToken        string
+}
+
+// newHTTPServer creates a new httpServer which serves api. gRPC traffic for
+// clustering is served by grpcSrv on the same port and is subject to the
+// same authentication; peers authenticate using PeerCredentials. defaultAddr
+// is the address to listen on when the server block doesn't set one.
+func newHTTPServer(l log.Logger, defaultAddr string, grpcSrv *grpc.Server, api http.Handler) *httpServer {
+	s := &httpServer{log: l, defaultAddr: defaultAddr}
+	s.srv = &http.Server{
+		Handler: h2c.NewHandler(s.authHandler(grpcHandler(grpcSrv, api)), &http2.Server{}),
+	}
+	return s
+}
+
+// ListenAddr returns the address the server listens on when opts is
+// applied.
+func (s *httpServer) ListenAddr(opts flow.ServerOptions) string {
+	if opts.HTTPListenAddress != "" {
+		return opts.HTTPListenAddress
+	}
+	return s.defaultAddr
+}
+
+// PendingOptions are server options which were validated by PrepareOptions
+// but not applied yet. Exactly one of Apply or Abort must be called.
+type PendingOptions struct {
+	s         *httpServer
+	addr      string
+	lis       net.Listener // nil when the current listener is kept.
+	readOnly  bool
+	tlsConfig *tls.Config
+	auth      *authSettings
+}
+
+// PrepareOptions validates opts and binds the listen address when it
+// changed, without affecting the running server. This allows applying the
+// server block only once the rest of the config file loaded successfully.
+func (s *httpServer) PrepareOptions(opts flow.ServerOptions) (*PendingOptions, error) {
+	var tlsConfig *tls.Config
+	if opts.TLS != nil {
+		c, err := opts.TLS.ServerConfig()
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls block: %w", err)
+		}
+		tlsConfig, err = c.ToTLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("invalid tls block: %w", err)
+		}
+		// Advertise HTTP/2 through ALPN, like http.Server.ServeTLS does.
+		tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	}
+
+	auth, err := newAuthSettings(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &PendingOptions{
+		s:         s,
+		addr:      s.ListenAddr(opts),
+		readOnly:  opts.ReadOnly,
+		tlsConfig: tlsConfig,
+		auth:      auth,
+	}
+
+	s.mut.RLock()
+	bound := s.lis != nil && p.addr == s.addr
+	s.mut.RUnlock()
+
+	if !bound {
+		p.lis, err = net.Listen("tcp", p.addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", p.addr, err)
+		}
+	}
+	return p, nil
+}
+
+// Apply applies the options to the server. The server starts listening on
+// the first call to Apply, and moves to the new listener whenever the listen
+// address changed.
+func (p *PendingOptions) Apply() {
+	s := p.s
+
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if p.lis != nil {
+		if s.lis != nil {
+			level.Info(s.log).Log("msg", "moving http server to new address", "old", s.addr, "new", p.addr)
+			// Connections accepted by the old listener are kept alive.
+			_ = s.lis.Close()
+		}
+		s.lis, s.addr = p.lis, p.addr
+
+		go s.serve(&serverListener{Listener: p.lis, s: s})
+	}
+
+	s.readOnly = p.readOnly
+	s.tlsConfig = p.tlsConfig
+	s.auth = p.auth
+}
+
+// Abort discards the options, closing the listener bound by PrepareOptions.
+func (p *PendingOptions) Abort() {
+	if p.lis != nil {
+		_ = p.lis.Close()
+	}
+}
+
+func (s *httpServer) serve(lis net.Listener) {
+	level.Info(s.log).Log("msg", "now listening for http traffic", "addr", lis.Addr())
+	err := s.srv.Serve(lis)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+		level.Error(s.log).Log("msg", "http server stopped", "addr", lis.Addr(), "err", err)
+	}
+}
+
+// ReadOnly returns true if endpoints which change the state of Flow are
+// disabled.
+func (s *httpServer) ReadOnly() bool {
+	s.mut.RLock()
+	defer s.mut.RUnlock()
+	return s.readOnly
+}
+
+// DisableInReadOnly wraps next so it responds with 403 Forbidden while the
+// server is in read-only mode.
+func (s *httpServer) DisableInReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.ReadOnly() {
+			http.Error(w, "endpoint is disabled in read-only mode", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Shutdown gracefully stops the server.
+func (s *httpServer) Shutdown(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+// grpcHandler routes gRPC requests to srv and all other requests to next.
+func grpcHandler(srv *grpc.Server, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+			srv.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authHandler rejects requests which don't pass the configured
+// authentication.
+func (s *httpServer) authHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mut.RLock()
+		auth := s.auth
+		s.mut.RUnlock()
+
+		if auth != nil && !auth.Allowed(r) {
+			if auth.username != "" {
+				w.Header().Set("WWW-Authenticate", `Basic realm="agentflow"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// newAuthSettings builds authSettings from opts. Secrets are read from files
+// every time the options are applied. nil is returned when authentication
+// is disabled.
+func newAuthSettings(opts flow.ServerOptions) (*authSettings, error) {
+	var auth authSettings
+
+	if ba := opts.BasicAuth; ba != nil {
+		auth.username = ba.Username
+		auth.password = string(ba.Password)
+
+		if ba.PasswordFile != "" {
+			bb, err := os.ReadFile(ba.PasswordFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading basic_auth password_file: %w", err)
+			}
+			auth.password = strings.TrimSpace(string(bb))
+		}
+	}
+
+	auth.bearerToken = string(opts.BearerToken)
+	if opts.BearerTokenFile != "" {
+		bb, err := os.ReadFile(opts.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading bearer_token_file: %w", err)
+		}
+		auth.bearerToken = strings.TrimSpace(string(bb))
+	}
+
+	if auth == (authSettings{}) {
+		return nil, nil
+	}
+	return &auth, nil
+}
+
+// Allowed returns true if r passes basic or bearer authentication.
+func (a *authSettings) Allowed(r *http.Request) bool {
+	if a.username != "" {
+		if user, pass, ok := r.BasicAuth(); ok &&
+			secureEqual(user, a.username) && secureEqual(pass, a.password) {
+			return true
+		}
+	}
+	if a.bearerToken != "" {
+		const prefix = "Bearer "
+		if h := r.Header.Get("Authorization"); strings.HasPrefix(h, prefix) &&
+			secureEqual(strings.TrimPrefix(h, prefix), a.bearerToken) {
+			return true
+		}
+	}
+	return false
+}
+
+// PeerCredentials returns gRPC credentials which authenticate requests to
+// other cluster peers using the current authentication settings. All peers
+// of a cluster are expected to share the same settings.
+func (s *httpServer) PeerCredentials() credentials.PerRPCCredentials {
+	return peerCredentials{s: s}
+}
+
+type peerCredentials struct{ s *httpServer }
+
+func (pc peerCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	pc.s.mut.RLock()
+	auth := pc.s.auth
+	pc.s.mut.RUnlock()
+
+	switch {
+	case auth == nil:
+		return nil, nil
+	case auth.bearerToken != "":
+		return map[string]string{"authorization": "Bearer " + auth.bearerToken}, nil
+	default:
+		creds := base64.StdEncoding.EncodeToString([]byte(auth.username + ":" + auth.password))
+		return map[string]string{"authorization": "Basic " + creds}, nil
+	}
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials. Peers
+// connect over plaintext HTTP/2, so credentials are sent without TLS.
+func (pc peerCredentials) RequireTransportSecurity() bool { return false }
+
+func secureEqual(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// serverListener wraps accepted connections with TLS when the server has
+// TLS enabled. TLS can be enabled or disabled at runtime without moving to a
+// new listener. http.Server handles *tls.Conn connections like the ones
+// accepted by ServeTLS: it negotiates HTTP/2 and sets Request.TLS.
+type serverListener struct {
+	net.Listener
+	s *httpServer
+}
+
+func (l *serverListener) Accept() (net.Conn, error) {
+	nc, err := l.Listener.Accept()
+	if err != nil {
+		return nc, err
+	}
+
+	l.s.mut.RLock()
+	defer l.s.mut.RUnlock()
+	if l.s.tlsConfig != nil {
+		return tls.Server(nc, l.s.tlsConfig), nil
+	}
+	return nc, nil
+}
@@ -2,7 +2,22 @@
 package all
 
 import (
-	_ "github.com/grafana/agent/component/local/file"          // Import local.file
-	_ "github.com/grafana/agent/component/metrics/remotewrite" // Import metrics.remotewrite
-	_ "github.com/grafana/agent/component/targets/mutate"      // Import targets.mutate
+	_ "github.com/grafana/agent/component/integrations"                       // Import integrations.*
+	_ "github.com/grafana/agent/component/local/exec"                         // Import local.exec
+	_ "github.com/grafana/agent/component/local/file"                         // Import local.file
+	_ "github.com/grafana/agent/component/logs/process"                       // Import logs.process
+	_ "github.com/grafana/agent/component/logs/source/file"                   // Import logs.source.file
+	_ "github.com/grafana/agent/component/logs/write/loki"                    // Import logs.write.loki
+	_ "github.com/grafana/agent/component/metrics/aggregate"                  // Import metrics.aggregate
+	_ "github.com/grafana/agent/component/metrics/exporter"                   // Import metrics.exporter
+	_ "github.com/grafana/agent/component/metrics/receiveremotewrite"         // Import metrics.receive_remote_write
+	_ "github.com/grafana/agent/component/metrics/remotewrite"                // Import metrics.remotewrite
+	_ "github.com/grafana/agent/component/metrics/scraper"                    // Import metrics.scrape
+	_ "github.com/grafana/agent/component/otelcol/exporter/otlp"              // Import otelcol.exporter.otlp
+	_ "github.com/grafana/agent/component/otelcol/processor/automaticlogging" // Import otelcol.processor.automatic_logging
+	_ "github.com/grafana/agent/component/otelcol/processor/batch"            // Import otelcol.processor.batch
+	_ "github.com/grafana/agent/component/otelcol/processor/promsd"           // Import otelcol.processor.prom_sd
+	_ "github.com/grafana/agent/component/otelcol/processor/servicegraph"     // Import otelcol.processor.service_graph
+	_ "github.com/grafana/agent/component/otelcol/receiver/otlp"              // Import otelcol.receiver.otlp
+	_ "github.com/grafana/agent/component/targets/mutate"                     // Import targets.mutate
 )
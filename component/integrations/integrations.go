@@ -0,0 +1,430 @@
+// Package integrations exposes integrations from pkg/integrations/v2 as Flow
+// components. Each registered integration is available as an
+// integrations.<name> component, where <name> is the name the integration is
+// registered with, such as integrations.node_exporter or integrations.redis.
+//
+// Integrations run outside of the static mode subsystems: Globals.Metrics,
+// Globals.Logs, and Globals.Tracing are always nil, and autoscraping is
+// disabled. Scraping is done by passing the exported targets to a
+// metrics.scrape component instead. Integrations which require a subsystem
+// will fail to be created.
+package integrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/targets/mutate"
+	"github.com/grafana/agent/pkg/flow/hcltypes"
+	v2 "github.com/grafana/agent/pkg/integrations/v2"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/prometheus/common/model"
+	"github.com/rfratto/gohcl"
+
+	_ "github.com/grafana/agent/pkg/integrations/install" // Register all integrations
+)
+
+func init() {
+	for _, name := range v2.RegisteredNames() {
+		name := name
+
+		component.Register(component.Registration{
+			Name:    "integrations." + name,
+			Args:    Arguments{},
+			Exports: Exports{},
+
+			Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+				return New(opts, name, args.(Arguments))
+			},
+		})
+	}
+}
+
+// Arguments holds values which are used to configure an integrations.<name>
+// component.
+type Arguments struct {
+	// Config is the YAML configuration of the integration, using the same
+	// format as in the integrations block of the static mode config. Configs
+	// often hold credentials, so Config is a secret and is never displayed.
+	Config hcltypes.Secret `hcl:"config,optional"`
+
+	// ListenAddress is the host:port the integration's HTTP handlers are
+	// served on. Exported targets point at this address.
+	ListenAddress string `hcl:"listen_address,optional"`
+}
+
+// DefaultArguments provides the default arguments for integrations.<name>
+// components. The default listen address picks a random free port.
+var DefaultArguments = Arguments{
+	ListenAddress: "127.0.0.1:0",
+}
+
+var _ gohcl.Decoder = (*Arguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (a *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*a = DefaultArguments
+
+	type arguments Arguments
+	return gohcl.DecodeBody(body, ctx, (*arguments)(a))
+}
+
+// Exports holds values which are exported by integrations.<name>
+// components.
+type Exports struct {
+	// Targets are the scrape targets exposed by the integration. Targets are
+	// empty for integrations which don't expose metrics.
+	Targets []mutate.Target `hcl:"targets"`
+}
+
+// Component runs a single integration.
+type Component struct {
+	log  log.Logger
+	opts component.Options
+	name string
+
+	hostname string
+
+	mut         sync.Mutex
+	cfg         v2.Config
+	globals     v2.Globals
+	integration v2.Integration
+	prefix      string
+
+	// Handler to serve requests with. Updated along with integration.
+	handlerMut sync.RWMutex
+	handler    http.Handler
+
+	lisMut        sync.Mutex
+	listenAddress string
+	lis           net.Listener
+	srv           *http.Server
+
+	newIntegration chan struct{}
+
+	healthMut sync.RWMutex
+	health    component.Health
+}
+
+var (
+	_ component.Component       = (*Component)(nil)
+	_ component.HealthComponent = (*Component)(nil)
+)
+
+// New creates a new component which runs the integration registered as name.
+func New(o component.Options, name string, args Arguments) (*Component, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	}
+
+	c := &Component{
+		log:      o.Logger,
+		opts:     o,
+		name:     name,
+		hostname: hostname,
+
+		newIntegration: make(chan struct{}, 1),
+	}
+	if err := c.Update(args); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run implements component.Component.
+func (c *Component) Run(ctx context.Context) error {
+	defer func() {
+		c.lisMut.Lock()
+		defer c.lisMut.Unlock()
+		if c.srv != nil {
+			_ = c.srv.Close()
+			c.srv, c.lis, c.listenAddress = nil, nil, ""
+		}
+	}()
+
+	var (
+		wg        sync.WaitGroup
+		cancelRun context.CancelFunc = func() {}
+	)
+	defer func() {
+		cancelRun()
+		wg.Wait()
+	}()
+
+	// Make sure the current integration is started even if Run was called
+	// again after returning.
+	select {
+	case c.newIntegration <- struct{}{}:
+	default:
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.newIntegration:
+			cancelRun()
+			wg.Wait()
+
+			c.mut.Lock()
+			integration := c.integration
+			c.mut.Unlock()
+
+			var runCtx context.Context
+			runCtx, cancelRun = context.WithCancel(ctx)
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.runIntegration(runCtx, integration)
+			}()
+		}
+	}
+}
+
+func (c *Component) runIntegration(ctx context.Context, i v2.Integration) {
+	c.setHealth(component.Health{
+		Health:     component.HealthTypeHealthy,
+		Message:    "integration running",
+		UpdateTime: c.opts.Clock.Now(),
+	})
+
+	err := i.RunIntegration(ctx)
+	if ctx.Err() != nil {
+		// The integration was stopped by us.
+		return
+	}
+	if err == nil {
+		err = errors.New("integration exited unexpectedly")
+	}
+
+	level.Error(c.log).Log("msg", "integration exited", "err", err)
+	c.setHealth(component.Health{
+		Health:     component.HealthTypeUnhealthy,
+		Message:    fmt.Sprintf("integration exited: %s", err),
+		UpdateTime: c.opts.Clock.Now(),
+	})
+}
+
+// Update implements component.Component.
+func (c *Component) Update(args component.Arguments) (err error) {
+	newArgs := args.(Arguments)
+
+	raw := string(newArgs.Config)
+	if strings.TrimSpace(raw) == "" {
+		// Integrations apply their defaults when unmarshaling, which is skipped
+		// for empty documents.
+		raw = "{}"
+	}
+	cfg, err := v2.UnmarshalConfig(c.name, []byte(raw))
+	if err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
+	if _, _, err := net.SplitHostPort(newArgs.ListenAddress); err != nil {
+		return fmt.Errorf("invalid listen_address: %w", err)
+	}
+
+	// The new listener is only served once the rest of the update succeeds,
+	// so a failed update keeps the current listener and integration running.
+	pending, addr, err := c.prepareListener(newArgs.ListenAddress)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			pending.abort()
+		}
+	}()
+
+	// SubsystemOpts is left as its zero value, which disables autoscraping.
+	globals := v2.Globals{
+		AgentIdentifier: c.hostname,
+		AgentBaseURL:    &url.URL{Scheme: "http", Host: addr},
+	}
+	if err := cfg.ApplyDefaults(globals); err != nil {
+		return fmt.Errorf("failed to apply defaults: %w", err)
+	}
+	id, err := cfg.Identifier(globals)
+	if err != nil {
+		return fmt.Errorf("failed to get identifier: %w", err)
+	}
+	prefix := path.Join("/integrations", c.name, id)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	// Try to update the running integration in place first.
+	if ui, ok := c.integration.(v2.UpdateIntegration); ok && c.prefix == prefix {
+		err := ui.ApplyConfig(cfg, globals)
+		if err == nil {
+			c.commitListener(pending)
+			c.cfg, c.globals = cfg, globals
+			c.exportTargets()
+			return nil
+		} else if !errors.Is(err, v2.ErrInvalidUpdate) {
+			return fmt.Errorf("failed to apply config: %w", err)
+		}
+	} else if cc, ok := cfg.(v2.ComparableConfig); ok && c.cfg != nil && c.prefix == prefix && cc.ConfigEquals(c.cfg) {
+		// Nothing changed other than possibly the listener; keep the running
+		// integration.
+		if c.commitListener(pending) {
+			c.globals = globals
+			c.exportTargets()
+		}
+		return nil
+	}
+
+	integration, err := cfg.NewIntegration(c.log, globals)
+	if err != nil {
+		return fmt.Errorf("failed to create integration: %w", err)
+	}
+
+	var handler http.Handler = http.NotFoundHandler()
+	if hi, ok := integration.(v2.HTTPIntegration); ok {
+		h, err := hi.Handler(prefix + "/")
+		if err != nil {
+			return fmt.Errorf("failed to create http handler: %w", err)
+		} else if h != nil {
+			handler = h
+		}
+	}
+
+	c.commitListener(pending)
+	c.cfg, c.globals, c.integration, c.prefix = cfg, globals, integration, prefix
+
+	c.handlerMut.Lock()
+	c.handler = handler
+	c.handlerMut.Unlock()
+
+	c.exportTargets()
+
+	select {
+	case c.newIntegration <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// pendingListener is a listener created by an update which hasn't been
+// served yet.
+type pendingListener struct {
+	addr string       // Requested listen address.
+	lis  net.Listener // nil if the current listener is kept.
+}
+
+// abort closes the listener if it was newly created.
+func (p pendingListener) abort() {
+	if p.lis != nil {
+		_ = p.lis.Close()
+	}
+}
+
+// prepareListener returns a listener for addr along with the address being
+// listened on. The current listener is reused if it was created for the same
+// address.
+func (c *Component) prepareListener(addr string) (pendingListener, string, error) {
+	c.lisMut.Lock()
+	defer c.lisMut.Unlock()
+
+	if c.lis != nil && c.listenAddress == addr {
+		return pendingListener{addr: addr}, c.lis.Addr().String(), nil
+	}
+
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return pendingListener{}, "", fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return pendingListener{addr: addr, lis: lis}, lis.Addr().String(), nil
+}
+
+// commitListener starts serving on a newly created listener and stops the
+// previous server. Returns true if the listener changed.
+func (c *Component) commitListener(p pendingListener) bool {
+	if p.lis == nil {
+		return false
+	}
+
+	c.lisMut.Lock()
+	defer c.lisMut.Unlock()
+
+	srv := &http.Server{Handler: http.HandlerFunc(c.serveHTTP)}
+	go func() { _ = srv.Serve(p.lis) }()
+
+	if c.srv != nil {
+		_ = c.srv.Close()
+	}
+	c.listenAddress, c.lis, c.srv = p.addr, p.lis, srv
+	return true
+}
+
+func (c *Component) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	c.handlerMut.RLock()
+	h := c.handler
+	c.handlerMut.RUnlock()
+
+	if h == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// exportTargets exports the targets of the current integration. c.mut must
+// be held when calling exportTargets.
+func (c *Component) exportTargets() {
+	targets := []mutate.Target{}
+
+	if mi, ok := c.integration.(v2.MetricsIntegration); ok {
+		ep := v2.Endpoint{Host: c.globals.AgentBaseURL.Host, Prefix: c.prefix}
+
+		for _, group := range mi.Targets(ep) {
+			for _, tgt := range group.Targets {
+				labels := make(model.LabelSet, len(group.Labels)+len(tgt))
+				for k, v := range group.Labels {
+					labels[k] = v
+				}
+				for k, v := range tgt {
+					labels[k] = v
+				}
+
+				t := make(mutate.Target, len(labels))
+				for k, v := range labels {
+					t[string(k)] = string(v)
+				}
+				targets = append(targets, t)
+			}
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i][model.AddressLabel]+targets[i][model.MetricsPathLabel] <
+			targets[j][model.AddressLabel]+targets[j][model.MetricsPathLabel]
+	})
+	c.opts.OnStateChange(Exports{Targets: targets})
+}
+
+// CurrentHealth implements component.HealthComponent.
+func (c *Component) CurrentHealth() component.Health {
+	c.healthMut.RLock()
+	defer c.healthMut.RUnlock()
+	return c.health
+}
+
+func (c *Component) setHealth(h component.Health) {
+	c.healthMut.Lock()
+	defer c.healthMut.Unlock()
+	c.health = h
+}
@@ -0,0 +1,75 @@
+package integrations
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/component"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIntegration_Agent(t *testing.T) {
+	var (
+		exportsMut sync.Mutex
+		exports    Exports
+	)
+	c, err := New(component.Options{
+		ID:     "integrations.agent.test",
+		Logger: log.NewNopLogger(),
+		Clock:  clock.New(),
+		OnStateChange: func(e component.Exports) {
+			exportsMut.Lock()
+			defer exportsMut.Unlock()
+			exports = e.(Exports)
+		},
+	}, "agent", DefaultArguments)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = c.Run(ctx) }()
+
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	exportsMut.Lock()
+	require.Len(t, exports.Targets, 1)
+	target := exports.Targets[0]
+	exportsMut.Unlock()
+
+	require.Equal(t, "integrations/agent", target["job"])
+	require.Equal(t, hostname, target["instance"])
+	require.Equal(t, fmt.Sprintf("/integrations/agent/%s/metrics", hostname), target["__metrics_path__"])
+
+	// The exported target should be scrapable.
+	require.Eventually(t, func() bool {
+		resp, err := http.Get("http://" + target["__address__"] + target["__metrics_path__"])
+		if err != nil {
+			return false
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestIntegration_InvalidConfig(t *testing.T) {
+	args := DefaultArguments
+	args.Config = "not_a_field: true"
+
+	_, err := New(component.Options{
+		ID:            "integrations.agent.test",
+		Logger:        log.NewNopLogger(),
+		Clock:         clock.New(),
+		OnStateChange: func(e component.Exports) {},
+	}, "agent", args)
+	require.Error(t, err)
+}
@@ -0,0 +1,354 @@
+// Package exec implements the local.exec component.
+package exec
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/pkg/flow/hcltypes"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/rfratto/gohcl"
+)
+
+// stderrTailSize is the maximum number of bytes of stderr which are exported
+// by the local.exec component.
+const stderrTailSize = 4096
+
+func init() {
+	component.Register(component.Registration{
+		Name:    "local.exec",
+		Args:    Arguments{},
+		Exports: Exports{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			return New(opts, args.(Arguments))
+		},
+	})
+}
+
+// Arguments holds values which are used to configure the local.exec
+// component.
+type Arguments struct {
+	// Command is the program to run followed by its arguments. The command is
+	// not run through a shell.
+	Command []string `hcl:"command,attr"`
+	// Env holds extra environment variables for the command. The command
+	// inherits the environment of the agent.
+	Env map[string]string `hcl:"env,optional"`
+	// WorkingDir is the directory to run the command from. Defaults to the
+	// working directory of the agent.
+	WorkingDir string `hcl:"working_dir,optional"`
+	// Interval is how often to run the command. If zero, the command only runs
+	// when the component is created or its arguments change.
+	Interval time.Duration `hcl:"interval,optional"`
+	// Timeout is the maximum amount of time a single run of the command may
+	// take before it is killed.
+	Timeout time.Duration `hcl:"timeout,optional"`
+	// IsSecret marks stdout of the command as holding a secret value which
+	// should not be displayed to the user.
+	IsSecret bool `hcl:"is_secret,optional"`
+}
+
+// DefaultArguments provides the default arguments for the local.exec
+// component.
+var DefaultArguments = Arguments{
+	Timeout: time.Minute,
+}
+
+var _ gohcl.Decoder = (*Arguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (a *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*a = DefaultArguments
+
+	type arguments Arguments
+	return gohcl.DecodeBody(body, ctx, (*arguments)(a))
+}
+
+// Exports holds values which are exported by the local.exec component.
+type Exports struct {
+	// Stdout of the most recent run of the command.
+	Stdout *hcltypes.OptionalSecret `hcl:"stdout,attr"`
+	// ExitCode of the most recent run of the command. ExitCode is -1 if the
+	// command couldn't be started or was killed.
+	ExitCode int `hcl:"exit_code,attr"`
+	// Stderr holds the last 4KiB written to stderr by the most recent run of
+	// the command.
+	Stderr string `hcl:"stderr,attr"`
+}
+
+// Component implements the local.exec component.
+type Component struct {
+	opts component.Options
+
+	mut    sync.Mutex
+	args   Arguments
+	gen    int // Incremented every time args changes.
+	ranGen int // gen of the arguments the command was run with in New.
+
+	healthMut sync.RWMutex
+	health    component.Health
+
+	// updateCh is a buffered channel which is written to when the arguments
+	// changed and the command must be rerun.
+	updateCh chan struct{}
+}
+
+var (
+	_ component.Component       = (*Component)(nil)
+	_ component.HealthComponent = (*Component)(nil)
+)
+
+// New creates a new local.exec component. The command is run once before New
+// returns, so components referencing the exports of local.exec are evaluated
+// with the output of the first run.
+func New(o component.Options, args Arguments) (*Component, error) {
+	c := &Component{
+		opts: o,
+
+		updateCh: make(chan struct{}, 1),
+	}
+	if err := c.Update(args); err != nil {
+		return nil, err
+	}
+
+	// Perform the first run, which will immediately set our exports.
+	args, c.ranGen = c.currentArgs()
+	c.runCommand(context.Background(), args)
+	return c, nil
+}
+
+// Run implements component.Component. Runs of the command never overlap,
+// since the command is only ever run from New, before Run is called, and
+// from Run.
+func (c *Component) Run(ctx context.Context) error {
+	args, gen := c.currentArgs()
+	ticker := c.newTicker(args.Interval)
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
+
+	// Skip running the command if New already ran it with the current
+	// arguments. Run may be called again later, so this only happens once.
+	c.mut.Lock()
+	ranGen := c.ranGen
+	c.ranGen = 0
+	c.mut.Unlock()
+	if gen != ranGen {
+		c.runCommand(ctx, args)
+	}
+
+	for {
+		var tickCh <-chan time.Time
+		if ticker != nil {
+			tickCh = ticker.C
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-c.updateCh:
+			newArgs, newGen := c.currentArgs()
+			if newGen == gen {
+				continue
+			}
+			if newArgs.Interval != args.Interval {
+				if ticker != nil {
+					ticker.Stop()
+				}
+				ticker = c.newTicker(newArgs.Interval)
+			}
+			args, gen = newArgs, newGen
+			c.runCommand(ctx, args)
+
+		case <-tickCh:
+			c.runCommand(ctx, args)
+		}
+	}
+}
+
+func (c *Component) currentArgs() (Arguments, int) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.args, c.gen
+}
+
+// newTicker returns a ticker for interval, or nil if the command should only
+// run once.
+func (c *Component) newTicker(interval time.Duration) *clock.Ticker {
+	if interval <= 0 {
+		return nil
+	}
+	return c.opts.Clock.Ticker(interval)
+}
+
+// runCommand runs the command and exports its output. Errors are logged and
+// reported through the health of the component.
+func (c *Component) runCommand(ctx context.Context, args Arguments) {
+	runCtx, cancel := context.WithTimeout(ctx, args.Timeout)
+	defer cancel()
+
+	var (
+		stdout bytes.Buffer
+		stderr = &tailWriter{size: stderrTailSize}
+	)
+
+	cmd := exec.CommandContext(runCtx, args.Command[0], args.Command[1:]...)
+	cmd.Dir = args.WorkingDir
+	cmd.Env = append(os.Environ(), envList(args.Env)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		// The component is shutting down; the command was killed by us.
+		return
+	}
+
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+
+	c.opts.OnStateChange(Exports{
+		Stdout: &hcltypes.OptionalSecret{
+			IsSecret: args.IsSecret,
+			Value:    stdout.String(),
+		},
+		ExitCode: exitCode,
+		Stderr:   stderr.String(),
+	})
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		c.setHealth(component.Health{
+			Health:     component.HealthTypeHealthy,
+			Message:    "command exited successfully",
+			UpdateTime: c.opts.Clock.Now(),
+		})
+
+	case runCtx.Err() == context.DeadlineExceeded:
+		c.setHealth(component.Health{
+			Health:     component.HealthTypeUnhealthy,
+			Message:    fmt.Sprintf("command timed out after %s", args.Timeout),
+			UpdateTime: c.opts.Clock.Now(),
+		})
+		level.Error(c.opts.Logger).Log("msg", "command timed out", "timeout", args.Timeout)
+
+	case errors.As(err, &exitErr):
+		c.setHealth(component.Health{
+			Health:     component.HealthTypeUnhealthy,
+			Message:    fmt.Sprintf("command exited with code %d", exitCode),
+			UpdateTime: c.opts.Clock.Now(),
+		})
+		level.Warn(c.opts.Logger).Log("msg", "command exited with non-zero code", "code", exitCode)
+
+	default:
+		c.setHealth(component.Health{
+			Health:     component.HealthTypeUnhealthy,
+			Message:    fmt.Sprintf("failed to run command: %s", err),
+			UpdateTime: c.opts.Clock.Now(),
+		})
+		level.Error(c.opts.Logger).Log("msg", "failed to run command", "err", err)
+	}
+}
+
+// Update implements component.Component.
+func (c *Component) Update(args component.Arguments) error {
+	newArgs := args.(Arguments)
+
+	if len(newArgs.Command) == 0 {
+		return fmt.Errorf("command must not be empty")
+	}
+	if newArgs.Timeout <= 0 {
+		return fmt.Errorf("timeout must be greater than 0")
+	}
+	if newArgs.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	// Report commands which can't be found early. Commands given as a path
+	// are resolved relative to working_dir when they run, so only commands
+	// looked up from PATH are checked.
+	if !strings.ContainsRune(newArgs.Command[0], filepath.Separator) {
+		if _, err := exec.LookPath(newArgs.Command[0]); err != nil {
+			return fmt.Errorf("failed to find command: %w", err)
+		}
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	// Arguments are re-evaluated whenever a dependency changes; only rerun the
+	// command if our arguments actually changed.
+	if c.gen > 0 && reflect.DeepEqual(c.args, newArgs) {
+		return nil
+	}
+	c.args = newArgs
+	c.gen++
+
+	select {
+	case c.updateCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// CurrentHealth implements component.HealthComponent.
+func (c *Component) CurrentHealth() component.Health {
+	c.healthMut.RLock()
+	defer c.healthMut.RUnlock()
+	return c.health
+}
+
+func (c *Component) setHealth(h component.Health) {
+	c.healthMut.Lock()
+	defer c.healthMut.Unlock()
+	c.health = h
+}
+
+// envList converts env into KEY=VALUE pairs sorted by key.
+func envList(env map[string]string) []string {
+	res := make([]string, 0, len(env))
+	for k, v := range env {
+		res = append(res, k+"="+v)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// tailWriter is an io.Writer which keeps the last size bytes written to it.
+type tailWriter struct {
+	size int
+	buf  []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if len(p) > w.size {
+		p = p[len(p)-w.size:]
+	}
+	w.buf = append(w.buf, p...)
+	if over := len(w.buf) - w.size; over > 0 {
+		w.buf = append(w.buf[:0], w.buf[over:]...)
+	}
+	return n, nil
+}
+
+func (w *tailWriter) String() string { return string(w.buf) }
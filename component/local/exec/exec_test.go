@@ -0,0 +1,123 @@
+package exec_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/component/local/exec"
+	"github.com/grafana/agent/component/local/file"
+	"github.com/grafana/agent/pkg/flow/componenttest"
+	"github.com/grafana/agent/pkg/flow/hcltypes"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExec(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test commands require a POSIX shell")
+	}
+
+	t.Run("Exports output of the command", func(t *testing.T) {
+		tc := runController(t, exec.Arguments{
+			Command:    []string{"sh", "-c", `echo "$GREETING from $(pwd)"; echo oops >&2`},
+			Env:        map[string]string{"GREETING": "hello"},
+			WorkingDir: "/",
+			Timeout:    time.Minute,
+			IsSecret:   true,
+		})
+
+		require.Equal(t, exec.Exports{
+			Stdout: &hcltypes.OptionalSecret{
+				IsSecret: true,
+				Value:    "hello from /\n",
+			},
+			ExitCode: 0,
+			Stderr:   "oops\n",
+		}, tc.Exports())
+	})
+
+	t.Run("Exports exit code of failed commands", func(t *testing.T) {
+		tc := runController(t, exec.Arguments{
+			Command: []string{"sh", "-c", "exit 3"},
+			Timeout: time.Minute,
+		})
+		require.Equal(t, 3, tc.Exports().(exec.Exports).ExitCode)
+	})
+
+	t.Run("Reruns command on interval", func(t *testing.T) {
+		counter := t.TempDir() + "/counter"
+		tc := runController(t, exec.Arguments{
+			Command:  []string{"sh", "-c", `echo x >> "$0"; wc -l < "$0"`, counter},
+			Interval: 50 * time.Millisecond,
+			Timeout:  time.Minute,
+		})
+
+		require.NoError(t, tc.WaitExports(time.Second))
+		require.Equal(t, "2", strings.TrimSpace(tc.Exports().(exec.Exports).Stdout.Value))
+	})
+
+	t.Run("Kills commands which time out", func(t *testing.T) {
+		tc := runController(t, exec.Arguments{
+			Command: []string{"sleep", "10"},
+			Timeout: 50 * time.Millisecond,
+		})
+		require.Equal(t, -1, tc.Exports().(exec.Exports).ExitCode)
+	})
+
+	t.Run("Commands which can't be started fail", func(t *testing.T) {
+		tc, err := componenttest.NewControllerFromID(nil, "local.exec")
+		require.NoError(t, err)
+		err = tc.Run(componenttest.TestContext(t), exec.Arguments{
+			Command: []string{"this-command-does-not-exist"},
+			Timeout: time.Minute,
+		})
+		require.Error(t, err)
+	})
+}
+
+func TestExec_Graph(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test commands require a POSIX shell")
+	}
+
+	path := filepath.Join(t.TempDir(), "contents")
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0600))
+
+	// local.file is evaluated with the output of the first run of the
+	// command, so its filename is never empty.
+	g := componenttest.NewGraph(t, fmt.Sprintf(`
+		local "exec" "path" {
+			command = ["printf", "%%s", %q]
+		}
+
+		local "file" "contents" {
+			filename = local.exec.path.stdout
+		}
+	`, path), componenttest.GraphOptions{})
+
+	require.Equal(t, "hello", g.Exports("local.file.contents").(file.Exports).Content.Value)
+}
+
+// runController runs local.exec with args and waits for the exports of the
+// first run of the command.
+func runController(t *testing.T, args exec.Arguments) *componenttest.Controller {
+	t.Helper()
+
+	tc, err := componenttest.NewControllerFromID(nil, "local.exec")
+	require.NoError(t, err)
+
+	// The cleanup checking the result of Run is registered before the
+	// context's cleanup so it runs after the context is canceled.
+	runErr := make(chan error, 1)
+	t.Cleanup(func() { require.NoError(t, <-runErr) })
+
+	ctx := componenttest.TestContext(t)
+	go func() { runErr <- tc.Run(ctx, args) }()
+
+	require.NoError(t, tc.WaitExports(5*time.Second))
+	return tc
+}
@@ -2,6 +2,8 @@ package file
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"os"
@@ -79,6 +81,8 @@ type Component struct {
 	args          Arguments
 	latestContent string
 	detector      io.Closer
+	lastRead      time.Time
+	lastErr       error
 
 	healthMut sync.RWMutex
 	health    component.Health
@@ -91,6 +95,7 @@ type Component struct {
 var (
 	_ component.Component       = (*Component)(nil)
 	_ component.HealthComponent = (*Component)(nil)
+	_ component.DebugComponent  = (*Component)(nil)
 )
 
 // New creates a new local.file component.
@@ -153,16 +158,18 @@ func (c *Component) Run(ctx context.Context) error {
 func (c *Component) readFile() error {
 	// Force a re-load of the file outside of the update detection mechanism.
 	bb, err := os.ReadFile(c.args.Filename)
+	c.lastErr = err
 	if err != nil {
 		c.setHealth(component.Health{
 			Health:     component.HealthTypeUnhealthy,
 			Message:    fmt.Sprintf("failed to read file: %s", err),
-			UpdateTime: time.Now(),
+			UpdateTime: c.opts.Clock.Now(),
 		})
 		level.Error(c.opts.Logger).Log("msg", "failed to read file", "path", c.opts.DataPath, "err", err)
 		return err
 	}
 	c.latestContent = string(bb)
+	c.lastRead = c.opts.Clock.Now()
 
 	c.opts.OnStateChange(Exports{
 		Content: &hcltypes.OptionalSecret{
@@ -174,7 +181,7 @@ func (c *Component) readFile() error {
 	c.setHealth(component.Health{
 		Health:     component.HealthTypeHealthy,
 		Message:    "read file",
-		UpdateTime: time.Now(),
+		UpdateTime: c.opts.Clock.Now(),
 	})
 	return nil
 }
@@ -260,3 +267,28 @@ func (c *Component) setHealth(h component.Health) {
 	defer c.healthMut.Unlock()
 	c.health = h
 }
+
+// DebugInfo implements component.DebugComponent.
+func (c *Component) DebugInfo() interface{} {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	var di debugInfo
+	di.Detector = c.args.Type.String()
+	di.LastRead = c.lastRead
+	if c.lastErr != nil {
+		di.LastError = c.lastErr.Error()
+	}
+	if !c.lastRead.IsZero() {
+		sum := sha256.Sum256([]byte(c.latestContent))
+		di.ContentSHA256 = hex.EncodeToString(sum[:])
+	}
+	return di
+}
+
+type debugInfo struct {
+	Detector      string    `hcl:"detector,attr"`
+	LastRead      time.Time `hcl:"last_read,optional"`
+	LastError     string    `hcl:"last_error,optional"`
+	ContentSHA256 string    `hcl:"content_sha256,optional"`
+}
@@ -8,9 +8,14 @@ import (
 	"testing"
 	"time"
 
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/component"
 	"github.com/grafana/agent/component/local/file"
 	"github.com/grafana/agent/pkg/flow/componenttest"
 	"github.com/grafana/agent/pkg/flow/hcltypes"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/rfratto/gohcl"
 	"github.com/stretchr/testify/require"
 )
 
@@ -139,3 +144,43 @@ func canceledContext() context.Context {
 	cancel()
 	return ctx
 }
+
+func TestFile_DebugInfo(t *testing.T) {
+	testFile := filepath.Join(t.TempDir(), "testfile")
+	require.NoError(t, os.WriteFile(testFile, []byte("Hello, world!"), 0664))
+
+	clk := clock.NewMock()
+	clk.Set(time.Date(2022, time.June, 1, 12, 0, 0, 0, time.UTC))
+
+	c, err := file.New(component.Options{
+		Logger:        log.NewNopLogger(),
+		OnStateChange: func(component.Exports) {},
+		Clock:         clk,
+	}, file.Arguments{
+		Filename:      testFile,
+		Type:          file.DetectorPoll,
+		PollFrequency: 1 * time.Hour,
+	})
+	require.NoError(t, err)
+
+	f := hclwrite.NewEmptyFile()
+	f.Body().AppendBlock(gohcl.EncodeAsBlock(c.DebugInfo(), "status"))
+
+	out := string(f.Bytes())
+	require.Contains(t, out, `detector       = "poll"`)
+	require.Contains(t, out, `last_read      = "2022-06-01T12:00:00Z"`)
+	require.NotContains(t, out, "last_error")
+	require.Contains(t, out, `content_sha256 = "315f5bdb76d078c43b8ac0064e4a0164612b1fce77c869345bfc94c75894edd3"`)
+
+	// Failed reads are reported until the file can be read again.
+	require.NoError(t, os.Remove(testFile))
+	require.Error(t, c.Update(file.Arguments{
+		Filename:      testFile,
+		Type:          file.DetectorPoll,
+		PollFrequency: 1 * time.Hour,
+	}))
+
+	f = hclwrite.NewEmptyFile()
+	f.Body().AppendBlock(gohcl.EncodeAsBlock(c.DebugInfo(), "status"))
+	require.Contains(t, string(f.Bytes()), "last_error")
+}
@@ -0,0 +1,233 @@
+// Package process implements the logs.process component.
+package process
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/logs"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/grafana/loki/clients/pkg/logentry/stages"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rfratto/gohcl"
+	"gopkg.in/yaml.v2"
+)
+
+// maxBatchSize is the maximum number of processed entries sent to receivers
+// at once.
+const maxBatchSize = 100
+
+func init() {
+	component.Register(component.Registration{
+		Name:    "logs.process",
+		Args:    Arguments{},
+		Exports: Exports{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			return New(opts, args.(Arguments))
+		},
+	})
+}
+
+// Arguments holds values which are used to configure the logs.process
+// component.
+type Arguments struct {
+	// Stages is a YAML list of promtail pipeline stages, using the same format
+	// as pipeline_stages in a promtail scrape config.
+	Stages    string           `hcl:"stages,attr"`
+	ForwardTo []*logs.Receiver `hcl:"forward_to"`
+}
+
+var _ gohcl.Decoder = (*Arguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (a *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	type arguments Arguments
+	if err := gohcl.DecodeBody(body, ctx, (*arguments)(a)); err != nil {
+		return err
+	}
+	_, err := a.pipelineStages()
+	return err
+}
+
+func (a *Arguments) pipelineStages() (stages.PipelineStages, error) {
+	var ps stages.PipelineStages
+	if err := yaml.UnmarshalStrict([]byte(a.Stages), &ps); err != nil {
+		return nil, fmt.Errorf("invalid stages: %w", err)
+	}
+	return ps, nil
+}
+
+// Exports holds values which are exported by the logs.process
+// component.
+type Exports struct {
+	Receiver *logs.Receiver `hcl:"receiver"`
+}
+
+// Component implements the logs.process component.
+type Component struct {
+	opts component.Options
+
+	mut      sync.RWMutex
+	args     Arguments
+	stages   *stages.Pipeline
+	pipeline *pipeline // Running instance of stages; nil when not running.
+	reg      *metrics.CollectorRegistry
+
+	receiver *logs.Receiver
+}
+
+var (
+	_ component.Component  = (*Component)(nil)
+	_ prometheus.Collector = (*Component)(nil)
+)
+
+// New creates a new logs.process component.
+func New(o component.Options, args Arguments) (*Component, error) {
+	c := &Component{opts: o}
+	c.receiver = &logs.Receiver{Receive: c.Receive}
+
+	if err := c.Update(args); err != nil {
+		return nil, err
+	}
+
+	o.OnStateChange(Exports{Receiver: c.receiver})
+	return c, nil
+}
+
+// Run implements component.Component.
+func (c *Component) Run(ctx context.Context) error {
+	c.mut.Lock()
+	if c.pipeline == nil {
+		c.pipeline = newPipeline(c.stages, c.args.ForwardTo)
+	}
+	c.mut.Unlock()
+
+	<-ctx.Done()
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	// Stop the pipeline so its goroutines exit. Receive drops entries while the
+	// component isn't running.
+	c.pipeline.Stop()
+	c.pipeline = nil
+	return nil
+}
+
+// Update implements component.Component.
+func (c *Component) Update(args component.Arguments) error {
+	newArgs := args.(Arguments)
+
+	ps, err := newArgs.pipelineStages()
+	if err != nil {
+		return err
+	}
+
+	// Stages register their metrics when created, so every pipeline gets a
+	// new registry to avoid duplicate registrations.
+	reg := metrics.NewCollectorRegistry()
+	p, err := stages.NewPipeline(c.opts.Logger, ps, nil, reg)
+	if err != nil {
+		return fmt.Errorf("failed to create pipeline: %w", err)
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.args = newArgs
+	c.stages = p
+	c.reg = reg
+
+	if c.pipeline != nil {
+		// There are no more senders to the old pipeline since we hold the
+		// write lock.
+		c.pipeline.Stop()
+		c.pipeline = newPipeline(p, newArgs.ForwardTo)
+	}
+	return nil
+}
+
+// Receive processes entries through the configured pipeline stages.
+func (c *Component) Receive(entries []logs.Entry) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	if c.pipeline == nil {
+		return
+	}
+	for _, e := range entries {
+		// Stages modify labels in place, but entries are shared with other
+		// receivers.
+		e.Labels = e.Labels.Clone()
+		c.pipeline.in <- stages.Entry{
+			Extracted: map[string]interface{}{},
+			Entry:     e,
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Component) Describe(ch chan<- *prometheus.Desc) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	c.reg.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Component) Collect(ch chan<- prometheus.Metric) {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	c.reg.Collect(ch)
+}
+
+// pipeline runs a set of stages, forwarding processed entries to receivers.
+type pipeline struct {
+	in   chan stages.Entry
+	done chan struct{}
+}
+
+func newPipeline(p *stages.Pipeline, receivers []*logs.Receiver) *pipeline {
+	in := make(chan stages.Entry)
+	out := p.Run(in)
+
+	pl := &pipeline{
+		in:   in,
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(pl.done)
+
+		for e := range out {
+			batch := make([]logs.Entry, 0, maxBatchSize)
+			batch = append(batch, e.Entry)
+
+		Gather:
+			for len(batch) < maxBatchSize {
+				select {
+				case e, ok := <-out:
+					if !ok {
+						break Gather
+					}
+					batch = append(batch, e.Entry)
+				default:
+					break Gather
+				}
+			}
+
+			logs.Fanout(receivers, batch)
+		}
+	}()
+
+	return pl
+}
+
+// Stop stops the pipeline once all pending entries have been forwarded.
+func (p *pipeline) Stop() {
+	close(p.in)
+	<-p.done
+}
@@ -0,0 +1,85 @@
+package process
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/logs"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStages(t *testing.T) {
+	var (
+		mut      sync.Mutex
+		received []logs.Entry
+	)
+	recv := &logs.Receiver{Receive: func(entries []logs.Entry) {
+		mut.Lock()
+		defer mut.Unlock()
+		received = append(received, entries...)
+	}}
+
+	c, err := New(component.Options{
+		ID:            "logs.process.test",
+		Logger:        log.NewNopLogger(),
+		DataPath:      t.TempDir(),
+		OnStateChange: func(e component.Exports) {},
+	}, Arguments{
+		Stages: `
+- regex:
+    expression: "^level=(?P<level>\\w+)"
+- labels:
+    level:
+- match:
+    selector: '{level="debug"}'
+    action: drop
+`,
+		ForwardTo: []*logs.Receiver{recv},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = c.Run(ctx) }()
+
+	input := []logs.Entry{
+		{Labels: model.LabelSet{"job": "test"}, Entry: logproto.Entry{Timestamp: time.Now(), Line: "level=info hello"}},
+		{Labels: model.LabelSet{"job": "test"}, Entry: logproto.Entry{Timestamp: time.Now(), Line: "level=debug dropped"}},
+	}
+	require.Eventually(t, func() bool {
+		c.mut.RLock()
+		defer c.mut.RUnlock()
+		return c.pipeline != nil
+	}, time.Second, 10*time.Millisecond)
+	c.Receive(input)
+
+	require.Eventually(t, func() bool {
+		mut.Lock()
+		defer mut.Unlock()
+		return len(received) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	mut.Lock()
+	defer mut.Unlock()
+	require.Equal(t, "level=info hello", received[0].Line)
+	require.Equal(t, model.LabelSet{"job": "test", "level": "info"}, received[0].Labels)
+
+	// The input entries must not be modified.
+	require.Equal(t, model.LabelSet{"job": "test"}, input[0].Labels)
+}
+
+func TestArguments_InvalidStages(t *testing.T) {
+	_, err := New(component.Options{
+		ID:            "logs.process.test",
+		Logger:        log.NewNopLogger(),
+		DataPath:      t.TempDir(),
+		OnStateChange: func(e component.Exports) {},
+	}, Arguments{Stages: `- unknown_stage: {}`})
+	require.Error(t, err)
+}
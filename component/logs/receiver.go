@@ -0,0 +1,36 @@
+// Package logs holds types shared by Flow components which handle log
+// entries.
+package logs
+
+import (
+	"github.com/grafana/agent/component"
+	"github.com/grafana/loki/clients/pkg/promtail/api"
+)
+
+func init() {
+	component.RegisterGoStruct("LogsReceiver", Receiver{})
+}
+
+// Receiver is used to pass a batch of log entries to another component.
+type Receiver struct {
+	// entries should be considered immutable.
+	Receive func(entries []Entry) `hcl:"receiver"`
+}
+
+// Entry is a single log line along with its labels. It is an alias of the
+// promtail entry type so pipeline stages and clients from promtail can be
+// used without converting between types.
+type Entry = api.Entry
+
+// Fanout sends entries to every non-nil receiver in receivers.
+func Fanout(receivers []*Receiver, entries []Entry) {
+	if len(entries) == 0 {
+		return
+	}
+	for _, r := range receivers {
+		if r == nil || r.Receive == nil {
+			continue
+		}
+		r.Receive(entries)
+	}
+}
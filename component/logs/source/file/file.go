@@ -0,0 +1,323 @@
+// Package file implements the logs.source.file component.
+package file
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/logs"
+	"github.com/grafana/agent/component/targets/mutate"
+	"github.com/grafana/loki/clients/pkg/promtail/positions"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/prometheus/common/model"
+	"github.com/rfratto/gohcl"
+)
+
+// positionsSyncPeriod is how often the read position of tailed files is saved.
+const positionsSyncPeriod = 10 * time.Second
+
+// PathLabel is the target label holding the path or glob pattern of the files
+// to tail.
+const PathLabel = "__path__"
+
+// FilenameLabel is the label added to every entry holding the path of the
+// file it was read from.
+const FilenameLabel = "filename"
+
+func init() {
+	component.Register(component.Registration{
+		Name: "logs.source.file",
+		Args: Arguments{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			return New(opts, args.(Arguments))
+		},
+	})
+}
+
+// Arguments holds values which are used to configure the logs.source.file
+// component.
+type Arguments struct {
+	// Targets describe the files to tail. The __path__ label of each target
+	// holds the path or glob pattern of the files; labels which do not start
+	// with a double underscore are added to every entry read from those files.
+	Targets   []mutate.Target  `hcl:"targets"`
+	ForwardTo []*logs.Receiver `hcl:"forward_to"`
+
+	// SyncPeriod is how often to re-evaluate the glob patterns of targets to
+	// find new and removed files.
+	SyncPeriod time.Duration `hcl:"sync_period,optional"`
+}
+
+// DefaultArguments provides the default arguments for the logs.source.file
+// component.
+var DefaultArguments = Arguments{
+	SyncPeriod: 10 * time.Second,
+}
+
+var _ gohcl.Decoder = (*Arguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (a *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*a = DefaultArguments
+
+	type arguments Arguments
+	if err := gohcl.DecodeBody(body, ctx, (*arguments)(a)); err != nil {
+		return err
+	}
+
+	if a.SyncPeriod <= 0 {
+		return fmt.Errorf("sync_period must be greater than 0")
+	}
+	return nil
+}
+
+// Component implements the logs.source.file component.
+type Component struct {
+	opts component.Options
+
+	mut       sync.RWMutex
+	args      Arguments
+	positions positions.Positions
+	tailers   map[string]*tailer // Resolved file path -> tailer
+
+	receiversMut sync.RWMutex
+	forwardTo    []*logs.Receiver
+
+	healthMut sync.RWMutex
+	health    component.Health
+
+	// syncCh is a buffered channel which is written to when the set of tailed
+	// files should be synced immediately.
+	syncCh chan struct{}
+}
+
+var (
+	_ component.Component       = (*Component)(nil)
+	_ component.HealthComponent = (*Component)(nil)
+)
+
+// New creates a new logs.source.file component. Read positions are saved to
+// a file inside of the component's data path.
+func New(o component.Options, args Arguments) (*Component, error) {
+	if err := os.MkdirAll(o.DataPath, 0750); err != nil {
+		return nil, fmt.Errorf("failed to create data path: %w", err)
+	}
+
+	c := &Component{
+		opts:    o,
+		tailers: make(map[string]*tailer),
+
+		syncCh: make(chan struct{}, 1),
+	}
+	if err := c.Update(args); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run implements component.Component.
+func (c *Component) Run(ctx context.Context) error {
+	// Positions are loaded when Run starts and saved when it exits, since Run
+	// may be called again after returning.
+	c.mut.Lock()
+	pos, err := positions.New(c.opts.Logger, positions.Config{
+		SyncPeriod:    positionsSyncPeriod,
+		PositionsFile: filepath.Join(c.opts.DataPath, "positions.yml"),
+	})
+	if err != nil {
+		c.mut.Unlock()
+		return fmt.Errorf("failed to load positions: %w", err)
+	}
+	c.positions = pos
+	ticker := c.opts.Clock.Ticker(c.args.SyncPeriod)
+	c.mut.Unlock()
+
+	defer ticker.Stop()
+	defer func() {
+		c.mut.Lock()
+		defer c.mut.Unlock()
+
+		for path, t := range c.tailers {
+			t.Stop()
+			delete(c.tailers, path)
+		}
+		c.positions.Stop()
+		c.positions = nil
+	}()
+
+	c.sync()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			c.sync()
+		case <-c.syncCh:
+			c.mut.RLock()
+			ticker.Reset(c.args.SyncPeriod)
+			c.mut.RUnlock()
+			c.sync()
+		}
+	}
+}
+
+// Update implements component.Component.
+func (c *Component) Update(args component.Arguments) error {
+	newArgs := args.(Arguments)
+
+	for i, t := range newArgs.Targets {
+		if t[PathLabel] == "" {
+			return fmt.Errorf("target %d is missing the %s label", i, PathLabel)
+		}
+	}
+
+	c.mut.Lock()
+	c.args = newArgs
+	c.mut.Unlock()
+
+	c.receiversMut.Lock()
+	c.forwardTo = newArgs.ForwardTo
+	c.receiversMut.Unlock()
+
+	select {
+	case c.syncCh <- struct{}{}:
+	default:
+		// no-op: a sync is already queued.
+	}
+	return nil
+}
+
+// sync starts tailing newly discovered files and stops tailing files which no
+// longer match any target.
+func (c *Component) sync() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.positions == nil {
+		// Not running.
+		return
+	}
+
+	var (
+		matched = make(map[string]model.LabelSet)
+		errs    []string
+	)
+
+	for _, t := range c.args.Targets {
+		paths, err := filepath.Glob(t[PathLabel])
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("invalid path %q: %s", t[PathLabel], err))
+			continue
+		}
+
+		for _, p := range paths {
+			if abs, err := filepath.Abs(p); err == nil {
+				p = abs
+			}
+			if fi, err := os.Stat(p); err != nil || fi.IsDir() {
+				continue
+			}
+			if _, exist := matched[p]; exist {
+				// The first target matching a file wins.
+				continue
+			}
+			matched[p] = entryLabels(t, p)
+		}
+	}
+
+	// Stop tailers for files which are no longer matched or for which the
+	// labels changed, and forget about tailers which exited on their own so
+	// they can be restarted.
+	for path, t := range c.tailers {
+		labels, keep := matched[path]
+		switch {
+		case !keep:
+			t.Stop()
+			c.positions.Remove(path)
+			delete(c.tailers, path)
+		case !labels.Equal(t.labels) || !t.Running():
+			t.Stop()
+			delete(c.tailers, path)
+		}
+	}
+
+	for path, labels := range matched {
+		if _, running := c.tailers[path]; running {
+			continue
+		}
+
+		t, err := newTailer(c.opts.Logger, c.opts.Clock, path, labels, c.positions, c.send)
+		if err != nil {
+			level.Error(c.opts.Logger).Log("msg", "failed to tail file", "path", path, "err", err)
+			errs = append(errs, fmt.Sprintf("failed to tail %s: %s", path, err))
+			continue
+		}
+		c.tailers[path] = t
+	}
+
+	if len(errs) > 0 {
+		c.setHealth(component.Health{
+			Health:     component.HealthTypeUnhealthy,
+			Message:    strings.Join(errs, "; "),
+			UpdateTime: c.opts.Clock.Now(),
+		})
+		return
+	}
+	c.setHealth(component.Health{
+		Health:     component.HealthTypeHealthy,
+		Message:    fmt.Sprintf("tailing %d files", len(c.tailers)),
+		UpdateTime: c.opts.Clock.Now(),
+	})
+}
+
+// entryLabels returns the labels to add to entries read from path.
+func entryLabels(t mutate.Target, path string) model.LabelSet {
+	ls := make(model.LabelSet, len(t)+1)
+	for k, v := range t {
+		if strings.HasPrefix(k, model.ReservedLabelPrefix) {
+			continue
+		}
+		ls[model.LabelName(k)] = model.LabelValue(v)
+	}
+	ls[FilenameLabel] = model.LabelValue(path)
+	return ls
+}
+
+func (c *Component) send(entries []logs.Entry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	// Tailers are stopped while holding mut, and a stopping tailer flushes its
+	// remaining lines, so receivers are guarded by their own mutex to avoid a
+	// deadlock.
+	logs.Fanout(c.receivers(), entries)
+}
+
+func (c *Component) receivers() []*logs.Receiver {
+	c.receiversMut.RLock()
+	defer c.receiversMut.RUnlock()
+	return c.forwardTo
+}
+
+// CurrentHealth implements component.HealthComponent.
+func (c *Component) CurrentHealth() component.Health {
+	c.healthMut.RLock()
+	defer c.healthMut.RUnlock()
+	return c.health
+}
+
+func (c *Component) setHealth(h component.Health) {
+	c.healthMut.Lock()
+	defer c.healthMut.Unlock()
+	c.health = h
+}
@@ -0,0 +1,133 @@
+package file
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/logs"
+	"github.com/grafana/agent/component/targets/mutate"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile(t *testing.T) {
+	var (
+		dataPath = t.TempDir()
+		logPath  = filepath.Join(t.TempDir(), "test.log")
+		recv     = &collector{}
+	)
+	writeLines(t, logPath, "first", "second")
+
+	args := DefaultArguments
+	args.Targets = []mutate.Target{{PathLabel: logPath, "job": "test", "__hidden": "true"}}
+	args.ForwardTo = []*logs.Receiver{{Receive: recv.Receive}}
+
+	stop := runComponent(t, dataPath, args)
+	require.Eventually(t, func() bool { return len(recv.Lines()) == 2 }, 10*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"first", "second"}, recv.Lines())
+	require.Equal(t, model.LabelSet{
+		"job":         "test",
+		FilenameLabel: model.LabelValue(logPath),
+	}, recv.Entries()[0].Labels)
+	stop()
+
+	// Lines written while the component isn't running are read on the next
+	// run, and lines which were already read aren't read again.
+	writeLines(t, logPath, "third")
+
+	recv.Reset()
+	stop = runComponent(t, dataPath, args)
+	defer stop()
+	require.Eventually(t, func() bool { return len(recv.Lines()) == 1 }, 10*time.Second, 10*time.Millisecond)
+	require.Equal(t, []string{"third"}, recv.Lines())
+}
+
+func TestArguments_MissingPath(t *testing.T) {
+	args := DefaultArguments
+	args.Targets = []mutate.Target{{"job": "test"}}
+
+	_, err := New(component.Options{
+		ID:            "logs.source.file.test",
+		Logger:        log.NewNopLogger(),
+		Clock:         clock.New(),
+		DataPath:      t.TempDir(),
+		OnStateChange: func(e component.Exports) {},
+	}, args)
+	require.EqualError(t, err, "target 0 is missing the __path__ label")
+}
+
+func runComponent(t *testing.T, dataPath string, args Arguments) (stop func()) {
+	t.Helper()
+
+	c, err := New(component.Options{
+		ID:            "logs.source.file.test",
+		Logger:        log.NewNopLogger(),
+		Clock:         clock.New(),
+		DataPath:      dataPath,
+		OnStateChange: func(e component.Exports) {},
+	}, args)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, c.Run(ctx))
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func writeLines(t *testing.T, path string, lines ...string) {
+	t.Helper()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for _, l := range lines {
+		_, err := f.WriteString(l + "\n")
+		require.NoError(t, err)
+	}
+}
+
+type collector struct {
+	mut     sync.Mutex
+	entries []logs.Entry
+}
+
+func (c *collector) Receive(entries []logs.Entry) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.entries = append(c.entries, entries...)
+}
+
+func (c *collector) Entries() []logs.Entry {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return append([]logs.Entry(nil), c.entries...)
+}
+
+func (c *collector) Lines() []string {
+	var lines []string
+	for _, e := range c.Entries() {
+		lines = append(lines, e.Line)
+	}
+	return lines
+}
+
+func (c *collector) Reset() {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.entries = nil
+}
@@ -0,0 +1,197 @@
+package file
+
+import (
+	"os"
+	"sync"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/component/logs"
+	"github.com/grafana/loki/clients/pkg/promtail/positions"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/grafana/loki/pkg/util"
+	"github.com/hpcloud/tail"
+	"github.com/prometheus/common/model"
+)
+
+// maxBatchSize is the maximum number of lines a tailer will send to
+// receivers at once.
+const maxBatchSize = 100
+
+// tailer tails a single file, sending every line it reads to send. The
+// position of the tailer is periodically saved so tailing can resume after a
+// restart.
+type tailer struct {
+	log       log.Logger
+	clock     clock.Clock
+	path      string
+	labels    model.LabelSet
+	positions positions.Positions
+	send      func([]logs.Entry)
+
+	tail *tail.Tail
+
+	stopOnce sync.Once
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+func newTailer(l log.Logger, clk clock.Clock, path string, labels model.LabelSet, pos positions.Positions, send func([]logs.Entry)) (*tailer, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := pos.Get(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// The file was truncated or replaced since the position was saved; start
+	// reading from the beginning.
+	if fi.Size() < offset {
+		pos.Remove(path)
+		offset = 0
+	}
+
+	t, err := tail.TailFile(path, tail.Config{
+		Follow:    true,
+		Poll:      true,
+		ReOpen:    true,
+		MustExist: true,
+		Location:  &tail.SeekInfo{Offset: offset, Whence: 0},
+		Logger:    util.NewLogAdapter(l),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tr := &tailer{
+		log:       log.With(l, "path", path),
+		clock:     clk,
+		path:      path,
+		labels:    labels,
+		positions: pos,
+		send:      send,
+
+		tail: t,
+
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go tr.run()
+	return tr, nil
+}
+
+func (t *tailer) run() {
+	defer close(t.done)
+
+	positionTick := t.clock.Ticker(t.positions.SyncPeriod())
+	defer positionTick.Stop()
+
+	for {
+		select {
+		case <-t.quit:
+			// Save the position before stopping the underlying tailer; lines
+			// which are still buffered have been counted as read and must be
+			// flushed below.
+			if err := t.markPosition(); err != nil {
+				level.Error(t.log).Log("msg", "failed to save position when stopping tailer", "err", err)
+			}
+			t.stopTail()
+			return
+
+		case <-positionTick.C:
+			if err := t.markPosition(); err != nil {
+				// The tailer is restarted by the next sync if the file still exists,
+				// resuming from the last saved position.
+				level.Error(t.log).Log("msg", "failed to save position, stopping tailer", "err", err)
+				t.stopTail()
+				return
+			}
+
+		case line, ok := <-t.tail.Lines:
+			if !ok {
+				level.Info(t.log).Log("msg", "tail channel closed, stopping tailer", "reason", t.tail.Tomb.Err())
+				return
+			}
+			t.sendBatch(line)
+		}
+	}
+}
+
+// sendBatch sends first along with any other lines which can be read without
+// blocking.
+func (t *tailer) sendBatch(first *tail.Line) {
+	batch := make([]logs.Entry, 0, maxBatchSize)
+	batch = t.appendLine(batch, first)
+
+Gather:
+	for len(batch) < maxBatchSize {
+		select {
+		case line, ok := <-t.tail.Lines:
+			if !ok {
+				break Gather
+			}
+			batch = t.appendLine(batch, line)
+		default:
+			break Gather
+		}
+	}
+
+	t.send(batch)
+}
+
+func (t *tailer) appendLine(batch []logs.Entry, line *tail.Line) []logs.Entry {
+	if line.Err != nil {
+		level.Error(t.log).Log("msg", "error reading line", "err", line.Err)
+		return batch
+	}
+	return append(batch, logs.Entry{
+		Labels: t.labels.Clone(),
+		Entry: logproto.Entry{
+			Timestamp: line.Time,
+			Line:      line.Text,
+		},
+	})
+}
+
+// stopTail stops the underlying tailer and flushes the lines it still has
+// buffered. The tail package never exits if its Lines channel isn't drained.
+func (t *tailer) stopTail() {
+	if err := t.tail.Stop(); err != nil {
+		level.Error(t.log).Log("msg", "failed to stop tailer", "err", err)
+	}
+	for line := range t.tail.Lines {
+		t.sendBatch(line)
+	}
+}
+
+func (t *tailer) markPosition() error {
+	pos, err := t.tail.Tell()
+	if err != nil {
+		// The file no longer exists, so there's nothing to save.
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	t.positions.Put(t.path, pos)
+	return nil
+}
+
+// Stop stops the tailer and saves its final position.
+func (t *tailer) Stop() {
+	t.stopOnce.Do(func() { close(t.quit) })
+	<-t.done
+}
+
+// Running returns true if the tailer is still tailing its file.
+func (t *tailer) Running() bool {
+	select {
+	case <-t.done:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,280 @@
+// Package loki implements the logs.write.loki component.
+package loki
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/logs"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/grafana/agent/pkg/flow/hcltypes"
+	"github.com/grafana/dskit/backoff"
+	"github.com/grafana/dskit/flagext"
+	"github.com/grafana/loki/clients/pkg/promtail/client"
+	lokiflag "github.com/grafana/loki/pkg/util/flagext"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	common "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/rfratto/gohcl"
+)
+
+func init() {
+	component.Register(component.Registration{
+		Name:    "logs.write.loki",
+		Args:    Arguments{},
+		Exports: Exports{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			return New(opts, args.(Arguments))
+		},
+	})
+}
+
+// Arguments holds values which are used to configure the logs.write.loki
+// component.
+type Arguments struct {
+	ExternalLabels map[string]string `hcl:"external_labels,optional"`
+	Endpoints      []*EndpointConfig `hcl:"endpoint,block"`
+}
+
+// EndpointConfig describes a single Loki-compatible endpoint to push entries
+// to.
+type EndpointConfig struct {
+	Name          string           `hcl:"name,optional"`
+	URL           string           `hcl:"url"`
+	TenantID      string           `hcl:"tenant_id,optional"`
+	BatchWait     time.Duration    `hcl:"batch_wait,optional"`
+	BatchSize     int              `hcl:"batch_size,optional"`
+	RemoteTimeout time.Duration    `hcl:"remote_timeout,optional"`
+	MinBackoff    time.Duration    `hcl:"min_backoff,optional"`
+	MaxBackoff    time.Duration    `hcl:"max_backoff,optional"`
+	MaxRetries    int              `hcl:"max_retries,optional"`
+	BasicAuth     *BasicAuthConfig `hcl:"basic_auth,block"`
+	BearerToken   hcltypes.Secret  `hcl:"bearer_token,optional"`
+}
+
+// DefaultEndpointConfig holds default values for EndpointConfig, matching
+// the defaults of promtail clients.
+var DefaultEndpointConfig = EndpointConfig{
+	BatchWait:     client.BatchWait,
+	BatchSize:     client.BatchSize,
+	RemoteTimeout: client.Timeout,
+	MinBackoff:    client.MinBackoff,
+	MaxBackoff:    client.MaxBackoff,
+	MaxRetries:    client.MaxRetries,
+}
+
+var _ gohcl.Decoder = (*EndpointConfig)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (ec *EndpointConfig) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*ec = DefaultEndpointConfig
+
+	type endpointConfig EndpointConfig
+	if err := gohcl.DecodeBody(body, ctx, (*endpointConfig)(ec)); err != nil {
+		return err
+	}
+
+	if ec.BasicAuth != nil && ec.BearerToken != "" {
+		return fmt.Errorf("at most one of basic_auth and bearer_token may be set")
+	}
+	return nil
+}
+
+// BasicAuthConfig configures basic authentication against the endpoint.
+type BasicAuthConfig struct {
+	Username string          `hcl:"username"`
+	Password hcltypes.Secret `hcl:"password"`
+}
+
+// Exports holds values which are exported by the logs.write.loki component.
+type Exports struct {
+	Receiver *logs.Receiver `hcl:"receiver"`
+}
+
+// Component implements the logs.write.loki component.
+type Component struct {
+	opts    component.Options
+	reg     *metrics.CollectorRegistry
+	metrics *client.Metrics
+
+	mut     sync.RWMutex
+	args    Arguments
+	clients *clientSet
+
+	receiver *logs.Receiver
+}
+
+// clientSet is the set of clients created by a single call to Update.
+type clientSet struct {
+	clients []client.Client
+
+	// inflight tracks calls to Receive which are sending to the set. Clients
+	// must not be stopped until all in-flight sends complete, since sending
+	// to a stopped client panics.
+	inflight sync.WaitGroup
+}
+
+// stop waits for in-flight sends to complete and then stops all clients. If
+// now is true, clients will not retry sending their pending batches.
+func (cs *clientSet) stop(now bool) {
+	cs.inflight.Wait()
+
+	for _, cl := range cs.clients {
+		if now {
+			cl.StopNow()
+		} else {
+			cl.Stop()
+		}
+	}
+}
+
+var (
+	_ component.Component  = (*Component)(nil)
+	_ prometheus.Collector = (*Component)(nil)
+)
+
+// New creates a new logs.write.loki component.
+func New(o component.Options, args Arguments) (*Component, error) {
+	reg := metrics.NewCollectorRegistry()
+
+	c := &Component{
+		opts:    o,
+		reg:     reg,
+		metrics: client.NewMetrics(reg, nil),
+		clients: &clientSet{},
+	}
+	c.receiver = &logs.Receiver{Receive: c.Receive}
+
+	if err := c.Update(args); err != nil {
+		return nil, err
+	}
+
+	o.OnStateChange(Exports{Receiver: c.receiver})
+	return c, nil
+}
+
+// Run implements component.Component.
+func (c *Component) Run(ctx context.Context) error {
+	<-ctx.Done()
+
+	c.mut.Lock()
+	old := c.clients
+	c.clients = &clientSet{}
+	c.mut.Unlock()
+
+	// Try to flush pending batches once before exiting, without retrying
+	// failed sends so shutdown isn't blocked by an unavailable endpoint.
+	old.stop(true)
+	return nil
+}
+
+// Update implements component.Component.
+func (c *Component) Update(args component.Arguments) error {
+	newArgs := args.(Arguments)
+
+	externalLabels := make(model.LabelSet, len(newArgs.ExternalLabels))
+	for k, v := range newArgs.ExternalLabels {
+		externalLabels[model.LabelName(k)] = model.LabelValue(v)
+	}
+	if err := externalLabels.Validate(); err != nil {
+		return fmt.Errorf("invalid external_labels: %w", err)
+	}
+
+	clients := make([]client.Client, 0, len(newArgs.Endpoints))
+	for _, ep := range newArgs.Endpoints {
+		cfg, err := ep.clientConfig(externalLabels)
+		if err != nil {
+			(&clientSet{clients: clients}).stop(true)
+			return err
+		}
+
+		cl, err := client.New(c.metrics, cfg, nil, log.With(c.opts.Logger, "endpoint", ep.URL))
+		if err != nil {
+			(&clientSet{clients: clients}).stop(true)
+			return fmt.Errorf("failed to create client for %s: %w", ep.URL, err)
+		}
+		clients = append(clients, cl)
+	}
+
+	c.mut.Lock()
+	old := c.clients
+	c.args = newArgs
+	c.clients = &clientSet{clients: clients}
+	c.mut.Unlock()
+
+	// Old clients are stopped outside of the lock: stopping waits for them to
+	// flush their pending batches, and Receive may be blocked sending to them.
+	old.stop(false)
+	return nil
+}
+
+func (ec *EndpointConfig) clientConfig(externalLabels model.LabelSet) (client.Config, error) {
+	u, err := url.Parse(ec.URL)
+	if err != nil {
+		return client.Config{}, fmt.Errorf("cannot parse endpoint url %q: %w", ec.URL, err)
+	}
+
+	httpClient := common.DefaultHTTPClientConfig
+	if ec.BasicAuth != nil {
+		httpClient.BasicAuth = &common.BasicAuth{
+			Username: ec.BasicAuth.Username,
+			Password: common.Secret(ec.BasicAuth.Password),
+		}
+	}
+	if ec.BearerToken != "" {
+		httpClient.Authorization = &common.Authorization{
+			Type:        "Bearer",
+			Credentials: common.Secret(ec.BearerToken),
+		}
+	}
+
+	return client.Config{
+		Name:      ec.Name,
+		URL:       flagext.URLValue{URL: u},
+		BatchWait: ec.BatchWait,
+		BatchSize: ec.BatchSize,
+		Client:    httpClient,
+		BackoffConfig: backoff.Config{
+			MinBackoff: ec.MinBackoff,
+			MaxBackoff: ec.MaxBackoff,
+			MaxRetries: ec.MaxRetries,
+		},
+		ExternalLabels: lokiflag.LabelSet{LabelSet: externalLabels},
+		Timeout:        ec.RemoteTimeout,
+		TenantID:       ec.TenantID,
+	}, nil
+}
+
+// Receive queues entries to be sent to all configured endpoints.
+func (c *Component) Receive(entries []logs.Entry) {
+	// Sending may block, so only hold the lock long enough to mark the send
+	// as in-flight; Update would otherwise be blocked on a slow endpoint.
+	c.mut.RLock()
+	cs := c.clients
+	cs.inflight.Add(1)
+	c.mut.RUnlock()
+	defer cs.inflight.Done()
+
+	for _, e := range entries {
+		for _, cl := range cs.clients {
+			cl.Chan() <- e
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Component) Describe(ch chan<- *prometheus.Desc) {
+	c.reg.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Component) Collect(ch chan<- prometheus.Metric) {
+	c.reg.Collect(ch)
+}
@@ -0,0 +1,142 @@
+package loki
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/logs"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/prometheus/common/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoki(t *testing.T) {
+	type push struct {
+		tenant string
+		req    logproto.PushRequest
+	}
+	pushes := make(chan push, 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		buf, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+
+		var req logproto.PushRequest
+		require.NoError(t, proto.Unmarshal(buf, &req))
+		pushes <- push{tenant: r.Header.Get("X-Scope-OrgID"), req: req}
+	}))
+	defer srv.Close()
+
+	ep := DefaultEndpointConfig
+	ep.URL = srv.URL + "/loki/api/v1/push"
+	ep.TenantID = "tenant-a"
+	ep.BatchWait = 10 * time.Millisecond
+
+	c, err := New(component.Options{
+		ID:            "logs.write.loki.test",
+		Logger:        log.NewNopLogger(),
+		DataPath:      t.TempDir(),
+		OnStateChange: func(e component.Exports) {},
+	}, Arguments{
+		ExternalLabels: map[string]string{"cluster": "test"},
+		Endpoints:      []*EndpointConfig{&ep},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = c.Run(ctx) }()
+
+	ts := time.Unix(100, 0).UTC()
+	c.Receive([]logs.Entry{{
+		Labels: model.LabelSet{"job": "test"},
+		Entry:  logproto.Entry{Timestamp: ts, Line: "hello"},
+	}})
+
+	select {
+	case p := <-pushes:
+		require.Equal(t, "tenant-a", p.tenant)
+		require.Len(t, p.req.Streams, 1)
+		require.Equal(t, `{cluster="test", job="test"}`, p.req.Streams[0].Labels)
+		require.Equal(t, []logproto.Entry{{Timestamp: ts, Line: "hello"}}, p.req.Streams[0].Entries)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "timed out waiting for push")
+	}
+}
+
+func TestLoki_UpdateWhileReceiving(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+	}))
+	defer srv.Close()
+
+	ep := DefaultEndpointConfig
+	ep.URL = srv.URL + "/loki/api/v1/push"
+	ep.BatchWait = 10 * time.Millisecond
+	args := Arguments{Endpoints: []*EndpointConfig{&ep}}
+
+	c, err := New(component.Options{
+		ID:            "logs.write.loki.test",
+		Logger:        log.NewNopLogger(),
+		DataPath:      t.TempDir(),
+		OnStateChange: func(e component.Exports) {},
+	}, args)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	runExited := make(chan struct{})
+	go func() {
+		defer close(runExited)
+		_ = c.Run(ctx)
+	}()
+
+	done := make(chan struct{})
+	received := make(chan struct{})
+	go func() {
+		defer close(received)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				c.Receive([]logs.Entry{{
+					Labels: model.LabelSet{"job": "test"},
+					Entry:  logproto.Entry{Timestamp: time.Now(), Line: "hello"},
+				}})
+			}
+		}
+	}()
+
+	updated := make(chan error)
+	go func() {
+		defer close(updated)
+		for i := 0; i < 10; i++ {
+			if err := c.Update(args); err != nil {
+				updated <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-updated:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		require.FailNow(t, "timed out updating while receiving")
+	}
+
+	close(done)
+	<-received
+	cancel()
+	<-runExited
+}
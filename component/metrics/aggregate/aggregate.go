@@ -0,0 +1,253 @@
+// Package aggregate implements the metrics.aggregate component, which
+// aggregates incoming series into rollups before forwarding them.
+package aggregate
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/rfratto/gohcl"
+)
+
+func init() {
+	component.Register(component.Registration{
+		Name:    "metrics.aggregate",
+		Args:    Arguments{},
+		Exports: Exports{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			return New(opts, args.(Arguments))
+		},
+	})
+}
+
+// Arguments holds values which are used to configure the metrics.aggregate
+// component.
+type Arguments struct {
+	// Interval is how often aggregated series are sent to ForwardTo.
+	Interval time.Duration `hcl:"interval,optional"`
+	// StalenessTimeout is how long an input series may go without a sample
+	// before it is removed from its aggregation.
+	StalenessTimeout time.Duration `hcl:"staleness_timeout,optional"`
+
+	ForwardTo []*metrics.Receiver `hcl:"forward_to"`
+	Rules     []Rule              `hcl:"rule,block"`
+}
+
+// Rule describes a single aggregation.
+type Rule struct {
+	// MetricName is a regular expression matched against the metric name of
+	// incoming series.
+	MetricName string `hcl:"metric_name"`
+	// Operation is the aggregation to perform.
+	Operation Operation `hcl:"operation"`
+	// By and Without are mutually exclusive lists of labels to group by or to
+	// remove from the output. When neither is set, all series are aggregated
+	// into a single output series.
+	By      []string `hcl:"by,optional"`
+	Without []string `hcl:"without,optional"`
+	// OutputName is the metric name of the output series. Defaults to
+	// <metric_name>:<operation>.
+	OutputName string `hcl:"output_name,optional"`
+	// Counter marks the input series as counters. Summing counters adds up
+	// the increases of each input after its first sample, so the output stays
+	// monotonic across counter resets and inputs which disappear or return.
+	Counter bool `hcl:"counter,optional"`
+	// KeepInput forwards the input series in addition to the aggregated
+	// output. Otherwise, matched series are dropped.
+	KeepInput bool `hcl:"keep_input,optional"`
+}
+
+// DefaultArguments provides the default arguments for the metrics.aggregate
+// component.
+var DefaultArguments = Arguments{
+	Interval:         time.Minute,
+	StalenessTimeout: 5 * time.Minute,
+}
+
+var _ gohcl.Decoder = (*Arguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (a *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*a = DefaultArguments
+
+	type arguments Arguments
+	return gohcl.DecodeBody(body, ctx, (*arguments)(a))
+}
+
+// Validate returns an error if a is invalid.
+func (a *Arguments) Validate() error {
+	if a.Interval <= 0 {
+		return fmt.Errorf("interval must be greater than 0")
+	}
+	if a.StalenessTimeout <= 0 {
+		return fmt.Errorf("staleness_timeout must be greater than 0")
+	}
+
+	for i, r := range a.Rules {
+		if r.MetricName == "" {
+			return fmt.Errorf("rule %d: metric_name must not be empty", i)
+		}
+		if r.Operation == "" {
+			return fmt.Errorf("rule %d: operation must not be empty", i)
+		}
+		if len(r.By) > 0 && len(r.Without) > 0 {
+			return fmt.Errorf("rule %d: at most one of by and without may be set", i)
+		}
+		if r.OutputName != "" && !model.IsValidMetricName(model.LabelValue(r.OutputName)) {
+			return fmt.Errorf("rule %d: %q is not a valid output_name", i, r.OutputName)
+		}
+		if r.OutputName == "" && !model.IsValidMetricName(model.LabelValue(r.MetricName)) {
+			return fmt.Errorf("rule %d: output_name must be set when metric_name is not a literal metric name", i)
+		}
+	}
+	return nil
+}
+
+// Exports holds values which are exported by the metrics.aggregate component.
+type Exports struct {
+	Receiver *metrics.Receiver `hcl:"receiver"`
+}
+
+// Component implements the metrics.aggregate component.
+type Component struct {
+	opts     component.Options
+	receiver *metrics.Receiver
+
+	mut         sync.Mutex
+	args        Arguments
+	aggregators []*aggregator
+}
+
+var (
+	_ component.Component = (*Component)(nil)
+)
+
+// New creates a new metrics.aggregate component.
+func New(o component.Options, args Arguments) (*Component, error) {
+	c := &Component{opts: o}
+	c.receiver = &metrics.Receiver{Receive: c.Receive}
+
+	if err := c.Update(args); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run implements component.Component.
+func (c *Component) Run(ctx context.Context) error {
+	c.opts.OnStateChange(Exports{Receiver: c.receiver})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.opts.Clock.After(c.getInterval()):
+			c.flush(c.opts.Clock.Now())
+		}
+	}
+}
+
+func (c *Component) getInterval() time.Duration {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.args.Interval
+}
+
+// Receive implements the receiver.receive func that allows an array of
+// metrics to be passed. Series which aren't consumed by a rule are forwarded
+// immediately.
+func (c *Component) Receive(ts int64, metricArr []*metrics.FlowMetric) {
+	now := c.opts.Clock.Now()
+
+	c.mut.Lock()
+	forward := make([]*metrics.FlowMetric, 0, len(metricArr))
+	for _, m := range metricArr {
+		id := m.GlobalRefID
+		if id == 0 {
+			id = metrics.GlobalRefMapping.GetOrAddGlobalRefID(m.Labels)
+		}
+
+		var consumed bool
+		for _, a := range c.aggregators {
+			if !a.Matches(m) {
+				continue
+			}
+			a.Observe(id, m, now)
+			consumed = consumed || !a.rule.KeepInput
+		}
+
+		if !consumed {
+			forward = append(forward, m)
+		}
+	}
+	receivers := c.args.ForwardTo
+	c.mut.Unlock()
+
+	if len(forward) > 0 {
+		send(receivers, ts, forward)
+	}
+}
+
+// flush sends the current aggregated values of all rules.
+func (c *Component) flush(now time.Time) {
+	c.mut.Lock()
+	var (
+		staleBefore = now.Add(-c.args.StalenessTimeout)
+		out         []*metrics.FlowMetric
+	)
+	for _, a := range c.aggregators {
+		out = append(out, a.Flush(staleBefore)...)
+	}
+	receivers := c.args.ForwardTo
+	c.mut.Unlock()
+
+	if len(out) > 0 {
+		send(receivers, timestamp.FromTime(now), out)
+	}
+}
+
+func send(receivers []*metrics.Receiver, ts int64, metricArr []*metrics.FlowMetric) {
+	for _, r := range receivers {
+		if r == nil || r.Receive == nil {
+			continue
+		}
+		r.Receive(ts, metricArr)
+	}
+}
+
+// Update implements component.Component.
+func (c *Component) Update(args component.Arguments) error {
+	newArgs := args.(Arguments)
+	if err := newArgs.Validate(); err != nil {
+		return err
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	// Only rebuild the aggregators (discarding their state) if the rules
+	// changed.
+	if c.aggregators == nil || !reflect.DeepEqual(c.args.Rules, newArgs.Rules) {
+		aggregators := make([]*aggregator, 0, len(newArgs.Rules))
+		for i, r := range newArgs.Rules {
+			a, err := newAggregator(r)
+			if err != nil {
+				return fmt.Errorf("rule %d: invalid metric_name: %w", i, err)
+			}
+			aggregators = append(aggregators, a)
+		}
+		c.aggregators = aggregators
+	}
+
+	c.args = newArgs
+	return nil
+}
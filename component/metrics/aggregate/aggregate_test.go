@@ -0,0 +1,217 @@
+package aggregate
+
+import (
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregate_SumBy(t *testing.T) {
+	c, rec := newTestComponent(t, Rule{
+		MetricName: "requests_total",
+		Operation:  OperationSum,
+		By:         []string{"deployment"},
+	})
+
+	c.Receive(1000, []*metrics.FlowMetric{
+		{Labels: labels.FromStrings("__name__", "requests_total", "deployment", "a", "pod", "a-1"), Value: 1},
+		{Labels: labels.FromStrings("__name__", "requests_total", "deployment", "a", "pod", "a-2"), Value: 2},
+		{Labels: labels.FromStrings("__name__", "requests_total", "deployment", "b", "pod", "b-1"), Value: 5},
+		{Labels: labels.FromStrings("__name__", "other_metric", "pod", "a-1"), Value: 10},
+	})
+
+	// Only unmatched series are forwarded immediately.
+	require.Equal(t, []sample{
+		{labels: `{__name__="other_metric", pod="a-1"}`, value: 10},
+	}, rec.take())
+
+	c.flush(time.Now())
+	require.Equal(t, []sample{
+		{labels: `{__name__="requests_total:sum", deployment="a"}`, value: 3},
+		{labels: `{__name__="requests_total:sum", deployment="b"}`, value: 5},
+	}, rec.take())
+}
+
+func TestAggregate_Operations(t *testing.T) {
+	inputs := []*metrics.FlowMetric{
+		{Labels: labels.FromStrings("__name__", "temp", "room", "a"), Value: 1},
+		{Labels: labels.FromStrings("__name__", "temp", "room", "b"), Value: 4},
+		{Labels: labels.FromStrings("__name__", "temp", "room", "c"), Value: 7},
+	}
+
+	tt := map[Operation]float64{
+		OperationSum:   12,
+		OperationCount: 3,
+		OperationMin:   1,
+		OperationMax:   7,
+		OperationAvg:   4,
+	}
+	for op, expect := range tt {
+		t.Run(string(op), func(t *testing.T) {
+			c, rec := newTestComponent(t, Rule{
+				MetricName: "temp",
+				Operation:  op,
+				Without:    []string{"room"},
+				OutputName: "temp_total",
+			})
+			c.Receive(1000, inputs)
+			c.flush(time.Now())
+
+			require.Equal(t, []sample{{labels: `{__name__="temp_total"}`, value: expect}}, rec.take())
+		})
+	}
+}
+
+func TestAggregate_CounterResets(t *testing.T) {
+	c, rec := newTestComponent(t, Rule{
+		MetricName: "requests_total",
+		Operation:  OperationSum,
+		Counter:    true,
+	})
+
+	var (
+		podA = labels.FromStrings("__name__", "requests_total", "pod", "a")
+		podB = labels.FromStrings("__name__", "requests_total", "pod", "b")
+	)
+
+	// Only increases after the first sample of each input are counted.
+	c.Receive(1000, []*metrics.FlowMetric{{Labels: podA, Value: 10}, {Labels: podB, Value: 20}})
+	c.flush(time.Now())
+	require.Equal(t, []sample{{labels: `{__name__="requests_total:sum"}`, value: 0}}, rec.take())
+
+	// Pod a restarts and its counter resets.
+	c.Receive(2000, []*metrics.FlowMetric{{Labels: podA, Value: 2}, {Labels: podB, Value: 25}})
+	c.flush(time.Now())
+	require.Equal(t, []sample{{labels: `{__name__="requests_total:sum"}`, value: 7}}, rec.take())
+
+	// Pod b goes away; the sum must not decrease.
+	c.Receive(3000, []*metrics.FlowMetric{{Labels: podA, Value: 3}, {Labels: podB, Value: math.Float64frombits(value.StaleNaN)}})
+	c.flush(time.Now())
+	require.Equal(t, []sample{{labels: `{__name__="requests_total:sum"}`, value: 8}}, rec.take())
+}
+
+func TestAggregate_CounterReturns(t *testing.T) {
+	c, rec := newTestComponent(t, Rule{
+		MetricName: "requests_total",
+		Operation:  OperationSum,
+		Counter:    true,
+	})
+
+	var (
+		podA = labels.FromStrings("__name__", "requests_total", "pod", "a")
+		podB = labels.FromStrings("__name__", "requests_total", "pod", "b")
+	)
+
+	c.Receive(1000, []*metrics.FlowMetric{{Labels: podA, Value: 10}, {Labels: podB, Value: 20}})
+	c.Receive(2000, []*metrics.FlowMetric{{Labels: podA, Value: 11}, {Labels: podB, Value: 22}})
+	c.flush(time.Now())
+	require.Equal(t, []sample{{labels: `{__name__="requests_total:sum"}`, value: 3}}, rec.take())
+
+	// Pod b goes stale and then returns. Its value when it returns must not be
+	// counted again.
+	c.Receive(3000, []*metrics.FlowMetric{{Labels: podA, Value: 12}, {Labels: podB, Value: math.Float64frombits(value.StaleNaN)}})
+	c.flush(time.Now())
+	require.Equal(t, []sample{{labels: `{__name__="requests_total:sum"}`, value: 4}}, rec.take())
+
+	c.Receive(4000, []*metrics.FlowMetric{{Labels: podA, Value: 12}, {Labels: podB, Value: 30}})
+	c.flush(time.Now())
+	require.Equal(t, []sample{{labels: `{__name__="requests_total:sum"}`, value: 4}}, rec.take())
+
+	c.Receive(5000, []*metrics.FlowMetric{{Labels: podA, Value: 13}, {Labels: podB, Value: 31}})
+	c.flush(time.Now())
+	require.Equal(t, []sample{{labels: `{__name__="requests_total:sum"}`, value: 6}}, rec.take())
+}
+
+func TestAggregate_Staleness(t *testing.T) {
+	c, rec := newTestComponent(t, Rule{
+		MetricName: "requests_total",
+		Operation:  OperationSum,
+	})
+
+	podA := labels.FromStrings("__name__", "requests_total", "pod", "a")
+	c.Receive(1000, []*metrics.FlowMetric{{Labels: podA, Value: 10}})
+	c.Receive(2000, []*metrics.FlowMetric{{Labels: podA, Value: math.Float64frombits(value.StaleNaN)}})
+
+	// The output series is marked stale once it has no more inputs, and then
+	// is no longer emitted.
+	c.flush(time.Now())
+	out := rec.take()
+	require.Len(t, out, 1)
+	require.True(t, value.IsStaleNaN(out[0].value))
+
+	c.flush(time.Now())
+	require.Empty(t, rec.take())
+
+	// Inputs that stop receiving samples are removed after the staleness
+	// timeout.
+	c.Receive(3000, []*metrics.FlowMetric{{Labels: podA, Value: 10}})
+	c.flush(time.Now().Add(DefaultArguments.StalenessTimeout + time.Second))
+	out = rec.take()
+	require.Len(t, out, 1)
+	require.True(t, value.IsStaleNaN(out[0].value))
+}
+
+func TestArguments_Validate(t *testing.T) {
+	args := DefaultArguments
+	args.Rules = []Rule{{MetricName: "a", Operation: OperationSum, By: []string{"a"}, Without: []string{"b"}}}
+	require.EqualError(t, args.Validate(), "rule 0: at most one of by and without may be set")
+
+	args.Rules = []Rule{{MetricName: "a_.*", Operation: OperationSum}}
+	require.EqualError(t, args.Validate(), "rule 0: output_name must be set when metric_name is not a literal metric name")
+}
+
+type sample struct {
+	labels string
+	value  float64
+}
+
+// recorder is a metrics.Receiver which records all received samples.
+type recorder struct {
+	mut     sync.Mutex
+	samples []sample
+}
+
+func (r *recorder) receive(_ int64, metricArr []*metrics.FlowMetric) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for _, m := range metricArr {
+		r.samples = append(r.samples, sample{labels: m.Labels.String(), value: m.Value})
+	}
+}
+
+func (r *recorder) take() []sample {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	res := r.samples
+	r.samples = nil
+	return res
+}
+
+func newTestComponent(t *testing.T, rules ...Rule) (*Component, *recorder) {
+	t.Helper()
+
+	rec := &recorder{}
+
+	args := DefaultArguments
+	args.Rules = rules
+	args.ForwardTo = []*metrics.Receiver{{Receive: rec.receive}}
+
+	c, err := New(component.Options{
+		ID:            "metrics.aggregate.test",
+		Logger:        log.NewNopLogger(),
+		DataPath:      t.TempDir(),
+		OnStateChange: func(e component.Exports) {},
+		Clock:         clock.New(),
+	}, args)
+	require.NoError(t, err)
+	return c, rec
+}
@@ -0,0 +1,221 @@
+package aggregate
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/grafana/agent/component/metrics"
+	"github.com/grafana/regexp"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/model/value"
+)
+
+// aggregator holds the in-memory state for a single aggregation rule.
+type aggregator struct {
+	rule       Rule
+	nameRegex  *regexp.Regexp
+	outputName string
+	metadata   *metrics.Metadata // Metadata of the output series.
+
+	groups map[string]*group // Groups by the string form of their labels.
+	inputs map[uint64]*input // Inputs by GlobalRefID.
+}
+
+// group is a single output series of an aggregator.
+type group struct {
+	labels   labels.Labels
+	globalID uint64
+	inputs   map[uint64]*input
+
+	// total is the sum of the increases of all counter inputs since they were
+	// first seen. Increases are kept when inputs go away, so summing counters
+	// stays monotonic.
+	total float64
+}
+
+// input is the state of a single series which feeds into a group.
+type input struct {
+	group   *group
+	value   float64 // Most recent raw value.
+	updated time.Time
+}
+
+func newAggregator(r Rule) (*aggregator, error) {
+	re, err := regexp.Compile("^(?:" + r.MetricName + ")$")
+	if err != nil {
+		return nil, err
+	}
+
+	outputName := r.OutputName
+	if outputName == "" {
+		outputName = r.MetricName + ":" + string(r.Operation)
+	}
+
+	// Summing counters produces a counter; every other aggregation can go up
+	// and down.
+	metadata := &metrics.Metadata{Type: textparse.MetricTypeGauge}
+	if r.Counter && r.Operation == OperationSum {
+		metadata.Type = textparse.MetricTypeCounter
+	}
+
+	return &aggregator{
+		rule:       r,
+		nameRegex:  re,
+		outputName: outputName,
+		metadata:   metadata,
+
+		groups: make(map[string]*group),
+		inputs: make(map[uint64]*input),
+	}, nil
+}
+
+// Matches returns true if the aggregator should process m.
+func (a *aggregator) Matches(m *metrics.FlowMetric) bool {
+	return a.nameRegex.MatchString(m.Labels.Get(labels.MetricName))
+}
+
+// Observe records a new sample for the series identified by id.
+func (a *aggregator) Observe(id uint64, m *metrics.FlowMetric, now time.Time) {
+	if value.IsStaleNaN(m.Value) {
+		if in, ok := a.inputs[id]; ok {
+			a.removeInput(id, in)
+		}
+		return
+	}
+
+	in, ok := a.inputs[id]
+	if !ok {
+		// The first sample of a counter is only used as the starting point for
+		// counting its increases. This includes inputs which went away and came
+		// back, whose earlier increases have already been counted.
+		g := a.getOrCreateGroup(m.Labels)
+		in = &input{group: g, value: m.Value}
+		g.inputs[id] = in
+		a.inputs[id] = in
+	}
+
+	if a.rule.Counter {
+		increase := m.Value - in.value
+		if increase < 0 {
+			// The counter reset, so it increased by its whole new value.
+			increase = m.Value
+		}
+		in.group.total += increase
+	}
+	in.value = m.Value
+	in.updated = now
+}
+
+func (a *aggregator) getOrCreateGroup(ls labels.Labels) *group {
+	out := a.groupLabels(ls)
+	key := out.String()
+
+	g, ok := a.groups[key]
+	if !ok {
+		g = &group{
+			labels:   out,
+			globalID: metrics.GlobalRefMapping.GetOrAddGlobalRefID(out),
+			inputs:   make(map[uint64]*input),
+		}
+		a.groups[key] = g
+	}
+	return g
+}
+
+// groupLabels returns the labels of the output series that ls belongs to.
+func (a *aggregator) groupLabels(ls labels.Labels) labels.Labels {
+	var lb *labels.Builder
+
+	if len(a.rule.Without) > 0 {
+		lb = labels.NewBuilder(ls)
+		lb.Del(a.rule.Without...)
+	} else {
+		// Keep only the labels from By. This drops all labels when By is empty.
+		lb = labels.NewBuilder(nil)
+		for _, name := range a.rule.By {
+			if v := ls.Get(name); v != "" {
+				lb.Set(name, v)
+			}
+		}
+	}
+
+	lb.Set(labels.MetricName, a.outputName)
+	return lb.Labels()
+}
+
+func (a *aggregator) removeInput(id uint64, in *input) {
+	delete(in.group.inputs, id)
+	delete(a.inputs, id)
+}
+
+// Flush removes inputs which haven't been updated since staleBefore and
+// returns the aggregated value of every group. Groups which no longer have
+// any inputs are emitted a final time with a stale marker and then removed.
+func (a *aggregator) Flush(staleBefore time.Time) []*metrics.FlowMetric {
+	for id, in := range a.inputs {
+		if in.updated.Before(staleBefore) {
+			a.removeInput(id, in)
+		}
+	}
+
+	res := make([]*metrics.FlowMetric, 0, len(a.groups))
+	for key, g := range a.groups {
+		v := math.Float64frombits(value.StaleNaN)
+		if len(g.inputs) > 0 {
+			v = a.compute(g)
+		} else {
+			delete(a.groups, key)
+		}
+
+		res = append(res, &metrics.FlowMetric{
+			GlobalRefID: g.globalID,
+			Labels:      g.labels,
+			Value:       v,
+			Metadata:    a.metadata,
+		})
+	}
+
+	// Sort for predictable output.
+	sort.Slice(res, func(i, j int) bool { return labels.Compare(res[i].Labels, res[j].Labels) < 0 })
+	return res
+}
+
+func (a *aggregator) compute(g *group) float64 {
+	switch a.rule.Operation {
+	case OperationCount:
+		return float64(len(g.inputs))
+
+	case OperationMin:
+		res := math.Inf(1)
+		for _, in := range g.inputs {
+			res = math.Min(res, in.value)
+		}
+		return res
+
+	case OperationMax:
+		res := math.Inf(-1)
+		for _, in := range g.inputs {
+			res = math.Max(res, in.value)
+		}
+		return res
+
+	case OperationAvg:
+		var sum float64
+		for _, in := range g.inputs {
+			sum += in.value
+		}
+		return sum / float64(len(g.inputs))
+
+	default: // OperationSum
+		if a.rule.Counter {
+			return g.total
+		}
+		var sum float64
+		for _, in := range g.inputs {
+			sum += in.value
+		}
+		return sum
+	}
+}
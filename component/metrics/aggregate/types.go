@@ -0,0 +1,39 @@
+package aggregate
+
+import (
+	"encoding"
+	"fmt"
+)
+
+// Operation is an aggregation operation to apply to a group of series.
+type Operation string
+
+// Supported aggregation operations.
+const (
+	OperationSum   Operation = "sum"
+	OperationCount Operation = "count"
+	OperationMin   Operation = "min"
+	OperationMax   Operation = "max"
+	OperationAvg   Operation = "avg"
+)
+
+var (
+	_ encoding.TextMarshaler   = Operation("")
+	_ encoding.TextUnmarshaler = (*Operation)(nil)
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (op Operation) MarshalText() (text []byte, err error) {
+	return []byte(op), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (op *Operation) UnmarshalText(text []byte) error {
+	switch Operation(text) {
+	case OperationSum, OperationCount, OperationMin, OperationMax, OperationAvg:
+		*op = Operation(text)
+	default:
+		return fmt.Errorf("unrecognized operation %q, expected one of sum, count, min, max, avg", string(text))
+	}
+	return nil
+}
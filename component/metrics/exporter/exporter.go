@@ -0,0 +1,520 @@
+// Package exporter implements the metrics.exporter component, which exposes
+// the metrics it receives as a Prometheus /metrics endpoint.
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/golang/protobuf/proto"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/grafana/agent/component/metrics/internal/httpserver"
+	"github.com/grafana/regexp"
+	"github.com/hashicorp/hcl/v2"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/rfratto/gohcl"
+)
+
+func init() {
+	component.Register(component.Registration{
+		Name:    "metrics.exporter",
+		Args:    Arguments{},
+		Exports: Exports{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			return New(opts, args.(Arguments))
+		},
+	})
+}
+
+// Arguments holds values which are used to configure the metrics.exporter
+// component.
+type Arguments struct {
+	// ListenAddress is the host:port to serve metrics on.
+	ListenAddress string `hcl:"listen_address,optional"`
+	// MetricsPath is the HTTP path to serve metrics on.
+	MetricsPath string `hcl:"metrics_path,optional"`
+	// IncludeMetricNames is an optional list of regular expressions. When set,
+	// only series whose metric name fully matches one of them are exposed.
+	IncludeMetricNames []string `hcl:"include_metric_names,optional"`
+	// StalenessTimeout is how long a series may go without receiving a sample
+	// before it is no longer exposed.
+	StalenessTimeout time.Duration `hcl:"staleness_timeout,optional"`
+}
+
+// DefaultArguments provides the default arguments for the metrics.exporter
+// component.
+var DefaultArguments = Arguments{
+	ListenAddress:    "127.0.0.1:9091",
+	MetricsPath:      "/metrics",
+	StalenessTimeout: 5 * time.Minute,
+}
+
+var _ gohcl.Decoder = (*Arguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (a *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*a = DefaultArguments
+
+	type arguments Arguments
+	if err := gohcl.DecodeBody(body, ctx, (*arguments)(a)); err != nil {
+		return err
+	}
+	return a.Validate()
+}
+
+// Validate returns an error if a is invalid.
+func (a *Arguments) Validate() error {
+	if a.ListenAddress == "" {
+		return fmt.Errorf("listen_address must not be empty")
+	}
+	if !strings.HasPrefix(a.MetricsPath, "/") {
+		return fmt.Errorf("metrics_path must start with /")
+	}
+	if a.StalenessTimeout <= 0 {
+		return fmt.Errorf("staleness_timeout must be greater than 0")
+	}
+	return nil
+}
+
+// Exports holds values which are exported by the metrics.exporter component.
+type Exports struct {
+	Receiver *metrics.Receiver `hcl:"receiver"`
+}
+
+// series is the latest sample of a received series.
+type series struct {
+	labels    labels.Labels
+	value     float64
+	timestamp int64
+	metadata  *metrics.Metadata
+	updated   time.Time
+}
+
+// Component implements the metrics.exporter component.
+type Component struct {
+	opts     component.Options
+	receiver *metrics.Receiver
+	server   *httpserver.Server
+
+	mut     sync.RWMutex
+	args    Arguments
+	include []*regexp.Regexp
+
+	seriesMut sync.RWMutex
+	series    map[uint64]*series
+}
+
+var (
+	_ component.Component       = (*Component)(nil)
+	_ component.HealthComponent = (*Component)(nil)
+)
+
+// New creates a new metrics.exporter component.
+func New(o component.Options, args Arguments) (*Component, error) {
+	c := &Component{
+		opts:   o,
+		series: make(map[uint64]*series),
+	}
+	c.receiver = &metrics.Receiver{Receive: c.Receive}
+	c.server = httpserver.New(o.Logger, o.Clock, "metrics", c.handler())
+
+	if err := c.Update(args); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run implements component.Component.
+func (c *Component) Run(ctx context.Context) error {
+	c.opts.OnStateChange(Exports{Receiver: c.receiver})
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = c.server.Run(ctx)
+	}()
+
+	gcTicker := c.opts.Clock.Ticker(time.Minute)
+	defer gcTicker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-gcTicker.C:
+			c.removeExpired(c.opts.Clock.Now())
+		}
+	}
+}
+
+// Receive implements the receiver.receive func that allows an array of
+// metrics to be passed.
+func (c *Component) Receive(ts int64, metricArr []*metrics.FlowMetric) {
+	now := c.opts.Clock.Now()
+
+	c.seriesMut.Lock()
+	defer c.seriesMut.Unlock()
+
+	for _, m := range metricArr {
+		id := m.GlobalRefID
+		if id == 0 {
+			id = metrics.GlobalRefMapping.GetOrAddGlobalRefID(m.Labels)
+		}
+
+		// A stale marker means the series went away; stop exposing it
+		// immediately rather than waiting for the staleness timeout.
+		if value.IsStaleNaN(m.Value) {
+			delete(c.series, id)
+			continue
+		}
+
+		if existing, ok := c.series[id]; ok && existing.timestamp > ts {
+			// Ignore out-of-order samples; we only expose the latest value.
+			continue
+		}
+		c.series[id] = &series{
+			labels:    m.Labels,
+			value:     m.Value,
+			timestamp: ts,
+			metadata:  m.Metadata,
+			updated:   now,
+		}
+	}
+}
+
+// removeExpired removes series which haven't been updated within the
+// staleness timeout.
+func (c *Component) removeExpired(now time.Time) {
+	c.mut.RLock()
+	timeout := c.args.StalenessTimeout
+	c.mut.RUnlock()
+
+	c.seriesMut.Lock()
+	defer c.seriesMut.Unlock()
+
+	for id, s := range c.series {
+		if now.Sub(s.updated) > timeout {
+			delete(c.series, id)
+		}
+	}
+}
+
+// handler returns an http.Handler which writes the current set of series in
+// the format negotiated with the client.
+func (c *Component) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mut.RLock()
+		path := c.args.MetricsPath
+		c.mut.RUnlock()
+
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+
+		format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+		w.Header().Set("Content-Type", string(format))
+
+		enc := expfmt.NewEncoder(w, format)
+		for _, mf := range c.gather(c.opts.Clock.Now()) {
+			if err := enc.Encode(mf); err != nil {
+				level.Error(c.opts.Logger).Log("msg", "failed to encode metrics", "err", err)
+				return
+			}
+		}
+		if closer, ok := enc.(expfmt.Closer); ok {
+			_ = closer.Close()
+		}
+	})
+}
+
+// gather converts the current set of series into metric families sorted by
+// name. Series without metadata are exposed as untyped. The series of
+// histograms and summaries are combined into a single metric per label set.
+func (c *Component) gather(now time.Time) []*dto.MetricFamily {
+	c.mut.RLock()
+	var (
+		timeout = c.args.StalenessTimeout
+		include = c.include
+	)
+	c.mut.RUnlock()
+
+	c.seriesMut.RLock()
+	defer c.seriesMut.RUnlock()
+
+	var (
+		families = make(map[string]*dto.MetricFamily)
+		// Histograms and summaries by family name and labels, excluding the
+		// bucket or quantile label.
+		compositeMetrics = make(map[string]*dto.Metric)
+	)
+	for _, s := range c.series {
+		if now.Sub(s.updated) > timeout {
+			continue
+		}
+
+		name := s.labels.Get(labels.MetricName)
+		if name == "" || !includeName(include, name) {
+			continue
+		}
+
+		var (
+			typ          = metricType(s.metadata)
+			family, part = name, ""
+			composite    = typ == dto.MetricType_HISTOGRAM || typ == dto.MetricType_SUMMARY
+		)
+		if composite {
+			var ok bool
+			family, part, ok = splitCompositeName(s.labels, typ)
+			if !ok {
+				// The series doesn't fit the metric family, so expose it on its
+				// own.
+				family, typ, composite = name, dto.MetricType_UNTYPED, false
+			}
+		}
+
+		mf, ok := families[family]
+		if !ok {
+			mf = &dto.MetricFamily{
+				Name: proto.String(family),
+				Type: typ.Enum(),
+			}
+			if s.metadata != nil && s.metadata.Help != "" {
+				mf.Help = proto.String(s.metadata.Help)
+			}
+			families[family] = mf
+		} else if mf.GetType() != typ {
+			level.Debug(c.opts.Logger).Log("msg", "skipping series with conflicting metric type", "series", s.labels, "type", typ, "family_type", mf.GetType())
+			continue
+		}
+
+		if !composite {
+			m := &dto.Metric{
+				Label:       labelPairs(s.labels, ""),
+				TimestampMs: proto.Int64(s.timestamp),
+			}
+			switch typ {
+			case dto.MetricType_COUNTER:
+				m.Counter = &dto.Counter{Value: proto.Float64(s.value)}
+			case dto.MetricType_GAUGE:
+				m.Gauge = &dto.Gauge{Value: proto.Float64(s.value)}
+			default:
+				m.Untyped = &dto.Untyped{Value: proto.Float64(s.value)}
+			}
+			mf.Metric = append(mf.Metric, m)
+			continue
+		}
+
+		// The bucket and quantile labels identify the part of the metric, not
+		// the metric itself.
+		var partLabel string
+		switch part {
+		case partBucket:
+			partLabel = labels.BucketLabel
+		case partQuantile:
+			partLabel = quantileLabel
+		}
+		key := family + labels.NewBuilder(s.labels).Del(labels.MetricName, partLabel).Labels().String()
+
+		m, ok := compositeMetrics[key]
+		if !ok {
+			m = &dto.Metric{Label: labelPairs(s.labels, partLabel)}
+			if typ == dto.MetricType_HISTOGRAM {
+				m.Histogram = &dto.Histogram{}
+			} else {
+				m.Summary = &dto.Summary{}
+			}
+			compositeMetrics[key] = m
+			mf.Metric = append(mf.Metric, m)
+		}
+		if s.timestamp > m.GetTimestampMs() {
+			m.TimestampMs = proto.Int64(s.timestamp)
+		}
+		addCompositePart(m, part, s)
+	}
+
+	res := make([]*dto.MetricFamily, 0, len(families))
+	for _, mf := range families {
+		for _, m := range mf.Metric {
+			if h := m.Histogram; h != nil {
+				sort.Slice(h.Bucket, func(i, j int) bool { return h.Bucket[i].GetUpperBound() < h.Bucket[j].GetUpperBound() })
+			}
+			if s := m.Summary; s != nil {
+				sort.Slice(s.Quantile, func(i, j int) bool { return s.Quantile[i].GetQuantile() < s.Quantile[j].GetQuantile() })
+			}
+		}
+		sort.Slice(mf.Metric, func(i, j int) bool {
+			return labelPairsLess(mf.Metric[i].Label, mf.Metric[j].Label)
+		})
+		res = append(res, mf)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].GetName() < res[j].GetName() })
+	return res
+}
+
+const quantileLabel = "quantile"
+
+// Parts of a histogram or summary which are exposed as separate series.
+const (
+	partBucket   = "bucket"
+	partQuantile = "quantile"
+	partSum      = "sum"
+	partCount    = "count"
+)
+
+// metricType returns the type to expose a series with metadata md as.
+func metricType(md *metrics.Metadata) dto.MetricType {
+	if md == nil {
+		return dto.MetricType_UNTYPED
+	}
+	switch md.Type {
+	case textparse.MetricTypeCounter:
+		return dto.MetricType_COUNTER
+	case textparse.MetricTypeGauge:
+		return dto.MetricType_GAUGE
+	case textparse.MetricTypeHistogram:
+		return dto.MetricType_HISTOGRAM
+	case textparse.MetricTypeSummary:
+		return dto.MetricType_SUMMARY
+	default:
+		return dto.MetricType_UNTYPED
+	}
+}
+
+// splitCompositeName returns the name of the metric family of the histogram or
+// summary series ls and the part of the metric it holds. ok is false if ls
+// isn't a valid series for typ.
+func splitCompositeName(ls labels.Labels, typ dto.MetricType) (family, part string, ok bool) {
+	name := ls.Get(labels.MetricName)
+
+	switch {
+	case strings.HasSuffix(name, "_sum"):
+		return strings.TrimSuffix(name, "_sum"), partSum, true
+	case strings.HasSuffix(name, "_count"):
+		return strings.TrimSuffix(name, "_count"), partCount, true
+	case typ == dto.MetricType_HISTOGRAM && strings.HasSuffix(name, "_bucket"):
+		_, err := strconv.ParseFloat(ls.Get(labels.BucketLabel), 64)
+		return strings.TrimSuffix(name, "_bucket"), partBucket, err == nil
+	case typ == dto.MetricType_SUMMARY:
+		_, err := strconv.ParseFloat(ls.Get(quantileLabel), 64)
+		return name, partQuantile, err == nil
+	default:
+		return name, "", false
+	}
+}
+
+// addCompositePart sets the part of the histogram or summary m held by s.
+func addCompositePart(m *dto.Metric, part string, s *series) {
+	switch {
+	case m.Histogram != nil && part == partSum:
+		m.Histogram.SampleSum = proto.Float64(s.value)
+	case m.Histogram != nil && part == partCount:
+		m.Histogram.SampleCount = proto.Uint64(uint64(s.value))
+	case m.Histogram != nil && part == partBucket:
+		le, _ := strconv.ParseFloat(s.labels.Get(labels.BucketLabel), 64)
+		m.Histogram.Bucket = append(m.Histogram.Bucket, &dto.Bucket{
+			UpperBound:      proto.Float64(le),
+			CumulativeCount: proto.Uint64(uint64(s.value)),
+		})
+	case m.Summary != nil && part == partSum:
+		m.Summary.SampleSum = proto.Float64(s.value)
+	case m.Summary != nil && part == partCount:
+		m.Summary.SampleCount = proto.Uint64(uint64(s.value))
+	case m.Summary != nil && part == partQuantile:
+		q, _ := strconv.ParseFloat(s.labels.Get(quantileLabel), 64)
+		m.Summary.Quantile = append(m.Summary.Quantile, &dto.Quantile{
+			Quantile: proto.Float64(q),
+			Value:    proto.Float64(s.value),
+		})
+	}
+}
+
+// labelPairs converts ls into label pairs, omitting the metric name and
+// exclude.
+func labelPairs(ls labels.Labels, exclude string) []*dto.LabelPair {
+	res := make([]*dto.LabelPair, 0, len(ls))
+	for _, l := range ls {
+		if l.Name == labels.MetricName || l.Name == exclude {
+			continue
+		}
+		res = append(res, &dto.LabelPair{
+			Name:  proto.String(l.Name),
+			Value: proto.String(l.Value),
+		})
+	}
+	return res
+}
+
+func includeName(include []*regexp.Regexp, name string) bool {
+	if len(include) == 0 {
+		return true
+	}
+	for _, re := range include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func labelPairsLess(a, b []*dto.LabelPair) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i].GetName() != b[i].GetName() {
+			return a[i].GetName() < b[i].GetName()
+		}
+		if a[i].GetValue() != b[i].GetValue() {
+			return a[i].GetValue() < b[i].GetValue()
+		}
+	}
+	return len(a) < len(b)
+}
+
+// Update implements component.Component.
+func (c *Component) Update(args component.Arguments) error {
+	newArgs := args.(Arguments)
+	if err := newArgs.Validate(); err != nil {
+		return err
+	}
+
+	include := make([]*regexp.Regexp, 0, len(newArgs.IncludeMetricNames))
+	for _, expr := range newArgs.IncludeMetricNames {
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return fmt.Errorf("invalid include_metric_names regex %q: %w", expr, err)
+		}
+		include = append(include, re)
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	restart := c.args.ListenAddress != newArgs.ListenAddress
+	c.args = newArgs
+	c.include = include
+
+	if restart {
+		c.server.Apply(httpserver.Settings{ListenAddress: newArgs.ListenAddress})
+	}
+	return nil
+}
+
+// CurrentHealth implements component.HealthComponent.
+func (c *Component) CurrentHealth() component.Health {
+	return c.server.CurrentHealth()
+}
@@ -0,0 +1,159 @@
+package exporter
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExporter(t *testing.T) {
+	c := newTestComponent(t, DefaultArguments)
+
+	c.Receive(1000, []*metrics.FlowMetric{
+		{Labels: labels.FromStrings("__name__", "b_metric", "instance", "a"), Value: 1},
+		{Labels: labels.FromStrings("__name__", "a_metric", "instance", "a"), Value: 2},
+	})
+	c.Receive(2000, []*metrics.FlowMetric{
+		{Labels: labels.FromStrings("__name__", "b_metric", "instance", "a"), Value: 3},
+	})
+
+	expect := `# TYPE a_metric untyped
+a_metric{instance="a"} 2 1000
+# TYPE b_metric untyped
+b_metric{instance="a"} 3 2000
+`
+	require.Equal(t, expect, scrape(t, c, ""))
+}
+
+func TestExporter_OpenMetrics(t *testing.T) {
+	c := newTestComponent(t, DefaultArguments)
+
+	c.Receive(1000, []*metrics.FlowMetric{
+		{Labels: labels.FromStrings("__name__", "a_metric"), Value: 2},
+	})
+
+	expect := `# TYPE a_metric unknown
+a_metric 2.0 1.0
+# EOF
+`
+	require.Equal(t, expect, scrape(t, c, "application/openmetrics-text; version=0.0.1"))
+}
+
+func TestExporter_Staleness(t *testing.T) {
+	c := newTestComponent(t, DefaultArguments)
+
+	c.Receive(1000, []*metrics.FlowMetric{
+		{Labels: labels.FromStrings("__name__", "a_metric"), Value: 1},
+		{Labels: labels.FromStrings("__name__", "b_metric"), Value: 1},
+	})
+	c.Receive(2000, []*metrics.FlowMetric{
+		{Labels: labels.FromStrings("__name__", "a_metric"), Value: math.Float64frombits(value.StaleNaN)},
+	})
+
+	expect := `# TYPE b_metric untyped
+b_metric 1 1000
+`
+	require.Equal(t, expect, scrape(t, c, ""))
+
+	// Series which stopped receiving samples are removed after the staleness
+	// timeout.
+	c.removeExpired(time.Now().Add(DefaultArguments.StalenessTimeout + time.Second))
+	require.Equal(t, "", scrape(t, c, ""))
+}
+
+func TestExporter_IncludeMetricNames(t *testing.T) {
+	args := DefaultArguments
+	args.IncludeMetricNames = []string{"a_.*"}
+	c := newTestComponent(t, args)
+
+	c.Receive(1000, []*metrics.FlowMetric{
+		{Labels: labels.FromStrings("__name__", "a_metric"), Value: 1},
+		{Labels: labels.FromStrings("__name__", "b_metric"), Value: 1},
+	})
+
+	expect := `# TYPE a_metric untyped
+a_metric 1 1000
+`
+	require.Equal(t, expect, scrape(t, c, ""))
+}
+
+func TestExporter_Metadata(t *testing.T) {
+	c := newTestComponent(t, DefaultArguments)
+
+	var (
+		counter   = &metrics.Metadata{Type: textparse.MetricTypeCounter, Help: "Total requests."}
+		histogram = &metrics.Metadata{Type: textparse.MetricTypeHistogram}
+	)
+	c.Receive(1000, []*metrics.FlowMetric{
+		{Labels: labels.FromStrings("__name__", "requests_total"), Value: 5, Metadata: counter},
+		{Labels: labels.FromStrings("__name__", "latency_bucket", "le", "0.5"), Value: 1, Metadata: histogram},
+		{Labels: labels.FromStrings("__name__", "latency_bucket", "le", "+Inf"), Value: 3, Metadata: histogram},
+		{Labels: labels.FromStrings("__name__", "latency_sum"), Value: 2.5, Metadata: histogram},
+		{Labels: labels.FromStrings("__name__", "latency_count"), Value: 3, Metadata: histogram},
+	})
+
+	expect := `# TYPE latency histogram
+latency_bucket{le="0.5"} 1 1000
+latency_bucket{le="+Inf"} 3 1000
+latency_sum 2.5 1000
+latency_count 3 1000
+# HELP requests_total Total requests.
+# TYPE requests_total counter
+requests_total 5 1000
+`
+	require.Equal(t, expect, scrape(t, c, ""))
+}
+
+func TestExporter_Path(t *testing.T) {
+	c := newTestComponent(t, DefaultArguments)
+
+	rec := httptest.NewRecorder()
+	c.handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/other", nil))
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestArguments_Validate(t *testing.T) {
+	for _, path := range []string{"", "metrics"} {
+		args := DefaultArguments
+		args.MetricsPath = path
+		require.EqualError(t, args.Validate(), "metrics_path must start with /")
+	}
+}
+
+func newTestComponent(t *testing.T, args Arguments) *Component {
+	t.Helper()
+
+	c, err := New(component.Options{
+		ID:            "metrics.exporter.test",
+		Logger:        log.NewNopLogger(),
+		Clock:         clock.New(),
+		DataPath:      t.TempDir(),
+		OnStateChange: func(e component.Exports) {},
+	}, args)
+	require.NoError(t, err)
+	return c
+}
+
+func scrape(t *testing.T, c *Component, accept string) string {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	c.handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
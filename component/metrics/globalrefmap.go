@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/prometheus/prometheus/model/labels"
+	"go.uber.org/atomic"
 )
 
 // GlobalRefMapping is used when translating to and from remote writes and the rest of the system (mostly scrapers)
@@ -18,13 +19,43 @@ func init() {
 // staleDuration determines how often we should wait after a stale value is received to GC that value
 var staleDuration = time.Minute * 10
 
+// numShards is the number of shards used for series and stale markers. Must
+// be a power of two so the shard can be picked with a mask.
+const numShards = 64
+
 // GlobalRefMap allows conversion from remote_write refids to global refs ids that everything else can use
+//
+// GlobalRefMap is safe for concurrent use. Series are sharded by their label
+// hash and stale markers are sharded by their global ref ID so that
+// concurrent appends from many scrapers rarely contend on the same lock.
 type GlobalRefMap struct {
-	mut                sync.Mutex
-	globalRefID        uint64
-	mappings           map[string]*remoteWriteMapping
-	labelsHashToGlobal map[uint64]uint64
-	staleGlobals       map[uint64]*staleMarker
+	globalRefID atomic.Uint64
+	hash        func(labels.Labels) uint64 // Overridden in tests to force collisions.
+
+	series [numShards]*seriesShard
+	stale  [numShards]*staleShard
+
+	mappingsMut sync.RWMutex
+	mappings    map[string]*remoteWriteMapping
+}
+
+// seriesShard holds a subset of the label hash to global ID mappings.
+type seriesShard struct {
+	mut sync.RWMutex
+	// Label hashes are not unique; more than one series is stored for a hash
+	// when there is a collision.
+	series map[uint64][]*seriesEntry
+}
+
+type seriesEntry struct {
+	labels   labels.Labels
+	globalID uint64
+}
+
+// staleShard holds a subset of the stale markers.
+type staleShard struct {
+	mut     sync.RWMutex
+	markers map[uint64]*staleMarker
 }
 
 type staleMarker struct {
@@ -35,126 +66,239 @@ type staleMarker struct {
 
 // newGlobalRefMap creates a refmap for usage, there should ONLY be one of these
 func newGlobalRefMap() *GlobalRefMap {
-	return &GlobalRefMap{
-		globalRefID:        0,
-		mappings:           make(map[string]*remoteWriteMapping),
-		labelsHashToGlobal: make(map[uint64]uint64),
-		staleGlobals:       make(map[uint64]*staleMarker),
+	g := &GlobalRefMap{
+		hash:     func(l labels.Labels) uint64 { return l.Hash() },
+		mappings: make(map[string]*remoteWriteMapping),
 	}
+	for i := 0; i < numShards; i++ {
+		g.series[i] = &seriesShard{series: make(map[uint64][]*seriesEntry)}
+		g.stale[i] = &staleShard{markers: make(map[uint64]*staleMarker)}
+	}
+	return g
+}
+
+func (g *GlobalRefMap) seriesShardFor(labelHash uint64) *seriesShard {
+	return g.series[labelHash&(numShards-1)]
+}
+
+func (g *GlobalRefMap) staleShardFor(globalRefID uint64) *staleShard {
+	return g.stale[globalRefID&(numShards-1)]
 }
 
 // GetOrAddLink is called by a remote_write endpoint component to add mapping and get back the global id.
 func (g *GlobalRefMap) GetOrAddLink(componentID string, localRefID uint64, l labels.Labels) uint64 {
-	g.mut.Lock()
-	defer g.mut.Unlock()
+	globalID := g.GetOrAddGlobalRefID(l)
+
+	m := g.getOrAddMapping(componentID)
+	m.link(localRefID, globalID)
+	return globalID
+}
 
-	// If the mapping doesn't exist then we need to create it
+func (g *GlobalRefMap) getOrAddMapping(componentID string) *remoteWriteMapping {
+	g.mappingsMut.RLock()
 	m, found := g.mappings[componentID]
-	if !found {
-		m = &remoteWriteMapping{
-			RemoteWriteID: componentID,
-			localToGlobal: make(map[uint64]uint64),
-			globalToLocal: make(map[uint64]uint64),
-		}
-		g.mappings[componentID] = m
+	g.mappingsMut.RUnlock()
+	if found {
+		return m
 	}
 
-	labelHash := l.Hash()
-	globalID, found := g.labelsHashToGlobal[labelHash]
-	if found {
-		m.localToGlobal[localRefID] = globalID
-		m.globalToLocal[globalID] = localRefID
-		return globalID
+	g.mappingsMut.Lock()
+	defer g.mappingsMut.Unlock()
+
+	// Check again in case the mapping was created while we didn't hold the
+	// lock.
+	if m, found := g.mappings[componentID]; found {
+		return m
 	}
-	// We have a value we have never seen before so increment the globalrefid and assign
-	g.globalRefID++
-	g.labelsHashToGlobal[labelHash] = g.globalRefID
-	m.localToGlobal[localRefID] = g.globalRefID
-	m.globalToLocal[g.globalRefID] = localRefID
-	return g.globalRefID
+	m = newRemoteWriteMapping(componentID)
+	g.mappings[componentID] = m
+	return m
+}
+
+func (g *GlobalRefMap) getMapping(componentID string) *remoteWriteMapping {
+	g.mappingsMut.RLock()
+	defer g.mappingsMut.RUnlock()
+	return g.mappings[componentID]
 }
 
 // GetOrAddGlobalRefID is used to create a global refid for a labelset
 func (g *GlobalRefMap) GetOrAddGlobalRefID(l labels.Labels) uint64 {
-	g.mut.Lock()
-	defer g.mut.Unlock()
+	labelHash := g.hash(l)
+	shard := g.seriesShardFor(labelHash)
 
-	labelHash := l.Hash()
-	globalID, found := g.labelsHashToGlobal[labelHash]
+	// Fast path: most calls are for series which already exist.
+	shard.mut.RLock()
+	globalID, found := shard.get(labelHash, l)
+	shard.mut.RUnlock()
 	if found {
 		return globalID
 	}
-	g.globalRefID++
-	g.labelsHashToGlobal[labelHash] = g.globalRefID
-	return g.globalRefID
+
+	shard.mut.Lock()
+	defer shard.mut.Unlock()
+
+	// Check again in case the series was added while we didn't hold the lock.
+	if globalID, found := shard.get(labelHash, l); found {
+		return globalID
+	}
+	// We have a value we have never seen before so increment the globalrefid and assign
+	globalID = g.globalRefID.Inc()
+	shard.series[labelHash] = append(shard.series[labelHash], &seriesEntry{
+		labels:   l.Copy(),
+		globalID: globalID,
+	})
+	return globalID
+}
+
+// get returns the global ID for l. The shard's mut must be held.
+func (s *seriesShard) get(labelHash uint64, l labels.Labels) (uint64, bool) {
+	for _, entry := range s.series[labelHash] {
+		if labels.Equal(entry.labels, l) {
+			return entry.globalID, true
+		}
+	}
+	return 0, false
+}
+
+// delete removes the series for globalID. The shard's mut must be held.
+func (s *seriesShard) delete(labelHash uint64, globalID uint64) {
+	entries := s.series[labelHash]
+	for i, entry := range entries {
+		if entry.globalID != globalID {
+			continue
+		}
+		entries = append(entries[:i], entries[i+1:]...)
+		break
+	}
+
+	if len(entries) == 0 {
+		delete(s.series, labelHash)
+	} else {
+		s.series[labelHash] = entries
+	}
 }
 
 // GetGlobalRefID returns the global refid for a component local combo, or 0 if not found
 func (g *GlobalRefMap) GetGlobalRefID(componentID string, localRefID uint64) uint64 {
-	g.mut.Lock()
-	defer g.mut.Unlock()
-
-	m, found := g.mappings[componentID]
-	if !found {
+	m := g.getMapping(componentID)
+	if m == nil {
 		return 0
 	}
-	global := m.localToGlobal[localRefID]
-	return global
+	return m.getGlobal(localRefID)
 }
 
 // GetLocalRefID returns the local refid for a component global combo, or 0 if not found
 func (g *GlobalRefMap) GetLocalRefID(componentID string, globalRefID uint64) uint64 {
-	g.mut.Lock()
-	defer g.mut.Unlock()
-
-	m, found := g.mappings[componentID]
-	if !found {
+	m := g.getMapping(componentID)
+	if m == nil {
 		return 0
 	}
-	local := m.globalToLocal[globalRefID]
-	return local
+	return m.getLocal(globalRefID)
 }
 
 // AddStaleMarker adds a stale marker
 func (g *GlobalRefMap) AddStaleMarker(globalRefID uint64, l labels.Labels) {
-	g.mut.Lock()
-	defer g.mut.Unlock()
+	shard := g.staleShardFor(globalRefID)
+	shard.mut.Lock()
+	defer shard.mut.Unlock()
 
-	g.staleGlobals[globalRefID] = &staleMarker{
+	shard.markers[globalRefID] = &staleMarker{
 		lastMarkedStale: time.Now(),
-		labelHash:       l.Hash(),
+		labelHash:       g.hash(l),
 		globalID:        globalRefID,
 	}
 }
 
 // RemoveStaleMarker removes a stale marker
 func (g *GlobalRefMap) RemoveStaleMarker(globalRefID uint64) {
-	g.mut.Lock()
-	defer g.mut.Unlock()
+	shard := g.staleShardFor(globalRefID)
 
-	delete(g.staleGlobals, globalRefID)
+	// RemoveStaleMarker is called for every non-stale sample, so avoid taking
+	// the write lock unless there is something to remove.
+	shard.mut.RLock()
+	_, found := shard.markers[globalRefID]
+	shard.mut.RUnlock()
+	if !found {
+		return
+	}
+
+	shard.mut.Lock()
+	defer shard.mut.Unlock()
+	delete(shard.markers, globalRefID)
 }
 
 // CheckStaleMarkers is called to garbage collect and items that have grown stale over stale duration (10m)
 func (g *GlobalRefMap) CheckStaleMarkers() {
-	g.mut.Lock()
-	defer g.mut.Unlock()
-
 	curr := time.Now()
-	idsToBeGCed := make([]*staleMarker, 0)
-	for _, stale := range g.staleGlobals {
-		// If the difference between now and the last time the stale was marked doesnt exceed stale then let it stay
-		if curr.Sub(stale.lastMarkedStale) < staleDuration {
-			continue
+
+	for _, shard := range g.stale {
+		shard.mut.RLock()
+		idsToBeGCed := make([]*staleMarker, 0)
+		for _, stale := range shard.markers {
+			// If the difference between now and the last time the stale was marked doesnt exceed stale then let it stay
+			if curr.Sub(stale.lastMarkedStale) < staleDuration {
+				continue
+			}
+			idsToBeGCed = append(idsToBeGCed, stale)
 		}
-		idsToBeGCed = append(idsToBeGCed, stale)
-	}
-	for _, marker := range idsToBeGCed {
-		delete(g.staleGlobals, marker.globalID)
-		delete(g.labelsHashToGlobal, marker.labelHash)
-		// Delete our mapping keys
-		for _, mapping := range g.mappings {
-			mapping.deleteStaleIDs(marker.globalID)
+		shard.mut.RUnlock()
+
+		for _, marker := range idsToBeGCed {
+			g.gcMarker(shard, marker, curr)
 		}
 	}
 }
+
+// gcMarker removes the series for marker, so long as marker wasn't removed or
+// refreshed since it was found to be expired.
+func (g *GlobalRefMap) gcMarker(shard *staleShard, marker *staleMarker, curr time.Time) {
+	// Locks are always taken in series shard -> stale shard order; no other
+	// method holds more than one shard lock at a time.
+	series := g.seriesShardFor(marker.labelHash)
+	series.mut.Lock()
+	shard.mut.Lock()
+
+	current, found := shard.markers[marker.globalID]
+	if !found || curr.Sub(current.lastMarkedStale) < staleDuration {
+		// The series received a new sample or was marked stale again.
+		shard.mut.Unlock()
+		series.mut.Unlock()
+		return
+	}
+	delete(shard.markers, marker.globalID)
+	series.delete(marker.labelHash, marker.globalID)
+
+	shard.mut.Unlock()
+	series.mut.Unlock()
+
+	// Delete our mapping keys
+	g.mappingsMut.RLock()
+	defer g.mappingsMut.RUnlock()
+	for _, mapping := range g.mappings {
+		mapping.deleteStaleIDs(marker.globalID)
+	}
+}
+
+// seriesCount returns the number of series tracked by g.
+func (g *GlobalRefMap) seriesCount() int {
+	var n int
+	for _, shard := range g.series {
+		shard.mut.RLock()
+		for _, entries := range shard.series {
+			n += len(entries)
+		}
+		shard.mut.RUnlock()
+	}
+	return n
+}
+
+// staleCount returns the number of stale markers tracked by g.
+func (g *GlobalRefMap) staleCount() int {
+	var n int
+	for _, shard := range g.stale {
+		shard.mut.RLock()
+		n += len(shard.markers)
+		shard.mut.RUnlock()
+	}
+	return n
+}
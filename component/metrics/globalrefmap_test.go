@@ -1,6 +1,9 @@
 package metrics
 
 import (
+	"fmt"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
@@ -18,7 +21,7 @@ func TestAddingMarker(t *testing.T) {
 	globalID := mapping.GetOrAddGlobalRefID(l)
 	shouldBeSameGlobalID := mapping.GetOrAddGlobalRefID(l)
 	require.True(t, globalID == shouldBeSameGlobalID)
-	require.Len(t, mapping.labelsHashToGlobal, 1)
+	require.Equal(t, 1, mapping.seriesCount())
 }
 
 func TestAddingDifferentMarkers(t *testing.T) {
@@ -36,7 +39,7 @@ func TestAddingDifferentMarkers(t *testing.T) {
 	globalID := mapping.GetOrAddGlobalRefID(l)
 	shouldBeDifferentID := mapping.GetOrAddGlobalRefID(l2)
 	require.True(t, globalID != shouldBeDifferentID)
-	require.Len(t, mapping.labelsHashToGlobal, 2)
+	require.Equal(t, 2, mapping.seriesCount())
 }
 
 func TestAddingLocalMapping(t *testing.T) {
@@ -50,7 +53,7 @@ func TestAddingLocalMapping(t *testing.T) {
 	globalID := mapping.GetOrAddGlobalRefID(l)
 	shouldBeSameGlobalID := mapping.GetOrAddLink("1", 1, l)
 	require.True(t, globalID == shouldBeSameGlobalID)
-	require.Len(t, mapping.labelsHashToGlobal, 1)
+	require.Equal(t, 1, mapping.seriesCount())
 	require.Len(t, mapping.mappings, 1)
 	require.True(t, mapping.mappings["1"].RemoteWriteID == "1")
 	require.True(t, mapping.mappings["1"].globalToLocal[shouldBeSameGlobalID] == 1)
@@ -70,7 +73,7 @@ func TestAddingLocalMappings(t *testing.T) {
 	shouldBeSameGlobalID2 := mapping.GetOrAddLink("2", 1, l)
 	require.True(t, globalID == shouldBeSameGlobalID)
 	require.True(t, globalID == shouldBeSameGlobalID2)
-	require.Len(t, mapping.labelsHashToGlobal, 1)
+	require.Equal(t, 1, mapping.seriesCount())
 	require.Len(t, mapping.mappings, 2)
 
 	require.True(t, mapping.mappings["1"].RemoteWriteID == "1")
@@ -93,7 +96,7 @@ func TestAddingLocalMappingsWithoutCreatingGlobalUpfront(t *testing.T) {
 	shouldBeSameGlobalID := mapping.GetOrAddLink("1", 1, l)
 	shouldBeSameGlobalID2 := mapping.GetOrAddLink("2", 1, l)
 	require.True(t, shouldBeSameGlobalID2 == shouldBeSameGlobalID)
-	require.Len(t, mapping.labelsHashToGlobal, 1)
+	require.Equal(t, 1, mapping.seriesCount())
 	require.Len(t, mapping.mappings, 2)
 
 	require.True(t, mapping.mappings["1"].RemoteWriteID == "1")
@@ -121,13 +124,13 @@ func TestStaleness(t *testing.T) {
 	global1 := mapping.GetOrAddLink("1", 1, l)
 	_ = mapping.GetOrAddLink("2", 1, l2)
 	mapping.AddStaleMarker(global1, l)
-	require.Len(t, mapping.staleGlobals, 1)
-	require.Len(t, mapping.labelsHashToGlobal, 2)
-	staleDuration = 1 * time.Millisecond
+	require.Equal(t, 1, mapping.staleCount())
+	require.Equal(t, 2, mapping.seriesCount())
+	setStaleDuration(t, time.Millisecond)
 	time.Sleep(10 * time.Millisecond)
 	mapping.CheckStaleMarkers()
-	require.Len(t, mapping.staleGlobals, 0)
-	require.Len(t, mapping.labelsHashToGlobal, 1)
+	require.Equal(t, 0, mapping.staleCount())
+	require.Equal(t, 1, mapping.seriesCount())
 }
 
 func TestRemovingStaleness(t *testing.T) {
@@ -140,7 +143,114 @@ func TestRemovingStaleness(t *testing.T) {
 
 	global1 := mapping.GetOrAddLink("1", 1, l)
 	mapping.AddStaleMarker(global1, l)
-	require.Len(t, mapping.staleGlobals, 1)
+	require.Equal(t, 1, mapping.staleCount())
 	mapping.RemoveStaleMarker(global1)
-	require.Len(t, mapping.staleGlobals, 0)
+	require.Equal(t, 0, mapping.staleCount())
+}
+
+func TestHashCollisions(t *testing.T) {
+	mapping := newGlobalRefMap()
+	mapping.hash = func(labels.Labels) uint64 { return 1234 }
+
+	l := labels.FromStrings("__name__", "test")
+	l2 := labels.FromStrings("__name__", "test2")
+
+	global1 := mapping.GetOrAddGlobalRefID(l)
+	global2 := mapping.GetOrAddGlobalRefID(l2)
+	require.NotEqual(t, global1, global2)
+	require.Equal(t, global1, mapping.GetOrAddGlobalRefID(l))
+	require.Equal(t, global2, mapping.GetOrAddGlobalRefID(l2))
+	require.Equal(t, 2, mapping.seriesCount())
+
+	// Garbage collecting one of the colliding series must leave the other
+	// intact.
+	mapping.AddStaleMarker(global1, l)
+	setStaleDuration(t, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	mapping.CheckStaleMarkers()
+	require.Equal(t, 1, mapping.seriesCount())
+	require.Equal(t, global2, mapping.GetOrAddGlobalRefID(l2))
+}
+
+func TestConcurrentGetOrAddGlobalRefID(t *testing.T) {
+	mapping := newGlobalRefMap()
+	sets := generateLabelSets(1000)
+
+	var (
+		wg      sync.WaitGroup
+		results = make([][]uint64, 8)
+	)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ids := make([]uint64, len(sets))
+			for j, l := range sets {
+				ids[j] = mapping.GetOrAddGlobalRefID(l)
+			}
+			results[i] = ids
+		}(i)
+	}
+	wg.Wait()
+
+	// Every goroutine must have been given the same ID for the same series.
+	for _, ids := range results[1:] {
+		require.Equal(t, results[0], ids)
+	}
+	require.Equal(t, len(sets), mapping.seriesCount())
+}
+
+func BenchmarkGlobalRefMap_GetOrAddGlobalRefID(b *testing.B) {
+	mapping := newGlobalRefMap()
+	sets := generateLabelSets(10000)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			_ = mapping.GetOrAddGlobalRefID(sets[i%len(sets)])
+			i++
+		}
+	})
+}
+
+// BenchmarkGlobalRefMap_Append emulates the calls made by the scraper's
+// appender for each sample.
+func BenchmarkGlobalRefMap_Append(b *testing.B) {
+	mapping := newGlobalRefMap()
+	sets := generateLabelSets(10000)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		var i int
+		for pb.Next() {
+			l := sets[i%len(sets)]
+			ref := mapping.GetOrAddGlobalRefID(l)
+			if i%100 == 0 {
+				mapping.AddStaleMarker(ref, l)
+			} else {
+				mapping.RemoveStaleMarker(ref)
+			}
+			i++
+		}
+	})
+}
+
+func generateLabelSets(n int) []labels.Labels {
+	res := make([]labels.Labels, 0, n)
+	for i := 0; i < n; i++ {
+		res = append(res, labels.FromStrings(
+			"__name__", "test_metric",
+			"instance", fmt.Sprintf("instance-%d", i%100),
+			"series", strconv.Itoa(i),
+		))
+	}
+	return res
+}
+
+// setStaleDuration overrides staleDuration for the duration of the test.
+func setStaleDuration(t *testing.T, d time.Duration) {
+	prev := staleDuration
+	staleDuration = d
+	t.Cleanup(func() { staleDuration = prev })
 }
@@ -0,0 +1,171 @@
+// Package httpserver runs the HTTP server of a Flow component, restarting it
+// whenever the component changes its listener settings.
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/component"
+)
+
+// Settings configure where a Server listens.
+type Settings struct {
+	// ListenAddress is the host:port to listen on.
+	ListenAddress string
+
+	// TLS, when non-nil, is called every time the server starts to build the
+	// TLS config to serve with. The server serves plain HTTP when TLS is nil.
+	TLS func() (*tls.Config, error)
+}
+
+// Server serves an http.Handler with the most recently applied Settings.
+// The server only runs while Run is running.
+type Server struct {
+	log         log.Logger
+	clock       clock.Clock
+	description string
+	handler     http.Handler
+
+	mut      sync.Mutex
+	settings Settings
+
+	// restartCh is written to when the server must be restarted to pick up
+	// new settings.
+	restartCh chan struct{}
+
+	healthMut sync.RWMutex
+	health    component.Health
+}
+
+// New creates a new Server serving h. description describes what is being
+// served and is used in log messages and health reports, e.g. "metrics".
+// Health reports are timestamped with clk.
+func New(l log.Logger, clk clock.Clock, description string, h http.Handler) *Server {
+	return &Server{
+		log:         l,
+		clock:       clk,
+		description: description,
+		handler:     h,
+		restartCh:   make(chan struct{}, 1),
+	}
+}
+
+// Apply queues a restart of the server with new settings. The server is
+// started with the latest settings once Run is called.
+func (s *Server) Apply(settings Settings) {
+	s.mut.Lock()
+	s.settings = settings
+	s.mut.Unlock()
+
+	select {
+	case s.restartCh <- struct{}{}:
+	default:
+		// no-op: a restart is already queued.
+	}
+}
+
+// Run runs the server until ctx is canceled. Failing to start the server
+// marks it as unhealthy until the next successful restart; it does not cause
+// Run to exit.
+func (s *Server) Run(ctx context.Context) error {
+	var srv *http.Server
+	defer func() { s.stop(srv) }()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.restartCh:
+			s.stop(srv)
+
+			var err error
+			srv, err = s.start()
+			if err != nil {
+				level.Error(s.log).Log("msg", "failed to start http server", "err", err)
+				s.setHealth(component.Health{
+					Health:     component.HealthTypeUnhealthy,
+					Message:    fmt.Sprintf("failed to start http server: %s", err),
+					UpdateTime: s.clock.Now(),
+				})
+			}
+		}
+	}
+}
+
+func (s *Server) start() (*http.Server, error) {
+	s.mut.Lock()
+	settings := s.settings
+	s.mut.Unlock()
+
+	srv := &http.Server{Handler: s.handler}
+	if settings.TLS != nil {
+		tlsConfig, err := settings.TLS()
+		if err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = tlsConfig
+	}
+
+	lis, err := net.Listen("tcp", settings.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		level.Info(s.log).Log("msg", "now serving "+s.description, "addr", lis.Addr())
+
+		// ServeTLS is used rather than wrapping the listener so that HTTP/2 can
+		// be negotiated and handlers see the connection state in r.TLS.
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ServeTLS(lis, "", "")
+		} else {
+			err = srv.Serve(lis)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			level.Error(s.log).Log("msg", "http server exited", "err", err)
+		}
+	}()
+
+	s.setHealth(component.Health{
+		Health:     component.HealthTypeHealthy,
+		Message:    "serving " + s.description,
+		UpdateTime: s.clock.Now(),
+	})
+	return srv, nil
+}
+
+func (s *Server) stop(srv *http.Server) {
+	if srv == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		level.Warn(s.log).Log("msg", "failed to gracefully shut down http server", "err", err)
+	}
+}
+
+// CurrentHealth returns the health of the server.
+func (s *Server) CurrentHealth() component.Health {
+	s.healthMut.RLock()
+	defer s.healthMut.RUnlock()
+	return s.health
+}
+
+func (s *Server) setHealth(h component.Health) {
+	s.healthMut.Lock()
+	defer s.healthMut.Unlock()
+	s.health = h
+}
@@ -0,0 +1,73 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/component"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Apply(t *testing.T) {
+	s := New(log.NewNopLogger(), clock.New(), "test", http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "ok")
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	addrA, addrB := freeAddr(t), freeAddr(t)
+
+	s.Apply(Settings{ListenAddress: addrA})
+	require.Eventually(t, func() bool { return get(addrA) == nil }, 5*time.Second, 10*time.Millisecond)
+	require.Equal(t, component.HealthTypeHealthy, s.CurrentHealth().Health)
+
+	// Applying new settings moves the server to the new address.
+	s.Apply(Settings{ListenAddress: addrB})
+	require.Eventually(t, func() bool { return get(addrB) == nil }, 5*time.Second, 10*time.Millisecond)
+	require.Error(t, get(addrA))
+
+	cancel()
+	require.NoError(t, <-done)
+	require.Error(t, get(addrB))
+}
+
+func TestServer_Unhealthy(t *testing.T) {
+	s := New(log.NewNopLogger(), clock.New(), "test", http.NotFoundHandler())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = s.Run(ctx) }()
+
+	s.Apply(Settings{ListenAddress: "invalid"})
+	require.Eventually(t, func() bool {
+		return s.CurrentHealth().Health == component.HealthTypeUnhealthy
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+func get(addr string) error {
+	resp, err := http.Get(fmt.Sprintf("http://%s/", addr))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
@@ -1,13 +1,48 @@
 package metrics
 
+import "sync"
+
 // remoteWriteMapping maps a remote_write to a set of global ids
 type remoteWriteMapping struct {
 	RemoteWriteID string
+
+	mut           sync.RWMutex
 	localToGlobal map[uint64]uint64
 	globalToLocal map[uint64]uint64
 }
 
+func newRemoteWriteMapping(componentID string) *remoteWriteMapping {
+	return &remoteWriteMapping{
+		RemoteWriteID: componentID,
+		localToGlobal: make(map[uint64]uint64),
+		globalToLocal: make(map[uint64]uint64),
+	}
+}
+
+func (rw *remoteWriteMapping) link(localID, globalID uint64) {
+	rw.mut.Lock()
+	defer rw.mut.Unlock()
+
+	rw.localToGlobal[localID] = globalID
+	rw.globalToLocal[globalID] = localID
+}
+
+func (rw *remoteWriteMapping) getGlobal(localID uint64) uint64 {
+	rw.mut.RLock()
+	defer rw.mut.RUnlock()
+	return rw.localToGlobal[localID]
+}
+
+func (rw *remoteWriteMapping) getLocal(globalID uint64) uint64 {
+	rw.mut.RLock()
+	defer rw.mut.RUnlock()
+	return rw.globalToLocal[globalID]
+}
+
 func (rw *remoteWriteMapping) deleteStaleIDs(globalID uint64) {
+	rw.mut.Lock()
+	defer rw.mut.Unlock()
+
 	localID, found := rw.globalToLocal[globalID]
 	if !found {
 		return
@@ -1,9 +1,15 @@
 package metrics
 
 import (
+	"github.com/grafana/agent/component"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
 )
 
+func init() {
+	component.RegisterGoStruct("MetricsReceiver", Receiver{})
+}
+
 // Receiver is used to pass an array of metrics to another receiver
 type Receiver struct {
 	// metrics should be considered immutable
@@ -15,4 +21,15 @@ type FlowMetric struct {
 	GlobalRefID uint64
 	Labels      labels.Labels
 	Value       float64
+
+	// Metadata of the metric family the metric belongs to. Nil when the
+	// metadata is unknown.
+	Metadata *Metadata
+}
+
+// Metadata describes a metric family.
+type Metadata struct {
+	Type textparse.MetricType
+	Help string
+	Unit string
 }
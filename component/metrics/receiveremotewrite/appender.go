@@ -0,0 +1,110 @@
+package receiveremotewrite
+
+import (
+	"strings"
+
+	"github.com/grafana/agent/component/metrics"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// tenant is a label injected into all series of a request.
+type tenant struct {
+	label, value string
+}
+
+// appender converts the series of a single remote_write request into
+// FlowMetrics which are forwarded to the component's receivers.
+type appender struct {
+	tenant    tenant
+	receivers []*metrics.Receiver
+	metadata  map[string]*metrics.Metadata // Metadata by metric family name.
+	buffer    map[int64][]*metrics.FlowMetric
+}
+
+func newAppender(t tenant, receivers []*metrics.Receiver, mds []prompb.MetricMetadata) *appender {
+	metadata := make(map[string]*metrics.Metadata, len(mds))
+	for _, md := range mds {
+		metadata[md.MetricFamilyName] = &metrics.Metadata{
+			Type: textparse.MetricType(strings.ToLower(md.Type.String())),
+			Help: md.Help,
+			Unit: md.Unit,
+		}
+	}
+
+	return &appender{
+		tenant:    t,
+		receivers: receivers,
+		metadata:  metadata,
+		buffer:    make(map[int64][]*metrics.FlowMetric),
+	}
+}
+
+// familySuffixes are suffixes which series may have in addition to the name
+// of their metric family.
+var familySuffixes = []string{"_total", "_bucket", "_sum", "_count", "_created", "_info"}
+
+// metadataFor returns the metadata of the metric family which the series
+// named name belongs to.
+func (a *appender) metadataFor(name string) *metrics.Metadata {
+	if md, ok := a.metadata[name]; ok {
+		return md
+	}
+	for _, suffix := range familySuffixes {
+		if !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		if md, ok := a.metadata[strings.TrimSuffix(name, suffix)]; ok {
+			return md
+		}
+	}
+	return nil
+}
+
+func (a *appender) Append(l labels.Labels, t int64, v float64) {
+	if len(a.receivers) == 0 {
+		return
+	}
+
+	if a.tenant.value != "" {
+		l = labels.NewBuilder(l).Set(a.tenant.label, a.tenant.value).Labels()
+	}
+
+	// Remote write requests never include refs, so we always need to look up
+	// the global ID.
+	globalID := metrics.GlobalRefMapping.GetOrAddGlobalRefID(l)
+	if value.IsStaleNaN(v) {
+		metrics.GlobalRefMapping.AddStaleMarker(globalID, l)
+	} else {
+		metrics.GlobalRefMapping.RemoveStaleMarker(globalID)
+	}
+
+	a.buffer[t] = append(a.buffer[t], &metrics.FlowMetric{
+		GlobalRefID: globalID,
+		Labels:      l,
+		Value:       v,
+		Metadata:    a.metadataFor(l.Get(labels.MetricName)),
+	})
+}
+
+func (a *appender) Commit() {
+	for _, r := range a.receivers {
+		if r == nil || r.Receive == nil {
+			continue
+		}
+		for ts, metrics := range a.buffer {
+			r.Receive(ts, metrics)
+		}
+	}
+	a.buffer = make(map[int64][]*metrics.FlowMetric)
+}
+
+func labelProtosToLabels(lps []prompb.Label) labels.Labels {
+	res := make(labels.Labels, 0, len(lps))
+	for _, l := range lps {
+		res = append(res, labels.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
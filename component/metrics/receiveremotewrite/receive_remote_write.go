@@ -0,0 +1,271 @@
+// Package receiveremotewrite implements the metrics.receive_remote_write
+// component, which accepts Prometheus remote_write requests over HTTP and
+// forwards the received series to other components.
+package receiveremotewrite
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/grafana/agent/component/metrics/internal/httpserver"
+	"github.com/grafana/agent/pkg/flow/hcltypes"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/rfratto/gohcl"
+)
+
+func init() {
+	component.Register(component.Registration{
+		Name: "metrics.receive_remote_write",
+		Args: Arguments{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			return New(opts, args.(Arguments))
+		},
+	})
+}
+
+// Arguments holds values which are used to configure the
+// metrics.receive_remote_write component.
+type Arguments struct {
+	// ListenAddress is the host:port to listen for remote_write requests on.
+	ListenAddress string `hcl:"listen_address,optional"`
+
+	// Path is the HTTP path to accept remote_write requests on.
+	Path string `hcl:"path,optional"`
+
+	// TLS enables TLS for the HTTP server when set.
+	TLS *TLSConfig `hcl:"tls,block"`
+
+	// BasicAuth and BearerToken are mutually exclusive ways of authenticating
+	// incoming requests. Requests are not authenticated when neither is set.
+	BasicAuth   *BasicAuthConfig `hcl:"basic_auth,block"`
+	BearerToken hcltypes.Secret  `hcl:"bearer_token,optional"`
+
+	// TenantHeader is an HTTP header whose value will be added to all received
+	// series as the TenantLabel label.
+	TenantHeader string `hcl:"tenant_header,optional"`
+	TenantLabel  string `hcl:"tenant_label,optional"`
+
+	// ForwardTo is the list of receivers to send received series to.
+	ForwardTo []*metrics.Receiver `hcl:"forward_to"`
+}
+
+// TLSConfig configures TLS for the HTTP server.
+type TLSConfig struct {
+	CertFile     string `hcl:"cert_file"`
+	KeyFile      string `hcl:"key_file"`
+	ClientCAFile string `hcl:"client_ca_file,optional"`
+}
+
+// BasicAuthConfig configures the credentials that incoming requests must use.
+type BasicAuthConfig struct {
+	Username string          `hcl:"username"`
+	Password hcltypes.Secret `hcl:"password"`
+}
+
+// DefaultArguments provides the default arguments for the
+// metrics.receive_remote_write component.
+var DefaultArguments = Arguments{
+	ListenAddress: "127.0.0.1:9009",
+	Path:          "/api/v1/push",
+	TenantLabel:   "tenant",
+}
+
+var _ gohcl.Decoder = (*Arguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (a *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*a = DefaultArguments
+
+	type arguments Arguments
+	return gohcl.DecodeBody(body, ctx, (*arguments)(a))
+}
+
+// Validate returns an error if a is invalid.
+func (a *Arguments) Validate() error {
+	if a.ListenAddress == "" {
+		return fmt.Errorf("listen_address must not be empty")
+	}
+	if !strings.HasPrefix(a.Path, "/") {
+		return fmt.Errorf("path must start with /")
+	}
+	if a.BasicAuth != nil && a.BearerToken != "" {
+		return fmt.Errorf("at most one of basic_auth and bearer_token may be set")
+	}
+	if a.TenantHeader != "" && !model.LabelName(a.TenantLabel).IsValid() {
+		return fmt.Errorf("%q is not a valid tenant_label", a.TenantLabel)
+	}
+	return nil
+}
+
+// Component implements the metrics.receive_remote_write component.
+type Component struct {
+	opts   component.Options
+	server *httpserver.Server
+
+	mut  sync.RWMutex
+	args Arguments
+}
+
+var (
+	_ component.Component       = (*Component)(nil)
+	_ component.HealthComponent = (*Component)(nil)
+)
+
+// New creates a new metrics.receive_remote_write component.
+func New(o component.Options, args Arguments) (*Component, error) {
+	c := &Component{opts: o}
+	c.server = httpserver.New(o.Logger, o.Clock, "remote_write requests", c.handler())
+
+	if err := c.Update(args); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run implements component.Component.
+func (c *Component) Run(ctx context.Context) error {
+	return c.server.Run(ctx)
+}
+
+// handler returns the http.Handler for incoming remote_write requests.
+func (c *Component) handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.mut.RLock()
+		args := c.args
+		c.mut.RUnlock()
+
+		if r.URL.Path != args.Path {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !authorized(args, r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics.receive_remote_write"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		req, err := remote.DecodeWriteRequest(r.Body)
+		if err != nil {
+			level.Error(c.opts.Logger).Log("msg", "failed to decode remote_write request", "err", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var t tenant
+		if args.TenantHeader != "" {
+			t = tenant{label: args.TenantLabel, value: r.Header.Get(args.TenantHeader)}
+		}
+
+		app := newAppender(t, c.receivers(), req.Metadata)
+		for _, ts := range req.Timeseries {
+			lset := labelProtosToLabels(ts.Labels)
+			for _, s := range ts.Samples {
+				app.Append(lset, s.Timestamp, s.Value)
+			}
+		}
+		app.Commit()
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func authorized(args Arguments, r *http.Request) bool {
+	switch {
+	case args.BasicAuth != nil:
+		user, pass, ok := r.BasicAuth()
+		return ok &&
+			secureCompare(user, args.BasicAuth.Username) &&
+			secureCompare(pass, string(args.BasicAuth.Password))
+
+	case args.BearerToken != "":
+		scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+		return ok &&
+			strings.EqualFold(scheme, "Bearer") &&
+			secureCompare(token, string(args.BearerToken))
+
+	default:
+		return true
+	}
+}
+
+func secureCompare(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// receivers returns the current set of receivers to forward metrics to.
+func (c *Component) receivers() []*metrics.Receiver {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+	return c.args.ForwardTo
+}
+
+// Update implements component.Component.
+func (c *Component) Update(args component.Arguments) error {
+	newArgs := args.(Arguments)
+	if err := newArgs.Validate(); err != nil {
+		return err
+	}
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	restart := c.args.ListenAddress != newArgs.ListenAddress || !reflect.DeepEqual(c.args.TLS, newArgs.TLS)
+	c.args = newArgs
+
+	if restart {
+		settings := httpserver.Settings{ListenAddress: newArgs.ListenAddress}
+		if newArgs.TLS != nil {
+			settings.TLS = newArgs.TLS.buildConfig
+		}
+		c.server.Apply(settings)
+	}
+	return nil
+}
+
+// CurrentHealth implements component.HealthComponent.
+func (c *Component) CurrentHealth() component.Health {
+	return c.server.CurrentHealth()
+}
+
+func (tc *TLSConfig) buildConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load server certificate: %w", err)
+	}
+
+	res := &tls.Config{Certificates: []tls.Certificate{cert}}
+
+	if tc.ClientCAFile != "" {
+		bb, err := os.ReadFile(tc.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bb) {
+			return nil, fmt.Errorf("no certificates found in client CA file %q", tc.ClientCAFile)
+		}
+		res.ClientCAs = pool
+		res.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return res, nil
+}
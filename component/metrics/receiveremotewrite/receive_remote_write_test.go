@@ -0,0 +1,189 @@
+package receiveremotewrite
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReceive(t *testing.T) {
+	var (
+		mut      sync.Mutex
+		received = map[int64][]*metrics.FlowMetric{}
+	)
+	receiver := &metrics.Receiver{Receive: func(ts int64, m []*metrics.FlowMetric) {
+		mut.Lock()
+		defer mut.Unlock()
+		received[ts] = append(received[ts], m...)
+	}}
+
+	args := DefaultArguments
+	args.TenantHeader = "X-Scope-OrgID"
+	args.ForwardTo = []*metrics.Receiver{receiver}
+	c := newTestComponent(t, args)
+
+	req := newWriteRequest(t, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "test_metric"}},
+			Samples: []prompb.Sample{{Timestamp: 10, Value: 1}, {Timestamp: 20, Value: 2}},
+		}},
+	})
+	req.Header.Set("X-Scope-OrgID", "team-a")
+
+	rec := httptest.NewRecorder()
+	c.handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	expectLabels := labels.FromStrings("__name__", "test_metric", "tenant", "team-a")
+	require.Len(t, received, 2)
+	for ts, value := range map[int64]float64{10: 1, 20: 2} {
+		require.Len(t, received[ts], 1)
+		require.Equal(t, expectLabels, received[ts][0].Labels)
+		require.Equal(t, value, received[ts][0].Value)
+		require.NotZero(t, received[ts][0].GlobalRefID)
+	}
+}
+
+func TestReceive_Metadata(t *testing.T) {
+	var received []*metrics.FlowMetric
+	receiver := &metrics.Receiver{Receive: func(_ int64, m []*metrics.FlowMetric) {
+		received = append(received, m...)
+	}}
+
+	args := DefaultArguments
+	args.ForwardTo = []*metrics.Receiver{receiver}
+	c := newTestComponent(t, args)
+
+	req := newWriteRequest(t, &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "requests_total"}},
+				Samples: []prompb.Sample{{Timestamp: 10, Value: 1}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "unknown_metric"}},
+				Samples: []prompb.Sample{{Timestamp: 10, Value: 1}},
+			},
+		},
+		Metadata: []prompb.MetricMetadata{{
+			Type:             prompb.MetricMetadata_COUNTER,
+			MetricFamilyName: "requests",
+			Help:             "Total requests.",
+		}},
+	})
+
+	rec := httptest.NewRecorder()
+	c.handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNoContent, rec.Code)
+
+	require.Len(t, received, 2)
+	require.Equal(t, &metrics.Metadata{Type: textparse.MetricTypeCounter, Help: "Total requests."}, received[0].Metadata)
+	require.Nil(t, received[1].Metadata)
+}
+
+func TestReceive_Auth(t *testing.T) {
+	args := DefaultArguments
+	args.BasicAuth = &BasicAuthConfig{Username: "user", Password: "pass"}
+	c := newTestComponent(t, args)
+
+	t.Run("missing credentials", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		c.handler().ServeHTTP(rec, newWriteRequest(t, &prompb.WriteRequest{}))
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("valid credentials", func(t *testing.T) {
+		req := newWriteRequest(t, &prompb.WriteRequest{})
+		req.SetBasicAuth("user", "pass")
+
+		rec := httptest.NewRecorder()
+		c.handler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusNoContent, rec.Code)
+	})
+}
+
+func TestReceive_BearerToken(t *testing.T) {
+	args := DefaultArguments
+	args.BearerToken = "token"
+	c := newTestComponent(t, args)
+
+	tt := []struct {
+		name   string
+		header string
+		expect int
+	}{
+		{name: "missing", header: "", expect: http.StatusUnauthorized},
+		{name: "no scheme", header: "token", expect: http.StatusUnauthorized},
+		{name: "wrong scheme", header: "Basic token", expect: http.StatusUnauthorized},
+		{name: "wrong token", header: "Bearer other", expect: http.StatusUnauthorized},
+		{name: "valid", header: "Bearer token", expect: http.StatusNoContent},
+		{name: "lowercase scheme", header: "bearer token", expect: http.StatusNoContent},
+	}
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req := newWriteRequest(t, &prompb.WriteRequest{})
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+
+			rec := httptest.NewRecorder()
+			c.handler().ServeHTTP(rec, req)
+			require.Equal(t, tc.expect, rec.Code)
+		})
+	}
+}
+
+func TestReceive_Path(t *testing.T) {
+	c := newTestComponent(t, DefaultArguments)
+
+	for _, path := range []string{"/", "/api/v1/push/extra", "/other"} {
+		req := newWriteRequest(t, &prompb.WriteRequest{})
+		req.URL.Path = path
+
+		rec := httptest.NewRecorder()
+		c.handler().ServeHTTP(rec, req)
+		require.Equal(t, http.StatusNotFound, rec.Code, path)
+	}
+}
+
+func TestArguments_Validate(t *testing.T) {
+	args := DefaultArguments
+	args.BasicAuth = &BasicAuthConfig{Username: "user", Password: "pass"}
+	args.BearerToken = "token"
+	require.EqualError(t, args.Validate(), "at most one of basic_auth and bearer_token may be set")
+}
+
+func newTestComponent(t *testing.T, args Arguments) *Component {
+	t.Helper()
+
+	c, err := New(component.Options{
+		ID:            "metrics.receive_remote_write.test",
+		Logger:        log.NewNopLogger(),
+		Clock:         clock.New(),
+		DataPath:      t.TempDir(),
+		OnStateChange: func(e component.Exports) {},
+	}, args)
+	require.NoError(t, err)
+	return c
+}
+
+func newWriteRequest(t *testing.T, wr *prompb.WriteRequest) *http.Request {
+	t.Helper()
+
+	bb, err := proto.Marshal(wr)
+	require.NoError(t, err)
+	return httptest.NewRequest(http.MethodPost, "/api/v1/push", bytes.NewReader(snappy.Encode(nil, bb)))
+}
@@ -39,6 +39,7 @@ var (
 	minWALTime           = 5 * time.Minute
 	maxWALTime           = 8 * time.Hour
 	remoteFlushDeadline  = 1 * time.Minute
+	staleGCFrequency     = 1 * time.Minute
 )
 
 func init() {
@@ -147,11 +148,20 @@ func (c *Component) Run(ctx context.Context) error {
 	// deleted until at least some new data has been sent.
 	var lastTs = int64(math.MinInt64)
 
+	truncate := time.NewTicker(walTruncateFrequency)
+	defer truncate.Stop()
+	staleGC := time.NewTicker(staleGCFrequency)
+	defer staleGC.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-time.After(walTruncateFrequency):
+		case <-staleGC.C:
+			// Release global refs for series which have been stale for too long
+			// so the global mapping doesn't grow forever.
+			metrics.GlobalRefMapping.CheckStaleMarkers()
+		case <-truncate.C:
 			// The timestamp ts is used to determine which series are not receiving
 			// samples and may be deleted from the WAL. Their most recent append
 			// timestamp is compared to ts, and if that timestamp is older then ts,
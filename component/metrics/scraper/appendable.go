@@ -87,8 +87,8 @@ func (s *scrapeAppendable) Rollback() error {
 }
 
 func (s *scrapeAppendable) AppendExemplar(ref storage.SeriesRef, l labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
-	//TODO implement me
-	panic("implement me")
+	// Exemplars aren't supported by receivers yet, so they are dropped.
+	return ref, nil
 }
 
 func (s *scrapeAppendable) Appender(ctx context.Context) storage.Appender {
@@ -0,0 +1,231 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/grafana/agent/component/targets/mutate"
+	"github.com/grafana/agent/pkg/cluster"
+	"github.com/hashicorp/hcl/v2"
+	common "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/rfratto/gohcl"
+)
+
+func init() {
+	component.Register(component.Registration{
+		Name: "metrics.scrape",
+		Args: Arguments{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			return New(opts, args.(Arguments))
+		},
+	})
+}
+
+// Arguments holds values which are used to configure the metrics.scrape
+// component.
+type Arguments struct {
+	// Targets to scrape, such as the targets exported by an integrations.*
+	// component or a targets.mutate component.
+	Targets []mutate.Target `hcl:"targets"`
+	// ForwardTo receives the scraped metrics.
+	ForwardTo []*metrics.Receiver `hcl:"forward_to"`
+
+	// JobName is the default value of the job label of scraped targets.
+	// Defaults to the ID of the component.
+	JobName        string        `hcl:"job_name,optional"`
+	HonorLabels    bool          `hcl:"honor_labels,optional"`
+	ScrapeInterval time.Duration `hcl:"scrape_interval,optional"`
+	ScrapeTimeout  time.Duration `hcl:"scrape_timeout,optional"`
+	MetricsPath    string        `hcl:"metrics_path,optional"`
+	Scheme         string        `hcl:"scheme,optional"`
+}
+
+// DefaultArguments provides the default arguments for the metrics.scrape
+// component.
+var DefaultArguments = Arguments{
+	ScrapeInterval: time.Minute,
+	ScrapeTimeout:  10 * time.Second,
+	MetricsPath:    "/metrics",
+	Scheme:         "http",
+}
+
+var _ gohcl.Decoder = (*Arguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (a *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*a = DefaultArguments
+
+	type arguments Arguments
+	if err := gohcl.DecodeBody(body, ctx, (*arguments)(a)); err != nil {
+		return err
+	}
+	return a.Validate()
+}
+
+// Validate returns an error if a is invalid.
+func (a *Arguments) Validate() error {
+	if a.ScrapeInterval <= 0 {
+		return fmt.Errorf("scrape_interval must be greater than 0")
+	}
+	if a.ScrapeTimeout <= 0 || a.ScrapeTimeout > a.ScrapeInterval {
+		return fmt.Errorf("scrape_timeout must be greater than 0 and not greater than scrape_interval")
+	}
+	if !strings.HasPrefix(a.MetricsPath, "/") {
+		return fmt.Errorf("metrics_path must start with /")
+	}
+	if a.Scheme != "http" && a.Scheme != "https" {
+		return fmt.Errorf("scheme must be http or https")
+	}
+	return nil
+}
+
+// Component implements the metrics.scrape component.
+type Component struct {
+	opts       component.Options
+	appendable *scrapeAppendable
+
+	mut  sync.Mutex
+	args Arguments
+
+	reload chan struct{}
+}
+
+var (
+	_ component.Component = (*Component)(nil)
+)
+
+// New creates a new metrics.scrape component.
+func New(o component.Options, args Arguments) (*Component, error) {
+	c := &Component{
+		opts:       o,
+		appendable: newScrapeAppendable(nil),
+		reload:     make(chan struct{}, 1),
+	}
+	if err := c.Update(args); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Run implements component.Component.
+func (c *Component) Run(ctx context.Context) error {
+	mgr := scrape.NewManager(nil, c.opts.Logger, c.appendable)
+	defer mgr.Stop()
+
+	targetSets := make(chan map[string][]*targetgroup.Group)
+	go func() { _ = mgr.Run(targetSets) }()
+
+	// Targets are sharded across the cluster, so they must be rebalanced
+	// whenever peers join or leave.
+	cluster.ObserveParticipants(c.opts.Clusterer, func() bool {
+		select {
+		case c.reload <- struct{}{}:
+		default:
+		}
+		return ctx.Err() == nil
+	})
+
+	// Apply the current arguments, which may have been set before Run was
+	// called.
+	select {
+	case c.reload <- struct{}{}:
+	default:
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-c.reload:
+			c.mut.Lock()
+			cfg, jobName, groups := c.scrapeConfig(), c.jobName(), c.targetGroups()
+			c.mut.Unlock()
+
+			if err := mgr.ApplyConfig(cfg); err != nil {
+				level.Error(c.opts.Logger).Log("msg", "failed to apply scrape config", "err", err)
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case targetSets <- map[string][]*targetgroup.Group{jobName: groups}:
+			}
+		}
+	}
+}
+
+// Update implements component.Component.
+func (c *Component) Update(args component.Arguments) error {
+	newArgs := args.(Arguments)
+	if err := newArgs.Validate(); err != nil {
+		return err
+	}
+
+	c.mut.Lock()
+	c.args = newArgs
+	c.mut.Unlock()
+
+	c.appendable.set(newArgs.ForwardTo)
+
+	select {
+	case c.reload <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// jobName returns the job name to use for scraping. c.mut must be held.
+func (c *Component) jobName() string {
+	if c.args.JobName != "" {
+		return c.args.JobName
+	}
+	return c.opts.ID
+}
+
+// scrapeConfig converts the current arguments into a Prometheus config. c.mut
+// must be held.
+func (c *Component) scrapeConfig() *config.Config {
+	return &config.Config{
+		GlobalConfig: config.DefaultGlobalConfig,
+		ScrapeConfigs: []*config.ScrapeConfig{{
+			JobName:          c.jobName(),
+			HonorLabels:      c.args.HonorLabels,
+			HonorTimestamps:  true,
+			ScrapeInterval:   model.Duration(c.args.ScrapeInterval),
+			ScrapeTimeout:    model.Duration(c.args.ScrapeTimeout),
+			MetricsPath:      c.args.MetricsPath,
+			Scheme:           c.args.Scheme,
+			HTTPClientConfig: common.DefaultHTTPClientConfig,
+		}},
+	}
+}
+
+// targetGroups converts the current targets owned by the local cluster node
+// into a target group. c.mut must be held.
+func (c *Component) targetGroups() []*targetgroup.Group {
+	group := &targetgroup.Group{Source: c.opts.ID}
+	for _, t := range c.args.Targets {
+		if !cluster.OwnsTarget(c.opts.Clusterer, t) {
+			continue
+		}
+
+		ls := make(model.LabelSet, len(t))
+		for k, v := range t {
+			ls[model.LabelName(k)] = model.LabelValue(v)
+		}
+		group.Targets = append(group.Targets, ls)
+	}
+	return []*targetgroup.Group{group}
+}
@@ -0,0 +1,120 @@
+package scraper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/grafana/agent/component/targets/mutate"
+	"github.com/grafana/agent/pkg/cluster"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/rfratto/ckit"
+	"github.com/rfratto/ckit/peer"
+	"github.com/rfratto/ckit/shard"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrape(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintln(w, "test_metric 42")
+	}))
+	defer srv.Close()
+
+	received := make(chan *metrics.FlowMetric, 100)
+	receiver := &metrics.Receiver{Receive: func(ts int64, metricArr []*metrics.FlowMetric) {
+		for _, m := range metricArr {
+			select {
+			case received <- m:
+			default:
+			}
+		}
+	}}
+
+	args := DefaultArguments
+	args.Targets = []mutate.Target{{"__address__": srv.Listener.Addr().String(), "team": "a"}}
+	args.ForwardTo = []*metrics.Receiver{receiver}
+	args.ScrapeInterval = 100 * time.Millisecond
+	args.ScrapeTimeout = 50 * time.Millisecond
+
+	c, err := New(component.Options{
+		ID:            "metrics.scrape.test",
+		Logger:        log.NewNopLogger(),
+		OnStateChange: func(e component.Exports) {},
+		Clusterer:     cluster.NewLocalNode("127.0.0.1:12345"),
+	}, args)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = c.Run(ctx) }()
+
+	// The scrape manager only applies new targets every 5 seconds.
+	timeout := time.After(15 * time.Second)
+	for {
+		select {
+		case m := <-received:
+			if m.Labels.Get(labels.MetricName) != "test_metric" {
+				continue
+			}
+			require.Equal(t, "metrics.scrape.test", m.Labels.Get("job"))
+			require.Equal(t, "a", m.Labels.Get("team"))
+			require.Equal(t, float64(42), m.Value)
+			return
+		case <-timeout:
+			require.FailNow(t, "timed out waiting for scraped metric")
+		}
+	}
+}
+
+func TestArguments_Validate(t *testing.T) {
+	args := DefaultArguments
+	require.NoError(t, args.Validate())
+
+	args = DefaultArguments
+	args.ScrapeTimeout = 2 * args.ScrapeInterval
+	require.Error(t, args.Validate())
+
+	args = DefaultArguments
+	args.MetricsPath = "metrics"
+	require.Error(t, args.Validate())
+
+	args = DefaultArguments
+	args.Scheme = "ftp"
+	require.Error(t, args.Validate())
+}
+
+func TestScrape_ShardsTargets(t *testing.T) {
+	args := DefaultArguments
+	args.Targets = []mutate.Target{{"__address__": "a:80"}, {"__address__": "b:80"}}
+
+	c, err := New(component.Options{
+		ID:            "metrics.scrape.test",
+		Logger:        log.NewNopLogger(),
+		OnStateChange: func(e component.Exports) {},
+		Clusterer:     remoteNode{},
+	}, args)
+	require.NoError(t, err)
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	groups := c.targetGroups()
+	require.Len(t, groups, 1)
+	require.Empty(t, groups[0].Targets)
+}
+
+// remoteNode is a cluster.Node where every key is owned by another peer.
+type remoteNode struct{}
+
+func (remoteNode) Lookup(shard.Key, int, shard.Op) ([]peer.Peer, error) {
+	return []peer.Peer{{Name: "remote", Self: false}}, nil
+}
+
+func (remoteNode) Observe(ckit.Observer) {}
+
+func (remoteNode) Peers() []peer.Peer { return nil }
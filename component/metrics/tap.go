@@ -0,0 +1,234 @@
+package metrics
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+
+	"github.com/grafana/agent/component"
+	"github.com/prometheus/prometheus/model/labels"
+	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
+)
+
+// MaxTapRate is the maximum number of metrics per second that a single tap
+// subscription may receive, regardless of what the subscriber asks for.
+const MaxTapRate = 1000
+
+// TapOptions configures a tap subscription.
+type TapOptions struct {
+	// Matchers filters the metrics sent to the subscriber. All matchers must
+	// match for a metric to be sent.
+	Matchers []*labels.Matcher
+
+	// SampleRatio is the probability (0, 1] of any given metric being sent to
+	// the subscriber.
+	SampleRatio float64
+
+	// Rate is the maximum number of metrics per second sent to the subscriber.
+	// Clamped to MaxTapRate.
+	Rate float64
+}
+
+// TapBatch is a batch of metrics observed by a tap.
+type TapBatch struct {
+	Timestamp int64
+	Metrics   []*FlowMetric
+}
+
+// TapRegistry is used to observe metrics flowing into components which export
+// a Receiver. It implements flow.ExportsHook, so the Flow controller wraps
+// exported Receivers and individual components don't have to do anything to
+// be tapped.
+type TapRegistry struct {
+	active atomic.Int64 // Total number of subscribers, used as a fast path.
+
+	mut     sync.RWMutex
+	subs    map[string]map[*tapSubscriber]struct{} // Subscribers by component ID.
+	wrapped map[string]map[string]*tappedReceiver  // Wrapped receivers by component ID and field.
+}
+
+type tappedReceiver struct {
+	inner, outer *Receiver
+}
+
+type tapSubscriber struct {
+	opts    TapOptions
+	limiter *rate.Limiter
+	ch      chan TapBatch
+}
+
+// NewTapRegistry creates a new TapRegistry.
+func NewTapRegistry() *TapRegistry {
+	return &TapRegistry{
+		subs:    make(map[string]map[*tapSubscriber]struct{}),
+		wrapped: make(map[string]map[string]*tappedReceiver),
+	}
+}
+
+// Subscribe starts receiving metrics passed to Receivers exported by the
+// component with the given ID. Batches are dropped if the returned channel
+// isn't read from fast enough. Call the returned function to unsubscribe.
+func (tr *TapRegistry) Subscribe(componentID string, opts TapOptions) (<-chan TapBatch, func()) {
+	if opts.SampleRatio <= 0 || opts.SampleRatio > 1 {
+		opts.SampleRatio = 1
+	}
+	if opts.Rate <= 0 || opts.Rate > MaxTapRate {
+		opts.Rate = MaxTapRate
+	}
+
+	burst := int(opts.Rate)
+	if burst < 1 {
+		burst = 1
+	}
+
+	sub := &tapSubscriber{
+		opts:    opts,
+		limiter: rate.NewLimiter(rate.Limit(opts.Rate), burst),
+		ch:      make(chan TapBatch, 16),
+	}
+
+	tr.mut.Lock()
+	if tr.subs[componentID] == nil {
+		tr.subs[componentID] = make(map[*tapSubscriber]struct{})
+	}
+	tr.subs[componentID][sub] = struct{}{}
+	tr.mut.Unlock()
+	tr.active.Inc()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			tr.mut.Lock()
+			defer tr.mut.Unlock()
+
+			delete(tr.subs[componentID], sub)
+			if len(tr.subs[componentID]) == 0 {
+				delete(tr.subs, componentID)
+			}
+			tr.active.Dec()
+		})
+	}
+}
+
+// WrapExports returns a copy of e where every exported *Receiver field is
+// replaced by a Receiver which also publishes received metrics to
+// subscribers of componentID. e is returned unmodified if it has no Receiver
+// fields.
+//
+// Wrapping the same Receiver for the same component always returns the same
+// wrapper, so exports remain comparable across calls.
+func (tr *TapRegistry) WrapExports(componentID string, e component.Exports) component.Exports {
+	v := reflect.ValueOf(e)
+	if !v.IsValid() || v.Kind() != reflect.Struct {
+		return e
+	}
+
+	receiverType := reflect.TypeOf((*Receiver)(nil))
+
+	var copied reflect.Value
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.Type != receiverType || field.PkgPath != "" || v.Field(i).IsNil() {
+			continue
+		}
+
+		if !copied.IsValid() {
+			copied = reflect.New(v.Type()).Elem()
+			copied.Set(v)
+		}
+		inner := v.Field(i).Interface().(*Receiver)
+		copied.Field(i).Set(reflect.ValueOf(tr.wrap(componentID, field.Name, inner)))
+	}
+
+	if !copied.IsValid() {
+		return e
+	}
+	return copied.Interface()
+}
+
+func (tr *TapRegistry) wrap(componentID, field string, inner *Receiver) *Receiver {
+	tr.mut.Lock()
+	defer tr.mut.Unlock()
+
+	if w, ok := tr.wrapped[componentID][field]; ok && w.inner == inner {
+		return w.outer
+	}
+
+	outer := &Receiver{
+		Receive: func(ts int64, metrics []*FlowMetric) {
+			if inner.Receive != nil {
+				inner.Receive(ts, metrics)
+			}
+			tr.publish(componentID, ts, metrics)
+		},
+	}
+	if tr.wrapped[componentID] == nil {
+		tr.wrapped[componentID] = make(map[string]*tappedReceiver)
+	}
+	tr.wrapped[componentID][field] = &tappedReceiver{inner: inner, outer: outer}
+	return outer
+}
+
+// ComponentRemoved forgets the receivers wrapped for componentID.
+func (tr *TapRegistry) ComponentRemoved(componentID string) {
+	tr.mut.Lock()
+	defer tr.mut.Unlock()
+	delete(tr.wrapped, componentID)
+}
+
+// tappable returns true if componentID exports a wrapped receiver.
+func (tr *TapRegistry) tappable(componentID string) bool {
+	tr.mut.RLock()
+	defer tr.mut.RUnlock()
+	return len(tr.wrapped[componentID]) > 0
+}
+
+func (tr *TapRegistry) publish(componentID string, ts int64, metrics []*FlowMetric) {
+	if tr.active.Load() == 0 {
+		return
+	}
+
+	tr.mut.RLock()
+	defer tr.mut.RUnlock()
+
+	for sub := range tr.subs[componentID] {
+		batch := sub.filter(metrics)
+		if len(batch) == 0 {
+			continue
+		}
+
+		select {
+		case sub.ch <- TapBatch{Timestamp: ts, Metrics: batch}:
+		default:
+			// The subscriber isn't keeping up; drop the batch rather than blocking
+			// the pipeline.
+		}
+	}
+}
+
+// filter returns the subset of metrics which should be sent to the
+// subscriber.
+func (sub *tapSubscriber) filter(metrics []*FlowMetric) []*FlowMetric {
+	var res []*FlowMetric
+
+Outer:
+	for _, m := range metrics {
+		for _, matcher := range sub.opts.Matchers {
+			if !matcher.Matches(m.Labels.Get(matcher.Name)) {
+				continue Outer
+			}
+		}
+		if sub.opts.SampleRatio < 1 && rand.Float64() >= sub.opts.SampleRatio {
+			continue
+		}
+		if !sub.limiter.Allow() {
+			// Once the limit has been reached, the rest of the batch would be
+			// dropped too.
+			break
+		}
+		res = append(res, m)
+	}
+
+	return res
+}
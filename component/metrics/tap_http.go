@@ -0,0 +1,129 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Handler returns an http.HandlerFunc which streams metrics received by a
+// component as newline-delimited JSON until the client disconnects. Only
+// components which export a Receiver can be tapped.
+//
+// The following query parameters are supported:
+//
+//     component: ID of the component to tap (required).
+//     selector:  Series selector to filter metrics, e.g. {job="api"}.
+//     sample:    Ratio (0, 1] of metrics to send. Defaults to 1.
+//     rate:      Maximum number of metrics per second. Defaults to 100 and is
+//                capped at MaxTapRate.
+func (tr *TapRegistry) Handler(l log.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, err := parseTapOptions(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		componentID := r.URL.Query().Get("component")
+		if componentID == "" {
+			http.Error(w, "component parameter is required", http.StatusBadRequest)
+			return
+		} else if !tr.tappable(componentID) {
+			http.Error(w, fmt.Sprintf("component %q does not exist or does not export a receiver", componentID), http.StatusNotFound)
+			return
+		}
+
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+			return
+		}
+
+		batches, unsubscribe := tr.Subscribe(componentID, opts)
+		defer unsubscribe()
+
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		enc := json.NewEncoder(w)
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case batch := <-batches:
+				if err := enc.Encode(newTapResponse(componentID, batch)); err != nil {
+					level.Debug(l).Log("msg", "stopping tap", "component", componentID, "err", err)
+					return
+				}
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func parseTapOptions(r *http.Request) (TapOptions, error) {
+	opts := TapOptions{SampleRatio: 1, Rate: 100}
+	query := r.URL.Query()
+
+	if selector := query.Get("selector"); selector != "" {
+		matchers, err := parser.ParseMetricSelector(selector)
+		if err != nil {
+			return opts, fmt.Errorf("invalid selector: %w", err)
+		}
+		opts.Matchers = matchers
+	}
+
+	if sample := query.Get("sample"); sample != "" {
+		ratio, err := strconv.ParseFloat(sample, 64)
+		if err != nil || ratio <= 0 || ratio > 1 {
+			return opts, fmt.Errorf("sample must be a number in (0, 1]")
+		}
+		opts.SampleRatio = ratio
+	}
+
+	if rate := query.Get("rate"); rate != "" {
+		limit, err := strconv.ParseFloat(rate, 64)
+		if err != nil || limit <= 0 {
+			return opts, fmt.Errorf("rate must be a positive number")
+		}
+		opts.Rate = limit
+	}
+
+	return opts, nil
+}
+
+type tapResponse struct {
+	Component string         `json:"component"`
+	Timestamp int64          `json:"timestamp"`
+	Metrics   []tapResMetric `json:"metrics"`
+}
+
+type tapResMetric struct {
+	GlobalRefID uint64        `json:"global_ref_id"`
+	Labels      labels.Labels `json:"labels"`
+	Value       string        `json:"value"` // String to support NaN and Inf.
+}
+
+func newTapResponse(componentID string, batch TapBatch) tapResponse {
+	res := tapResponse{
+		Component: componentID,
+		Timestamp: batch.Timestamp,
+		Metrics:   make([]tapResMetric, 0, len(batch.Metrics)),
+	}
+	for _, m := range batch.Metrics {
+		res.Metrics = append(res.Metrics, tapResMetric{
+			GlobalRefID: m.GlobalRefID,
+			Labels:      m.Labels,
+			Value:       strconv.FormatFloat(m.Value, 'f', -1, 64),
+		})
+	}
+	return res
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+)
+
+type tapTestExports struct {
+	Receiver *Receiver `hcl:"receiver"`
+	Other    string    `hcl:"other"`
+}
+
+func TestTapRegistry_WrapExports(t *testing.T) {
+	tr := NewTapRegistry()
+
+	var received []*FlowMetric
+	inner := &Receiver{Receive: func(_ int64, m []*FlowMetric) { received = append(received, m...) }}
+
+	e := tapTestExports{Receiver: inner, Other: "value"}
+	wrapped := tr.WrapExports("metrics.test.a", e).(tapTestExports)
+	require.NotSame(t, inner, wrapped.Receiver)
+	require.Equal(t, "value", wrapped.Other)
+
+	// Wrapping the same receiver again must return the same wrapper so exports
+	// are still considered unchanged.
+	require.Equal(t, wrapped, tr.WrapExports("metrics.test.a", e))
+
+	// Calls to the wrapper must still reach the original receiver.
+	wrapped.Receiver.Receive(0, []*FlowMetric{{Labels: labels.FromStrings("__name__", "a")}})
+	require.Len(t, received, 1)
+
+	// Exports without receivers are returned unmodified.
+	require.Equal(t, "hello", tr.WrapExports("metrics.test.a", "hello"))
+}
+
+func TestTapRegistry_Subscribe(t *testing.T) {
+	tr := NewTapRegistry()
+	recv := tr.WrapExports("metrics.test.a", tapTestExports{Receiver: &Receiver{}}).(tapTestExports).Receiver
+
+	ch, unsubscribe := tr.Subscribe("metrics.test.a", TapOptions{
+		Matchers: []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "api")},
+	})
+
+	recv.Receive(10, []*FlowMetric{
+		{Labels: labels.FromStrings("__name__", "a", "job", "api"), Value: 1},
+		{Labels: labels.FromStrings("__name__", "b", "job", "db"), Value: 2},
+	})
+
+	select {
+	case batch := <-ch:
+		require.Equal(t, int64(10), batch.Timestamp)
+		require.Len(t, batch.Metrics, 1)
+		require.Equal(t, "a", batch.Metrics[0].Labels.Get("__name__"))
+	case <-time.After(time.Second):
+		require.FailNow(t, "timed out waiting for batch")
+	}
+
+	unsubscribe()
+	recv.Receive(20, []*FlowMetric{{Labels: labels.FromStrings("__name__", "a", "job", "api")}})
+	require.Len(t, ch, 0)
+	require.Equal(t, int64(0), tr.active.Load())
+}
+
+func TestTapRegistry_RateLimit(t *testing.T) {
+	tr := NewTapRegistry()
+	recv := tr.WrapExports("metrics.test.a", tapTestExports{Receiver: &Receiver{}}).(tapTestExports).Receiver
+
+	ch, unsubscribe := tr.Subscribe("metrics.test.a", TapOptions{Rate: 5})
+	defer unsubscribe()
+
+	batch := make([]*FlowMetric, 0, 100)
+	for i := 0; i < 100; i++ {
+		batch = append(batch, &FlowMetric{Labels: labels.FromStrings("__name__", "a")})
+	}
+	recv.Receive(10, batch)
+
+	res := <-ch
+	require.Len(t, res.Metrics, 5)
+}
+
+func TestTapRegistry_ComponentRemoved(t *testing.T) {
+	tr := NewTapRegistry()
+	tr.WrapExports("metrics.test.a", tapTestExports{Receiver: &Receiver{}})
+	require.True(t, tr.tappable("metrics.test.a"))
+
+	tr.ComponentRemoved("metrics.test.a")
+	require.False(t, tr.tappable("metrics.test.a"))
+	require.Empty(t, tr.wrapped)
+}
+
+func TestTapRegistry_Handler(t *testing.T) {
+	tr := NewTapRegistry()
+	tr.WrapExports("metrics.test.a", tapTestExports{Receiver: &Receiver{}})
+
+	tt := []struct {
+		query  string
+		expect int
+	}{
+		{query: "", expect: http.StatusBadRequest},
+		{query: "component=metrics.test.a&sample=2", expect: http.StatusBadRequest},
+		{query: "component=metrics.test.missing", expect: http.StatusNotFound},
+	}
+	for _, tc := range tt {
+		rec := httptest.NewRecorder()
+		tr.Handler(log.NewNopLogger()).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/tap?"+tc.query, nil))
+		require.Equal(t, tc.expect, rec.Code, tc.query)
+	}
+}
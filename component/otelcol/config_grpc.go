@@ -0,0 +1,82 @@
+package otelcol
+
+import (
+	"go.opentelemetry.io/collector/config/configcompression"
+	"go.opentelemetry.io/collector/config/configgrpc"
+	"go.opentelemetry.io/collector/config/confignet"
+)
+
+// GRPCServerArguments holds shared gRPC settings for components which launch
+// gRPC servers.
+type GRPCServerArguments struct {
+	Endpoint  string `hcl:"endpoint,optional"`
+	Transport string `hcl:"transport,optional"`
+
+	MaxRecvMsgSizeMiB    uint64 `hcl:"max_recv_msg_size_mib,optional"`
+	MaxConcurrentStreams uint32 `hcl:"max_concurrent_streams,optional"`
+	ReadBufferSize       int    `hcl:"read_buffer_size,optional"`
+	WriteBufferSize      int    `hcl:"write_buffer_size,optional"`
+
+	// IncludeMetadata propagates client metadata from incoming requests to
+	// downstream consumers.
+	IncludeMetadata bool `hcl:"include_metadata,optional"`
+}
+
+// Convert converts args into the upstream type. Convert returns nil if args
+// is nil.
+func (args *GRPCServerArguments) Convert() *configgrpc.GRPCServerSettings {
+	if args == nil {
+		return nil
+	}
+
+	return &configgrpc.GRPCServerSettings{
+		NetAddr: confignet.NetAddr{
+			Endpoint:  args.Endpoint,
+			Transport: args.Transport,
+		},
+
+		MaxRecvMsgSizeMiB:    args.MaxRecvMsgSizeMiB,
+		MaxConcurrentStreams: args.MaxConcurrentStreams,
+		ReadBufferSize:       args.ReadBufferSize,
+		WriteBufferSize:      args.WriteBufferSize,
+
+		IncludeMetadata: args.IncludeMetadata,
+	}
+}
+
+// GRPCClientArguments holds shared gRPC settings for components which launch
+// gRPC clients.
+type GRPCClientArguments struct {
+	Endpoint string `hcl:"endpoint"`
+
+	Compression string              `hcl:"compression,optional"`
+	TLS         *TLSClientArguments `hcl:"tls,block"`
+
+	ReadBufferSize  int               `hcl:"read_buffer_size,optional"`
+	WriteBufferSize int               `hcl:"write_buffer_size,optional"`
+	WaitForReady    bool              `hcl:"wait_for_ready,optional"`
+	Headers         map[string]string `hcl:"headers,optional"`
+	BalancerName    string            `hcl:"balancer_name,optional"`
+}
+
+// DefaultGRPCClientArguments holds the default settings for gRPC clients.
+var DefaultGRPCClientArguments = GRPCClientArguments{
+	Compression:     "gzip",
+	WriteBufferSize: 512 * 1024,
+}
+
+// Convert converts args into the upstream type.
+func (args *GRPCClientArguments) Convert() configgrpc.GRPCClientSettings {
+	return configgrpc.GRPCClientSettings{
+		Endpoint: args.Endpoint,
+
+		Compression: configcompression.CompressionType(args.Compression),
+		TLSSetting:  args.TLS.Convert(),
+
+		ReadBufferSize:  args.ReadBufferSize,
+		WriteBufferSize: args.WriteBufferSize,
+		WaitForReady:    args.WaitForReady,
+		Headers:         args.Headers,
+		BalancerName:    args.BalancerName,
+	}
+}
@@ -0,0 +1,29 @@
+package otelcol
+
+import "go.opentelemetry.io/collector/config/confighttp"
+
+// HTTPServerArguments holds shared settings for components which launch HTTP
+// servers.
+type HTTPServerArguments struct {
+	Endpoint string `hcl:"endpoint,optional"`
+
+	MaxRequestBodySize int64 `hcl:"max_request_body_size,optional"`
+
+	// IncludeMetadata propagates client metadata from incoming requests to
+	// downstream consumers.
+	IncludeMetadata bool `hcl:"include_metadata,optional"`
+}
+
+// Convert converts args into the upstream type. Convert returns nil if args
+// is nil.
+func (args *HTTPServerArguments) Convert() *confighttp.HTTPServerSettings {
+	if args == nil {
+		return nil
+	}
+
+	return &confighttp.HTTPServerSettings{
+		Endpoint:           args.Endpoint,
+		MaxRequestBodySize: args.MaxRequestBodySize,
+		IncludeMetadata:    args.IncludeMetadata,
+	}
+}
@@ -0,0 +1,39 @@
+package otelcol
+
+import "go.opentelemetry.io/collector/config/configtls"
+
+// TLSClientArguments holds shared TLS settings for components which launch
+// TLS clients.
+type TLSClientArguments struct {
+	CAFile   string `hcl:"ca_file,optional"`
+	CertFile string `hcl:"cert_file,optional"`
+	KeyFile  string `hcl:"key_file,optional"`
+
+	MinVersion string `hcl:"min_version,optional"`
+	MaxVersion string `hcl:"max_version,optional"`
+
+	Insecure           bool   `hcl:"insecure,optional"`
+	InsecureSkipVerify bool   `hcl:"insecure_skip_verify,optional"`
+	ServerName         string `hcl:"server_name,optional"`
+}
+
+// Convert converts args into the upstream type. A nil args converts into the
+// default TLS settings.
+func (args *TLSClientArguments) Convert() configtls.TLSClientSetting {
+	if args == nil {
+		return configtls.TLSClientSetting{}
+	}
+
+	return configtls.TLSClientSetting{
+		TLSSetting: configtls.TLSSetting{
+			CAFile:     args.CAFile,
+			CertFile:   args.CertFile,
+			KeyFile:    args.KeyFile,
+			MinVersion: args.MinVersion,
+			MaxVersion: args.MaxVersion,
+		},
+		Insecure:           args.Insecure,
+		InsecureSkipVerify: args.InsecureSkipVerify,
+		ServerName:         args.ServerName,
+	}
+}
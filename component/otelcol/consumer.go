@@ -0,0 +1,35 @@
+// Package otelcol holds types shared by Flow components which wrap
+// OpenTelemetry Collector receivers, processors, and exporters.
+package otelcol
+
+import (
+	"github.com/grafana/agent/component"
+	"go.opentelemetry.io/collector/consumer"
+)
+
+func init() {
+	component.RegisterGoStruct("OtelcolConsumer", Consumer{})
+}
+
+// Consumer is a handle to an OpenTelemetry Collector consumer which can be
+// passed between components. Fields are nil for telemetry signals the
+// consumer does not support.
+type Consumer struct {
+	Traces  consumer.Traces
+	Metrics consumer.Metrics
+	Logs    consumer.Logs
+}
+
+// ConsumerArguments is used by components to describe which consumers
+// telemetry data should be sent to, by signal type.
+type ConsumerArguments struct {
+	Traces  []*Consumer `hcl:"traces,optional"`
+	Metrics []*Consumer `hcl:"metrics,optional"`
+	Logs    []*Consumer `hcl:"logs,optional"`
+}
+
+// ConsumerExports is the common Exports type for components which receive
+// telemetry data from other components.
+type ConsumerExports struct {
+	Input *Consumer `hcl:"input"`
+}
@@ -0,0 +1,171 @@
+// Package exporter provides utilities to create a Flow component from
+// OpenTelemetry Collector exporters.
+package exporter
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/otelcol"
+	"github.com/grafana/agent/component/otelcol/internal/lazyconsumer"
+	"github.com/grafana/agent/component/otelcol/internal/scheduler"
+	"github.com/grafana/agent/component/otelcol/internal/zapadapter"
+	"github.com/grafana/agent/pkg/build"
+	"github.com/prometheus/client_golang/prometheus"
+	otelcomponent "go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	otelconfig "go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Arguments is an extension of component.Arguments which contains necessary
+// settings for OpenTelemetry Collector exporters.
+type Arguments interface {
+	component.Arguments
+
+	// Convert converts the Arguments into an OpenTelemetry Collector exporter
+	// configuration.
+	Convert() otelconfig.Exporter
+}
+
+// Exporter is a Flow component shim which manages an OpenTelemetry Collector
+// exporter component.
+type Exporter struct {
+	opts     component.Options
+	factory  otelcomponent.ExporterFactory
+	consumer *lazyconsumer.Consumer
+
+	sched *scheduler.Scheduler
+}
+
+var (
+	_ component.Component       = (*Exporter)(nil)
+	_ component.HealthComponent = (*Exporter)(nil)
+	_ prometheus.Collector      = (*Exporter)(nil)
+)
+
+// New creates a new Flow component which encapsulates an OpenTelemetry
+// Collector exporter. args must hold a value of the argument type registered
+// with the Flow component.
+//
+// The registered component must be registered to export the
+// otelcol.ConsumerExports type, otherwise New will panic.
+func New(opts component.Options, f otelcomponent.ExporterFactory, args Arguments) (*Exporter, error) {
+	consumer := lazyconsumer.New()
+
+	// Immediately set our state with our consumer. The exports will never
+	// change throughout the lifetime of our component.
+	//
+	// This will panic if the wrapping component is not registered to export
+	// otelcol.ConsumerExports.
+	opts.OnStateChange(otelcol.ConsumerExports{
+		Input: &otelcol.Consumer{
+			Traces:  consumer,
+			Metrics: consumer,
+			Logs:    consumer,
+		},
+	})
+
+	e := &Exporter{
+		opts:     opts,
+		factory:  f,
+		consumer: consumer,
+
+		sched: scheduler.New(opts.Logger, opts.Clock),
+	}
+	if err := e.Update(args); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Run starts the Exporter component.
+func (e *Exporter) Run(ctx context.Context) error {
+	return e.sched.Run(ctx)
+}
+
+// Update implements component.Component. It will convert the Arguments into
+// configuration for OpenTelemetry Collector exporter configuration and manage
+// the underlying OpenTelemetry Collector exporter.
+func (e *Exporter) Update(args component.Arguments) error {
+	eargs := args.(Arguments)
+
+	host := &scheduler.Host{Logger: e.opts.Logger}
+
+	settings := otelcomponent.ExporterCreateSettings{
+		TelemetrySettings: otelcomponent.TelemetrySettings{
+			Logger:         zapadapter.New(e.opts.Logger),
+			TracerProvider: trace.NewNoopTracerProvider(),
+			MeterProvider:  metric.NewNoopMeterProvider(),
+		},
+		BuildInfo: otelcomponent.BuildInfo{
+			Command:     os.Args[0],
+			Description: "Grafana Agent",
+			Version:     build.Version,
+		},
+	}
+
+	exporterConfig := eargs.Convert()
+	if err := exporterConfig.Validate(); err != nil {
+		return err
+	}
+
+	// Create instances of the exporter from our factory for each of our
+	// supported telemetry signals.
+	var (
+		components []otelcomponent.Component
+
+		tracesExporter  consumer.Traces
+		metricsExporter consumer.Metrics
+		logsExporter    consumer.Logs
+	)
+
+	te, err := e.factory.CreateTracesExporter(context.Background(), settings, exporterConfig)
+	if err != nil && !errors.Is(err, componenterror.ErrDataTypeIsNotSupported) {
+		return err
+	} else if te != nil {
+		components = append(components, te)
+		tracesExporter = te
+	}
+
+	me, err := e.factory.CreateMetricsExporter(context.Background(), settings, exporterConfig)
+	if err != nil && !errors.Is(err, componenterror.ErrDataTypeIsNotSupported) {
+		return err
+	} else if me != nil {
+		components = append(components, me)
+		metricsExporter = me
+	}
+
+	le, err := e.factory.CreateLogsExporter(context.Background(), settings, exporterConfig)
+	if err != nil && !errors.Is(err, componenterror.ErrDataTypeIsNotSupported) {
+		return err
+	} else if le != nil {
+		components = append(components, le)
+		logsExporter = le
+	}
+
+	// Schedule the components to run once our component is running, and
+	// forward data we receive to the new exporters.
+	e.sched.Schedule(host, components...)
+	e.consumer.SetConsumers(tracesExporter, metricsExporter, logsExporter)
+	return nil
+}
+
+// CurrentHealth implements component.HealthComponent.
+func (e *Exporter) CurrentHealth() component.Health {
+	return e.sched.CurrentHealth()
+}
+
+// Describe implements prometheus.Collector.
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	e.sched.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	e.sched.Collect(ch)
+}
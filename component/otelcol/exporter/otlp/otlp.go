@@ -0,0 +1,163 @@
+// Package otlp provides an otelcol.exporter.otlp component.
+package otlp
+
+import (
+	"time"
+
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/otelcol"
+	"github.com/grafana/agent/component/otelcol/exporter"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/rfratto/gohcl"
+	otelconfig "go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/exporter/exporterhelper"
+	"go.opentelemetry.io/collector/exporter/otlpexporter"
+)
+
+func init() {
+	component.Register(component.Registration{
+		Name:    "otelcol.exporter.otlp",
+		Args:    Arguments{},
+		Exports: otelcol.ConsumerExports{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			fact := otlpexporter.NewFactory()
+			return exporter.New(opts, fact, args.(Arguments))
+		},
+	})
+}
+
+// Arguments configures the otelcol.exporter.otlp component.
+type Arguments struct {
+	// Timeout is the timeout for every attempt to send data to the backend.
+	Timeout time.Duration `hcl:"timeout,optional"`
+
+	Client *GRPCClientArguments `hcl:"client,block"`
+	Queue  *QueueArguments      `hcl:"sending_queue,block"`
+	Retry  *RetryArguments      `hcl:"retry_on_failure,block"`
+}
+
+var (
+	_ exporter.Arguments = Arguments{}
+	_ gohcl.Decoder      = (*Arguments)(nil)
+)
+
+// DefaultArguments holds default values for Arguments.
+var DefaultArguments = Arguments{
+	Timeout: 5 * time.Second,
+}
+
+// DecodeHCL implements gohcl.Decoder.
+func (args *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*args = DefaultArguments
+
+	type arguments Arguments
+	if err := gohcl.DecodeBody(body, ctx, (*arguments)(args)); err != nil {
+		return err
+	}
+
+	if args.Client == nil {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Missing required client block",
+			Subject:  body.MissingItemRange().Ptr(),
+		}}
+	}
+	return nil
+}
+
+// Convert implements exporter.Arguments.
+func (args Arguments) Convert() otelconfig.Exporter {
+	queue := DefaultQueueArguments
+	if args.Queue != nil {
+		queue = *args.Queue
+	}
+	retry := DefaultRetryArguments
+	if args.Retry != nil {
+		retry = *args.Retry
+	}
+
+	return &otlpexporter.Config{
+		ExporterSettings: otelconfig.NewExporterSettings(otelconfig.NewComponentID("otlp")),
+		TimeoutSettings: exporterhelper.TimeoutSettings{
+			Timeout: args.Timeout,
+		},
+		QueueSettings: exporterhelper.QueueSettings{
+			Enabled:      queue.Enabled,
+			NumConsumers: queue.NumConsumers,
+			QueueSize:    queue.QueueSize,
+		},
+		RetrySettings: exporterhelper.RetrySettings{
+			Enabled:         retry.Enabled,
+			InitialInterval: retry.InitialInterval,
+			MaxInterval:     retry.MaxInterval,
+			MaxElapsedTime:  retry.MaxElapsedTime,
+		},
+		GRPCClientSettings: (*otelcol.GRPCClientArguments)(args.Client).Convert(),
+	}
+}
+
+// GRPCClientArguments is used to configure otelcol.exporter.otlp with
+// component-specific defaults.
+type GRPCClientArguments otelcol.GRPCClientArguments
+
+var _ gohcl.Decoder = (*GRPCClientArguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (args *GRPCClientArguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*args = GRPCClientArguments(otelcol.DefaultGRPCClientArguments)
+
+	type arguments GRPCClientArguments
+	return gohcl.DecodeBody(body, ctx, (*arguments)(args))
+}
+
+// QueueArguments configures the in-memory queue of batches waiting to be
+// sent.
+type QueueArguments struct {
+	Enabled      bool `hcl:"enabled,optional"`
+	NumConsumers int  `hcl:"num_consumers,optional"`
+	QueueSize    int  `hcl:"queue_size,optional"`
+}
+
+// DefaultQueueArguments holds default settings for QueueArguments.
+var DefaultQueueArguments = QueueArguments{
+	Enabled:      true,
+	NumConsumers: 10,
+	QueueSize:    5000,
+}
+
+var _ gohcl.Decoder = (*QueueArguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (args *QueueArguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*args = DefaultQueueArguments
+
+	type arguments QueueArguments
+	return gohcl.DecodeBody(body, ctx, (*arguments)(args))
+}
+
+// RetryArguments configures how failed requests are retried.
+type RetryArguments struct {
+	Enabled         bool          `hcl:"enabled,optional"`
+	InitialInterval time.Duration `hcl:"initial_interval,optional"`
+	MaxInterval     time.Duration `hcl:"max_interval,optional"`
+	MaxElapsedTime  time.Duration `hcl:"max_elapsed_time,optional"`
+}
+
+// DefaultRetryArguments holds default settings for RetryArguments.
+var DefaultRetryArguments = RetryArguments{
+	Enabled:         true,
+	InitialInterval: 5 * time.Second,
+	MaxInterval:     30 * time.Second,
+	MaxElapsedTime:  5 * time.Minute,
+}
+
+var _ gohcl.Decoder = (*RetryArguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (args *RetryArguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*args = DefaultRetryArguments
+
+	type arguments RetryArguments
+	return gohcl.DecodeBody(body, ctx, (*arguments)(args))
+}
@@ -0,0 +1,139 @@
+// Package fanoutconsumer creates consumers which send telemetry data to a set
+// of otelcol.Consumers.
+package fanoutconsumer
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/grafana/agent/component/otelcol"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.uber.org/multierr"
+)
+
+// Traces creates a consumer which sends traces to each of cc. Traces returns
+// an error if any of cc does not support traces.
+func Traces(cc []*otelcol.Consumer) (consumer.Traces, error) {
+	var (
+		passthrough []consumer.Traces // Consumers which don't mutate data.
+		mutating    []consumer.Traces // Consumers which need their own copy.
+	)
+	for i, c := range cc {
+		if c == nil || c.Traces == nil {
+			return nil, fmt.Errorf("traces consumer %d does not support traces", i)
+		}
+		if c.Traces.Capabilities().MutatesData {
+			mutating = append(mutating, c.Traces)
+		} else {
+			passthrough = append(passthrough, c.Traces)
+		}
+	}
+	return &tracesFanout{passthrough: passthrough, mutating: mutating}, nil
+}
+
+type tracesFanout struct {
+	passthrough, mutating []consumer.Traces
+}
+
+func (f *tracesFanout) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: false}
+}
+
+func (f *tracesFanout) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	var errs error
+
+	// Consumers which mutate data get a copy, except for the last one when no
+	// other consumer will read the data afterwards.
+	for i, c := range f.mutating {
+		data := td
+		if i < len(f.mutating)-1 || len(f.passthrough) > 0 {
+			data = td.Clone()
+		}
+		errs = multierr.Append(errs, c.ConsumeTraces(ctx, data))
+	}
+	for _, c := range f.passthrough {
+		errs = multierr.Append(errs, c.ConsumeTraces(ctx, td))
+	}
+	return errs
+}
+
+// Metrics creates a consumer which sends metrics to each of cc. Metrics
+// returns an error if any of cc does not support metrics.
+func Metrics(cc []*otelcol.Consumer) (consumer.Metrics, error) {
+	var passthrough, mutating []consumer.Metrics
+	for i, c := range cc {
+		if c == nil || c.Metrics == nil {
+			return nil, fmt.Errorf("metrics consumer %d does not support metrics", i)
+		}
+		if c.Metrics.Capabilities().MutatesData {
+			mutating = append(mutating, c.Metrics)
+		} else {
+			passthrough = append(passthrough, c.Metrics)
+		}
+	}
+	return &metricsFanout{passthrough: passthrough, mutating: mutating}, nil
+}
+
+type metricsFanout struct {
+	passthrough, mutating []consumer.Metrics
+}
+
+func (f *metricsFanout) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: false}
+}
+
+func (f *metricsFanout) ConsumeMetrics(ctx context.Context, md pdata.Metrics) error {
+	var errs error
+	for i, c := range f.mutating {
+		data := md
+		if i < len(f.mutating)-1 || len(f.passthrough) > 0 {
+			data = md.Clone()
+		}
+		errs = multierr.Append(errs, c.ConsumeMetrics(ctx, data))
+	}
+	for _, c := range f.passthrough {
+		errs = multierr.Append(errs, c.ConsumeMetrics(ctx, md))
+	}
+	return errs
+}
+
+// Logs creates a consumer which sends logs to each of cc. Logs returns an
+// error if any of cc does not support logs.
+func Logs(cc []*otelcol.Consumer) (consumer.Logs, error) {
+	var passthrough, mutating []consumer.Logs
+	for i, c := range cc {
+		if c == nil || c.Logs == nil {
+			return nil, fmt.Errorf("logs consumer %d does not support logs", i)
+		}
+		if c.Logs.Capabilities().MutatesData {
+			mutating = append(mutating, c.Logs)
+		} else {
+			passthrough = append(passthrough, c.Logs)
+		}
+	}
+	return &logsFanout{passthrough: passthrough, mutating: mutating}, nil
+}
+
+type logsFanout struct {
+	passthrough, mutating []consumer.Logs
+}
+
+func (f *logsFanout) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: false}
+}
+
+func (f *logsFanout) ConsumeLogs(ctx context.Context, ld pdata.Logs) error {
+	var errs error
+	for i, c := range f.mutating {
+		data := ld
+		if i < len(f.mutating)-1 || len(f.passthrough) > 0 {
+			data = ld.Clone()
+		}
+		errs = multierr.Append(errs, c.ConsumeLogs(ctx, data))
+	}
+	for _, c := range f.passthrough {
+		errs = multierr.Append(errs, c.ConsumeLogs(ctx, ld))
+	}
+	return errs
+}
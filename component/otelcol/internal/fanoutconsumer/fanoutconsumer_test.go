@@ -0,0 +1,56 @@
+package fanoutconsumer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/agent/component/otelcol"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestTraces(t *testing.T) {
+	var (
+		passthrough = new(consumertest.TracesSink)
+		mutating    = &mutatingTraces{}
+	)
+
+	fanout, err := Traces([]*otelcol.Consumer{
+		{Traces: passthrough},
+		{Traces: mutating},
+	})
+	require.NoError(t, err)
+
+	td := pdata.NewTraces()
+	td.ResourceSpans().AppendEmpty().InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty().SetName("span")
+	require.NoError(t, fanout.ConsumeTraces(context.Background(), td))
+
+	// The mutating consumer must have been given its own copy, leaving the
+	// data seen by the passthrough consumer untouched.
+	require.Len(t, passthrough.AllTraces(), 1)
+	require.Equal(t, "span", firstSpanName(passthrough.AllTraces()[0]))
+	require.Equal(t, 1, mutating.calls)
+}
+
+func TestTraces_Unsupported(t *testing.T) {
+	_, err := Traces([]*otelcol.Consumer{{Metrics: new(consumertest.MetricsSink)}})
+	require.EqualError(t, err, "traces consumer 0 does not support traces")
+}
+
+type mutatingTraces struct{ calls int }
+
+func (m *mutatingTraces) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: true}
+}
+
+func (m *mutatingTraces) ConsumeTraces(_ context.Context, td pdata.Traces) error {
+	m.calls++
+	td.ResourceSpans().At(0).InstrumentationLibrarySpans().At(0).Spans().At(0).SetName("mutated")
+	return nil
+}
+
+func firstSpanName(td pdata.Traces) string {
+	return td.ResourceSpans().At(0).InstrumentationLibrarySpans().At(0).Spans().At(0).Name()
+}
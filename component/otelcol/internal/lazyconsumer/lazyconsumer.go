@@ -0,0 +1,93 @@
+// Package lazyconsumer implements a consumer whose underlying consumers may
+// be replaced at any time.
+package lazyconsumer
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/collector/component/componenterror"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+// Consumer is a consumer of traces, metrics, and logs which forwards data to
+// consumers set by SetConsumers. Components export a Consumer once and
+// replace what it forwards to whenever they are updated, so that exports
+// stay stable across updates.
+type Consumer struct {
+	mut             sync.RWMutex
+	metricsConsumer consumer.Metrics
+	logsConsumer    consumer.Logs
+	tracesConsumer  consumer.Traces
+}
+
+var (
+	_ consumer.Traces  = (*Consumer)(nil)
+	_ consumer.Metrics = (*Consumer)(nil)
+	_ consumer.Logs    = (*Consumer)(nil)
+)
+
+// New creates a new Consumer. Data is rejected until SetConsumers is called.
+func New() *Consumer {
+	return &Consumer{}
+}
+
+// Capabilities implements consumer.baseConsumer. Data is never mutated by
+// Consumer; underlying consumers which mutate data are given a copy.
+func (c *Consumer) Capabilities() consumer.Capabilities {
+	return consumer.Capabilities{MutatesData: false}
+}
+
+// ConsumeTraces implements consumer.Traces.
+func (c *Consumer) ConsumeTraces(ctx context.Context, td pdata.Traces) error {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	if c.tracesConsumer == nil {
+		return componenterror.ErrDataTypeIsNotSupported
+	}
+	if c.tracesConsumer.Capabilities().MutatesData {
+		td = td.Clone()
+	}
+	return c.tracesConsumer.ConsumeTraces(ctx, td)
+}
+
+// ConsumeMetrics implements consumer.Metrics.
+func (c *Consumer) ConsumeMetrics(ctx context.Context, md pdata.Metrics) error {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	if c.metricsConsumer == nil {
+		return componenterror.ErrDataTypeIsNotSupported
+	}
+	if c.metricsConsumer.Capabilities().MutatesData {
+		md = md.Clone()
+	}
+	return c.metricsConsumer.ConsumeMetrics(ctx, md)
+}
+
+// ConsumeLogs implements consumer.Logs.
+func (c *Consumer) ConsumeLogs(ctx context.Context, ld pdata.Logs) error {
+	c.mut.RLock()
+	defer c.mut.RUnlock()
+
+	if c.logsConsumer == nil {
+		return componenterror.ErrDataTypeIsNotSupported
+	}
+	if c.logsConsumer.Capabilities().MutatesData {
+		ld = ld.Clone()
+	}
+	return c.logsConsumer.ConsumeLogs(ctx, ld)
+}
+
+// SetConsumers updates the underlying consumers. Consumers may be nil for
+// signals which aren't supported.
+func (c *Consumer) SetConsumers(t consumer.Traces, m consumer.Metrics, l consumer.Logs) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	c.tracesConsumer = t
+	c.metricsConsumer = m
+	c.logsConsumer = l
+}
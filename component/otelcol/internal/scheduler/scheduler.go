@@ -0,0 +1,207 @@
+// Package scheduler runs OpenTelemetry Collector components for a Flow
+// component.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/grafana/agent/pkg/traces/contextkeys"
+	"github.com/prometheus/client_golang/prometheus"
+	otelcomponent "go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/config"
+	"go.uber.org/multierr"
+)
+
+// Scheduler runs the most recently scheduled set of OpenTelemetry Collector
+// components. Components are only started while Run is running.
+//
+// Components are started with a context holding a prometheus.Registerer
+// under contextkeys.PrometheusRegisterer. Each set of scheduled components
+// gets its own registry so metrics of stopped components are dropped;
+// Scheduler exposes the metrics of the running set as a prometheus.Collector.
+type Scheduler struct {
+	log   log.Logger
+	clock clock.Clock
+
+	mut        sync.Mutex
+	host       otelcomponent.Host
+	components []otelcomponent.Component
+
+	newComponentsCh chan struct{}
+
+	healthMut sync.RWMutex
+	health    component.Health
+
+	regMut sync.RWMutex
+	reg    *metrics.CollectorRegistry
+}
+
+var _ prometheus.Collector = (*Scheduler)(nil)
+
+// New creates a new Scheduler. Health reports are timestamped with clk.
+func New(l log.Logger, clk clock.Clock) *Scheduler {
+	return &Scheduler{
+		log:             l,
+		clock:           clk,
+		newComponentsCh: make(chan struct{}, 1),
+		reg:             metrics.NewCollectorRegistry(),
+	}
+}
+
+// Schedule replaces the set of running components with cc. The previously
+// scheduled components are shut down before cc are started.
+func (s *Scheduler) Schedule(h otelcomponent.Host, cc ...otelcomponent.Component) {
+	s.mut.Lock()
+	s.host = h
+	s.components = cc
+	s.mut.Unlock()
+
+	select {
+	case s.newComponentsCh <- struct{}{}:
+	default:
+		// no-op: new components are already queued.
+	}
+}
+
+// Run starts scheduled components until ctx is canceled. ctx is also passed
+// to each component when it is started.
+func (s *Scheduler) Run(ctx context.Context) error {
+	var running []otelcomponent.Component
+
+	defer func() {
+		s.stopComponents(running)
+	}()
+
+	// The most recently scheduled components must be started even if they
+	// were scheduled before Run was called.
+	select {
+	case s.newComponentsCh <- struct{}{}:
+	default:
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-s.newComponentsCh:
+			s.stopComponents(running)
+
+			s.mut.Lock()
+			host, cc := s.host, s.components
+			s.mut.Unlock()
+
+			running = s.startComponents(ctx, host, cc)
+		}
+	}
+}
+
+func (s *Scheduler) stopComponents(cc []otelcomponent.Component) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, c := range cc {
+		if err := c.Shutdown(ctx); err != nil {
+			level.Error(s.log).Log("msg", "failed to stop scheduled component", "err", err)
+		}
+	}
+}
+
+// startComponents starts cc and returns the components which started
+// successfully.
+func (s *Scheduler) startComponents(ctx context.Context, h otelcomponent.Host, cc []otelcomponent.Component) []otelcomponent.Component {
+	var (
+		errs    error
+		started = make([]otelcomponent.Component, 0, len(cc))
+	)
+
+	reg := metrics.NewCollectorRegistry()
+	s.regMut.Lock()
+	s.reg = reg
+	s.regMut.Unlock()
+
+	ctx = context.WithValue(ctx, contextkeys.PrometheusRegisterer, prometheus.Registerer(reg))
+
+	for _, c := range cc {
+		if err := c.Start(ctx, h); err != nil {
+			level.Error(s.log).Log("msg", "failed to start scheduled component", "err", err)
+			errs = multierr.Append(errs, err)
+			continue
+		}
+		started = append(started, c)
+	}
+
+	if errs != nil {
+		s.setHealth(component.Health{
+			Health:     component.HealthTypeUnhealthy,
+			Message:    fmt.Sprintf("failed to start components: %s", errs),
+			UpdateTime: s.clock.Now(),
+		})
+	} else {
+		s.setHealth(component.Health{
+			Health:     component.HealthTypeHealthy,
+			Message:    "started scheduled components",
+			UpdateTime: s.clock.Now(),
+		})
+	}
+	return started
+}
+
+// CurrentHealth implements component.HealthComponent. The health reflects
+// whether the most recently scheduled components started successfully.
+func (s *Scheduler) CurrentHealth() component.Health {
+	s.healthMut.RLock()
+	defer s.healthMut.RUnlock()
+	return s.health
+}
+
+func (s *Scheduler) setHealth(h component.Health) {
+	s.healthMut.Lock()
+	defer s.healthMut.Unlock()
+	s.health = h
+}
+
+// Describe implements prometheus.Collector.
+func (s *Scheduler) Describe(ch chan<- *prometheus.Desc) {
+	s.regMut.RLock()
+	defer s.regMut.RUnlock()
+	s.reg.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (s *Scheduler) Collect(ch chan<- prometheus.Metric) {
+	s.regMut.RLock()
+	defer s.regMut.RUnlock()
+	s.reg.Collect(ch)
+}
+
+// Host implements otelcomponent.Host for components which don't depend on
+// extensions, exporters, or other factories.
+type Host struct {
+	Logger log.Logger
+}
+
+var _ otelcomponent.Host = (*Host)(nil)
+
+// ReportFatalError implements otelcomponent.Host.
+func (h *Host) ReportFatalError(err error) {
+	level.Error(h.Logger).Log("msg", "fatal error reported by component", "err", err)
+}
+
+// GetFactory implements otelcomponent.Host.
+func (h *Host) GetFactory(otelcomponent.Kind, config.Type) otelcomponent.Factory { return nil }
+
+// GetExtensions implements otelcomponent.Host.
+func (h *Host) GetExtensions() map[config.ComponentID]otelcomponent.Extension { return nil }
+
+// GetExporters implements otelcomponent.Host.
+func (h *Host) GetExporters() map[config.DataType]map[config.ComponentID]otelcomponent.Exporter {
+	return nil
+}
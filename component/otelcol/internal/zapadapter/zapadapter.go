@@ -0,0 +1,89 @@
+// Package zapadapter allows creating a zap.Logger which writes to a go-kit
+// logger, since OpenTelemetry Collector components log through zap.
+package zapadapter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// New returns a new zap.Logger which writes to l.
+func New(l log.Logger) *zap.Logger {
+	return zap.New(&loggerCore{inner: l})
+}
+
+// loggerCore is a zapcore.Core implementation which writes to a go-kit
+// logger. Filtering by level is left to the go-kit logger.
+type loggerCore struct {
+	inner log.Logger
+}
+
+var _ zapcore.Core = (*loggerCore)(nil)
+
+// Enabled implements zapcore.Core.
+func (lc *loggerCore) Enabled(zapcore.Level) bool { return true }
+
+// With implements zapcore.Core.
+func (lc *loggerCore) With(ff []zapcore.Field) zapcore.Core {
+	return &loggerCore{inner: log.With(lc.inner, fieldsToKeyvals(ff)...)}
+}
+
+// Check implements zapcore.Core.
+func (lc *loggerCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	return ce.AddCore(e, lc)
+}
+
+// Write implements zapcore.Core.
+func (lc *loggerCore) Write(e zapcore.Entry, ff []zapcore.Field) error {
+	l := lc.inner
+	if e.LoggerName != "" {
+		l = log.With(l, "logger", e.LoggerName)
+	}
+
+	switch e.Level {
+	case zapcore.DebugLevel:
+		l = level.Debug(l)
+	case zapcore.InfoLevel:
+		l = level.Info(l)
+	case zapcore.WarnLevel:
+		l = level.Warn(l)
+	default:
+		l = level.Error(l)
+	}
+
+	keyvals := append([]interface{}{"msg", e.Message}, fieldsToKeyvals(ff)...)
+	return l.Log(keyvals...)
+}
+
+// Sync implements zapcore.Core.
+func (lc *loggerCore) Sync() error { return nil }
+
+// fieldsToKeyvals converts zap fields into go-kit key/value pairs.
+func fieldsToKeyvals(ff []zapcore.Field) []interface{} {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range ff {
+		f.AddTo(enc)
+	}
+
+	keyvals := make([]interface{}, 0, len(ff)*2)
+	for _, f := range ff {
+		v, ok := enc.Fields[f.Key]
+		if !ok {
+			continue
+		}
+		switch v := v.(type) {
+		case time.Duration:
+			keyvals = append(keyvals, f.Key, v.String())
+		case fmt.Stringer, error, string, bool, int64, uint64, float64:
+			keyvals = append(keyvals, f.Key, v)
+		default:
+			keyvals = append(keyvals, f.Key, fmt.Sprintf("%v", v))
+		}
+	}
+	return keyvals
+}
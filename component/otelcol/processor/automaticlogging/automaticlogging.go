@@ -0,0 +1,125 @@
+// Package automaticlogging provides an otelcol.processor.automatic_logging
+// component.
+package automaticlogging
+
+import (
+	"time"
+
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/otelcol"
+	"github.com/grafana/agent/component/otelcol/processor"
+	"github.com/grafana/agent/pkg/traces/automaticloggingprocessor"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/rfratto/gohcl"
+	otelconfig "go.opentelemetry.io/collector/config"
+)
+
+func init() {
+	component.Register(component.Registration{
+		Name:    "otelcol.processor.automatic_logging",
+		Args:    Arguments{},
+		Exports: otelcol.ConsumerExports{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			fact := automaticloggingprocessor.NewFactory()
+			return processor.New(opts, fact, args.(Arguments))
+		},
+	})
+}
+
+// Arguments configures the otelcol.processor.automatic_logging component.
+//
+// Log lines are always written to stdout; the logs_instance backend of the
+// static mode processor depends on a logs subsystem which Flow doesn't have.
+type Arguments struct {
+	Spans     bool `hcl:"spans,optional"`
+	Roots     bool `hcl:"roots,optional"`
+	Processes bool `hcl:"processes,optional"`
+
+	SpanAttributes    []string `hcl:"span_attributes,optional"`
+	ProcessAttributes []string `hcl:"process_attributes,optional"`
+	Labels            []string `hcl:"labels,optional"`
+
+	Overrides *OverrideArguments `hcl:"overrides,block"`
+
+	// Timeout is how long to wait for a trace to be complete before logging
+	// its root span and process.
+	Timeout time.Duration `hcl:"timeout,optional"`
+
+	// Output configures where to send traces. Required.
+	Output *otelcol.ConsumerArguments `hcl:"output,block"`
+}
+
+// OverrideArguments overrides the keys used in log lines.
+type OverrideArguments struct {
+	ServiceKey  string `hcl:"service_key,optional"`
+	SpanNameKey string `hcl:"span_name_key,optional"`
+	StatusKey   string `hcl:"status_key,optional"`
+	DurationKey string `hcl:"duration_key,optional"`
+	TraceIDKey  string `hcl:"trace_id_key,optional"`
+}
+
+var (
+	_ processor.Arguments = Arguments{}
+	_ gohcl.Decoder       = (*Arguments)(nil)
+)
+
+// DecodeHCL implements gohcl.Decoder.
+func (args *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*args = Arguments{}
+
+	type arguments Arguments
+	if err := gohcl.DecodeBody(body, ctx, (*arguments)(args)); err != nil {
+		return err
+	}
+
+	if !args.Spans && !args.Roots && !args.Processes {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "At least one of spans, roots, or processes must be enabled",
+			Subject:  body.MissingItemRange().Ptr(),
+		}}
+	}
+	if args.Output == nil {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Missing required output block",
+			Subject:  body.MissingItemRange().Ptr(),
+		}}
+	}
+	return nil
+}
+
+// Convert implements processor.Arguments.
+func (args Arguments) Convert() otelconfig.Processor {
+	var overrides automaticloggingprocessor.OverrideConfig
+	if args.Overrides != nil {
+		overrides = automaticloggingprocessor.OverrideConfig{
+			ServiceKey:  args.Overrides.ServiceKey,
+			SpanNameKey: args.Overrides.SpanNameKey,
+			StatusKey:   args.Overrides.StatusKey,
+			DurationKey: args.Overrides.DurationKey,
+			TraceIDKey:  args.Overrides.TraceIDKey,
+		}
+	}
+
+	return &automaticloggingprocessor.Config{
+		ProcessorSettings: otelconfig.NewProcessorSettings(otelconfig.NewComponentID(automaticloggingprocessor.TypeStr)),
+		LoggingConfig: &automaticloggingprocessor.AutomaticLoggingConfig{
+			Backend:           automaticloggingprocessor.BackendStdout,
+			Spans:             args.Spans,
+			Roots:             args.Roots,
+			Processes:         args.Processes,
+			SpanAttributes:    args.SpanAttributes,
+			ProcessAttributes: args.ProcessAttributes,
+			Overrides:         overrides,
+			Timeout:           args.Timeout,
+			Labels:            args.Labels,
+		},
+	}
+}
+
+// NextConsumers implements processor.Arguments.
+func (args Arguments) NextConsumers() *otelcol.ConsumerArguments {
+	return args.Output
+}
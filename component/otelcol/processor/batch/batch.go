@@ -0,0 +1,95 @@
+// Package batch provides an otelcol.processor.batch component.
+package batch
+
+import (
+	"time"
+
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/otelcol"
+	"github.com/grafana/agent/component/otelcol/processor"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/rfratto/gohcl"
+	otelconfig "go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/processor/batchprocessor"
+)
+
+func init() {
+	component.Register(component.Registration{
+		Name:    "otelcol.processor.batch",
+		Args:    Arguments{},
+		Exports: otelcol.ConsumerExports{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			fact := batchprocessor.NewFactory()
+			return processor.New(opts, fact, args.(Arguments))
+		},
+	})
+}
+
+// Arguments configures the otelcol.processor.batch component.
+type Arguments struct {
+	// Timeout is how long to wait before flushing a batch regardless of its
+	// size.
+	Timeout time.Duration `hcl:"timeout,optional"`
+	// SendBatchSize is the number of spans, metric data points, or log
+	// records after which a batch is sent regardless of Timeout.
+	SendBatchSize uint32 `hcl:"send_batch_size,optional"`
+	// SendBatchMaxSize is the upper limit of a batch size. Larger batches are
+	// split. 0 means no limit.
+	SendBatchMaxSize uint32 `hcl:"send_batch_max_size,optional"`
+
+	// Output configures where to send processed data. Required.
+	Output *otelcol.ConsumerArguments `hcl:"output,block"`
+}
+
+var (
+	_ processor.Arguments = Arguments{}
+	_ gohcl.Decoder       = (*Arguments)(nil)
+)
+
+// DefaultArguments holds default settings for Arguments.
+var DefaultArguments = Arguments{
+	Timeout:       200 * time.Millisecond,
+	SendBatchSize: 8192,
+}
+
+// DecodeHCL implements gohcl.Decoder.
+func (args *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*args = DefaultArguments
+
+	type arguments Arguments
+	if err := gohcl.DecodeBody(body, ctx, (*arguments)(args)); err != nil {
+		return err
+	}
+
+	if args.SendBatchMaxSize > 0 && args.SendBatchMaxSize < args.SendBatchSize {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "send_batch_max_size must be greater or equal to send_batch_size",
+			Subject:  body.MissingItemRange().Ptr(),
+		}}
+	}
+	if args.Output == nil {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Missing required output block",
+			Subject:  body.MissingItemRange().Ptr(),
+		}}
+	}
+	return nil
+}
+
+// Convert implements processor.Arguments.
+func (args Arguments) Convert() otelconfig.Processor {
+	return &batchprocessor.Config{
+		ProcessorSettings: otelconfig.NewProcessorSettings(otelconfig.NewComponentID("batch")),
+		Timeout:           args.Timeout,
+		SendBatchSize:     args.SendBatchSize,
+		SendBatchMaxSize:  args.SendBatchMaxSize,
+	}
+}
+
+// NextConsumers implements processor.Arguments.
+func (args Arguments) NextConsumers() *otelcol.ConsumerArguments {
+	return args.Output
+}
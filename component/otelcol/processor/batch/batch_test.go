@@ -0,0 +1,61 @@
+package batch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/otelcol"
+	"github.com/grafana/agent/component/otelcol/processor"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.opentelemetry.io/collector/processor/batchprocessor"
+)
+
+func TestBatch(t *testing.T) {
+	sink := new(consumertest.TracesSink)
+
+	var exports otelcol.ConsumerExports
+	p, err := processor.New(component.Options{
+		ID:       "otelcol.processor.batch.test",
+		Logger:   log.NewNopLogger(),
+		Clock:    clock.New(),
+		DataPath: t.TempDir(),
+		OnStateChange: func(e component.Exports) {
+			exports = e.(otelcol.ConsumerExports)
+		},
+	}, batchprocessor.NewFactory(), Arguments{
+		Timeout:       10 * time.Millisecond,
+		SendBatchSize: 100,
+		Output:        &otelcol.ConsumerArguments{Traces: []*otelcol.Consumer{{Traces: sink}}},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, exports.Input)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = p.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return p.CurrentHealth().Health == component.HealthTypeHealthy
+	}, time.Second, 10*time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		td := pdata.NewTraces()
+		td.ResourceSpans().AppendEmpty().InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty()
+		require.NoError(t, exports.Input.Traces.ConsumeTraces(context.Background(), td))
+	}
+
+	// The three spans should be flushed together once the timeout elapses.
+	require.Eventually(t, func() bool {
+		return sink.SpanCount() == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, sink.AllTraces(), 1)
+
+	// Metrics aren't supported by the output, so they must be rejected.
+	require.Error(t, exports.Input.Metrics.ConsumeMetrics(context.Background(), pdata.NewMetrics()))
+}
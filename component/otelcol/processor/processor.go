@@ -0,0 +1,202 @@
+// Package processor provides utilities to create a Flow component from
+// OpenTelemetry Collector processors.
+package processor
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/otelcol"
+	"github.com/grafana/agent/component/otelcol/internal/fanoutconsumer"
+	"github.com/grafana/agent/component/otelcol/internal/lazyconsumer"
+	"github.com/grafana/agent/component/otelcol/internal/scheduler"
+	"github.com/grafana/agent/component/otelcol/internal/zapadapter"
+	"github.com/grafana/agent/pkg/build"
+	"github.com/prometheus/client_golang/prometheus"
+	otelcomponent "go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	otelconfig "go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Arguments is an extension of component.Arguments which contains necessary
+// settings for OpenTelemetry Collector processors.
+type Arguments interface {
+	component.Arguments
+
+	// Convert converts the Arguments into an OpenTelemetry Collector processor
+	// configuration.
+	Convert() otelconfig.Processor
+
+	// NextConsumers returns the set of consumers to send data to.
+	NextConsumers() *otelcol.ConsumerArguments
+}
+
+// Processor is a Flow component shim which manages an OpenTelemetry Collector
+// processor component.
+type Processor struct {
+	opts     component.Options
+	factory  otelcomponent.ProcessorFactory
+	consumer *lazyconsumer.Consumer
+
+	sched *scheduler.Scheduler
+}
+
+var (
+	_ component.Component       = (*Processor)(nil)
+	_ component.HealthComponent = (*Processor)(nil)
+	_ prometheus.Collector      = (*Processor)(nil)
+)
+
+// New creates a new Flow component which encapsulates an OpenTelemetry
+// Collector processor. args must hold a value of the argument type registered
+// with the Flow component.
+//
+// The registered component must be registered to export the
+// otelcol.ConsumerExports type, otherwise New will panic.
+func New(opts component.Options, f otelcomponent.ProcessorFactory, args Arguments) (*Processor, error) {
+	consumer := lazyconsumer.New()
+
+	// Immediately set our state with our consumer. The exports will never
+	// change throughout the lifetime of our component.
+	//
+	// This will panic if the wrapping component is not registered to export
+	// otelcol.ConsumerExports.
+	opts.OnStateChange(otelcol.ConsumerExports{
+		Input: &otelcol.Consumer{
+			Traces:  consumer,
+			Metrics: consumer,
+			Logs:    consumer,
+		},
+	})
+
+	p := &Processor{
+		opts:     opts,
+		factory:  f,
+		consumer: consumer,
+
+		sched: scheduler.New(opts.Logger, opts.Clock),
+	}
+	if err := p.Update(args); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Run starts the Processor component.
+func (p *Processor) Run(ctx context.Context) error {
+	return p.sched.Run(ctx)
+}
+
+// Update implements component.Component. It will convert the Arguments into
+// configuration for OpenTelemetry Collector processor configuration and manage
+// the underlying OpenTelemetry Collector processor.
+func (p *Processor) Update(args component.Arguments) error {
+	pargs := args.(Arguments)
+
+	host := &scheduler.Host{Logger: p.opts.Logger}
+
+	settings := otelcomponent.ProcessorCreateSettings{
+		TelemetrySettings: otelcomponent.TelemetrySettings{
+			Logger:         zapadapter.New(p.opts.Logger),
+			TracerProvider: trace.NewNoopTracerProvider(),
+			MeterProvider:  metric.NewNoopMeterProvider(),
+		},
+		BuildInfo: otelcomponent.BuildInfo{
+			Command:     os.Args[0],
+			Description: "Grafana Agent",
+			Version:     build.Version,
+		},
+	}
+
+	processorConfig := pargs.Convert()
+	if err := processorConfig.Validate(); err != nil {
+		return err
+	}
+
+	var (
+		next        = pargs.NextConsumers()
+		nextTraces  consumer.Traces
+		nextMetrics consumer.Metrics
+		nextLogs    consumer.Logs
+		err         error
+	)
+	if next == nil {
+		next = &otelcol.ConsumerArguments{}
+	}
+	if len(next.Traces) > 0 {
+		if nextTraces, err = fanoutconsumer.Traces(next.Traces); err != nil {
+			return err
+		}
+	}
+	if len(next.Metrics) > 0 {
+		if nextMetrics, err = fanoutconsumer.Metrics(next.Metrics); err != nil {
+			return err
+		}
+	}
+	if len(next.Logs) > 0 {
+		if nextLogs, err = fanoutconsumer.Logs(next.Logs); err != nil {
+			return err
+		}
+	}
+
+	// Create instances of the processor from our factory for each of our
+	// supported telemetry signals which have a consumer.
+	var (
+		components []otelcomponent.Component
+
+		tracesProcessor  otelcomponent.TracesProcessor
+		metricsProcessor otelcomponent.MetricsProcessor
+		logsProcessor    otelcomponent.LogsProcessor
+	)
+
+	if nextTraces != nil {
+		tracesProcessor, err = p.factory.CreateTracesProcessor(context.Background(), settings, processorConfig, nextTraces)
+		if err != nil && !errors.Is(err, componenterror.ErrDataTypeIsNotSupported) {
+			return err
+		} else if tracesProcessor != nil {
+			components = append(components, tracesProcessor)
+		}
+	}
+	if nextMetrics != nil {
+		metricsProcessor, err = p.factory.CreateMetricsProcessor(context.Background(), settings, processorConfig, nextMetrics)
+		if err != nil && !errors.Is(err, componenterror.ErrDataTypeIsNotSupported) {
+			return err
+		} else if metricsProcessor != nil {
+			components = append(components, metricsProcessor)
+		}
+	}
+	if nextLogs != nil {
+		logsProcessor, err = p.factory.CreateLogsProcessor(context.Background(), settings, processorConfig, nextLogs)
+		if err != nil && !errors.Is(err, componenterror.ErrDataTypeIsNotSupported) {
+			return err
+		} else if logsProcessor != nil {
+			components = append(components, logsProcessor)
+		}
+	}
+
+	// Schedule the components to run once our component is running, and
+	// forward data we receive to the new processors.
+	p.sched.Schedule(host, components...)
+	p.consumer.SetConsumers(tracesProcessor, metricsProcessor, logsProcessor)
+	return nil
+}
+
+// CurrentHealth implements component.HealthComponent.
+func (p *Processor) CurrentHealth() component.Health {
+	return p.sched.CurrentHealth()
+}
+
+// Describe implements prometheus.Collector.
+func (p *Processor) Describe(ch chan<- *prometheus.Desc) {
+	p.sched.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (p *Processor) Collect(ch chan<- prometheus.Metric) {
+	p.sched.Collect(ch)
+}
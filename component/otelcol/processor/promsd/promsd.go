@@ -0,0 +1,112 @@
+// Package promsd provides an otelcol.processor.prom_sd component.
+package promsd
+
+import (
+	"fmt"
+
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/otelcol"
+	"github.com/grafana/agent/component/otelcol/processor"
+	"github.com/grafana/agent/pkg/traces/promsdprocessor"
+	"github.com/hashicorp/hcl/v2"
+	prom_config "github.com/prometheus/prometheus/config"
+	"github.com/rfratto/gohcl"
+	otelconfig "go.opentelemetry.io/collector/config"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	component.Register(component.Registration{
+		Name:    "otelcol.processor.prom_sd",
+		Args:    Arguments{},
+		Exports: otelcol.ConsumerExports{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			fact := promsdprocessor.NewFactory()
+			return processor.New(opts, fact, args.(Arguments))
+		},
+	})
+}
+
+// Arguments configures the otelcol.processor.prom_sd component.
+type Arguments struct {
+	// ScrapeConfigs is a YAML list of Prometheus scrape configs whose
+	// discovered targets are used to add labels to spans.
+	ScrapeConfigs string `hcl:"scrape_configs"`
+	// OperationType is one of insert, update, or upsert.
+	OperationType string `hcl:"operation_type,optional"`
+	// PodAssociations is the list of span attributes used to find the IP of
+	// the target which emitted a span.
+	PodAssociations []string `hcl:"pod_associations,optional"`
+
+	// Output configures where to send traces. Required.
+	Output *otelcol.ConsumerArguments `hcl:"output,block"`
+}
+
+var (
+	_ processor.Arguments = Arguments{}
+	_ gohcl.Decoder       = (*Arguments)(nil)
+)
+
+// DefaultArguments holds default settings for Arguments.
+var DefaultArguments = Arguments{
+	OperationType: promsdprocessor.OperationTypeUpsert,
+}
+
+// DecodeHCL implements gohcl.Decoder.
+func (args *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*args = DefaultArguments
+
+	type arguments Arguments
+	if err := gohcl.DecodeBody(body, ctx, (*arguments)(args)); err != nil {
+		return err
+	}
+
+	var scrapeConfigs []*prom_config.ScrapeConfig
+	if err := yaml.UnmarshalStrict([]byte(args.ScrapeConfigs), &scrapeConfigs); err != nil {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid scrape_configs: %s", err),
+			Subject:  body.MissingItemRange().Ptr(),
+		}}
+	}
+
+	switch args.OperationType {
+	case promsdprocessor.OperationTypeInsert, promsdprocessor.OperationTypeUpdate, promsdprocessor.OperationTypeUpsert:
+	default:
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Invalid operation_type %q", args.OperationType),
+			Subject:  body.MissingItemRange().Ptr(),
+		}}
+	}
+
+	if args.Output == nil {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Missing required output block",
+			Subject:  body.MissingItemRange().Ptr(),
+		}}
+	}
+	return nil
+}
+
+// Convert implements processor.Arguments.
+func (args Arguments) Convert() otelconfig.Processor {
+	// The processor expects scrape configs as generic YAML values. They were
+	// already validated when the arguments were decoded.
+	var scrapeConfigs []interface{}
+	_ = yaml.Unmarshal([]byte(args.ScrapeConfigs), &scrapeConfigs)
+
+	return &promsdprocessor.Config{
+		ProcessorSettings: otelconfig.NewProcessorSettings(otelconfig.NewComponentID(promsdprocessor.TypeStr)),
+		ScrapeConfigs:     scrapeConfigs,
+		OperationType:     args.OperationType,
+		PodAssociations:   args.PodAssociations,
+	}
+}
+
+// NextConsumers implements processor.Arguments.
+func (args Arguments) NextConsumers() *otelcol.ConsumerArguments {
+	return args.Output
+}
@@ -0,0 +1,88 @@
+// Package servicegraph provides an otelcol.processor.service_graph component.
+package servicegraph
+
+import (
+	"time"
+
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/otelcol"
+	"github.com/grafana/agent/component/otelcol/processor"
+	"github.com/grafana/agent/pkg/traces/servicegraphprocessor"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/rfratto/gohcl"
+	otelconfig "go.opentelemetry.io/collector/config"
+)
+
+func init() {
+	component.Register(component.Registration{
+		Name:    "otelcol.processor.service_graph",
+		Args:    Arguments{},
+		Exports: otelcol.ConsumerExports{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			fact := servicegraphprocessor.NewFactory()
+			return processor.New(opts, fact, args.(Arguments))
+		},
+	})
+}
+
+// Arguments configures the otelcol.processor.service_graph component.
+// Service graph metrics are exposed as metrics of the component.
+type Arguments struct {
+	// Wait is how long to wait for the other side of an edge before the edge
+	// is considered expired.
+	Wait time.Duration `hcl:"wait,optional"`
+	// MaxItems is the maximum number of incomplete edges to keep in memory.
+	MaxItems int `hcl:"max_items,optional"`
+	// Workers is the number of workers which complete edges.
+	Workers int `hcl:"workers,optional"`
+
+	// Output configures where to send traces. Required.
+	Output *otelcol.ConsumerArguments `hcl:"output,block"`
+}
+
+var (
+	_ processor.Arguments = Arguments{}
+	_ gohcl.Decoder       = (*Arguments)(nil)
+)
+
+// DefaultArguments holds default settings for Arguments.
+var DefaultArguments = Arguments{
+	Wait:     servicegraphprocessor.DefaultWait,
+	MaxItems: servicegraphprocessor.DefaultMaxItems,
+	Workers:  servicegraphprocessor.DefaultWorkers,
+}
+
+// DecodeHCL implements gohcl.Decoder.
+func (args *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*args = DefaultArguments
+
+	type arguments Arguments
+	if err := gohcl.DecodeBody(body, ctx, (*arguments)(args)); err != nil {
+		return err
+	}
+
+	if args.Output == nil {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Missing required output block",
+			Subject:  body.MissingItemRange().Ptr(),
+		}}
+	}
+	return nil
+}
+
+// Convert implements processor.Arguments.
+func (args Arguments) Convert() otelconfig.Processor {
+	return &servicegraphprocessor.Config{
+		ProcessorSettings: otelconfig.NewProcessorSettings(otelconfig.NewComponentID(servicegraphprocessor.TypeStr)),
+		Wait:              args.Wait,
+		MaxItems:          args.MaxItems,
+		Workers:           args.Workers,
+	}
+}
+
+// NextConsumers implements processor.Arguments.
+func (args Arguments) NextConsumers() *otelcol.ConsumerArguments {
+	return args.Output
+}
@@ -0,0 +1,122 @@
+// Package otlp provides an otelcol.receiver.otlp component.
+package otlp
+
+import (
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/otelcol"
+	"github.com/grafana/agent/component/otelcol/receiver"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/rfratto/gohcl"
+	otelconfig "go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/receiver/otlpreceiver"
+)
+
+func init() {
+	component.Register(component.Registration{
+		Name: "otelcol.receiver.otlp",
+		Args: Arguments{},
+
+		Build: func(opts component.Options, args component.Arguments) (component.Component, error) {
+			fact := otlpreceiver.NewFactory()
+			return receiver.New(opts, fact, args.(Arguments))
+		},
+	})
+}
+
+// Arguments configures the otelcol.receiver.otlp component.
+type Arguments struct {
+	GRPC *GRPCServerArguments `hcl:"grpc,block"`
+	HTTP *HTTPServerArguments `hcl:"http,block"`
+
+	// Output configures where to send received data. Required.
+	Output *otelcol.ConsumerArguments `hcl:"output,block"`
+}
+
+var (
+	_ receiver.Arguments = Arguments{}
+	_ gohcl.Decoder      = (*Arguments)(nil)
+)
+
+// DecodeHCL implements gohcl.Decoder.
+func (args *Arguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*args = Arguments{}
+
+	type arguments Arguments
+	if err := gohcl.DecodeBody(body, ctx, (*arguments)(args)); err != nil {
+		return err
+	}
+
+	if args.GRPC == nil && args.HTTP == nil {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "At least one of the grpc or http blocks must be provided",
+			Subject:  body.MissingItemRange().Ptr(),
+		}}
+	}
+	if args.Output == nil {
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Missing required output block",
+			Subject:  body.MissingItemRange().Ptr(),
+		}}
+	}
+	return nil
+}
+
+// Convert implements receiver.Arguments.
+func (args Arguments) Convert() otelconfig.Receiver {
+	return &otlpreceiver.Config{
+		ReceiverSettings: otelconfig.NewReceiverSettings(otelconfig.NewComponentID("otlp")),
+		Protocols: otlpreceiver.Protocols{
+			GRPC: (*otelcol.GRPCServerArguments)(args.GRPC).Convert(),
+			HTTP: (*otelcol.HTTPServerArguments)(args.HTTP).Convert(),
+		},
+	}
+}
+
+// NextConsumers implements receiver.Arguments.
+func (args Arguments) NextConsumers() *otelcol.ConsumerArguments {
+	return args.Output
+}
+
+// GRPCServerArguments is used to configure otelcol.receiver.otlp with
+// component-specific defaults.
+type GRPCServerArguments otelcol.GRPCServerArguments
+
+// DefaultGRPCServerArguments holds component-specific default settings for
+// the grpc block.
+var DefaultGRPCServerArguments = GRPCServerArguments{
+	Endpoint:       "0.0.0.0:4317",
+	Transport:      "tcp",
+	ReadBufferSize: 512 * 1024,
+}
+
+var _ gohcl.Decoder = (*GRPCServerArguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (args *GRPCServerArguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*args = DefaultGRPCServerArguments
+
+	type arguments GRPCServerArguments
+	return gohcl.DecodeBody(body, ctx, (*arguments)(args))
+}
+
+// HTTPServerArguments is used to configure otelcol.receiver.otlp with
+// component-specific defaults.
+type HTTPServerArguments otelcol.HTTPServerArguments
+
+// DefaultHTTPServerArguments holds component-specific default settings for
+// the http block.
+var DefaultHTTPServerArguments = HTTPServerArguments{
+	Endpoint: "0.0.0.0:4318",
+}
+
+var _ gohcl.Decoder = (*HTTPServerArguments)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (args *HTTPServerArguments) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*args = DefaultHTTPServerArguments
+
+	type arguments HTTPServerArguments
+	return gohcl.DecodeBody(body, ctx, (*arguments)(args))
+}
@@ -0,0 +1,68 @@
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/go-kit/log"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/otelcol"
+	"github.com/grafana/agent/component/otelcol/receiver"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/consumer/consumertest"
+	"go.opentelemetry.io/collector/model/otlp"
+	"go.opentelemetry.io/collector/model/pdata"
+	"go.opentelemetry.io/collector/receiver/otlpreceiver"
+)
+
+func TestReceiver_HTTP(t *testing.T) {
+	sink := new(consumertest.TracesSink)
+	addr := freeAddr(t)
+
+	r, err := receiver.New(component.Options{
+		ID:            "otelcol.receiver.otlp.test",
+		Logger:        log.NewNopLogger(),
+		Clock:         clock.New(),
+		DataPath:      t.TempDir(),
+		OnStateChange: func(e component.Exports) {},
+	}, otlpreceiver.NewFactory(), Arguments{
+		HTTP:   &HTTPServerArguments{Endpoint: addr},
+		Output: &otelcol.ConsumerArguments{Traces: []*otelcol.Consumer{{Traces: sink}}},
+	})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = r.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		return r.CurrentHealth().Health == component.HealthTypeHealthy
+	}, time.Second, 10*time.Millisecond)
+
+	td := pdata.NewTraces()
+	td.ResourceSpans().AppendEmpty().InstrumentationLibrarySpans().AppendEmpty().Spans().AppendEmpty().SetName("span")
+	body, err := otlp.NewProtobufTracesMarshaler().MarshalTraces(td)
+	require.NoError(t, err)
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/v1/traces", addr), "application/x-protobuf", bytes.NewReader(body))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.Equal(t, 1, sink.SpanCount())
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
@@ -0,0 +1,173 @@
+// Package receiver provides utilities to create a Flow component from
+// OpenTelemetry Collector receivers.
+package receiver
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/otelcol"
+	"github.com/grafana/agent/component/otelcol/internal/fanoutconsumer"
+	"github.com/grafana/agent/component/otelcol/internal/scheduler"
+	"github.com/grafana/agent/component/otelcol/internal/zapadapter"
+	"github.com/grafana/agent/pkg/build"
+	"github.com/prometheus/client_golang/prometheus"
+	otelcomponent "go.opentelemetry.io/collector/component"
+	"go.opentelemetry.io/collector/component/componenterror"
+	otelconfig "go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Arguments is an extension of component.Arguments which contains necessary
+// settings for OpenTelemetry Collector receivers.
+type Arguments interface {
+	component.Arguments
+
+	// Convert converts the Arguments into an OpenTelemetry Collector receiver
+	// configuration.
+	Convert() otelconfig.Receiver
+
+	// NextConsumers returns the set of consumers to send data to.
+	NextConsumers() *otelcol.ConsumerArguments
+}
+
+// Receiver is a Flow component shim which manages an OpenTelemetry Collector
+// receiver component.
+type Receiver struct {
+	opts    component.Options
+	factory otelcomponent.ReceiverFactory
+
+	sched *scheduler.Scheduler
+}
+
+var (
+	_ component.Component       = (*Receiver)(nil)
+	_ component.HealthComponent = (*Receiver)(nil)
+	_ prometheus.Collector      = (*Receiver)(nil)
+)
+
+// New creates a new Flow component which encapsulates an OpenTelemetry
+// Collector receiver. args must hold a value of the argument type registered
+// with the Flow component.
+func New(opts component.Options, f otelcomponent.ReceiverFactory, args Arguments) (*Receiver, error) {
+	r := &Receiver{
+		opts:    opts,
+		factory: f,
+
+		sched: scheduler.New(opts.Logger, opts.Clock),
+	}
+	if err := r.Update(args); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Run starts the Receiver component.
+func (r *Receiver) Run(ctx context.Context) error {
+	return r.sched.Run(ctx)
+}
+
+// Update implements component.Component. It will convert the Arguments into
+// configuration for OpenTelemetry Collector receiver configuration and manage
+// the underlying OpenTelemetry Collector receiver.
+func (r *Receiver) Update(args component.Arguments) error {
+	rargs := args.(Arguments)
+
+	host := &scheduler.Host{Logger: r.opts.Logger}
+
+	settings := otelcomponent.ReceiverCreateSettings{
+		TelemetrySettings: otelcomponent.TelemetrySettings{
+			Logger:         zapadapter.New(r.opts.Logger),
+			TracerProvider: trace.NewNoopTracerProvider(),
+			MeterProvider:  metric.NewNoopMeterProvider(),
+		},
+		BuildInfo: otelcomponent.BuildInfo{
+			Command:     os.Args[0],
+			Description: "Grafana Agent",
+			Version:     build.Version,
+		},
+	}
+
+	receiverConfig := rargs.Convert()
+	if err := receiverConfig.Validate(); err != nil {
+		return err
+	}
+
+	var (
+		next        = rargs.NextConsumers()
+		nextTraces  consumer.Traces
+		nextMetrics consumer.Metrics
+		nextLogs    consumer.Logs
+		err         error
+	)
+	if next == nil {
+		next = &otelcol.ConsumerArguments{}
+	}
+	if len(next.Traces) > 0 {
+		if nextTraces, err = fanoutconsumer.Traces(next.Traces); err != nil {
+			return err
+		}
+	}
+	if len(next.Metrics) > 0 {
+		if nextMetrics, err = fanoutconsumer.Metrics(next.Metrics); err != nil {
+			return err
+		}
+	}
+	if len(next.Logs) > 0 {
+		if nextLogs, err = fanoutconsumer.Logs(next.Logs); err != nil {
+			return err
+		}
+	}
+
+	// Create instances of the receiver from our factory for each of our
+	// supported telemetry signals which have a consumer.
+	var components []otelcomponent.Component
+
+	if nextTraces != nil {
+		tracesReceiver, err := r.factory.CreateTracesReceiver(context.Background(), settings, receiverConfig, nextTraces)
+		if err != nil && !errors.Is(err, componenterror.ErrDataTypeIsNotSupported) {
+			return err
+		} else if tracesReceiver != nil {
+			components = append(components, tracesReceiver)
+		}
+	}
+	if nextMetrics != nil {
+		metricsReceiver, err := r.factory.CreateMetricsReceiver(context.Background(), settings, receiverConfig, nextMetrics)
+		if err != nil && !errors.Is(err, componenterror.ErrDataTypeIsNotSupported) {
+			return err
+		} else if metricsReceiver != nil {
+			components = append(components, metricsReceiver)
+		}
+	}
+	if nextLogs != nil {
+		logsReceiver, err := r.factory.CreateLogsReceiver(context.Background(), settings, receiverConfig, nextLogs)
+		if err != nil && !errors.Is(err, componenterror.ErrDataTypeIsNotSupported) {
+			return err
+		} else if logsReceiver != nil {
+			components = append(components, logsReceiver)
+		}
+	}
+
+	// Schedule the components to run once our component is running.
+	r.sched.Schedule(host, components...)
+	return nil
+}
+
+// CurrentHealth implements component.HealthComponent.
+func (r *Receiver) CurrentHealth() component.Health {
+	return r.sched.CurrentHealth()
+}
+
+// Describe implements prometheus.Collector.
+func (r *Receiver) Describe(ch chan<- *prometheus.Desc) {
+	r.sched.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (r *Receiver) Collect(ch chan<- prometheus.Metric) {
+	r.sched.Collect(ch)
+}
@@ -3,11 +3,15 @@ package component
 import (
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
 
+	"github.com/benbjohnson/clock"
 	"github.com/go-kit/log"
+	"github.com/grafana/agent/pkg/cluster"
 	"github.com/grafana/regexp"
 	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 )
 
 // The parsedName of a component is the parts of its name ("remote.http") split
@@ -50,16 +54,29 @@ type Options struct {
 	// by the component; a component must use the same Exports type for its
 	// lifetime.
 	OnStateChange func(e Exports)
+
+	// Clusterer is the node of the cluster the Flow controller is running in.
+	// Components which work on targets can use Clusterer to only handle the
+	// targets owned by the local node, and can observe Clusterer to rebalance
+	// targets when peers join or leave the cluster.
+	//
+	// Clusterer is never nil; when clustering is disabled, it is a node in a
+	// single-node cluster which owns everything.
+	Clusterer cluster.Node
+
+	// Clock components should use for timers and getting the current time,
+	// allowing time to be faked in tests. Clock is never nil.
+	Clock clock.Clock
 }
 
 // Registration describes a single component.
 type Registration struct {
 	// Name of the component. Must be a list of period-delimited valid
-	// identifiers, such as "remote.s3". Components sharing a prefix must have
-	// the same number of identifiers; it is valid to register "remote.s3" and
-	// "remote.http" but not "remote".
+	// identifiers, such as "remote.s3". The name of a component may not be a
+	// prefix of the name of another component; it is valid to register
+	// "remote.s3" and "remote.http.client" but not "remote" or "remote.s3.get".
 	//
-	// Components may not have more than 2 identifiers.
+	// Components may not have more than 3 identifiers.
 	//
 	// Each identifier must start with a valid ASCII letter, and be followed by
 	// any number of underscores or alphanumeric ASCII characters.
@@ -137,8 +154,8 @@ func parseComponentName(name string) (parsedName, error) {
 		return nil, fmt.Errorf("missing name")
 	}
 
-	if len(parts) > 2 {
-		return nil, fmt.Errorf("component name may only have 1 or 2 identifiers, found %d", len(parts))
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("component name may only have between 1 and 3 identifiers, found %d", len(parts))
 	}
 
 	for _, part := range parts {
@@ -154,18 +171,15 @@ func parseComponentName(name string) (parsedName, error) {
 	return parts, nil
 }
 
-// validatePrefixMatch validates that components that share a prefix have the
-// same length of identifiers in their names.
+// validatePrefixMatch validates that the name of a component isn't a prefix of
+// the name of another component, which would make it ambiguous which
+// component a block refers to.
 //
 // For example, this will return an error if both a "remote" and "remote.http"
 // component are defined.
 func validatePrefixMatch(check parsedName, against map[string]parsedName) error {
 	for _, other := range against {
-		if other[0] != check[0] {
-			continue
-		}
-
-		if len(other) != len(check) {
+		if isPrefix(check, other) || isPrefix(other, check) {
 			return fmt.Errorf("%q cannot be used because it is incompatible with %q", check, other)
 		}
 	}
@@ -173,68 +187,173 @@ func validatePrefixMatch(check parsedName, against map[string]parsedName) error
 	return nil
 }
 
+// isPrefix returns true if all identifiers of prefix are the first
+// identifiers of name.
+func isPrefix(prefix, name parsedName) bool {
+	if len(prefix) > len(name) {
+		return false
+	}
+	for i := range prefix {
+		if prefix[i] != name[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // Get finds a registered component by name.
 func Get(name string) (Registration, bool) {
 	r, ok := registered[name]
 	return r, ok
 }
 
-// RegistrySchema returns an HCL body schema using all registered components.
-func RegistrySchema() *hcl.BodySchema {
-	var schema hcl.BodySchema
+// Blocks returns the blocks of body which define components. Blocks whose
+// type is listed in ignore are skipped, such as blocks configuring the Flow
+// controller itself. Diagnostics are returned for attributes and for blocks
+// which don't match a registered component.
+//
+// The first identifier of a component name is the type of its blocks and the
+// remaining identifiers are its first labels. Components which aren't
+// singletons take one more label for the user-supplied identifier:
+//
+//	logs "process" "default" { ... }       // logs.process
+//	logs "source" "file" "default" { ... } // logs.source.file
+//
+// Because no component name is a prefix of another, the labels of a block
+// identify at most one component.
+func Blocks(body *hclsyntax.Body, ignore ...string) (hcl.Blocks, hcl.Diagnostics) {
+	var diags hcl.Diagnostics
+
+	for _, attr := range body.Attributes {
+		diags = diags.Append(&hcl.Diagnostic{
+			Severity: hcl.DiagError,
+			Summary:  "Unsupported argument",
+			Detail:   fmt.Sprintf("An argument named %q is not expected here.", attr.Name),
+			Subject:  &attr.NameRange,
+		})
+	}
 
-	usedBlockSchemas := make(map[string]struct{})
+	var blocks hcl.Blocks
 
-	for _, rc := range registered {
-		parsed := parsedNames[rc.Name]
-		if parsed == nil {
-			// This will never happen when using the exposed API, but may creep up
-			// from tests that only set registered but not parsedNames.
-			panic(rc.Name + " is missing from parsedNames map")
+Blocks:
+	for _, b := range body.Blocks {
+		for _, t := range ignore {
+			if b.Type == t {
+				continue Blocks
+			}
 		}
 
-		// The generic name of a component uses the first identifier of the
-		// component and mapping the rest of the identifiers to labels.
-		labels := labelNames(parsed, rc.Singleton)
-		genericNameList := append([]string{parsed[0]}, labels...)
-		genericName := strings.Join(genericNameList, ".")
-		if _, defined := usedBlockSchemas[genericName]; defined {
-			// Ignore blocks that were already added. This will happen when
-			// processing a component for "remote.http" and "remote.s3", since both
-			// of them are injected into the schema as "remote.kind.name".
+		if blockDiags := checkBlock(b); blockDiags.HasErrors() {
+			diags = diags.Extend(blockDiags)
 			continue
 		}
-		usedBlockSchemas[genericName] = struct{}{}
-
-		schema.Blocks = append(schema.Blocks, hcl.BlockHeaderSchema{
-			Type:       parsed[0],
-			LabelNames: labels,
-		})
+		blocks = append(blocks, b.AsHCLBlock())
 	}
 
-	return &schema
+	return blocks, diags
 }
 
-func labelNames(in parsedName, singleton bool) []string {
-	if singleton {
-		// Component does *NOT* support a user-supplied identifier.
-		switch len(in) {
-		case 1:
-			return []string{}
-		case 2:
-			return []string{"kind"}
-		default:
-			panic("Unexpected component name " + in.String())
+// checkBlock validates that b refers to a registered component and has the
+// labels the component expects.
+func checkBlock(b *hclsyntax.Block) hcl.Diagnostics {
+	var (
+		rc     Registration
+		parsed parsedName
+		known  bool // Whether b.Type is the first identifier of any component.
+	)
+	for _, name := range registeredNames() {
+		other := parsedNames[name]
+		if other[0] != b.Type {
+			continue
+		}
+		known = true
+
+		// Match as many identifiers as there are labels, so blocks which are
+		// missing labels are still attributed to a component.
+		n := len(other) - 1
+		if len(b.Labels) < n {
+			n = len(b.Labels)
+		}
+		if isPrefix(other[1:n+1], b.Labels) {
+			rc, parsed = registered[name], other
+			break
+		}
+	}
+
+	typeRange := hcl.RangeBetween(b.TypeRange, b.OpenBraceRange).Ptr()
+
+	switch {
+	case !known:
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Unsupported block type",
+			Detail:   fmt.Sprintf("Blocks of type %q are not expected here.", b.Type),
+			Subject:  &b.TypeRange,
+		}}
+	case parsed == nil:
+		name := append(parsedName{b.Type}, b.Labels...)
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  "Unknown component",
+			Detail:   fmt.Sprintf("No component matches the block %q.", name),
+			Subject:  typeRange,
+		}}
+	}
+
+	labels := labelNames(parsed, rc.Singleton)
+	switch {
+	case len(b.Labels) > len(labels):
+		detail := fmt.Sprintf("No labels are expected for %s blocks.", parsed)
+		if len(labels) > 0 {
+			detail = fmt.Sprintf("Only %d labels (%s) are expected for %s blocks.", len(labels), strings.Join(labels, ", "), parsed)
 		}
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Extraneous label for %s", parsed),
+			Detail:   detail,
+			Subject:  b.LabelRanges[len(labels)].Ptr(),
+			Context:  typeRange,
+		}}
+	case len(b.Labels) < len(labels):
+		return hcl.Diagnostics{{
+			Severity: hcl.DiagError,
+			Summary:  fmt.Sprintf("Missing %s for %s", labels[len(b.Labels)], parsed),
+			Detail:   fmt.Sprintf("All %s blocks must have %d labels (%s).", parsed, len(labels), strings.Join(labels, ", ")),
+			Subject:  &b.OpenBraceRange,
+			Context:  typeRange,
+		}}
 	}
 
-	// Component supports a user-supplied identifier.
+	return nil
+}
+
+// registeredNames returns the names of all registered components in sorted
+// order.
+func registeredNames() []string {
+	names := make([]string, 0, len(registered))
+	for name := range registered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func labelNames(in parsedName, singleton bool) []string {
+	var labels []string
 	switch len(in) {
 	case 1:
-		return []string{"name"}
+		labels = []string{}
 	case 2:
-		return []string{"kind", "name"}
+		labels = []string{"kind"}
+	case 3:
+		labels = []string{"kind", "subkind"}
 	default:
 		panic("Unexpected component name " + in.String())
 	}
+
+	if !singleton {
+		// Component supports a user-supplied identifier.
+		labels = append(labels, "name")
+	}
+	return labels
 }
@@ -3,6 +3,8 @@ package component
 import (
 	"testing"
 
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,6 +17,8 @@ func Test_parseComponentName(t *testing.T) {
 		{check: " ", expectValid: false},
 		{check: "test", expectValid: true},
 		{check: "foo.bar", expectValid: true},
+		{check: "foo.bar.baz", expectValid: true},
+		{check: "foo.bar.baz.qux", expectValid: false},
 		{check: "foo.bar.", expectValid: false},
 		{check: "foo.bar. ", expectValid: false},
 		{check: "small.LARGE", expectValid: true},
@@ -47,6 +51,8 @@ func Test_validatePrefixMatch(t *testing.T) {
 		{check: "remote", expectValid: false},
 		{check: "test2", expectValid: true},
 		{check: "test.new", expectValid: false},
+		{check: "remote.http.new", expectValid: false},
+		{check: "remote.s3.get", expectValid: true},
 	}
 
 	for _, tc := range tt {
@@ -63,3 +69,89 @@ func Test_validatePrefixMatch(t *testing.T) {
 		})
 	}
 }
+
+func TestBlocks(t *testing.T) {
+	registerForTest(t,
+		Registration{Name: "logs.process"},
+		Registration{Name: "logs.source.file"},
+		Registration{Name: "single", Singleton: true},
+	)
+
+	tt := []struct {
+		name   string
+		input  string
+		expect []string // IDs of the returned blocks.
+		errors []string // Summaries of the returned diagnostics.
+	}{
+		{
+			name: "mixed lengths",
+			input: `
+				logs "process" "a" {}
+				logs "source" "file" "b" {}
+				single {}
+				ignored {}
+			`,
+			expect: []string{"logs.process.a", "logs.source.file.b", "single"},
+		},
+		{
+			name:   "unknown type",
+			input:  `remote "http" "a" {}`,
+			errors: []string{"Unsupported block type"},
+		},
+		{
+			name:   "unknown component",
+			input:  `logs "write" "loki" "a" {}`,
+			errors: []string{"Unknown component"},
+		},
+		{
+			name:   "missing name",
+			input:  `logs "source" "file" {}`,
+			errors: []string{"Missing name for logs.source.file"},
+		},
+		{
+			name:   "extraneous label",
+			input:  `logs "process" "a" "b" {}`,
+			errors: []string{"Extraneous label for logs.process"},
+		},
+		{
+			name:   "attribute",
+			input:  `foo = 5`,
+			errors: []string{"Unsupported argument"},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			file, diags := hclsyntax.ParseConfig([]byte(tc.input), t.Name(), hcl.InitialPos)
+			require.False(t, diags.HasErrors(), diags.Error())
+
+			blocks, diags := Blocks(file.Body.(*hclsyntax.Body), "ignored")
+
+			var errors []string
+			for _, d := range diags {
+				errors = append(errors, d.Summary)
+			}
+			require.Equal(t, tc.errors, errors)
+
+			var ids []string
+			for _, b := range blocks {
+				ids = append(ids, parsedName(append([]string{b.Type}, b.Labels...)).String())
+			}
+			require.Equal(t, tc.expect, ids)
+		})
+	}
+}
+
+// registerForTest replaces the registered components with rr for the
+// duration of t.
+func registerForTest(t *testing.T, rr ...Registration) {
+	t.Helper()
+
+	prevRegistered, prevParsed := registered, parsedNames
+	t.Cleanup(func() { registered, parsedNames = prevRegistered, prevParsed })
+
+	registered, parsedNames = map[string]Registration{}, map[string]parsedName{}
+	for _, r := range rr {
+		Register(r)
+	}
+}
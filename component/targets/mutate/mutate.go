@@ -3,6 +3,7 @@ package mutate
 import (
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/grafana/agent/component"
 	"github.com/grafana/regexp"
@@ -117,10 +118,14 @@ type Exports struct {
 // Component implements the targets.mutate component.
 type Component struct {
 	opts component.Options
+
+	debugMut  sync.RWMutex
+	debugInfo debugInfo
 }
 
 var (
-	_ component.Component = (*Component)(nil)
+	_ component.Component      = (*Component)(nil)
+	_ component.DebugComponent = (*Component)(nil)
 )
 
 // New creates a new targets.mutate component.
@@ -148,14 +153,39 @@ func (c *Component) Update(args component.Arguments) error {
 	targets := make([]Target, 0, len(newArgs.Targets))
 	relabelConfigs := hclToPromRelabelConfigs(newArgs.RelabelConfigs)
 
+	// Rules are applied one at a time so we can track which rule dropped each
+	// target.
+	dropped := make([]int, len(relabelConfigs))
+
 	for _, t := range newArgs.Targets {
 		lset := hclMapToPromLabels(t)
-		lset = relabel.Process(lset, relabelConfigs...)
+		for i, rc := range relabelConfigs {
+			lset = relabel.Process(lset, rc)
+			if lset == nil {
+				dropped[i]++
+				break
+			}
+		}
 		if lset != nil {
 			targets = append(targets, promLabelsToHCL(lset))
 		}
 	}
 
+	di := debugInfo{
+		InputTargets:  len(newArgs.Targets),
+		OutputTargets: len(targets),
+	}
+	for i, rc := range newArgs.RelabelConfigs {
+		di.RelabelConfigs = append(di.RelabelConfigs, relabelConfigDebugInfo{
+			Index:          i,
+			Action:         string(rc.Action),
+			DroppedTargets: dropped[i],
+		})
+	}
+	c.debugMut.Lock()
+	c.debugInfo = di
+	c.debugMut.Unlock()
+
 	c.opts.OnStateChange(Exports{
 		Output: targets,
 	})
@@ -163,6 +193,25 @@ func (c *Component) Update(args component.Arguments) error {
 	return nil
 }
 
+// DebugInfo implements component.DebugComponent.
+func (c *Component) DebugInfo() interface{} {
+	c.debugMut.RLock()
+	defer c.debugMut.RUnlock()
+	return c.debugInfo
+}
+
+type debugInfo struct {
+	InputTargets   int                      `hcl:"input_targets,attr"`
+	OutputTargets  int                      `hcl:"output_targets,attr"`
+	RelabelConfigs []relabelConfigDebugInfo `hcl:"relabel_config,block"`
+}
+
+type relabelConfigDebugInfo struct {
+	Index          int    `hcl:"index,attr"`
+	Action         string `hcl:"action,attr"`
+	DroppedTargets int    `hcl:"dropped_targets,attr"`
+}
+
 func hclMapToPromLabels(ls Target) labels.Labels {
 	res := make([]labels.Label, 0, len(ls))
 	for k, v := range ls {
@@ -4,9 +4,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/grafana/agent/component"
 	"github.com/grafana/agent/component/targets/mutate"
 	"github.com/grafana/agent/pkg/flow/componenttest"
 	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclwrite"
 	"github.com/rfratto/gohcl"
 	"github.com/stretchr/testify/require"
 )
@@ -79,3 +81,60 @@ relabel_config {
 	require.NoError(t, tc.WaitExports(time.Second))
 	require.Equal(t, expectedExports, tc.Exports())
 }
+
+func TestDebugInfo(t *testing.T) {
+	args := mutate.Arguments{
+		Targets: []mutate.Target{
+			{"__address__": "localhost:1", "app": "backend"},
+			{"__address__": "localhost:2", "app": "frontend"},
+			{"__address__": "localhost:3", "app": "db"},
+		},
+		RelabelConfigs: []*mutate.RelabelConfig{
+			relabelConfig(t, `
+				source_labels = ["app"]
+				action        = "drop"
+				regex         = "frontend"
+			`),
+			relabelConfig(t, `
+				source_labels = ["app"]
+				action        = "keep"
+				regex         = "backend"
+			`),
+		},
+	}
+
+	c, err := mutate.New(component.Options{OnStateChange: func(component.Exports) {}}, args)
+	require.NoError(t, err)
+
+	f := hclwrite.NewEmptyFile()
+	f.Body().AppendBlock(gohcl.EncodeAsBlock(c.DebugInfo(), "status"))
+
+	expect := `status {
+  input_targets  = 3
+  output_targets = 1
+
+  relabel_config {
+    index           = 0
+    action          = "drop"
+    dropped_targets = 1
+  }
+  relabel_config {
+    index           = 1
+    action          = "keep"
+    dropped_targets = 1
+  }
+}
+`
+	require.Equal(t, expect, string(f.Bytes()))
+}
+
+func relabelConfig(t *testing.T, src string) *mutate.RelabelConfig {
+	t.Helper()
+
+	file, diags := hclparse.NewParser().ParseHCL([]byte(src), t.Name())
+	require.False(t, diags.HasErrors())
+
+	var rc mutate.RelabelConfig
+	require.NoError(t, rc.DecodeHCL(file.Body, nil))
+	return &rc
+}
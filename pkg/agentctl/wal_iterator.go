@@ -1,6 +1,8 @@
 package agentctl
 
 import (
+	"path/filepath"
+
 	"github.com/prometheus/prometheus/tsdb/record"
 	"github.com/prometheus/prometheus/tsdb/wal"
 )
@@ -8,6 +10,12 @@ import (
 // walIterate iterates over the latest checkpoint in the provided WAL and all
 // of the segments in the WAL and calls f for each of them.
 func walIterate(w *wal.WAL, f func(r *wal.Reader) error) error {
+	return walIterateNamed(w, func(_ string, r *wal.Reader) error { return f(r) })
+}
+
+// walIterateNamed is like walIterate, but also passes the name of the
+// checkpoint directory or segment file being read to f.
+func walIterateNamed(w *wal.WAL, f func(name string, r *wal.Reader) error) error {
 	checkpoint, checkpointIdx, err := wal.LastCheckpoint(w.Dir())
 	if err != nil && err != record.ErrNotFound {
 		return err
@@ -23,7 +31,7 @@ func walIterate(w *wal.WAL, f func(r *wal.Reader) error) error {
 		if err != nil {
 			return err
 		}
-		err = f(wal.NewReader(sr))
+		err = f(filepath.Base(checkpoint), wal.NewReader(sr))
 		_ = sr.Close()
 		if err != nil {
 			return err
@@ -33,12 +41,13 @@ func walIterate(w *wal.WAL, f func(r *wal.Reader) error) error {
 	}
 
 	for i := startIdx; i <= last; i++ {
-		s, err := wal.OpenReadSegment(wal.SegmentName(w.Dir(), i))
+		name := wal.SegmentName(w.Dir(), i)
+		s, err := wal.OpenReadSegment(name)
 		if err != nil {
 			return err
 		}
 		sr := wal.NewSegmentBufReader(s)
-		err = f(wal.NewReader(sr))
+		err = f(filepath.Base(name), wal.NewReader(sr))
 		_ = sr.Close()
 		if err != nil {
 			return err
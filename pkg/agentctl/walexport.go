@@ -0,0 +1,263 @@
+package agentctl
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// ExportFormat is the format used by ExportWAL to write series and samples.
+type ExportFormat string
+
+// Supported export formats.
+const (
+	// ExportFormatOpenMetrics writes samples in the OpenMetrics text format,
+	// which can be backfilled with `promtool tsdb create-blocks-from openmetrics`.
+	ExportFormatOpenMetrics ExportFormat = "openmetrics"
+
+	// ExportFormatJSON writes one JSON object per series, separated by
+	// newlines.
+	ExportFormatJSON ExportFormat = "json"
+)
+
+// ExportOptions controls which series and samples are exported by ExportWAL.
+type ExportOptions struct {
+	// Selector is a label selector which series must match to be exported.
+	Selector string
+
+	// From and To are the inclusive bounds of the timestamps of exported
+	// samples. A zero value leaves that side of the range unbounded.
+	From, To time.Time
+
+	// Format is the format to write the export in.
+	Format ExportFormat
+}
+
+// ExportWAL writes the series and samples in the WAL at walDir which match
+// opts to out. Staleness markers are not exported.
+//
+// Samples are sorted by series and timestamp before being written, so the
+// matching samples of the whole WAL are held in memory.
+func ExportWAL(out io.Writer, walDir string, opts ExportOptions) error {
+	var write func(w *bufio.Writer, s *exportSeries) error
+	switch opts.Format {
+	case ExportFormatOpenMetrics:
+		write = writeOpenMetricsSeries
+	case ExportFormatJSON:
+		write = writeJSONSeries
+	default:
+		return fmt.Errorf("unsupported export format %q", opts.Format)
+	}
+
+	w, err := wal.Open(nil, walDir)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	selector, err := parser.ParseMetricSelector(opts.Selector)
+	if err != nil {
+		return err
+	}
+
+	labelsByRef := make(map[chunks.HeadSeriesRef]labels.Labels)
+	err = walIterate(w, func(r *wal.Reader) error {
+		return collectSeries(r, selector, labelsByRef)
+	})
+	if err != nil {
+		return fmt.Errorf("could not collect series: %w", err)
+	}
+
+	// The same series may have been given multiple refs over time, so samples
+	// are grouped by their labels rather than by ref.
+	var (
+		tr       = newTimeRange(opts.From, opts.To)
+		byLabels = make(map[string]*exportSeries)
+		byRef    = make(map[chunks.HeadSeriesRef]*exportSeries, len(labelsByRef))
+	)
+	for ref, lset := range labelsByRef {
+		key := lset.String()
+		s, ok := byLabels[key]
+		if !ok {
+			s = &exportSeries{Labels: lset}
+			byLabels[key] = s
+		}
+		byRef[ref] = s
+	}
+
+	err = walIterate(w, func(r *wal.Reader) error {
+		var dec record.Decoder
+
+		for r.Next() {
+			rec := r.Record()
+			if dec.Type(rec) != record.Samples {
+				continue
+			}
+			samples, err := dec.Samples(rec, nil)
+			if err != nil {
+				return err
+			}
+			for _, s := range samples {
+				series, ok := byRef[s.Ref]
+				if !ok || !tr.contains(s.T) || value.IsStaleNaN(s.V) {
+					continue
+				}
+				series.Samples = append(series.Samples, exportSample{T: s.T, V: s.V})
+			}
+		}
+		return r.Err()
+	})
+	if err != nil {
+		return fmt.Errorf("could not collect samples: %w", err)
+	}
+
+	series := make([]*exportSeries, 0, len(byLabels))
+	for _, s := range byLabels {
+		if len(s.Samples) == 0 {
+			continue
+		}
+		sort.SliceStable(s.Samples, func(i, j int) bool { return s.Samples[i].T < s.Samples[j].T })
+		series = append(series, s)
+	}
+	// Sorting by labels keeps series with the same metric name next to each
+	// other, as required by OpenMetrics.
+	sort.Slice(series, func(i, j int) bool {
+		return labels.Compare(series[i].Labels, series[j].Labels) < 0
+	})
+
+	bw := bufio.NewWriter(out)
+	for _, s := range series {
+		if err := write(bw, s); err != nil {
+			return err
+		}
+	}
+	if opts.Format == ExportFormatOpenMetrics {
+		if _, err := bw.WriteString("# EOF\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+type exportSeries struct {
+	Labels  labels.Labels  `json:"labels"`
+	Samples []exportSample `json:"samples"`
+}
+
+type exportSample struct {
+	T int64
+	V float64
+}
+
+// MarshalJSON implements json.Marshaler. The value is encoded as a string so
+// that special float values can be represented.
+func (s exportSample) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Timestamp int64  `json:"timestamp_ms"`
+		Value     string `json:"value"`
+	}{
+		Timestamp: s.T,
+		Value:     strconv.FormatFloat(s.V, 'f', -1, 64),
+	})
+}
+
+func writeJSONSeries(w *bufio.Writer, s *exportSeries) error {
+	bb, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(bb); err != nil {
+		return err
+	}
+	return w.WriteByte('\n')
+}
+
+var openMetricsEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+
+func writeOpenMetricsSeries(w *bufio.Writer, s *exportSeries) error {
+	var sb strings.Builder
+	sb.WriteString(s.Labels.Get(labels.MetricName))
+
+	var wroteLabel bool
+	for _, l := range s.Labels {
+		if l.Name == labels.MetricName {
+			continue
+		}
+		if wroteLabel {
+			sb.WriteByte(',')
+		} else {
+			sb.WriteByte('{')
+			wroteLabel = true
+		}
+		sb.WriteString(l.Name)
+		sb.WriteString(`="`)
+		sb.WriteString(openMetricsEscaper.Replace(l.Value))
+		sb.WriteByte('"')
+	}
+	if wroteLabel {
+		sb.WriteByte('}')
+	}
+	series := sb.String()
+
+	for _, sample := range s.Samples {
+		// OpenMetrics timestamps are in seconds.
+		_, err := fmt.Fprintf(w, "%s %s %s\n",
+			series,
+			formatOpenMetricsValue(sample.V),
+			strconv.FormatFloat(float64(sample.T)/1000, 'f', -1, 64),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatOpenMetricsValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// timeRange is an inclusive range of timestamps in milliseconds.
+type timeRange struct {
+	mint, maxt int64
+}
+
+// newTimeRange creates a timeRange from from to to. Zero times leave that side
+// of the range unbounded.
+func newTimeRange(from, to time.Time) timeRange {
+	tr := timeRange{mint: math.MinInt64, maxt: math.MaxInt64}
+	if !from.IsZero() {
+		tr.mint = timestamp.FromTime(from)
+	}
+	if !to.IsZero() {
+		tr.maxt = timestamp.FromTime(to)
+	}
+	return tr
+}
+
+func (tr timeRange) contains(t int64) bool {
+	return t >= tr.mint && t <= tr.maxt
+}
@@ -0,0 +1,51 @@
+package agentctl
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportWAL_OpenMetrics(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	var buf bytes.Buffer
+	err := ExportWAL(&buf, walDir, ExportOptions{
+		Selector: `{__name__="metric_1"}`,
+		Format:   ExportFormatOpenMetrics,
+	})
+	require.NoError(t, err)
+
+	expect := `metric_1{initial="no",instance="test-instance",job="test-job"} 1 0.004
+metric_1{initial="yes",instance="test-instance",job="test-job"} 1 0.003
+# EOF
+`
+	require.Equal(t, expect, buf.String())
+}
+
+func TestExportWAL_JSON(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	var buf bytes.Buffer
+	err := ExportWAL(&buf, walDir, ExportOptions{
+		Selector: `{__name__=~"metric_[12]"}`,
+		From:     timestamp.Time(4),
+		To:       timestamp.Time(5),
+		Format:   ExportFormatJSON,
+	})
+	require.NoError(t, err)
+
+	expect := `{"labels":{"__name__":"metric_1","initial":"no","instance":"test-instance","job":"test-job"},"samples":[{"timestamp_ms":4,"value":"1"}]}
+{"labels":{"__name__":"metric_2","initial":"yes","instance":"test-instance","job":"test-job"},"samples":[{"timestamp_ms":5,"value":"1"}]}
+`
+	require.Equal(t, expect, buf.String())
+}
+
+func TestExportWAL_InvalidFormat(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	err := ExportWAL(&bytes.Buffer{}, walDir, ExportOptions{Selector: "{}", Format: "csv"})
+	require.EqualError(t, err, `unsupported export format "csv"`)
+}
@@ -0,0 +1,370 @@
+package agentctl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/grafana/dskit/backoff"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+	"golang.org/x/time/rate"
+)
+
+// DefaultReplayOptions holds the default settings for replaying a WAL.
+var DefaultReplayOptions = ReplayOptions{
+	Selector:  "{}",
+	BatchSize: 500,
+	Backoff: backoff.Config{
+		MinBackoff: 30 * time.Millisecond,
+		MaxBackoff: 5 * time.Second,
+		MaxRetries: 10,
+	},
+}
+
+// ReplayOptions controls which samples are sent by ReplayWAL and how.
+type ReplayOptions struct {
+	// Selector is a label selector which series must match to be sent.
+	Selector string
+
+	// From and To are the inclusive bounds of the timestamps of sent samples.
+	// A zero value leaves that side of the range unbounded.
+	From, To time.Time
+
+	// BatchSize is the maximum number of samples sent per request.
+	BatchSize int
+
+	// RateLimit is the maximum number of samples sent per second. 0 disables
+	// rate limiting.
+	RateLimit float64
+
+	// ProgressFile, when set, is where progress is stored after every request.
+	// If the file already exists, the replay resumes from the progress stored
+	// in it.
+	ProgressFile string
+
+	// Backoff configures retries of requests which failed with a recoverable
+	// error.
+	Backoff backoff.Config
+}
+
+// ReplayProgress records how far a replay got. Records before the position
+// it points to have been sent; records after it may have been partially sent.
+type ReplayProgress struct {
+	// Segment is the name of the checkpoint or segment holding the next record
+	// to send.
+	Segment string `json:"segment"`
+
+	// Record is the index of the next record to send within Segment.
+	Record int `json:"record"`
+
+	// Samples is the total number of samples sent so far.
+	Samples int64 `json:"samples"`
+}
+
+// ReplayWAL sends the samples in the WAL at walDir which match opts to the
+// remote_write endpoint of client. The progress made is returned, even if an
+// error occurred.
+//
+// Samples are sent in the order they appear in the WAL. When a replay is
+// resumed, samples of a partially sent record are sent again.
+func ReplayWAL(ctx context.Context, logger log.Logger, walDir string, client remote.WriteClient, opts ReplayOptions) (ReplayProgress, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if opts.Selector == "" {
+		opts.Selector = DefaultReplayOptions.Selector
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultReplayOptions.BatchSize
+	}
+	if opts.Backoff == (backoff.Config{}) {
+		opts.Backoff = DefaultReplayOptions.Backoff
+	}
+
+	w, err := wal.Open(nil, walDir)
+	if err != nil {
+		return ReplayProgress{}, err
+	}
+	defer w.Close()
+
+	selector, err := parser.ParseMetricSelector(opts.Selector)
+	if err != nil {
+		return ReplayProgress{}, err
+	}
+
+	labelsByRef := make(map[chunks.HeadSeriesRef]labels.Labels)
+	err = walIterate(w, func(r *wal.Reader) error {
+		return collectSeries(r, selector, labelsByRef)
+	})
+	if err != nil {
+		return ReplayProgress{}, fmt.Errorf("could not collect series: %w", err)
+	}
+
+	var resume ReplayProgress
+	if opts.ProgressFile != "" {
+		resume, err = readReplayProgress(opts.ProgressFile)
+		if err != nil {
+			return ReplayProgress{}, err
+		}
+		if resume.Segment != "" {
+			level.Info(logger).Log("msg", "resuming replay", "segment", resume.Segment, "record", resume.Record, "samples", resume.Samples)
+		}
+	}
+
+	r := &walReplayer{
+		logger:      logger,
+		client:      client,
+		opts:        opts,
+		labelsByRef: labelsByRef,
+		timeRange:   newTimeRange(opts.From, opts.To),
+		progress:    resume,
+	}
+	if opts.RateLimit > 0 {
+		r.limiter = rate.NewLimiter(rate.Limit(opts.RateLimit), opts.BatchSize)
+	}
+
+	// Skip everything before the resumed position.
+	skipping := resume.Segment != ""
+
+	err = walIterateNamed(w, func(name string, wr *wal.Reader) error {
+		if skipping && name != resume.Segment {
+			return nil
+		}
+
+		var dec record.Decoder
+		for idx := 0; wr.Next(); idx++ {
+			if skipping && idx < resume.Record {
+				continue
+			}
+			skipping = false
+
+			rec := wr.Record()
+			if dec.Type(rec) == record.Samples {
+				samples, err := dec.Samples(rec, nil)
+				if err != nil {
+					return err
+				}
+				r.add(name, idx, samples)
+			}
+			r.lastSegment, r.lastRecord = name, idx+1
+
+			if err := r.flush(ctx, false); err != nil {
+				return err
+			}
+		}
+		if err := wr.Err(); err != nil {
+			return err
+		}
+
+		// The resumed segment may have no records left to read.
+		if skipping {
+			skipping = false
+			r.lastSegment, r.lastRecord = name, resume.Record
+		}
+		return nil
+	})
+	if err == nil && skipping {
+		err = fmt.Errorf("segment %s from progress file not found in WAL", resume.Segment)
+	}
+	if err == nil {
+		err = r.flush(ctx, true)
+	}
+	if err == nil {
+		err = r.saveProgress()
+	}
+	return r.progress, err
+}
+
+// walReplayer batches samples read from the WAL and sends them.
+type walReplayer struct {
+	logger      log.Logger
+	client      remote.WriteClient
+	opts        ReplayOptions
+	labelsByRef map[chunks.HeadSeriesRef]labels.Labels
+	timeRange   timeRange
+	limiter     *rate.Limiter // nil when rate limiting is disabled.
+
+	pending  []replaySample
+	progress ReplayProgress
+
+	// Position right after the last record read.
+	lastSegment string
+	lastRecord  int
+}
+
+// replaySample is a sample waiting to be sent along with the position of the
+// record it came from.
+type replaySample struct {
+	record.RefSample
+	segment string
+	record  int
+}
+
+func (r *walReplayer) add(segment string, idx int, samples []record.RefSample) {
+	for _, s := range samples {
+		if _, ok := r.labelsByRef[s.Ref]; !ok || !r.timeRange.contains(s.T) {
+			continue
+		}
+		r.pending = append(r.pending, replaySample{RefSample: s, segment: segment, record: idx})
+	}
+}
+
+// flush sends full batches of pending samples and stores the progress made.
+// If all is true, the final partial batch is sent too.
+func (r *walReplayer) flush(ctx context.Context, all bool) error {
+	for len(r.pending) >= r.opts.BatchSize || (all && len(r.pending) > 0) {
+		n := r.opts.BatchSize
+		if n > len(r.pending) {
+			n = len(r.pending)
+		}
+		if err := r.send(ctx, r.pending[:n]); err != nil {
+			return err
+		}
+		r.pending = r.pending[n:]
+		r.progress.Samples += int64(n)
+
+		r.updateProgress()
+		if err := r.saveProgress(); err != nil {
+			return err
+		}
+	}
+
+	// Records without matching samples don't need to be sent, so the replay
+	// can move past them right away.
+	if len(r.pending) == 0 {
+		r.updateProgress()
+	}
+	return nil
+}
+
+// updateProgress moves the progress to the first record which hasn't been
+// fully sent yet.
+func (r *walReplayer) updateProgress() {
+	if len(r.pending) > 0 {
+		r.progress.Segment, r.progress.Record = r.pending[0].segment, r.pending[0].record
+	} else if r.lastSegment != "" {
+		r.progress.Segment, r.progress.Record = r.lastSegment, r.lastRecord
+	}
+}
+
+// saveProgress writes the progress to the progress file, if one is set.
+func (r *walReplayer) saveProgress() error {
+	if r.opts.ProgressFile == "" {
+		return nil
+	}
+	return writeReplayProgress(r.opts.ProgressFile, r.progress)
+}
+
+func (r *walReplayer) send(ctx context.Context, samples []replaySample) error {
+	if r.limiter != nil {
+		if err := r.limiter.WaitN(ctx, len(samples)); err != nil {
+			return err
+		}
+	}
+
+	// Group samples by series, keeping the order series were first seen in.
+	var (
+		series []prompb.TimeSeries
+		index  = make(map[chunks.HeadSeriesRef]int)
+	)
+	for _, s := range samples {
+		i, ok := index[s.Ref]
+		if !ok {
+			i = len(series)
+			index[s.Ref] = i
+			series = append(series, prompb.TimeSeries{Labels: labelsToProto(r.labelsByRef[s.Ref])})
+		}
+		series[i].Samples = append(series[i].Samples, prompb.Sample{Timestamp: s.T, Value: s.V})
+	}
+	for _, ts := range series {
+		sort.SliceStable(ts.Samples, func(i, j int) bool { return ts.Samples[i].Timestamp < ts.Samples[j].Timestamp })
+	}
+
+	req := prompb.WriteRequest{Timeseries: series}
+	bb, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+	buf := snappy.Encode(nil, bb)
+
+	b := backoff.New(ctx, r.opts.Backoff)
+	for {
+		err = r.client.Store(ctx, buf)
+		if err == nil {
+			return nil
+		}
+		var re remote.RecoverableError
+		if !errors.As(err, &re) {
+			return err
+		}
+
+		level.Warn(r.logger).Log("msg", "failed to send samples, retrying", "count", len(samples), "err", err)
+		b.Wait()
+		if !b.Ongoing() {
+			return fmt.Errorf("failed to send samples: %w (%s)", err, b.Err())
+		}
+	}
+}
+
+func labelsToProto(lbls labels.Labels) []prompb.Label {
+	res := make([]prompb.Label, 0, len(lbls))
+	for _, l := range lbls {
+		res = append(res, prompb.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
+
+// readReplayProgress reads the progress stored in path. An empty progress is
+// returned if path doesn't exist.
+func readReplayProgress(path string) (ReplayProgress, error) {
+	var p ReplayProgress
+
+	bb, err := ioutil.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return p, nil
+	} else if err != nil {
+		return p, fmt.Errorf("failed to read progress file: %w", err)
+	}
+	if err := json.Unmarshal(bb, &p); err != nil {
+		return p, fmt.Errorf("failed to parse progress file %s: %w", path, err)
+	}
+	return p, nil
+}
+
+// writeReplayProgress atomically replaces the progress stored in path.
+func writeReplayProgress(path string, p ReplayProgress) error {
+	bb, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write progress file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(bb); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write progress file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write progress file: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,104 @@
+package agentctl
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayWAL(t *testing.T) {
+	walDir := setupTestWAL(t)
+
+	var client fakeWriteClient
+	progress, err := ReplayWAL(context.Background(), nil, walDir, &client, ReplayOptions{
+		Selector:  `{initial="yes"}`,
+		BatchSize: 3,
+	})
+	require.NoError(t, err)
+	require.Equal(t, ReplayProgress{Segment: "00000002", Record: 1, Samples: 10}, progress)
+
+	// 10 samples are sent in batches of 3.
+	require.Len(t, client.requests, 4)
+	require.Equal(t, 10, client.samples())
+	for _, req := range client.requests {
+		for _, ts := range req.Timeseries {
+			require.Contains(t, ts.Labels, prompb.Label{Name: "initial", Value: "yes"})
+		}
+	}
+}
+
+func TestReplayWAL_Resume(t *testing.T) {
+	walDir := setupTestWAL(t)
+	progressFile := filepath.Join(t.TempDir(), "progress.json")
+
+	opts := ReplayOptions{
+		Selector:     "{}",
+		BatchSize:    5,
+		ProgressFile: progressFile,
+	}
+
+	// Fail after two requests; the progress file should point to the record
+	// which was partially sent.
+	failing := fakeWriteClient{failAfter: 2}
+	progress, err := ReplayWAL(context.Background(), nil, walDir, &failing, opts)
+	require.Error(t, err)
+	require.Equal(t, ReplayProgress{Segment: "00000002", Record: 0, Samples: 10}, progress)
+
+	stored, err := readReplayProgress(progressFile)
+	require.NoError(t, err)
+	require.Equal(t, progress, stored)
+
+	// Resuming sends the partially sent record again.
+	var client fakeWriteClient
+	progress, err = ReplayWAL(context.Background(), nil, walDir, &client, opts)
+	require.NoError(t, err)
+	require.Equal(t, ReplayProgress{Segment: "00000002", Record: 1, Samples: 30}, progress)
+	require.Equal(t, 20, client.samples())
+
+	// Once finished, resuming has nothing left to send.
+	var finished fakeWriteClient
+	progress, err = ReplayWAL(context.Background(), nil, walDir, &finished, opts)
+	require.NoError(t, err)
+	require.Equal(t, ReplayProgress{Segment: "00000002", Record: 1, Samples: 30}, progress)
+	require.Empty(t, finished.requests)
+}
+
+type fakeWriteClient struct {
+	requests  []prompb.WriteRequest
+	failAfter int // Fail requests after this many succeeded. 0 never fails.
+}
+
+func (c *fakeWriteClient) Store(_ context.Context, buf []byte) error {
+	if c.failAfter > 0 && len(c.requests) >= c.failAfter {
+		return fmt.Errorf("server returned HTTP status 400 Bad Request")
+	}
+
+	bb, err := snappy.Decode(nil, buf)
+	if err != nil {
+		return err
+	}
+	var req prompb.WriteRequest
+	if err := req.Unmarshal(bb); err != nil {
+		return err
+	}
+	c.requests = append(c.requests, req)
+	return nil
+}
+
+func (c *fakeWriteClient) Name() string     { return "fake" }
+func (c *fakeWriteClient) Endpoint() string { return "http://fake" }
+
+func (c *fakeWriteClient) samples() int {
+	var n int
+	for _, req := range c.requests {
+		for _, ts := range req.Timeseries {
+			n += len(ts.Samples)
+		}
+	}
+	return n
+}
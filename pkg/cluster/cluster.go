@@ -10,10 +10,6 @@ import (
 	"github.com/rfratto/ckit/shard"
 )
 
-// NOTE(rfratto): pkg/cluster currently isn't wired in yet, but will be used
-// for the implementation of RFC-0003. Try to remember to remove this comment
-// once it gets used :)
-
 // Node is a read-only view of a cluster node.
 type Node interface {
 	// Lookup determines the set of replicationFactor owners for a given key.
@@ -0,0 +1,56 @@
+package cluster
+
+import (
+	"sort"
+
+	"github.com/rfratto/ckit"
+	"github.com/rfratto/ckit/peer"
+	"github.com/rfratto/ckit/shard"
+)
+
+// TargetKey returns the shard.Key for a target described by its set of
+// labels. Keys are independent of map iteration order.
+func TargetKey(labels map[string]string) shard.Key {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	kb := shard.NewKeyBuilder()
+	for _, name := range names {
+		_, _ = kb.Write([]byte(name))
+		_, _ = kb.Write([]byte{0})
+		_, _ = kb.Write([]byte(labels[name]))
+		_, _ = kb.Write([]byte{0})
+	}
+	return kb.Key()
+}
+
+// Owns returns true if the local node n is the owner of key.
+//
+// Owns also returns true if the owner of key can't be determined, such as
+// when n hasn't started yet or when no nodes are participants. Work is then
+// duplicated across nodes rather than dropped.
+func Owns(n Node, key shard.Key) bool {
+	owners, err := n.Lookup(key, 1, shard.OpReadWrite)
+	if err != nil || len(owners) == 0 {
+		return true
+	}
+	return owners[0].Self
+}
+
+// OwnsTarget returns true if the local node n is the owner of the target
+// described by labels. See Owns for details.
+func OwnsTarget(n Node, labels map[string]string) bool {
+	return Owns(n, TargetKey(labels))
+}
+
+// ObserveParticipants invokes f every time the set of participants in the
+// cluster changes, allowing callers to rebalance work between nodes. f is
+// invoked until it returns false.
+func ObserveParticipants(n Node, f func() (reregister bool)) {
+	n.Observe(ckit.ParticipantObserver(ckit.FuncObserver(func([]peer.Peer) bool {
+		return f()
+	})))
+}
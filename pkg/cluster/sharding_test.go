@@ -0,0 +1,62 @@
+package cluster
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/rfratto/ckit"
+	"github.com/rfratto/ckit/peer"
+	"github.com/rfratto/ckit/shard"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTargetKey(t *testing.T) {
+	a := TargetKey(map[string]string{"__address__": "localhost:9090", "job": "a"})
+	b := TargetKey(map[string]string{"job": "a", "__address__": "localhost:9090"})
+	require.Equal(t, a, b, "keys should not depend on label order")
+
+	// Label boundaries must be part of the key.
+	c := TargetKey(map[string]string{"ab": "c"})
+	d := TargetKey(map[string]string{"a": "bc"})
+	require.NotEqual(t, c, d)
+}
+
+func TestOwnsTarget(t *testing.T) {
+	t.Run("local node owns everything", func(t *testing.T) {
+		ln := NewLocalNode("localhost:8888")
+		require.True(t, OwnsTarget(ln, map[string]string{"__address__": "localhost:9090"}))
+	})
+
+	t.Run("targets are split between peers", func(t *testing.T) {
+		sharder := shard.Ring(tokensPerNode)
+		sharder.SetPeers([]peer.Peer{
+			{Name: "a", Addr: "a:80", Self: true, State: peer.StateParticipant},
+			{Name: "b", Addr: "b:80", State: peer.StateParticipant},
+		})
+		n := &sharderNode{sharder: sharder}
+
+		var owned int
+		for i := 0; i < 1000; i++ {
+			if OwnsTarget(n, map[string]string{"__address__": fmt.Sprintf("host-%d:80", i)}) {
+				owned++
+			}
+		}
+		require.InDelta(t, 500, owned, 100)
+	})
+
+	t.Run("unknown owner falls back to owning", func(t *testing.T) {
+		sharder := shard.Ring(tokensPerNode)
+		n := &sharderNode{sharder: sharder}
+		require.True(t, OwnsTarget(n, map[string]string{"__address__": "localhost:9090"}))
+	})
+}
+
+type sharderNode struct{ sharder shard.Sharder }
+
+func (sn *sharderNode) Lookup(key shard.Key, replicationFactor int, op shard.Op) ([]peer.Peer, error) {
+	return sn.sharder.Lookup(key, replicationFactor, op)
+}
+
+func (sn *sharderNode) Observe(ckit.Observer) {}
+
+func (sn *sharderNode) Peers() []peer.Peer { return sn.sharder.Peers() }
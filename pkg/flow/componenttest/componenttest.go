@@ -9,8 +9,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/go-kit/log"
 	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/pkg/cluster"
 )
 
 // A Controller is a testing controller which controls a single component.
@@ -126,6 +128,8 @@ func (c *Controller) buildComponent(dataPath string, args component.Arguments) (
 		Logger:        c.log,
 		DataPath:      dataPath,
 		OnStateChange: c.onStateChange,
+		Clusterer:     cluster.NewLocalNode(""),
+		Clock:         clock.New(),
 	}
 
 	inner, err := c.reg.Build(opts, args)
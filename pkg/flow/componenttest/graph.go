@@ -0,0 +1,247 @@
+package componenttest
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/logs"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/grafana/agent/pkg/flow"
+	"github.com/grafana/agent/pkg/flow/internal/testhooks"
+	"github.com/grafana/agent/pkg/flow/logging"
+)
+
+// StandIn builds a component which replaces a component defined in a Flow
+// file. The StandIn is given the arguments of the replaced component and must
+// export the same type as the replaced component.
+type StandIn func(opts component.Options, args component.Arguments) (component.Component, error)
+
+// GraphOptions configures a Graph.
+type GraphOptions struct {
+	// StandIns replaces components by their ID, such as
+	// "metrics.remote_write.default".
+	StandIns map[string]StandIn
+
+	// LogOutput is where logs of components are written. Logs are discarded
+	// if LogOutput is nil.
+	LogOutput io.Writer
+}
+
+// A Graph is a testing harness which runs all components of a Flow file.
+//
+// Components in the Graph use a mock clock which only moves forward when
+// the test calls Clock().Add.
+type Graph struct {
+	t     *testing.T
+	flow  *flow.Flow
+	clock *clock.Mock
+}
+
+// NewGraph loads the Flow file src and runs its components until t
+// finishes. The test fails if src can't be loaded.
+func NewGraph(t *testing.T, src string, opts GraphOptions) *Graph {
+	t.Helper()
+
+	logOutput := opts.LogOutput
+	if logOutput == nil {
+		logOutput = io.Discard
+	}
+	l, err := logging.New(logOutput, logging.DefaultOptions)
+	if err != nil {
+		t.Fatalf("building logger: %s", err)
+	}
+
+	overrides := make(testhooks.Overrides, len(opts.StandIns))
+	for id, standIn := range opts.StandIns {
+		overrides[id] = standIn
+	}
+
+	mockClock := clock.NewMock()
+	f := flow.NewWithOverrides(flow.Options{
+		Logger:   l,
+		DataPath: t.TempDir(),
+		Clock:    mockClock,
+	}, overrides)
+	t.Cleanup(func() { _ = f.Close() })
+
+	file, diags := flow.ReadFile(t.Name(), []byte(src))
+	if diags.HasErrors() {
+		t.Fatalf("reading Flow file: %s", diags)
+	}
+	if err := f.LoadFile(file); err != nil {
+		t.Fatalf("loading Flow file: %s", err)
+	}
+
+	for id := range opts.StandIns {
+		if _, ok := f.ComponentInfo(id); !ok {
+			t.Fatalf("stand-in given for component %q which isn't defined in the Flow file", id)
+		}
+	}
+
+	return &Graph{t: t, flow: f, clock: mockClock}
+}
+
+// Clock returns the mock clock used by components in the Graph.
+func (g *Graph) Clock() *clock.Mock { return g.clock }
+
+// Info returns the current state of the component with the given ID. The
+// test fails if the component doesn't exist.
+func (g *Graph) Info(id string) flow.ComponentInfo {
+	g.t.Helper()
+
+	info, ok := g.flow.ComponentInfo(id)
+	if !ok {
+		g.t.Fatalf("no component %q", id)
+	}
+	return info
+}
+
+// Exports returns the current exports of the component with the given ID.
+func (g *Graph) Exports(id string) component.Exports {
+	g.t.Helper()
+	return g.Info(id).Exports
+}
+
+// WaitHealth waits up to timeout for the component with the given ID to
+// reach the health type h. The test fails if the timeout is reached.
+func (g *Graph) WaitHealth(id string, h component.HealthType, timeout time.Duration) {
+	g.t.Helper()
+
+	g.wait(timeout, func() bool {
+		return g.Info(id).Health.Health == h
+	}, func() string {
+		health := g.Info(id).Health
+		return fmt.Sprintf("component %q has health %s (%s), expected %s", id, health.Health, health.Message, h)
+	})
+}
+
+// WaitExports waits up to timeout for the exports of the component with the
+// given ID to satisfy cond. The test fails if the timeout is reached.
+func (g *Graph) WaitExports(id string, cond func(e component.Exports) bool, timeout time.Duration) {
+	g.t.Helper()
+
+	g.wait(timeout, func() bool {
+		return cond(g.Exports(id))
+	}, func() string {
+		return fmt.Sprintf("component %q has exports %#v", id, g.Exports(id))
+	})
+}
+
+func (g *Graph) wait(timeout time.Duration, cond func() bool, describe func() string) {
+	g.t.Helper()
+
+	// Waiting uses the wall clock; only components are given the mock clock.
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			g.t.Fatalf("timed out after %s: %s", timeout, describe())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Sink is a stand-in which records the data sent to it. Sink replaces
+// components which export metrics or logs receivers; every exported receiver
+// of the replaced component is implemented by the Sink.
+type Sink struct {
+	mut     sync.Mutex
+	metrics []*metrics.FlowMetric
+	entries []logs.Entry
+}
+
+// NewSink creates a new Sink.
+func NewSink() *Sink {
+	return &Sink{}
+}
+
+// StandIn is a StandIn which replaces a component with s. Multiple
+// components may be replaced by the same Sink.
+func (s *Sink) StandIn(opts component.Options, args component.Arguments) (component.Component, error) {
+	reg, ok := registrationForID(opts.ID)
+	if !ok {
+		return nil, fmt.Errorf("no registration for component %q", opts.ID)
+	}
+	if reg.Exports == nil {
+		return nil, fmt.Errorf("component %q does not have exports", opts.ID)
+	}
+
+	var (
+		exports = reflect.New(reflect.TypeOf(reg.Exports)).Elem()
+		found   bool
+
+		metricsReceiver = &metrics.Receiver{Receive: s.receiveMetrics}
+		logsReceiver    = &logs.Receiver{Receive: s.receiveLogs}
+	)
+	for i := 0; i < exports.NumField(); i++ {
+		switch field := exports.Field(i); field.Type() {
+		case reflect.TypeOf(metricsReceiver):
+			field.Set(reflect.ValueOf(metricsReceiver))
+			found = true
+		case reflect.TypeOf(logsReceiver):
+			field.Set(reflect.ValueOf(logsReceiver))
+			found = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("component %q does not export a metrics or logs receiver", opts.ID)
+	}
+
+	opts.OnStateChange(exports.Interface())
+	return sinkComponent{}, nil
+}
+
+func (s *Sink) receiveMetrics(_ int64, mm []*metrics.FlowMetric) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.metrics = append(s.metrics, mm...)
+}
+
+func (s *Sink) receiveLogs(entries []logs.Entry) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	s.entries = append(s.entries, entries...)
+}
+
+// Metrics returns the metrics which were sent to s.
+func (s *Sink) Metrics() []*metrics.FlowMetric {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]*metrics.FlowMetric(nil), s.metrics...)
+}
+
+// Entries returns the log entries which were sent to s.
+func (s *Sink) Entries() []logs.Entry {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return append([]logs.Entry(nil), s.entries...)
+}
+
+type sinkComponent struct{}
+
+func (sinkComponent) Run(ctx context.Context) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (sinkComponent) Update(component.Arguments) error { return nil }
+
+// registrationForID returns the registration of the component with the
+// given ID. The last part of id is ignored for non-singleton components.
+func registrationForID(id string) (component.Registration, bool) {
+	if reg, ok := component.Get(id); ok {
+		return reg, ok
+	}
+	idx := strings.LastIndex(id, ".")
+	if idx == -1 {
+		return component.Registration{}, false
+	}
+	return component.Get(id[:idx])
+}
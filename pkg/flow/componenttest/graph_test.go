@@ -0,0 +1,81 @@
+package componenttest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/component/metrics"
+	"github.com/grafana/agent/component/metrics/aggregate"
+	"github.com/grafana/agent/pkg/flow/componenttest"
+	"github.com/grafana/agent/pkg/flow/internal/testcomponents"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/grafana/agent/component/metrics/remotewrite" // Import metrics.remote_write
+)
+
+func TestGraph_Clock(t *testing.T) {
+	g := componenttest.NewGraph(t, `
+		testcomponents "tick" "ticker" {
+			frequency = "1m"
+		}
+	`, componenttest.GraphOptions{})
+
+	// Time only moves when the mock clock is advanced. The ticker may not be
+	// waiting on the clock yet, so keep advancing until it ticks.
+	require.Eventually(t, func() bool {
+		g.Clock().Add(time.Minute)
+		return !g.Exports("testcomponents.tick.ticker").(testcomponents.TickExports).Time.IsZero()
+	}, 5*time.Second, 10*time.Millisecond)
+
+	tickTime := g.Exports("testcomponents.tick.ticker").(testcomponents.TickExports).Time
+	require.True(t, tickTime.Before(time.Unix(0, 0).Add(time.Hour)), "tick should use the mock clock")
+}
+
+func TestGraph_Sink(t *testing.T) {
+	sink := componenttest.NewSink()
+
+	g := componenttest.NewGraph(t, `
+		metrics "aggregate" "sum" {
+			interval   = "1m"
+			forward_to = [metrics.remote_write.default.receiver]
+
+			rule {
+				metric_name = "requests_total"
+				operation   = "sum"
+				by          = ["job"]
+			}
+		}
+
+		metrics "remote_write" "default" {
+			remote_write {
+				url = "http://localhost:9009/api/prom/push"
+			}
+		}
+	`, componenttest.GraphOptions{
+		StandIns: map[string]componenttest.StandIn{
+			"metrics.remote_write.default": sink.StandIn,
+		},
+	})
+
+	g.WaitHealth("metrics.remote_write.default", component.HealthTypeHealthy, 5*time.Second)
+	g.WaitExports("metrics.aggregate.sum", func(e component.Exports) bool {
+		return e.(aggregate.Exports).Receiver != nil
+	}, 5*time.Second)
+
+	receiver := g.Exports("metrics.aggregate.sum").(aggregate.Exports).Receiver
+	receiver.Receive(0, []*metrics.FlowMetric{
+		{Labels: labels.FromStrings("__name__", "requests_total", "job", "api", "instance", "a"), Value: 1},
+		{Labels: labels.FromStrings("__name__", "requests_total", "job", "api", "instance", "b"), Value: 2},
+	})
+
+	require.Eventually(t, func() bool {
+		g.Clock().Add(time.Minute)
+		return len(sink.Metrics()) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	m := sink.Metrics()[0]
+	require.Equal(t, labels.FromStrings("__name__", "requests_total:sum", "job", "api"), m.Labels)
+	require.Equal(t, 3.0, m.Value)
+}
@@ -1,8 +1,13 @@
 package flow
 
 import (
+	"fmt"
+
 	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/pkg/cluster"
+	"github.com/grafana/agent/pkg/flow/hcltypes"
 	"github.com/grafana/agent/pkg/flow/logging"
+	"github.com/grafana/agent/pkg/server"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclsyntax"
 	"github.com/rfratto/gohcl"
@@ -14,6 +19,11 @@ type File struct {
 	HCL  *hcl.File // Raw HCL file.
 
 	Logging logging.Options
+	Server  ServerOptions
+
+	// Clustering holds options for clustering Flow controllers. Clustering is
+	// nil when there was no clustering block in the file.
+	Clustering *ClusteringOptions
 
 	// Components holds the list of raw HCL blocks describing components. The
 	// Flow controller can interpret this block.
@@ -35,9 +45,8 @@ func ReadFile(name string, bb []byte) (*File, hcl.Diagnostics) {
 		return nil, diags
 	}
 
-	blockSchema := component.RegistrySchema()
-	content, remainDiags := root.Remain.Content(blockSchema)
-	diags = diags.Extend(remainDiags)
+	components, componentDiags := component.Blocks(file.Body.(*hclsyntax.Body), rootBlockTypes...)
+	diags = diags.Extend(componentDiags)
 	if diags.HasErrors() {
 		return nil, diags
 	}
@@ -46,25 +55,43 @@ func ReadFile(name string, bb []byte) (*File, hcl.Diagnostics) {
 		defaults := logging.DefaultOptions
 		root.Logger = &defaults
 	}
+	if root.Server == nil {
+		defaults := DefaultServerOptions
+		root.Server = &defaults
+	}
 
 	return &File{
 		Name:       name,
 		HCL:        file,
 		Logging:    *root.Logger,
-		Components: content.Blocks,
+		Server:     *root.Server,
+		Clustering: root.Clustering,
+		Components: components,
 	}, nil
 }
 
 type rootBlock struct {
-	Logger *logging.Options `hcl:"logging,block"`
-
-	// TODO(rfratto): server block for TLS settings
+	Logger     *logging.Options   `hcl:"logging,block"`
+	Server     *ServerOptions     `hcl:"server,block"`
+	Clustering *ClusteringOptions `hcl:"clustering,block"`
 
 	Remain hcl.Body `hcl:",remain"`
 }
 
 var defaultRootBlock = rootBlock{}
 
+// rootBlockTypes are the types of blocks decoded into rootBlock. All other
+// blocks define components.
+var rootBlockTypes = func() []string {
+	schema, _ := gohcl.ImpliedBodySchema(rootBlock{})
+
+	types := make([]string, 0, len(schema.Blocks))
+	for _, b := range schema.Blocks {
+		types = append(types, b.Type)
+	}
+	return types
+}()
+
 var _ gohcl.Decoder = (*rootBlock)(nil)
 
 func (rb *rootBlock) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
@@ -73,3 +100,193 @@ func (rb *rootBlock) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
 	type root rootBlock
 	return gohcl.DecodeBody(body, ctx, (*root)(rb))
 }
+
+// ClusteringOptions configures clustering of Flow controllers through gossip.
+// Components use the cluster to split work between nodes, such as sharding
+// targets. Changes to ClusteringOptions take effect after a restart.
+type ClusteringOptions struct {
+	// Name of the node within the cluster. Defaults to the hostname.
+	NodeName string `hcl:"node_name,optional"`
+
+	// host:port address to advertise to peers. Inferred from
+	// AdvertiseInterfaces when unset.
+	AdvertiseAddress    string   `hcl:"advertise_address,optional"`
+	AdvertiseInterfaces []string `hcl:"advertise_interfaces,optional"`
+
+	// Peers to join, either as a list of host:port addresses or a go-discover
+	// expression. At most one may be set.
+	JoinPeers     []string `hcl:"join_peers,optional"`
+	DiscoverPeers string   `hcl:"discover_peers,optional"`
+}
+
+// DefaultClusteringOptions holds default options for clustering.
+var DefaultClusteringOptions = ClusteringOptions{
+	AdvertiseInterfaces: cluster.DefaultGossipConfig.AdvertiseInterfaces,
+}
+
+var _ gohcl.Decoder = (*ClusteringOptions)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (o *ClusteringOptions) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*o = DefaultClusteringOptions
+
+	type options ClusteringOptions
+	return gohcl.DecodeBody(body, ctx, (*options)(o))
+}
+
+// GossipConfig converts o into a cluster.GossipConfig. ApplyDefaults must
+// be called on the result before it is used.
+func (o *ClusteringOptions) GossipConfig() *cluster.GossipConfig {
+	return &cluster.GossipConfig{
+		NodeName:            o.NodeName,
+		AdvertiseAddr:       o.AdvertiseAddress,
+		AdvertiseInterfaces: append([]string(nil), o.AdvertiseInterfaces...),
+		JoinPeers:           append([]string(nil), o.JoinPeers...),
+		DiscoverPeers:       o.DiscoverPeers,
+	}
+}
+
+// ServerOptions configures the HTTP server of Flow. Changes to ServerOptions
+// take effect when the file is reloaded.
+type ServerOptions struct {
+	// host:port address to listen for HTTP traffic on. When empty, the
+	// address given on the command line is used.
+	HTTPListenAddress string `hcl:"http_listen_address,optional"`
+
+	// ReadOnly disables HTTP endpoints which change the state of Flow, such
+	// as reloading the config file, and debug endpoints which expose the data
+	// flowing through components or profiles of the process.
+	ReadOnly bool `hcl:"read_only,optional"`
+
+	// TLS enables serving HTTP traffic over TLS when set.
+	TLS *TLSOptions `hcl:"tls,block"`
+
+	// Requests must authenticate with either BasicAuth or BearerToken when at
+	// least one of them is set.
+	BasicAuth       *BasicAuthOptions `hcl:"basic_auth,block"`
+	BearerToken     hcltypes.Secret   `hcl:"bearer_token,optional"`
+	BearerTokenFile string            `hcl:"bearer_token_file,optional"`
+}
+
+// DefaultServerOptions holds default options for the HTTP server.
+var DefaultServerOptions = ServerOptions{}
+
+var _ gohcl.Decoder = (*ServerOptions)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (o *ServerOptions) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*o = DefaultServerOptions
+
+	type options ServerOptions
+	if err := gohcl.DecodeBody(body, ctx, (*options)(o)); err != nil {
+		return err
+	}
+
+	if o.BearerToken != "" && o.BearerTokenFile != "" {
+		return fmt.Errorf("at most one of bearer_token and bearer_token_file may be set")
+	}
+	return nil
+}
+
+// TLSOptions configures TLS for the HTTP server. Settings have the same
+// meaning as the http_tls_config block of static mode.
+type TLSOptions struct {
+	CertFile                 string   `hcl:"cert_file,attr"`
+	KeyFile                  string   `hcl:"key_file,attr"`
+	ClientAuthType           string   `hcl:"client_auth_type,optional"`
+	ClientCAFile             string   `hcl:"client_ca_file,optional"`
+	CipherSuites             []string `hcl:"cipher_suites,optional"`
+	CurvePreferences         []string `hcl:"curve_preferences,optional"`
+	MinVersion               string   `hcl:"min_version,optional"`
+	MaxVersion               string   `hcl:"max_version,optional"`
+	PreferServerCipherSuites bool     `hcl:"prefer_server_cipher_suites,optional"`
+}
+
+var _ gohcl.Decoder = (*TLSOptions)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (o *TLSOptions) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*o = TLSOptions{}
+
+	type options TLSOptions
+	if err := gohcl.DecodeBody(body, ctx, (*options)(o)); err != nil {
+		return err
+	}
+
+	// Validate names early so typos are reported when the file is read.
+	_, err := o.ServerConfig()
+	return err
+}
+
+// ServerConfig converts o into a server.TLSConfig.
+func (o *TLSOptions) ServerConfig() (server.TLSConfig, error) {
+	c := server.TLSConfig{
+		TLSCertPath:              o.CertFile,
+		TLSKeyPath:               o.KeyFile,
+		ClientAuth:               o.ClientAuthType,
+		ClientCAs:                o.ClientCAFile,
+		PreferServerCipherSuites: o.PreferServerCipherSuites,
+	}
+
+	for _, name := range o.CipherSuites {
+		var cs server.TLSCipher
+		if err := unmarshalName(&cs, name); err != nil {
+			return c, err
+		}
+		c.CipherSuites = append(c.CipherSuites, cs)
+	}
+	for _, name := range o.CurvePreferences {
+		var curve server.TLSCurve
+		if err := unmarshalName(&curve, name); err != nil {
+			return c, err
+		}
+		c.CurvePreferences = append(c.CurvePreferences, curve)
+	}
+	if o.MinVersion != "" {
+		if err := unmarshalName(&c.MinVersion, o.MinVersion); err != nil {
+			return c, err
+		}
+	}
+	if o.MaxVersion != "" {
+		if err := unmarshalName(&c.MaxVersion, o.MaxVersion); err != nil {
+			return c, err
+		}
+	}
+
+	return c, nil
+}
+
+// unmarshalName unmarshals a name into one of the TLS setting types from
+// pkg/server, reusing their YAML parsing.
+func unmarshalName(u interface {
+	UnmarshalYAML(func(interface{}) error) error
+}, name string) error {
+	return u.UnmarshalYAML(func(v interface{}) error {
+		*v.(*string) = name
+		return nil
+	})
+}
+
+// BasicAuthOptions configures basic authentication for the HTTP server.
+type BasicAuthOptions struct {
+	Username     string          `hcl:"username,attr"`
+	Password     hcltypes.Secret `hcl:"password,optional"`
+	PasswordFile string          `hcl:"password_file,optional"`
+}
+
+var _ gohcl.Decoder = (*BasicAuthOptions)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (o *BasicAuthOptions) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*o = BasicAuthOptions{}
+
+	type options BasicAuthOptions
+	if err := gohcl.DecodeBody(body, ctx, (*options)(o)); err != nil {
+		return err
+	}
+
+	if (o.Password == "") == (o.PasswordFile == "") {
+		return fmt.Errorf("exactly one of password and password_file must be set")
+	}
+	return nil
+}
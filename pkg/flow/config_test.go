@@ -1,11 +1,15 @@
 package flow_test
 
 import (
+	"crypto/tls"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/grafana/agent/pkg/flow"
+	"github.com/grafana/agent/pkg/flow/hcltypes"
+	"github.com/grafana/agent/pkg/flow/logging"
+	"github.com/grafana/agent/pkg/server"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/stretchr/testify/require"
 
@@ -38,6 +42,141 @@ func TestReadFile_Defaults(t *testing.T) {
 	requireNoDiagErrors(t, f, diags)
 
 	require.Len(t, f.Components, 0)
+	require.Nil(t, f.Clustering)
+	require.Equal(t, flow.DefaultServerOptions, f.Server)
+}
+
+func TestReadFile_Clustering(t *testing.T) {
+	content := `
+		clustering {
+			node_name  = "node-a"
+			join_peers = ["node-b:12345"]
+		}
+	`
+
+	f, diags := flow.ReadFile(t.Name(), []byte(content))
+	require.NotNil(t, f)
+	requireNoDiagErrors(t, f, diags)
+
+	expect := flow.DefaultClusteringOptions
+	expect.NodeName = "node-a"
+	expect.JoinPeers = []string{"node-b:12345"}
+	require.Equal(t, &expect, f.Clustering)
+}
+
+func TestReadFile_Logging(t *testing.T) {
+	content := `
+		logging {
+			level = "warn"
+			sink  = "file"
+
+			file {
+				path = "/var/log/agent.log"
+			}
+
+			component "metrics.remote_write.default" {
+				level = "debug"
+			}
+		}
+	`
+
+	f, diags := flow.ReadFile(t.Name(), []byte(content))
+	require.NotNil(t, f)
+	requireNoDiagErrors(t, f, diags)
+
+	expectFile := logging.DefaultFileOptions
+	expectFile.Path = "/var/log/agent.log"
+
+	expect := logging.DefaultOptions
+	expect.Level = logging.LevelWarn
+	expect.Sink = logging.SinkFile
+	expect.File = &expectFile
+	expect.Components = []logging.ComponentOptions{
+		{ID: "metrics.remote_write.default", Level: logging.LevelDebug},
+	}
+	require.Equal(t, expect, f.Logging)
+}
+
+func TestReadFile_Server(t *testing.T) {
+	content := `
+		server {
+			http_listen_address = "0.0.0.0:12345"
+			read_only           = true
+			bearer_token        = "token"
+
+			tls {
+				cert_file     = "server.crt"
+				key_file      = "server.key"
+				min_version   = "TLS12"
+				cipher_suites = ["TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"]
+			}
+
+			basic_auth {
+				username = "admin"
+				password = "secret"
+			}
+		}
+	`
+
+	f, diags := flow.ReadFile(t.Name(), []byte(content))
+	require.NotNil(t, f)
+	requireNoDiagErrors(t, f, diags)
+
+	require.Equal(t, "0.0.0.0:12345", f.Server.HTTPListenAddress)
+	require.True(t, f.Server.ReadOnly)
+	require.Equal(t, hcltypes.Secret("token"), f.Server.BearerToken)
+	require.Equal(t, &flow.BasicAuthOptions{Username: "admin", Password: "secret"}, f.Server.BasicAuth)
+
+	tlsConfig, err := f.Server.TLS.ServerConfig()
+	require.NoError(t, err)
+	require.Equal(t, server.TLSConfig{
+		TLSCertPath:  "server.crt",
+		TLSKeyPath:   "server.key",
+		MinVersion:   tls.VersionTLS12,
+		CipherSuites: []server.TLSCipher{server.TLSCipher(tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256)},
+	}, tlsConfig)
+}
+
+func TestReadFile_InvalidServer(t *testing.T) {
+	tt := []struct {
+		name    string
+		content string
+	}{
+		{
+			name: "unknown TLS version",
+			content: `server {
+				tls {
+					cert_file   = "server.crt"
+					key_file    = "server.key"
+					min_version = "TLS99"
+				}
+			}`,
+		},
+		{
+			name: "password and password_file",
+			content: `server {
+				basic_auth {
+					username      = "admin"
+					password      = "secret"
+					password_file = "/etc/password"
+				}
+			}`,
+		},
+		{
+			name: "bearer_token and bearer_token_file",
+			content: `server {
+				bearer_token      = "token"
+				bearer_token_file = "/etc/token"
+			}`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, diags := flow.ReadFile(t.Name(), []byte(tc.content))
+			require.True(t, diags.HasErrors())
+		})
+	}
 }
 
 func TestReadFile_InvalidComponent(t *testing.T) {
@@ -0,0 +1,354 @@
+// Package convert converts static mode configuration into Flow configuration
+// files.
+//
+// Static mode features which have no equivalent Flow component are reported
+// as diagnostics. Converted files should be reviewed before being used; files
+// converted with errors are partial and start with a comment saying so.
+package convert
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/grafana/agent/pkg/metrics"
+	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	common "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// Metrics converts the metrics subsystem of a static mode config into a Flow
+// file. A Flow file is always returned; diagnostics report static mode
+// features which weren't converted.
+//
+// Each metrics instance is converted into a metrics.remote_write component
+// named after the instance. The targets and relabel rules of each scrape job
+// in the instance are converted into a targets.mutate component named
+// <instance>_<job>, whose output is scraped by a metrics.scrape component of
+// the same name.
+func Metrics(c *metrics.Config) (*hclwrite.File, hcl.Diagnostics) {
+	var (
+		f     = hclwrite.NewEmptyFile()
+		diags hcl.Diagnostics
+		names = make(map[string]struct{})
+	)
+
+	if c.ServiceConfig.Enabled {
+		diags = append(diags, unsupported("scraping_service", "Flow does not support the scraping service; its instances must be converted separately."))
+	}
+
+	for _, inst := range c.Configs {
+		inst := inst
+		diags = append(diags, convertInstance(f.Body(), &c.Global, &inst, names)...)
+	}
+
+	if diags.HasErrors() {
+		partial := hclwrite.NewEmptyFile()
+		partial.Body().AppendUnstructuredTokens(hclwrite.Tokens{{
+			Type:  hclsyntax.TokenComment,
+			Bytes: []byte("// NOTE: This file was partially converted. Settings reported as errors by\n// the converter are missing and must be added before use.\n"),
+		}})
+		partial.Body().AppendNewline()
+		partial.Body().AppendUnstructuredTokens(f.BuildTokens(nil))
+		f = partial
+	}
+
+	return f, diags
+}
+
+func convertInstance(body *hclwrite.Body, global *instance.GlobalConfig, c *instance.Config, names map[string]struct{}) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	if c.HostFilter {
+		diags = append(diags, unsupported(fmt.Sprintf("instance %q: host_filter", c.Name), ""))
+	}
+	if c.WALTruncateFrequency != instance.DefaultConfig.WALTruncateFrequency ||
+		c.MinWALTime != instance.DefaultConfig.MinWALTime ||
+		c.MaxWALTime != instance.DefaultConfig.MaxWALTime ||
+		c.RemoteFlushDeadline != instance.DefaultConfig.RemoteFlushDeadline ||
+		c.WriteStaleOnShutdown != instance.DefaultConfig.WriteStaleOnShutdown {
+		diags = append(diags, unsupported(fmt.Sprintf("instance %q: WAL and flush settings", c.Name), "metrics.remote_write uses fixed defaults for its WAL."))
+	}
+
+	rws := c.RemoteWrite
+	if len(rws) == 0 {
+		rws = global.RemoteWrite
+	}
+
+	// Receivers scraped metrics are forwarded to.
+	var forwardTo []hclwrite.Tokens
+
+	if len(rws) == 0 {
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagWarning,
+			Summary:  fmt.Sprintf("instance %q has no remote_write configs", c.Name),
+			Detail:   "No metrics.remote_write component was generated for the instance.",
+		})
+	} else {
+		label := uniqueLabel(names, c.Name)
+		block := body.AppendNewBlock("metrics", []string{"remote_write", label})
+		diags = append(diags, writeRemoteWrite(block.Body(), c.Name, global, rws)...)
+		body.AppendNewline()
+
+		forwardTo = append(forwardTo, hclwrite.TokensForTraversal(hcl.Traversal{
+			hcl.TraverseRoot{Name: "metrics"},
+			hcl.TraverseAttr{Name: "remote_write"},
+			hcl.TraverseAttr{Name: label},
+			hcl.TraverseAttr{Name: "receiver"},
+		}))
+	}
+
+	for _, sc := range c.ScrapeConfigs {
+		label := uniqueLabel(names, c.Name+"_"+sc.JobName)
+		diags = append(diags, writeScrapeConfig(body, global, c.Name, label, sc, forwardTo)...)
+	}
+
+	return diags
+}
+
+func writeRemoteWrite(body *hclwrite.Body, instName string, global *instance.GlobalConfig, rws []*config.RemoteWriteConfig) hcl.Diagnostics {
+	var diags hcl.Diagnostics
+
+	if len(global.Prometheus.ExternalLabels) > 0 {
+		externalLabels := make(map[string]cty.Value, len(global.Prometheus.ExternalLabels))
+		for _, l := range global.Prometheus.ExternalLabels {
+			externalLabels[l.Name] = cty.StringVal(l.Value)
+		}
+		body.SetAttributeValue("external_labels", cty.MapVal(externalLabels))
+	}
+
+	for _, rw := range rws {
+		rwBody := body.AppendNewBlock("remote_write", nil).Body()
+		if rw.Name != "" {
+			rwBody.SetAttributeValue("name", cty.StringVal(rw.Name))
+		}
+		if rw.URL != nil {
+			rwBody.SetAttributeValue("url", cty.StringVal(rw.URL.String()))
+		}
+
+		what := fmt.Sprintf("instance %q: remote_write %q", instName, rw.URL)
+
+		httpConfig := rw.HTTPClientConfig
+		if ba := httpConfig.BasicAuth; ba != nil {
+			baBody := rwBody.AppendNewBlock("basic_auth", nil).Body()
+			baBody.SetAttributeValue("username", cty.StringVal(ba.Username))
+			baBody.SetAttributeValue("password", cty.StringVal(string(ba.Password)))
+
+			if ba.PasswordFile != "" {
+				diags = append(diags, unsupported(what+": basic_auth password_file", "The password must be set in the converted file."))
+			}
+		}
+		httpConfig.BasicAuth = nil
+		if !reflect.DeepEqual(httpConfig, common.DefaultHTTPClientConfig) {
+			diags = append(diags, unsupported(what+": HTTP client settings", "Only url and basic_auth are supported by metrics.remote_write."))
+		}
+
+		if rw.RemoteTimeout != config.DefaultRemoteWriteConfig.RemoteTimeout {
+			diags = append(diags, unsupported(what+": remote_timeout", ""))
+		}
+		if len(rw.Headers) > 0 {
+			diags = append(diags, unsupported(what+": headers", ""))
+		}
+		if len(rw.WriteRelabelConfigs) > 0 {
+			diags = append(diags, unsupported(what+": write_relabel_configs", ""))
+		}
+		if rw.QueueConfig != config.DefaultRemoteWriteConfig.QueueConfig {
+			diags = append(diags, unsupported(what+": queue_config", ""))
+		}
+		if rw.MetadataConfig != config.DefaultRemoteWriteConfig.MetadataConfig {
+			diags = append(diags, unsupported(what+": metadata_config", ""))
+		}
+		if rw.SigV4Config != nil {
+			diags = append(diags, unsupported(what+": sigv4", ""))
+		}
+	}
+
+	return diags
+}
+
+func writeScrapeConfig(body *hclwrite.Body, global *instance.GlobalConfig, instName, label string, sc *config.ScrapeConfig, forwardTo []hclwrite.Tokens) hcl.Diagnostics {
+	var (
+		diags hcl.Diagnostics
+		what  = fmt.Sprintf("instance %q: scrape job %q", instName, sc.JobName)
+	)
+
+	if len(sc.MetricRelabelConfigs) > 0 {
+		diags = append(diags, unsupported(what+": metric_relabel_configs", ""))
+	}
+	if !reflect.DeepEqual(sc.HTTPClientConfig, common.DefaultHTTPClientConfig) {
+		diags = append(diags, unsupported(what+": HTTP client settings", "metrics.scrape doesn't support HTTP client settings."))
+	}
+	if len(sc.Params) > 0 {
+		diags = append(diags, unsupported(what+": params", ""))
+	}
+	if sc.SampleLimit != 0 || sc.TargetLimit != 0 || sc.LabelLimit != 0 || sc.LabelNameLengthLimit != 0 || sc.LabelValueLengthLimit != 0 {
+		diags = append(diags, unsupported(what+": limits", ""))
+	}
+	if !sc.HonorTimestamps {
+		diags = append(diags, unsupported(what+": honor_timestamps", "metrics.scrape always honors timestamps."))
+	}
+
+	// Targets are given the same labels that Prometheus adds before applying
+	// relabel_configs.
+	var targets []cty.Value
+	for _, sd := range sc.ServiceDiscoveryConfigs {
+		static, ok := sd.(discovery.StaticConfig)
+		if !ok {
+			diags = append(diags, unsupported(fmt.Sprintf("%s: %s_sd_configs", what, sd.Name()), "Only static_configs are converted."))
+			continue
+		}
+
+		for _, group := range static {
+			for _, tgt := range group.Targets {
+				labels := model.LabelSet{
+					model.JobLabel:         model.LabelValue(sc.JobName),
+					model.MetricsPathLabel: model.LabelValue(sc.MetricsPath),
+					model.SchemeLabel:      model.LabelValue(sc.Scheme),
+				}
+				labels = labels.Merge(group.Labels).Merge(tgt)
+				targets = append(targets, labelSetValue(labels))
+			}
+		}
+	}
+	if len(targets) == 0 {
+		diags = append(diags, &hcl.Diagnostic{
+			Severity: hcl.DiagWarning,
+			Summary:  what + " has no static targets",
+			Detail:   "No targets.mutate or metrics.scrape component was generated for the job.",
+		})
+		return diags
+	}
+
+	body.AppendUnstructuredTokens(hclwrite.Tokens{{
+		Type:  hclsyntax.TokenComment,
+		Bytes: []byte(fmt.Sprintf("// Targets of scrape job %q from instance %q.\n", sc.JobName, instName)),
+	}})
+	mutateBody := body.AppendNewBlock("targets", []string{"mutate", label}).Body()
+	mutateBody.SetAttributeValue("targets", cty.ListVal(targets))
+
+	for _, rc := range sc.RelabelConfigs {
+		writeRelabelConfig(mutateBody.AppendNewBlock("relabel_config", nil).Body(), rc)
+	}
+	body.AppendNewline()
+
+	scrapeBody := body.AppendNewBlock("metrics", []string{"scrape", label}).Body()
+	scrapeBody.SetAttributeTraversal("targets", hcl.Traversal{
+		hcl.TraverseRoot{Name: "targets"},
+		hcl.TraverseAttr{Name: "mutate"},
+		hcl.TraverseAttr{Name: label},
+		hcl.TraverseAttr{Name: "output"},
+	})
+	scrapeBody.SetAttributeRaw("forward_to", hclwrite.TokensForTuple(forwardTo))
+	scrapeBody.SetAttributeValue("job_name", cty.StringVal(sc.JobName))
+	if sc.HonorLabels {
+		scrapeBody.SetAttributeValue("honor_labels", cty.True)
+	}
+
+	// Scrape jobs inherit unset intervals and timeouts from the global config.
+	interval, timeout := sc.ScrapeInterval, sc.ScrapeTimeout
+	if interval == 0 {
+		interval = global.Prometheus.ScrapeInterval
+	}
+	if timeout == 0 {
+		timeout = global.Prometheus.ScrapeTimeout
+	}
+	if interval != 0 {
+		scrapeBody.SetAttributeValue("scrape_interval", cty.StringVal(time.Duration(interval).String()))
+	}
+	if timeout != 0 {
+		scrapeBody.SetAttributeValue("scrape_timeout", cty.StringVal(time.Duration(timeout).String()))
+	}
+	body.AppendNewline()
+
+	return diags
+}
+
+func labelSetValue(ls model.LabelSet) cty.Value {
+	vals := make(map[string]cty.Value, len(ls))
+	for k, v := range ls {
+		vals[string(k)] = cty.StringVal(string(v))
+	}
+	return cty.MapVal(vals)
+}
+
+// writeRelabelConfig writes rc into body, omitting fields which match the
+// defaults of targets.mutate.
+func writeRelabelConfig(body *hclwrite.Body, rc *relabel.Config) {
+	if len(rc.SourceLabels) > 0 {
+		sourceLabels := make([]cty.Value, 0, len(rc.SourceLabels))
+		for _, l := range rc.SourceLabels {
+			sourceLabels = append(sourceLabels, cty.StringVal(string(l)))
+		}
+		body.SetAttributeValue("source_labels", cty.ListVal(sourceLabels))
+	}
+	if rc.Separator != relabel.DefaultRelabelConfig.Separator {
+		body.SetAttributeValue("separator", cty.StringVal(rc.Separator))
+	}
+	if regex, _ := rc.Regex.MarshalYAML(); regex != nil && regex != "(.*)" {
+		body.SetAttributeValue("regex", cty.StringVal(regex.(string)))
+	}
+	if rc.Modulus != 0 {
+		body.SetAttributeValue("modulus", cty.NumberUIntVal(rc.Modulus))
+	}
+	if rc.TargetLabel != "" {
+		body.SetAttributeValue("target_label", cty.StringVal(rc.TargetLabel))
+	}
+	if rc.Replacement != relabel.DefaultRelabelConfig.Replacement {
+		body.SetAttributeValue("replacement", cty.StringVal(rc.Replacement))
+	}
+	if rc.Action != relabel.DefaultRelabelConfig.Action {
+		body.SetAttributeValue("action", cty.StringVal(string(rc.Action)))
+	}
+}
+
+// uniqueLabel returns a component label for name which hasn't been used yet
+// and marks it as used. Suffixes are added until the label is unique, since a
+// suffixed label may itself already be in use.
+func uniqueLabel(used map[string]struct{}, name string) string {
+	var (
+		base  = sanitizeLabel(name)
+		label = base
+	)
+	for n := 2; ; n++ {
+		if _, exists := used[label]; !exists {
+			break
+		}
+		label = fmt.Sprintf("%s_%d", base, n)
+	}
+
+	used[label] = struct{}{}
+	return label
+}
+
+// sanitizeLabel replaces characters which can't be used in component labels
+// with underscores.
+func sanitizeLabel(name string) string {
+	if name == "" {
+		return "default"
+	}
+
+	out := []rune(name)
+	for i, r := range out {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+		default:
+			out[i] = '_'
+		}
+	}
+	return string(out)
+}
+
+func unsupported(what, detail string) *hcl.Diagnostic {
+	return &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  what + " is not supported",
+		Detail:   detail,
+	}
+}
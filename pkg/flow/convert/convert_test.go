@@ -0,0 +1,153 @@
+package convert_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/grafana/agent/pkg/flow"
+	"github.com/grafana/agent/pkg/flow/convert"
+	"github.com/grafana/agent/pkg/metrics"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+
+	_ "github.com/grafana/agent/component/metrics/remotewrite" // Import metrics.remote_write
+	_ "github.com/grafana/agent/component/metrics/scraper"     // Import metrics.scrape
+	_ "github.com/grafana/agent/component/targets/mutate"      // Import targets.mutate
+)
+
+func TestMetrics(t *testing.T) {
+	in := `
+global:
+  external_labels:
+    cluster: prod
+configs:
+- name: default
+  remote_write:
+  - url: http://localhost:9009/api/prom/push
+    basic_auth:
+      username: user
+      password: pass
+  scrape_configs:
+  - job_name: api
+    static_configs:
+    - targets: ['api:8080']
+      labels:
+        team: a
+    relabel_configs:
+    - source_labels: [team]
+      target_label: owner
+`
+
+	var c metrics.Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(in), &c))
+
+	f, diags := convert.Metrics(&c)
+
+	expect := `metrics "remote_write" "default" {
+  external_labels = {
+    cluster = "prod"
+  }
+  remote_write {
+    url = "http://localhost:9009/api/prom/push"
+    basic_auth {
+      username = "user"
+      password = "pass"
+    }
+  }
+}
+
+// Targets of scrape job "api" from instance "default".
+targets "mutate" "default_api" {
+  targets = [{
+    __address__      = "api:8080"
+    __metrics_path__ = "/metrics"
+    __scheme__       = "http"
+    job              = "api"
+    team             = "a"
+  }]
+  relabel_config {
+    source_labels = ["team"]
+    target_label  = "owner"
+  }
+}
+
+metrics "scrape" "default_api" {
+  targets         = targets.mutate.default_api.output
+  forward_to      = [metrics.remote_write.default.receiver]
+  job_name        = "api"
+  scrape_interval = "1m0s"
+  scrape_timeout  = "10s"
+}
+
+`
+	require.Equal(t, expect, string(f.Bytes()))
+	require.Empty(t, diags)
+
+	// The converted file must be loadable.
+	_, readDiags := flow.ReadFile(t.Name(), f.Bytes())
+	require.False(t, readDiags.HasErrors(), readDiags.Error())
+}
+
+func TestMetrics_Unsupported(t *testing.T) {
+	in := `
+configs:
+- name: default
+  host_filter: true
+  remote_write:
+  - url: http://localhost:9009/api/prom/push
+    headers:
+      X-Scope-OrgID: a
+  scrape_configs:
+  - job_name: k8s
+    kubernetes_sd_configs:
+    - role: pod
+`
+
+	var c metrics.Config
+	require.NoError(t, yaml.UnmarshalStrict([]byte(in), &c))
+
+	f, diags := convert.Metrics(&c)
+	require.True(t, diags.HasErrors())
+	require.True(t, strings.HasPrefix(string(f.Bytes()), "// NOTE: This file was partially converted."))
+
+	var summaries []string
+	for _, diag := range diags {
+		summaries = append(summaries, diag.Summary)
+	}
+	require.Equal(t, []string{
+		`instance "default": host_filter is not supported`,
+		`instance "default": remote_write "http://localhost:9009/api/prom/push": headers is not supported`,
+		`instance "default": scrape job "k8s": kubernetes_sd_configs is not supported`,
+		`instance "default": scrape job "k8s" has no static targets`,
+	}, summaries)
+}
+
+func TestMetrics_UniqueLabels(t *testing.T) {
+	// The second "a" instance would be labeled a_2, which is already used by
+	// the "a_2" instance.
+	in := `
+configs:
+- name: a
+  remote_write:
+  - url: http://localhost:9009/api/prom/push
+- name: a_2
+  remote_write:
+  - url: http://localhost:9009/api/prom/push
+- name: a
+  remote_write:
+  - url: http://localhost:9009/api/prom/push
+`
+
+	var c metrics.Config
+	require.NoError(t, yaml.Unmarshal([]byte(in), &c))
+
+	f, _ := convert.Metrics(&c)
+	out := string(f.Bytes())
+	require.Contains(t, out, `metrics "remote_write" "a" {`)
+	require.Contains(t, out, `metrics "remote_write" "a_2" {`)
+	require.Contains(t, out, `metrics "remote_write" "a_3" {`)
+
+	// The converted file must be loadable.
+	_, readDiags := flow.ReadFile(t.Name(), f.Bytes())
+	require.False(t, readDiags.HasErrors(), readDiags.Error())
+}
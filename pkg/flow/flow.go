@@ -51,8 +51,12 @@ import (
 	"io"
 	"sync"
 
+	"github.com/benbjohnson/clock"
 	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/pkg/cluster"
 	"github.com/grafana/agent/pkg/flow/internal/controller"
+	"github.com/grafana/agent/pkg/flow/internal/testhooks"
 	"github.com/grafana/agent/pkg/flow/logging"
 	"github.com/hashicorp/hcl/v2"
 )
@@ -66,6 +70,34 @@ type Options struct {
 	// Directory where components can write data. Components will create
 	// subdirectories for component-specific data.
 	DataPath string
+
+	// Clusterer is the cluster node that components use to shard work with
+	// other Flow controllers. A single-node cluster is used if Clusterer is
+	// nil.
+	Clusterer cluster.Node
+
+	// Clock for components to use. The wall clock is used if Clock is nil.
+	Clock clock.Clock
+
+	// ExportsHooks are invoked with the exports of every component, in order.
+	ExportsHooks []ExportsHook
+
+	// overrides replaces the Build function of components by their ID. It is
+	// only set through NewWithOverrides.
+	overrides testhooks.Overrides
+}
+
+// ExportsHook observes the exports of components, such as to tap the metrics
+// sent to them.
+type ExportsHook interface {
+	// WrapExports is called with new exports of the component with the given
+	// ID before they are exposed to other components. It must return a value
+	// of the same type as e.
+	WrapExports(componentID string, e component.Exports) component.Exports
+
+	// ComponentRemoved is called once the component with the given ID has
+	// been removed from the controller and stopped running.
+	ComponentRemoved(componentID string)
 }
 
 // Flow is the Flow system.
@@ -83,6 +115,10 @@ type Flow struct {
 
 	loadMut    sync.RWMutex
 	loadedOnce bool
+
+	// IDs of removed components which hooks haven't been informed about yet.
+	removedMut sync.Mutex
+	removed    map[string]struct{}
 }
 
 // New creates and starts a new Flow controller. Call Close to stop
@@ -93,6 +129,14 @@ func New(o Options) *Flow {
 	return c
 }
 
+// NewWithOverrides is like New, but replaces the Build function of the
+// components in overrides. The type of overrides is internal to flow, so only
+// testing packages of flow, such as componenttest, can use NewWithOverrides.
+func NewWithOverrides(o Options, overrides testhooks.Overrides) *Flow {
+	o.overrides = overrides
+	return New(o)
+}
+
 func newFlow(o Options) (*Flow, context.Context) {
 	ctx, cancel := context.WithCancel(context.Background())
 
@@ -106,6 +150,11 @@ func newFlow(o Options) (*Flow, context.Context) {
 		}
 	}
 
+	clusterer := o.Clusterer
+	if clusterer == nil {
+		clusterer = cluster.NewLocalNode("")
+	}
+
 	var (
 		queue  = controller.NewQueue()
 		sched  = controller.NewScheduler()
@@ -116,6 +165,15 @@ func newFlow(o Options) (*Flow, context.Context) {
 				// Changed components should be queued for reevaluation.
 				queue.Enqueue(cn)
 			},
+			WrapExports: func(id string, e component.Exports) component.Exports {
+				for _, h := range o.ExportsHooks {
+					e = h.WrapExports(id, e)
+				}
+				return e
+			},
+			Clusterer:   clusterer,
+			Clock:       o.Clock,
+			Overrides:   o.overrides,
 		})
 	)
 
@@ -130,6 +188,8 @@ func newFlow(o Options) (*Flow, context.Context) {
 		cancel:       cancel,
 		exited:       make(chan struct{}, 1),
 		loadFinished: make(chan struct{}, 1),
+
+		removed: make(map[string]struct{}),
 	}, ctx
 }
 
@@ -163,7 +223,11 @@ func (c *Flow) run(ctx context.Context) {
 			err := c.sched.Synchronize(runnables)
 			if err != nil {
 				level.Error(c.log).Log("msg", "failed to load components", "err", err)
+				continue
 			}
+
+			// Removed components have stopped by the time Synchronize returns.
+			c.notifyRemoved(components)
 		}
 	}
 }
@@ -186,7 +250,9 @@ func (c *Flow) LoadFile(f *File) error {
 		return fmt.Errorf("error updating logger: %w", err)
 	}
 
+	prev := c.loader.Components()
 	diags := c.loader.Apply(rootEvalContext, f.Components)
+	c.trackRemoved(prev, c.loader.Components())
 	if !c.loadedOnce && diags.HasErrors() {
 		// The first call to Load should not run any components if there were
 		// errors in the coniguration file.
@@ -202,6 +268,43 @@ func (c *Flow) LoadFile(f *File) error {
 	return diagsOrNil(diags)
 }
 
+// notifyRemoved informs hooks about components which were removed by calls
+// to LoadFile and aren't part of the loaded components.
+func (c *Flow) notifyRemoved(loaded []*controller.ComponentNode) {
+	c.removedMut.Lock()
+	removed := c.removed
+	c.removed = make(map[string]struct{})
+	c.removedMut.Unlock()
+
+	for _, cn := range loaded {
+		// The component was added back after being removed.
+		delete(removed, cn.NodeID())
+	}
+	for id := range removed {
+		c.log.RemoveComponent(id)
+		for _, h := range c.opts.ExportsHooks {
+			h.ComponentRemoved(id)
+		}
+	}
+}
+
+// trackRemoved records the components in prev which aren't in next so hooks
+// can be informed about them once they stopped running.
+func (c *Flow) trackRemoved(prev, next []*controller.ComponentNode) {
+	keep := make(map[string]struct{}, len(next))
+	for _, cn := range next {
+		keep[cn.NodeID()] = struct{}{}
+	}
+
+	c.removedMut.Lock()
+	defer c.removedMut.Unlock()
+	for _, cn := range prev {
+		if _, ok := keep[cn.NodeID()]; !ok {
+			c.removed[cn.NodeID()] = struct{}{}
+		}
+	}
+}
+
 func diagsOrNil(d hcl.Diagnostics) error {
 	if len(d) > 0 {
 		return d
@@ -209,6 +312,31 @@ func diagsOrNil(d hcl.Diagnostics) error {
 	return nil
 }
 
+// ComponentInfo holds the current state of a component.
+type ComponentInfo struct {
+	ID        string
+	Arguments component.Arguments
+	Exports   component.Exports
+	Health    component.Health
+}
+
+// ComponentInfo returns the current state of the component with the given
+// ID. ok is false if the component doesn't exist.
+func (c *Flow) ComponentInfo(id string) (info ComponentInfo, ok bool) {
+	for _, cn := range c.loader.Components() {
+		if cn.NodeID() != id {
+			continue
+		}
+		return ComponentInfo{
+			ID:        id,
+			Arguments: cn.Arguments(),
+			Exports:   cn.Exports(),
+			Health:    cn.CurrentHealth(),
+		}, true
+	}
+	return ComponentInfo{}, false
+}
+
 // Close closes the controller and all running components.
 func (c *Flow) Close() error {
 	c.cancel()
@@ -43,6 +43,27 @@ func (f *Flow) ConfigHandler() http.HandlerFunc {
 	}
 }
 
+// LogsHandler returns an http.HandlerFunc which writes the most recent log
+// lines of a component, oldest first. The component is chosen with the
+// required component query parameter.
+func (f *Flow) LogsHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		componentID := r.URL.Query().Get("component")
+		if componentID == "" {
+			http.Error(w, "component parameter is required", http.StatusBadRequest)
+			return
+		} else if f.loader.Graph().GetByID(componentID) == nil {
+			http.Error(w, fmt.Sprintf("component %q does not exist", componentID), http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, line := range f.log.RecentLines(componentID) {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
 // configBytes dumps the current state of the flow config as HCL.
 func (f *Flow) configBytes(w io.Writer, debugInfo bool) (n int64, err error) {
 	file := hclwrite.NewFile()
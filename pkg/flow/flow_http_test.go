@@ -2,6 +2,8 @@ package flow
 
 import (
 	"bytes"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	_ "github.com/grafana/agent/pkg/flow/internal/testcomponents" // Import testcomponents
@@ -53,3 +55,32 @@ testcomponents "passthrough" "static" {
 
 	require.Equal(t, expect, actual)
 }
+
+func TestLogsHandler(t *testing.T) {
+	configFile := `
+		testcomponents "passthrough" "static" {
+			input = "hello, world!"
+		}
+	`
+
+	file, diags := ReadFile(t.Name(), []byte(configFile))
+	require.NotNil(t, file)
+	require.False(t, diags.HasErrors(), "Found errors when loading file")
+
+	f, _ := newFlow(testOptions(t))
+	require.NoError(t, f.LoadFile(file))
+
+	t.Run("recent lines", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		f.LogsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/logs?component=testcomponents.passthrough.static", nil))
+
+		require.Equal(t, http.StatusOK, rec.Code)
+		require.Contains(t, rec.Body.String(), `msg="passing through value" value="hello, world!"`)
+	})
+
+	t.Run("unknown component", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		f.LogsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/logs?component=testcomponents.passthrough.missing", nil))
+		require.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
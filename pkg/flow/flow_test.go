@@ -2,7 +2,9 @@ package flow
 
 import (
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/grafana/agent/component"
 	"github.com/grafana/agent/pkg/flow/internal/controller"
@@ -49,6 +51,69 @@ func TestController_LoadFile_Evaluation(t *testing.T) {
 	require.Equal(t, "hello, world!", out.(testcomponents.PassthroughExports).Output)
 }
 
+func TestController_ExportsHooks(t *testing.T) {
+	hook := &recordingHook{removed: make(chan string, 1)}
+
+	opts := testOptions(t)
+	opts.ExportsHooks = []ExportsHook{hook}
+	ctrl := New(opts)
+	defer ctrl.Close()
+
+	f, diags := ReadFile(t.Name(), []byte(testFile))
+	require.False(t, diags.HasErrors())
+	require.NoError(t, ctrl.LoadFile(f))
+	require.Contains(t, hook.wrapped(), "testcomponents.passthrough.static")
+
+	// Removing a component informs hooks once it stopped running.
+	f, diags = ReadFile(t.Name(), []byte(`
+		testcomponents "passthrough" "static" {
+			input = "hello, world!"
+		}
+	`))
+	require.False(t, diags.HasErrors())
+	require.NoError(t, ctrl.LoadFile(f))
+
+	removed := make(map[string]bool)
+	for len(removed) < 3 {
+		select {
+		case id := <-hook.removed:
+			removed[id] = true
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "timed out waiting for removed components")
+		}
+	}
+	require.Equal(t, map[string]bool{
+		"testcomponents.tick.ticker":           true,
+		"testcomponents.passthrough.ticker":    true,
+		"testcomponents.passthrough.forwarded": true,
+	}, removed)
+}
+
+type recordingHook struct {
+	mut sync.Mutex
+	ids map[string]struct{}
+
+	removed chan string
+}
+
+func (h *recordingHook) WrapExports(id string, e component.Exports) component.Exports {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	if h.ids == nil {
+		h.ids = make(map[string]struct{})
+	}
+	h.ids[id] = struct{}{}
+	return e
+}
+
+func (h *recordingHook) ComponentRemoved(id string) { h.removed <- id }
+
+func (h *recordingHook) wrapped() map[string]struct{} {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	return h.ids
+}
+
 func getFields(t *testing.T, g *dag.Graph, nodeID string) (component.Arguments, component.Exports) {
 	t.Helper()
 
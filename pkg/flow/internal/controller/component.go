@@ -10,9 +10,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/grafana/agent/component"
+	"github.com/grafana/agent/pkg/cluster"
 	"github.com/grafana/agent/pkg/flow/internal/dag"
 	"github.com/hashicorp/hcl/v2"
 	"github.com/rfratto/gohcl"
@@ -56,6 +58,24 @@ type ComponentGlobals struct {
 	Logger          log.Logger              // Logger shared between all managed components.
 	DataPath        string                  // Shared directory where component data may be stored
 	OnExportsChange func(cn *ComponentNode) // Invoked when the managed component updated its exports
+
+	// WrapExports is an optional hook invoked with new exports from a managed
+	// component before they are stored. It must return a value of the same
+	// type as e.
+	WrapExports func(id string, e component.Exports) component.Exports
+
+	// Clusterer is the cluster node passed to managed components. A
+	// single-node cluster is used if Clusterer is nil.
+	Clusterer cluster.Node
+
+	// Clock passed to managed components. The wall clock is used if Clock is
+	// nil.
+	Clock clock.Clock
+
+	// Overrides replaces the Build function of components by their ID. The
+	// overriding function receives the arguments of the replaced component
+	// and must export the same type as the replaced component.
+	Overrides map[string]func(opts component.Options, args component.Arguments) (component.Component, error)
 }
 
 // ComponentNode is a controller node which manages a user-defined component.
@@ -70,6 +90,7 @@ type ComponentNode struct {
 	managedOpts     component.Options
 	exportsType     reflect.Type
 	onExportsChange func(cn *ComponentNode) // Informs controller that we changed our exports
+	wrapExports     func(id string, e component.Exports) component.Exports
 
 	mut     sync.RWMutex
 	block   *hcl.Block          // Current HCL block to derive args from
@@ -109,6 +130,9 @@ func NewComponentNode(globals ComponentGlobals, b *hcl.Block) *ComponentNode {
 		// expected component.
 		panic("NewComponentNode: could not find registration for component " + nodeID)
 	}
+	if build, ok := globals.Overrides[nodeID]; ok {
+		reg.Build = build
+	}
 
 	initHealth := component.Health{
 		Health:     component.HealthTypeUnknown,
@@ -122,6 +146,7 @@ func NewComponentNode(globals ComponentGlobals, b *hcl.Block) *ComponentNode {
 		reg:             reg,
 		exportsType:     getExportsType(reg),
 		onExportsChange: globals.OnExportsChange,
+		wrapExports:     globals.WrapExports,
 
 		block: b,
 
@@ -153,14 +178,27 @@ func getRegistration(id ComponentID) (component.Registration, bool) {
 }
 
 func getManagedOptions(globals ComponentGlobals, cn *ComponentNode) component.Options {
+	clusterer := globals.Clusterer
+	if clusterer == nil {
+		clusterer = cluster.NewLocalNode("")
+	}
+	clk := globals.Clock
+	if clk == nil {
+		clk = wallClock
+	}
+
 	return component.Options{
 		ID:            cn.nodeID,
 		Logger:        log.With(globals.Logger, "component", cn.nodeID),
 		DataPath:      filepath.Join(globals.DataPath, cn.nodeID),
 		OnStateChange: cn.setExports,
+		Clusterer:     clusterer,
+		Clock:         clk,
 	}
 }
 
+var wallClock = clock.New()
+
 func getExportsType(reg component.Registration) reflect.Type {
 	if reg.Exports != nil {
 		return reflect.TypeOf(reg.Exports)
@@ -317,6 +355,9 @@ func (cn *ComponentNode) setExports(e component.Exports) {
 	if reflect.TypeOf(e) != cn.exportsType {
 		panic(fmt.Sprintf("Component %s changed Exports types from %T to %T", cn.nodeID, cn.reg.Exports, e))
 	}
+	if cn.wrapExports != nil {
+		e = cn.wrapExports(cn.nodeID, e)
+	}
 
 	// Some components may aggressively reexport values even though no exposed
 	// state has changed. This may be done for components which always supply
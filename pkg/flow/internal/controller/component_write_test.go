@@ -105,13 +105,12 @@ func loadFile(t *testing.T, bb []byte) hcl.Blocks {
 		require.FailNow(t, diags.Error())
 	}
 
-	blockSchema := component.RegistrySchema()
-	content, contentDiags := file.Body.Content(blockSchema)
-	if contentDiags.HasErrors() {
-		require.FailNow(t, contentDiags.Error())
+	blocks, blockDiags := component.Blocks(file.Body.(*hclsyntax.Body))
+	if blockDiags.HasErrors() {
+		require.FailNow(t, blockDiags.Error())
 	}
 
-	return content.Blocks
+	return blocks
 }
 
 func marshalBlock(b *hclwrite.Block) string {
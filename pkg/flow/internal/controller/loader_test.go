@@ -146,14 +146,13 @@ func applyFromContent(t *testing.T, l *controller.Loader, bb []byte) hcl.Diagnos
 		return diags
 	}
 
-	blockSchema := component.RegistrySchema()
-	content, remainDiags := file.Body.Content(blockSchema)
-	diags = diags.Extend(remainDiags)
+	blocks, blockDiags := component.Blocks(file.Body.(*hclsyntax.Body))
+	diags = diags.Extend(blockDiags)
 	if diags.HasErrors() {
 		return diags
 	}
 
-	applyDiags := l.Apply(nil, content.Blocks)
+	applyDiags := l.Apply(nil, blocks)
 	diags = diags.Extend(applyDiags)
 
 	return diags
@@ -33,7 +33,7 @@ type TickExports struct {
 	Time time.Time `hcl:"tick_time,optional"`
 }
 
-// Tick implements the testcomponents.tick component, where the wallclock time
+// Tick implements the testcomponents.tick component, where the current time
 // will be emitted on a given frequency.
 type Tick struct {
 	opts component.Options
@@ -62,9 +62,9 @@ func (t *Tick) Run(ctx context.Context) error {
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-time.After(t.getNextTick()):
+		case <-t.opts.Clock.After(t.getNextTick()):
 			level.Info(t.log).Log("msg", "ticked")
-			t.opts.OnStateChange(TickExports{Time: time.Now()})
+			t.opts.OnStateChange(TickExports{Time: t.opts.Clock.Now()})
 		}
 	}
 }
@@ -0,0 +1,15 @@
+// Package testhooks holds types which let testing packages change internals
+// of the flow package without making them part of the public API of flow.
+package testhooks
+
+import "github.com/grafana/agent/component"
+
+// BuildFunc builds a component. It has the same signature as
+// component.Registration.Build.
+type BuildFunc = func(opts component.Options, args component.Arguments) (component.Component, error)
+
+// Overrides replaces the Build function of components by their ID, such as
+// "metrics.remote_write.default". The overriding function receives the
+// arguments of the replaced component and must export the same type as the
+// replaced component.
+type Overrides map[string]BuildFunc
@@ -14,13 +14,26 @@ type Options struct {
 	Level  Level  `hcl:"level,optional"`
 	Format Format `hcl:"format,optional"`
 
-	// TODO: log sink parameter (e.g., to use the Windows Event logger)
+	// Sink is where logs are written. File and Syslog configure the file and
+	// syslog sinks.
+	Sink   Sink           `hcl:"sink,optional"`
+	File   *FileOptions   `hcl:"file,block"`
+	Syslog *SyslogOptions `hcl:"syslog,block"`
+
+	// Components overrides the log level of individual components.
+	Components []ComponentOptions `hcl:"component,block"`
+
+	// BufferedLines is the number of recent log lines kept in memory for each
+	// component. Buffering is disabled when 0.
+	BufferedLines int `hcl:"buffered_lines,optional"`
 }
 
 // DefaultOptions holds defaults for creating a Logger.
 var DefaultOptions = Options{
-	Level:  LevelDefault,
-	Format: FormatDefault,
+	Level:         LevelDefault,
+	Format:        FormatDefault,
+	Sink:          SinkDefault,
+	BufferedLines: 100,
 }
 
 var _ gohcl.Decoder = (*Options)(nil)
@@ -30,6 +43,93 @@ func (o *Options) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
 	*o = DefaultOptions
 
 	type options Options
+	if err := gohcl.DecodeBody(body, ctx, (*options)(o)); err != nil {
+		return err
+	}
+
+	if o.Sink == SinkFile && o.File == nil {
+		return fmt.Errorf("the file sink requires a file block")
+	}
+	if o.Sink == SinkSyslog && o.Syslog == nil {
+		defaults := DefaultSyslogOptions
+		o.Syslog = &defaults
+	}
+	if o.BufferedLines < 0 {
+		return fmt.Errorf("buffered_lines must not be negative")
+	}
+
+	seen := make(map[string]struct{}, len(o.Components))
+	for _, c := range o.Components {
+		if _, ok := seen[c.ID]; ok {
+			return fmt.Errorf("log level for component %q set more than once", c.ID)
+		}
+		seen[c.ID] = struct{}{}
+	}
+	return nil
+}
+
+// ComponentOptions overrides the log level of the component with the given
+// ID, such as "metrics.remote_write.default".
+type ComponentOptions struct {
+	ID    string `hcl:",label"`
+	Level Level  `hcl:"level,attr"`
+}
+
+// FileOptions configures the file sink. The file is rotated once it grows
+// past MaxSizeMB.
+type FileOptions struct {
+	Path       string `hcl:"path,attr"`
+	MaxSizeMB  int    `hcl:"max_size_mb,optional"`
+	MaxBackups int    `hcl:"max_backups,optional"`
+}
+
+// DefaultFileOptions holds defaults for the file sink.
+var DefaultFileOptions = FileOptions{
+	MaxSizeMB:  100,
+	MaxBackups: 5,
+}
+
+var _ gohcl.Decoder = (*FileOptions)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (o *FileOptions) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*o = DefaultFileOptions
+
+	type options FileOptions
+	if err := gohcl.DecodeBody(body, ctx, (*options)(o)); err != nil {
+		return err
+	}
+
+	if o.MaxSizeMB <= 0 {
+		return fmt.Errorf("max_size_mb must be greater than 0")
+	}
+	if o.MaxBackups < 0 {
+		return fmt.Errorf("max_backups must not be negative")
+	}
+	return nil
+}
+
+// SyslogOptions configures the syslog sink. Logs are sent to the local
+// syslog daemon.
+type SyslogOptions struct {
+	// Socket is the path of the Unix socket of the syslog daemon. When empty,
+	// common socket paths such as /dev/log are tried.
+	Socket string `hcl:"socket,optional"`
+	Tag    string `hcl:"tag,optional"`
+}
+
+// DefaultSyslogOptions holds defaults for the syslog sink.
+var DefaultSyslogOptions = SyslogOptions{
+	Tag: "agentflow",
+}
+
+var _ gohcl.Decoder = (*SyslogOptions)(nil)
+
+// DecodeHCL implements gohcl.Decoder.
+func (o *SyslogOptions) DecodeHCL(body hcl.Body, ctx *hcl.EvalContext) error {
+	*o = DefaultSyslogOptions
+
+	type options SyslogOptions
 	return gohcl.DecodeBody(body, ctx, (*options)(o))
 }
 
@@ -69,20 +169,25 @@ func (ll *Level) UnmarshalText(text []byte) error {
 	return nil
 }
 
-// Filter returns a go-kit logging filter from the level.
-func (ll Level) Filter() level.Option {
-	switch ll {
-	case LevelDebug:
-		return level.AllowDebug()
-	case LevelInfo:
-		return level.AllowInfo()
-	case LevelWarn:
-		return level.AllowWarn()
-	case LevelError:
-		return level.AllowError()
-	default:
-		return level.AllowAll()
+// levelRanks orders log levels by severity.
+var levelRanks = map[string]int{
+	level.DebugValue().String(): 0,
+	level.InfoValue().String():  1,
+	level.WarnValue().String():  2,
+	level.ErrorValue().String(): 3,
+}
+
+// Allows returns true if a log line with the level v should be logged at
+// the level ll. Log lines without a level are always logged.
+func (ll Level) Allows(v level.Value) bool {
+	if v == nil {
+		return true
 	}
+	min, ok := levelRanks[string(ll)]
+	if !ok {
+		return true
+	}
+	return levelRanks[v.String()] >= min
 }
 
 // Format represents a text format to use when writing logs.
@@ -118,3 +223,38 @@ func (ll *Format) UnmarshalText(text []byte) error {
 	}
 	return nil
 }
+
+// Sink represents where logs are written.
+type Sink string
+
+// Supported log sinks.
+const (
+	SinkStderr Sink = "stderr"
+	SinkFile   Sink = "file"
+	SinkSyslog Sink = "syslog"
+
+	SinkDefault = SinkStderr
+)
+
+var (
+	_ encoding.TextMarshaler   = SinkDefault
+	_ encoding.TextUnmarshaler = (*Sink)(nil)
+)
+
+// MarshalText implements encoding.TextMarshaler.
+func (s Sink) MarshalText() (text []byte, err error) {
+	return []byte(s), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (s *Sink) UnmarshalText(text []byte) error {
+	switch Sink(text) {
+	case "":
+		*s = SinkDefault
+	case SinkStderr, SinkFile, SinkSyslog:
+		*s = Sink(text)
+	default:
+		return fmt.Errorf("unrecognized log sink %q", string(text))
+	}
+	return nil
+}
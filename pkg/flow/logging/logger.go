@@ -1,67 +1,290 @@
 package logging
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"reflect"
+	"strings"
 	"sync"
 
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 )
 
+// componentKey is the log key which holds the ID of the component which
+// logged a line.
+const componentKey = "component"
+
 // Logger implements the github.com/go-kit/log.Logger interface. It supports
 // being dynamically updated at runtime.
 type Logger struct {
 	w io.Writer
 
-	mut sync.RWMutex
-	l   log.Logger
+	mut       sync.RWMutex
+	opts      Options
+	sink      sink
+	overrides map[string]Level
+
+	buffers  *lineBuffers
+	encoders sync.Pool // Pool of *encoder.
 }
 
 // New creates a New logger with the default log level and format.
 func New(w io.Writer, o Options) (*Logger, error) {
-	inner, err := buildLogger(w, o)
-	if err != nil {
+	l := &Logger{w: w, buffers: newLineBuffers()}
+	l.encoders.New = func() interface{} { return newEncoder() }
+	if err := l.Update(o); err != nil {
 		return nil, err
 	}
-
-	return &Logger{w: w, l: inner}, nil
+	return l, nil
 }
 
 // Log implements log.Logger.
 func (l *Logger) Log(kvps ...interface{}) error {
 	l.mut.RLock()
 	defer l.mut.RUnlock()
-	return l.l.Log(kvps...)
-}
 
-// Update re-configures the options used for the logger.
-func (l *Logger) Update(o Options) error {
-	newLogger, err := buildLogger(l.w, o)
+	var (
+		componentID = findComponent(kvps)
+		lvl         = findLevel(kvps)
+	)
+
+	minLevel := l.opts.Level
+	if override, ok := l.overrides[componentID]; ok {
+		minLevel = override
+	}
+	if !minLevel.Allows(lvl) {
+		return nil
+	}
+
+	enc := l.encoders.Get().(*encoder)
+	defer l.encoders.Put(enc)
+
+	line, err := enc.Encode(l.opts.Format, kvps)
 	if err != nil {
 		return err
 	}
+	if componentID != "" {
+		l.buffers.Add(componentID, strings.TrimSuffix(string(line), "\n"))
+	}
+	return l.sink.Write(lvl, line)
+}
+
+// Update re-configures the options used for the logger. The sink is only
+// recreated when its options change.
+func (l *Logger) Update(o Options) error {
+	switch o.Format {
+	case FormatLogfmt, FormatJSON:
+	default:
+		return fmt.Errorf("unrecognized log format %q", o.Format)
+	}
+
+	overrides := make(map[string]Level, len(o.Components))
+	for _, c := range o.Components {
+		overrides[c.ID] = c.Level
+	}
 
 	l.mut.Lock()
 	defer l.mut.Unlock()
-	l.l = newLogger
+
+	if l.sink == nil || !sameSink(l.opts, o) {
+		newSink, err := newSink(l.w, o)
+		if err != nil {
+			return fmt.Errorf("building log sink: %w", err)
+		}
+		if l.sink != nil {
+			_ = l.sink.Close()
+		}
+		l.sink = newSink
+	}
+
+	l.opts = o
+	l.overrides = overrides
+	l.buffers.SetSize(o.BufferedLines)
 	return nil
 }
 
-func buildLogger(w io.Writer, o Options) (log.Logger, error) {
-	var l log.Logger
+// RecentLines returns the most recent log lines of the component with the
+// given ID, oldest first. The number of lines kept is set by
+// Options.BufferedLines.
+func (l *Logger) RecentLines(componentID string) []string {
+	return l.buffers.Lines(componentID)
+}
+
+// RemoveComponent drops the recent log lines of the component with the given
+// ID. It is called once a component has been removed.
+func (l *Logger) RemoveComponent(componentID string) {
+	l.buffers.Remove(componentID)
+}
 
-	switch o.Format {
-	case FormatLogfmt:
-		l = log.NewLogfmtLogger(log.NewSyncWriter(w))
-	case FormatJSON:
-		l = log.NewJSONLogger(log.NewSyncWriter(w))
-	default:
-		return nil, fmt.Errorf("unrecognized log format %q", o.Format)
+// Close closes the sink of the logger. Log lines written after Close are
+// dropped.
+func (l *Logger) Close() error {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+
+	err := l.sink.Close()
+	l.sink = &writerSink{w: io.Discard}
+	return err
+}
+
+func sameSink(a, b Options) bool {
+	return a.Sink == b.Sink &&
+		reflect.DeepEqual(a.File, b.File) &&
+		reflect.DeepEqual(a.Syslog, b.Syslog)
+}
+
+// encoder encodes log lines into a reusable buffer. encoders aren't safe
+// for concurrent use.
+type encoder struct {
+	buf    bytes.Buffer
+	logfmt log.Logger
+	json   log.Logger
+}
+
+func newEncoder() *encoder {
+	e := &encoder{}
+	e.logfmt = log.With(log.NewLogfmtLogger(&e.buf), "ts", log.DefaultTimestampUTC)
+	e.json = log.With(log.NewJSONLogger(&e.buf), "ts", log.DefaultTimestampUTC)
+	return e
+}
+
+// Encode encodes kvps in format f. The returned slice is only valid until
+// the next call to Encode.
+func (e *encoder) Encode(f Format, kvps []interface{}) ([]byte, error) {
+	e.buf.Reset()
+
+	l := e.logfmt
+	if f == FormatJSON {
+		l = e.json
+	}
+	if err := l.Log(kvps...); err != nil {
+		return nil, err
 	}
+	return e.buf.Bytes(), nil
+}
 
-	l = level.NewFilter(l, o.Level.Filter())
+// findComponent returns the component ID from kvps, if any.
+func findComponent(kvps []interface{}) string {
+	for i := 0; i+1 < len(kvps); i += 2 {
+		if kvps[i] == componentKey {
+			if id, ok := kvps[i+1].(string); ok {
+				return id
+			}
+		}
+	}
+	return ""
+}
 
-	l = log.With(l, "ts", log.DefaultTimestampUTC)
-	return l, nil
+// findLevel returns the level from kvps, if any.
+func findLevel(kvps []interface{}) level.Value {
+	for i := 0; i+1 < len(kvps); i += 2 {
+		if kvps[i] == level.Key() {
+			if v, ok := kvps[i+1].(level.Value); ok {
+				return v
+			}
+		}
+	}
+	return nil
+}
+
+// lineBuffers holds a ring buffer of recent log lines for each component.
+type lineBuffers struct {
+	mut     sync.Mutex
+	size    int
+	buffers map[string]*lineRing
+}
+
+func newLineBuffers() *lineBuffers {
+	return &lineBuffers{buffers: make(map[string]*lineRing)}
+}
+
+// SetSize changes the number of lines kept for each component. Existing
+// buffers keep their most recent lines.
+func (lb *lineBuffers) SetSize(size int) {
+	lb.mut.Lock()
+	defer lb.mut.Unlock()
+
+	if size == lb.size {
+		return
+	}
+	lb.size = size
+
+	for id, r := range lb.buffers {
+		if size == 0 {
+			delete(lb.buffers, id)
+			continue
+		}
+		lines := r.Lines()
+		if len(lines) > size {
+			lines = lines[len(lines)-size:]
+		}
+		nr := newLineRing(size)
+		for _, line := range lines {
+			nr.Add(line)
+		}
+		lb.buffers[id] = nr
+	}
+}
+
+func (lb *lineBuffers) Add(componentID, line string) {
+	lb.mut.Lock()
+	defer lb.mut.Unlock()
+
+	if lb.size == 0 {
+		return
+	}
+	r, ok := lb.buffers[componentID]
+	if !ok {
+		r = newLineRing(lb.size)
+		lb.buffers[componentID] = r
+	}
+	r.Add(line)
+}
+
+func (lb *lineBuffers) Remove(componentID string) {
+	lb.mut.Lock()
+	defer lb.mut.Unlock()
+	delete(lb.buffers, componentID)
+}
+
+func (lb *lineBuffers) Lines(componentID string) []string {
+	lb.mut.Lock()
+	defer lb.mut.Unlock()
+
+	r, ok := lb.buffers[componentID]
+	if !ok {
+		return nil
+	}
+	return r.Lines()
+}
+
+// lineRing is a fixed-size ring buffer of lines.
+type lineRing struct {
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLineRing(size int) *lineRing {
+	return &lineRing{lines: make([]string, size)}
+}
+
+func (r *lineRing) Add(line string) {
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Lines returns the lines in r, oldest first.
+func (r *lineRing) Lines() []string {
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	res := make([]string, 0, len(r.lines))
+	res = append(res, r.lines[r.next:]...)
+	return append(res, r.lines[:r.next]...)
 }
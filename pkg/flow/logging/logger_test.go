@@ -0,0 +1,99 @@
+package logging_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/pkg/flow/logging"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger_ComponentLevels(t *testing.T) {
+	opts := logging.DefaultOptions
+	opts.Components = []logging.ComponentOptions{
+		{ID: "local.file.debug", Level: logging.LevelDebug},
+	}
+
+	var buf bytes.Buffer
+	l, err := logging.New(&buf, opts)
+	require.NoError(t, err)
+
+	level.Debug(log.With(l, "component", "local.file.debug")).Log("msg", "from debug component")
+	level.Debug(log.With(l, "component", "local.file.other")).Log("msg", "from other component")
+	level.Debug(l).Log("msg", "from controller")
+
+	require.Contains(t, buf.String(), "from debug component")
+	require.NotContains(t, buf.String(), "from other component")
+	require.NotContains(t, buf.String(), "from controller")
+
+	// Overrides are removed when the options are updated.
+	require.NoError(t, l.Update(logging.DefaultOptions))
+	buf.Reset()
+	level.Debug(log.With(l, "component", "local.file.debug")).Log("msg", "from debug component")
+	require.Empty(t, buf.String())
+}
+
+func TestLogger_RecentLines(t *testing.T) {
+	opts := logging.DefaultOptions
+	opts.BufferedLines = 3
+
+	var buf bytes.Buffer
+	l, err := logging.New(&buf, opts)
+	require.NoError(t, err)
+
+	cl := log.With(l, "component", "local.file.a")
+	for i := 0; i < 5; i++ {
+		level.Info(cl).Log("msg", fmt.Sprintf("line %d", i))
+	}
+	// Lines which are filtered out aren't buffered.
+	level.Debug(cl).Log("msg", "filtered")
+
+	lines := l.RecentLines("local.file.a")
+	require.Len(t, lines, 3)
+	for i, line := range lines {
+		require.Contains(t, line, fmt.Sprintf(`msg="line %d"`, i+2))
+	}
+	require.Empty(t, l.RecentLines("local.file.b"))
+
+	// Shrinking the buffer keeps the most recent lines.
+	opts.BufferedLines = 1
+	require.NoError(t, l.Update(opts))
+	lines = l.RecentLines("local.file.a")
+	require.Len(t, lines, 1)
+	require.Contains(t, lines[0], `msg="line 4"`)
+
+	// Lines of removed components are dropped.
+	l.RemoveComponent("local.file.a")
+	require.Empty(t, l.RecentLines("local.file.a"))
+}
+
+func TestLogger_FileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.log")
+
+	opts := logging.DefaultOptions
+	opts.Sink = logging.SinkFile
+	opts.File = &logging.FileOptions{Path: path, MaxSizeMB: 1, MaxBackups: 2}
+
+	l, err := logging.New(nil, opts)
+	require.NoError(t, err)
+	defer l.Close()
+
+	// Write enough logs to rotate the file a few times.
+	padding := string(bytes.Repeat([]byte("x"), 1024))
+	for i := 0; i < 4*1024; i++ {
+		level.Info(l).Log("msg", "hello", "padding", padding)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		fi, err := os.Stat(name)
+		require.NoError(t, err)
+		require.LessOrEqual(t, fi.Size(), int64(1024*1024))
+	}
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err), "only max_backups old files should be kept")
+}
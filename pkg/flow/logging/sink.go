@@ -0,0 +1,144 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/go-kit/log/level"
+)
+
+// sink writes formatted log lines.
+type sink interface {
+	// Write writes a single log line p logged at the level lvl. lvl is nil if
+	// the log line has no level.
+	Write(lvl level.Value, p []byte) error
+	Close() error
+}
+
+// newSink creates the sink configured by o. w is used for SinkStderr.
+func newSink(w io.Writer, o Options) (sink, error) {
+	switch o.Sink {
+	case SinkStderr:
+		return &writerSink{w: w}, nil
+	case SinkFile:
+		if o.File == nil {
+			return nil, fmt.Errorf("the file sink requires a file block")
+		}
+		return newFileSink(*o.File)
+	case SinkSyslog:
+		so := DefaultSyslogOptions
+		if o.Syslog != nil {
+			so = *o.Syslog
+		}
+		return newSyslogSink(so)
+	default:
+		return nil, fmt.Errorf("unrecognized log sink %q", o.Sink)
+	}
+}
+
+// writerSink writes log lines to an io.Writer.
+type writerSink struct {
+	mut sync.Mutex
+	w   io.Writer
+}
+
+func (s *writerSink) Write(_ level.Value, p []byte) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	_, err := s.w.Write(p)
+	return err
+}
+
+func (s *writerSink) Close() error { return nil }
+
+// fileSink writes log lines to a file. The file is rotated once it grows
+// past its max size, keeping up to MaxBackups old files as <path>.1,
+// <path>.2, and so on, where <path>.1 is the most recent.
+type fileSink struct {
+	opts    FileOptions
+	maxSize int64
+
+	mut  sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func newFileSink(o FileOptions) (*fileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(o.Path), 0755); err != nil {
+		return nil, err
+	}
+
+	s := &fileSink{opts: o, maxSize: int64(o.MaxSizeMB) * 1024 * 1024}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the log file for appending. mut must be held when called.
+func (s *fileSink) open() error {
+	f, err := os.OpenFile(s.opts.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.f, s.size = f, fi.Size()
+	return nil
+}
+
+func (s *fileSink) Write(_ level.Value, p []byte) error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if s.size > 0 && s.size+int64(len(p)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("rotating log file: %w", err)
+		}
+	}
+
+	n, err := s.f.Write(p)
+	s.size += int64(n)
+	return err
+}
+
+// rotate moves the current log file to a backup and opens a new one. mut
+// must be held when called.
+func (s *fileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+
+	backup := func(n int) string { return fmt.Sprintf("%s.%d", s.opts.Path, n) }
+
+	if s.opts.MaxBackups == 0 {
+		if err := os.Remove(s.opts.Path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return s.open()
+	}
+
+	// Shift existing backups, dropping the oldest one.
+	_ = os.Remove(backup(s.opts.MaxBackups))
+	for n := s.opts.MaxBackups - 1; n >= 1; n-- {
+		if err := os.Rename(backup(n), backup(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(s.opts.Path, backup(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.open()
+}
+
+func (s *fileSink) Close() error {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.f.Close()
+}
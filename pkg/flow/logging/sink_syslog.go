@@ -0,0 +1,54 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package logging
+
+import (
+	"log/syslog"
+
+	"github.com/go-kit/log/level"
+)
+
+// syslogSink writes log lines to the local syslog daemon. The syslog
+// priority is derived from the level of each log line.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink(o SyslogOptions) (sink, error) {
+	var (
+		network string
+		w       *syslog.Writer
+		err     error
+	)
+	if o.Socket != "" {
+		network = "unixgram"
+	}
+
+	w, err = syslog.Dial(network, o.Socket, syslog.LOG_INFO|syslog.LOG_DAEMON, o.Tag)
+	if err != nil && o.Socket != "" {
+		// Some syslog daemons listen on stream sockets instead.
+		w, err = syslog.Dial("unix", o.Socket, syslog.LOG_INFO|syslog.LOG_DAEMON, o.Tag)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) Write(lvl level.Value, p []byte) error {
+	msg := string(p)
+
+	switch lvl {
+	case level.DebugValue():
+		return s.w.Debug(msg)
+	case level.WarnValue():
+		return s.w.Warning(msg)
+	case level.ErrorValue():
+		return s.w.Err(msg)
+	default:
+		return s.w.Info(msg)
+	}
+}
+
+func (s *syslogSink) Close() error { return s.w.Close() }
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package logging
+
+import "fmt"
+
+func newSyslogSink(o SyslogOptions) (sink, error) {
+	return nil, fmt.Errorf("the syslog sink is not supported on this platform")
+}
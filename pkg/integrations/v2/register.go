@@ -134,6 +134,28 @@ func Registered() []Config {
 	return res
 }
 
+// RegisteredNames returns the names of all integrations which were passed to
+// Register or RegisterLegacy, in the order they were registered.
+func RegisteredNames() []string {
+	res := make([]string, 0, len(registered))
+	for _, r := range registered {
+		res = append(res, nameByType[reflect.TypeOf(r)])
+	}
+	return res
+}
+
+// UnmarshalConfig unmarshals raw YAML into a new Config for the integration
+// registered under name. Configs registered through RegisterLegacy are
+// upgraded, so the common metrics settings may be set in raw alongside the
+// settings of the integration.
+func UnmarshalConfig(name string, raw []byte) (Config, error) {
+	ref, ok := integrationByName[name]
+	if !ok {
+		return nil, fmt.Errorf("integration %q not registered", name)
+	}
+	return deferredConfigUnmarshal(util.RawYAML(raw), ref)
+}
+
 // RegisteredType returns the registered integrations.Type for c.
 func RegisteredType(c Config) (Type, bool) {
 	// We want to look up the registered type. Integrations are always registered
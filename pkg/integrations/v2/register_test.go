@@ -186,6 +186,23 @@ func TestIntegrationRegistration_Marshal_MultipleSingleton(t *testing.T) {
 	require.EqualError(t, err, `integration "test" may not be defined more than once`)
 }
 
+func TestUnmarshalConfig(t *testing.T) {
+	setRegistered(t, map[Config]Type{
+		&testIntegrationA{}: TypeSingleton,
+	})
+	require.Equal(t, []string{"test"}, RegisteredNames())
+
+	c, err := UnmarshalConfig("test", []byte(`text: Hello, world!`))
+	require.NoError(t, err)
+	require.Equal(t, &testIntegrationA{Text: "Hello, world!", Truth: true}, c)
+
+	_, err = UnmarshalConfig("test", []byte(`unknown: true`))
+	require.Error(t, err)
+
+	_, err = UnmarshalConfig("missing", nil)
+	require.EqualError(t, err, `integration "missing" not registered`)
+}
+
 type legacyConfig struct {
 	Text string `yaml:"text"`
 }
@@ -234,7 +234,7 @@ func (s *Subsystem) WireAPI(r *mux.Router) {
 
 	r.HandleFunc(IntegrationsAutoscrapeTargetsEndpoint, func(rw http.ResponseWriter, r *http.Request) {
 		allTargets := s.autoscraper.TargetsActive()
-		metrics.ListTargetsHandler(allTargets).ServeHTTP(rw, r)
+		metrics.ListTargetsHandler(allTargets, nil).ServeHTTP(rw, r)
 	})
 }
 
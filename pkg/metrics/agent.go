@@ -10,15 +10,20 @@ import (
 	"sync"
 	"time"
 
+	"github.com/alecthomas/units"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/storage"
 	"go.uber.org/atomic"
 	"google.golang.org/grpc"
 
 	"github.com/grafana/agent/pkg/metrics/cluster"
 	"github.com/grafana/agent/pkg/metrics/cluster/client"
+	"github.com/grafana/agent/pkg/metrics/ingest"
 	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/metrics/otlp"
+	"github.com/grafana/agent/pkg/metrics/wal"
 	"github.com/grafana/agent/pkg/util"
 )
 
@@ -41,11 +46,13 @@ type Config struct {
 	WALDir                 string                `yaml:"wal_directory,omitempty"`
 	WALCleanupAge          time.Duration         `yaml:"wal_cleanup_age,omitempty"`
 	WALCleanupPeriod       time.Duration         `yaml:"wal_cleanup_period,omitempty"`
+	MaxTotalWALSize        units.Base2Bytes      `yaml:"max_total_wal_size,omitempty"`
 	ServiceConfig          cluster.Config        `yaml:"scraping_service,omitempty"`
 	ServiceClientConfig    client.Config         `yaml:"scraping_service_client,omitempty"`
 	Configs                []instance.Config     `yaml:"configs,omitempty,omitempty"`
 	InstanceRestartBackoff time.Duration         `yaml:"instance_restart_backoff,omitempty"`
 	InstanceMode           instance.Mode         `yaml:"instance_mode,omitempty"`
+	Listeners              ingest.Config         `yaml:"listeners,omitempty"`
 
 	// Unmarshaled is true when the Config was unmarshaled from YAML.
 	Unmarshaled bool `yaml:"-"`
@@ -78,6 +85,14 @@ func (c *Config) ApplyDefaults() error {
 		return errors.New("cannot use configs when scraping_service mode is enabled")
 	}
 
+	if c.MaxTotalWALSize < 0 {
+		return errors.New("max_total_wal_size must not be negative")
+	}
+
+	if err := c.Listeners.Validate(); err != nil {
+		return fmt.Errorf("invalid listeners: %w", err)
+	}
+
 	usedNames := map[string]struct{}{}
 
 	for i := range c.Configs {
@@ -138,8 +153,17 @@ type Agent struct {
 
 	instanceFactory instanceFactory
 
+	// walBudget limits the combined size of the WALs of all instances.
+	walBudget *wal.SizeBudget
+
 	cluster *cluster.Cluster
 
+	// otlp writes OTLP metrics received over HTTP or gRPC to instances.
+	otlp *otlp.Receiver
+
+	// listeners runs the Graphite and Influx listeners.
+	listeners *ingest.Manager
+
 	stopped  bool
 	stopOnce sync.Once
 	actor    chan func()
@@ -156,6 +180,7 @@ func newAgent(reg prometheus.Registerer, cfg Config, logger log.Logger, fact ins
 	a := &Agent{
 		logger:          log.With(logger, "agent", "prometheus"),
 		instanceFactory: fact,
+		walBudget:       wal.NewSizeBudget(int64(cfg.MaxTotalWALSize)),
 		reg:             reg,
 		actor:           make(chan func(), 1),
 	}
@@ -175,6 +200,12 @@ func newAgent(reg prometheus.Registerer, cfg Config, logger log.Logger, fact ins
 		return nil, err
 	}
 
+	getInstance := func(name string) (storage.Appendable, error) {
+		return a.mm.GetInstance(name)
+	}
+	a.otlp = otlp.NewReceiver(a.logger, getInstance)
+	a.listeners = ingest.NewManager(a.logger, reg, getInstance)
+
 	if err := a.ApplyConfig(cfg); err != nil {
 		return nil, err
 	}
@@ -197,7 +228,7 @@ func (a *Agent) newInstance(c instance.Config) (instance.ManagedInstance, error)
 		instanceLabel: c.Name,
 	}, a.reg)
 
-	return a.instanceFactory(reg, c, a.cfg.WALDir, a.logger)
+	return a.instanceFactory(reg, c, a.cfg.WALDir, a.walBudget, a.cluster.Position, a.logger)
 }
 
 // Validate will validate the incoming Config and mutate it to apply defaults.
@@ -235,7 +266,8 @@ func (a *Agent) ApplyConfig(cfg Config) error {
 	// 2. Basic manager
 	// 3. Modal Manager
 	// 4. Cluster
-	// 5. Local configs
+	// 5. Listeners
+	// 6. Local configs
 
 	if a.cleaner != nil {
 		a.cleaner.Stop()
@@ -251,6 +283,9 @@ func (a *Agent) ApplyConfig(cfg Config) error {
 		)
 	}
 
+	// Instances pick up changes to the total size limit without restarting.
+	a.walBudget.SetMax(int64(cfg.MaxTotalWALSize))
+
 	a.bm.UpdateManagerConfig(instance.BasicManagerConfig{
 		InstanceRestartBackoff: cfg.InstanceRestartBackoff,
 	})
@@ -263,6 +298,10 @@ func (a *Agent) ApplyConfig(cfg Config) error {
 		return fmt.Errorf("failed to apply cluster config: %w", err)
 	}
 
+	if err := a.listeners.ApplyConfig(cfg.Listeners); err != nil {
+		return fmt.Errorf("failed to apply listeners config: %w", err)
+	}
+
 	// Queue an actor in the background to sync the instances. This is required
 	// because creating both this function and newInstance grab the mutex.
 	oldConfig := a.cfg
@@ -334,6 +373,7 @@ func (a *Agent) Ready() bool {
 // WireGRPC wires gRPC services into the provided server.
 func (a *Agent) WireGRPC(s *grpc.Server) {
 	a.cluster.WireGRPC(s)
+	a.otlp.WireGRPC(s)
 }
 
 // Config returns the configuration of this Agent.
@@ -353,6 +393,7 @@ func (a *Agent) Stop() {
 	})
 
 	a.cluster.Stop()
+	a.listeners.Stop()
 
 	if a.cleaner != nil {
 		a.cleaner.Stop()
@@ -365,8 +406,8 @@ func (a *Agent) Stop() {
 	a.stopped = true
 }
 
-type instanceFactory = func(reg prometheus.Registerer, cfg instance.Config, walDir string, logger log.Logger) (instance.ManagedInstance, error)
+type instanceFactory = func(reg prometheus.Registerer, cfg instance.Config, walDir string, walBudget *wal.SizeBudget, position instance.PositionFunc, logger log.Logger) (instance.ManagedInstance, error)
 
-func defaultInstanceFactory(reg prometheus.Registerer, cfg instance.Config, walDir string, logger log.Logger) (instance.ManagedInstance, error) {
-	return instance.New(reg, cfg, walDir, logger)
+func defaultInstanceFactory(reg prometheus.Registerer, cfg instance.Config, walDir string, walBudget *wal.SizeBudget, position instance.PositionFunc, logger log.Logger) (instance.ManagedInstance, error) {
+	return instance.New(reg, cfg, walDir, walBudget, position, logger)
 }
@@ -10,7 +10,9 @@ import (
 
 	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/go-kit/log"
+	"github.com/grafana/agent/pkg/metrics/ingest"
 	"github.com/grafana/agent/pkg/metrics/instance"
+	"github.com/grafana/agent/pkg/metrics/wal"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/prometheus/prometheus/storage"
@@ -58,6 +60,13 @@ func TestConfig_Validate(t *testing.T) {
 			},
 			expect: errors.New("prometheus instance names must be unique. found multiple instances with name instance"),
 		},
+		{
+			name: "invalid listener",
+			mutator: func(c *Config) {
+				c.Listeners.Graphite = []*ingest.ListenerConfig{{Name: "graphite", Instance: "instance"}}
+			},
+			expect: errors.New(`invalid listeners: graphite listener "graphite": listen_address must not be empty`),
+		},
 	}
 
 	for _, tc := range tt {
@@ -285,6 +294,18 @@ func (i *fakeInstance) TargetsActive() map[string][]*scrape.Target {
 	return nil
 }
 
+func (i *fakeInstance) TargetsDropped() map[string][]instance.DroppedTarget {
+	return nil
+}
+
+func (i *fakeInstance) TargetOffsets() map[*scrape.Target]time.Duration {
+	return nil
+}
+
+func (i *fakeInstance) PreviewScrape(_ context.Context, _, _ string) (*instance.ScrapePreview, error) {
+	return nil, instance.ErrTargetNotFound
+}
+
 func (i *fakeInstance) StorageDirectory() string {
 	return ""
 }
@@ -310,7 +331,7 @@ func (f *fakeInstanceFactory) Mocks() []*fakeInstance {
 	return f.mocks
 }
 
-func (f *fakeInstanceFactory) factory(_ prometheus.Registerer, cfg instance.Config, _ string, _ log.Logger) (instance.ManagedInstance, error) {
+func (f *fakeInstanceFactory) factory(_ prometheus.Registerer, cfg instance.Config, _ string, _ *wal.SizeBudget, _ instance.PositionFunc, _ log.Logger) (instance.ManagedInstance, error) {
 	f.created.Add(1)
 
 	f.mut.Lock()
@@ -145,6 +145,13 @@ func (c *Cluster) ApplyConfig(cfg Config) error {
 	return nil
 }
 
+// Position returns the position of the local agent among the healthy agents
+// of the cluster, along with the number of healthy agents. Returns 0 and 1
+// when the scraping service is disabled.
+func (c *Cluster) Position() (position, count int) {
+	return c.node.Position()
+}
+
 // WireAPI injects routes into the provided mux router for the config
 // management API.
 func (c *Cluster) WireAPI(r *mux.Router) {
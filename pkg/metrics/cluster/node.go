@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"hash/fnv"
 	"net/http"
+	"sort"
 	"sync"
 	"time"
 
@@ -374,6 +375,32 @@ func (n *node) Owns(key string) (bool, error) {
 	return false, nil
 }
 
+// Position returns the position of this node among the healthy nodes of the
+// ring, ordered by address, along with the number of healthy nodes. A node
+// which isn't part of a ring is alone in its cluster.
+func (n *node) Position() (position, count int) {
+	n.mut.RLock()
+	defer n.mut.RUnlock()
+
+	if n.ring == nil || n.lc == nil {
+		return 0, 1
+	}
+	rs, err := n.ring.GetAllHealthy(ring.Read)
+	if err != nil {
+		return 0, 1
+	}
+
+	addrs := rs.GetAddresses()
+	sort.Strings(addrs)
+	for i, addr := range addrs {
+		if addr == n.lc.Addr {
+			return i, len(addrs)
+		}
+	}
+	// The node isn't healthy yet.
+	return 0, 1
+}
+
 func keyHash(key string) uint32 {
 	h := fnv.New32()
 	_, _ = h.Write([]byte(key))
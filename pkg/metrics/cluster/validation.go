@@ -44,6 +44,20 @@ func validateNofiles(c *instance.Config) error {
 		}
 	}
 
+	if c.Rules != nil {
+		for i, am := range c.Rules.Alerting.AlertmanagerConfigs {
+			if err := validateHTTPNoFiles(&am.HTTPClientConfig); err != nil {
+				return fmt.Errorf("failed to validate alertmanager at index %d: %w", i, err)
+			}
+
+			for j, disc := range am.ServiceDiscoveryConfigs {
+				if err := validateDiscoveryNoFiles(disc); err != nil {
+					return fmt.Errorf("failed to validate service discovery at index %d within alertmanager at index %d: %w", j, i, err)
+				}
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -100,6 +100,19 @@ func Test_validateNoFiles(t *testing.T) {
 			`),
 			expect: fmt.Errorf("failed to validate scrape_config at index 0: password_file must be empty unless dangerous_allow_reading_files is set"),
 		},
+		{
+			name: "invalid alertmanager config",
+			input: util.Untab(`
+			rules:
+				alerting:
+					alertmanagers:
+					- static_configs:
+						- targets: ['localhost:9093']
+						tls_config:
+							ca_file: /etc/ca.pem
+			`),
+			expect: fmt.Errorf("failed to validate alertmanager at index 0: ca_file must be empty unless dangerous_allow_reading_files is set"),
+		},
 	}
 
 	for _, tc := range tt {
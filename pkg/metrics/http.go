@@ -1,17 +1,21 @@
 package metrics
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"sort"
+	"strconv"
 	"time"
 
 	"github.com/go-kit/log/level"
 	"github.com/gorilla/mux"
 	"github.com/grafana/agent/pkg/metrics/cluster/configapi"
+	"github.com/grafana/agent/pkg/metrics/instance"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/prometheus/prometheus/storage/remote"
 )
@@ -26,7 +30,11 @@ func (a *Agent) WireAPI(r *mux.Router) {
 
 	r.HandleFunc("/agent/api/v1/metrics/instances", a.ListInstancesHandler).Methods("GET")
 	r.HandleFunc("/agent/api/v1/metrics/targets", a.ListTargetsHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/metrics/targets/dropped", a.ListDroppedTargetsHandler).Methods("GET")
+	r.HandleFunc("/agent/api/v1/metrics/instance/{instance}/targets/scrape", a.ScrapeTargetHandler).Methods("GET")
 	r.HandleFunc("/agent/api/v1/metrics/instance/{instance}/write", a.PushMetricsHandler).Methods("POST")
+	r.HandleFunc("/agent/api/v1/metrics/instance/{instance}/otlp", a.PushOTLPMetricsHandler).Methods("POST")
+	r.HandleFunc("/agent/api/v1/metrics/instance/{instance}/otlp/v1/metrics", a.PushOTLPMetricsHandler).Methods("POST")
 }
 
 // ListInstancesHandler writes the set of currently running instances to the http.ResponseWriter.
@@ -49,14 +57,20 @@ func (a *Agent) ListInstancesHandler(w http.ResponseWriter, _ *http.Request) {
 func (a *Agent) ListTargetsHandler(w http.ResponseWriter, r *http.Request) {
 	instances := a.mm.ListInstances()
 	allTagets := make(map[string]TargetSet, len(instances))
+	offsets := make(map[*scrape.Target]time.Duration)
 	for instName, inst := range instances {
 		allTagets[instName] = inst.TargetsActive()
+		for t, offset := range inst.TargetOffsets() {
+			offsets[t] = offset
+		}
 	}
-	ListTargetsHandler(allTagets).ServeHTTP(w, r)
+	ListTargetsHandler(allTagets, offsets).ServeHTTP(w, r)
 }
 
-// ListTargetsHandler renders a mapping of instance to target set.
-func ListTargetsHandler(targets map[string]TargetSet) http.Handler {
+// ListTargetsHandler renders a mapping of instance to target set. offsets
+// holds the offsets within their scrape interval at which targets are
+// scraped and may be nil.
+func ListTargetsHandler(targets map[string]TargetSet, offsets map[*scrape.Target]time.Duration) http.Handler {
 	return http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
 		resp := ListTargetsResponse{}
 
@@ -68,6 +82,12 @@ func ListTargetsHandler(targets map[string]TargetSet) http.Handler {
 						lastError = scrapeError.Error()
 					}
 
+					var scrapeOffset *int64
+					if offset, ok := offsets[tgt]; ok {
+						ms := offset.Milliseconds()
+						scrapeOffset = &ms
+					}
+
 					resp = append(resp, TargetInfo{
 						InstanceName: instance,
 						TargetGroup:  key,
@@ -79,6 +99,7 @@ func ListTargetsHandler(targets map[string]TargetSet) http.Handler {
 						LastScrape:       tgt.LastScrape(),
 						ScrapeDuration:   tgt.LastScrapeDuration().Milliseconds(),
 						ScrapeError:      lastError,
+						ScrapeOffset:     scrapeOffset,
 					})
 				}
 			}
@@ -132,6 +153,165 @@ type TargetInfo struct {
 	LastScrape       time.Time     `json:"last_scrape"`
 	ScrapeDuration   int64         `json:"scrape_duration_ms"`
 	ScrapeError      string        `json:"scrape_error"`
+	// ScrapeOffset is the offset within the scrape interval at which the
+	// target is scraped. Unset when the offset isn't known.
+	ScrapeOffset *int64 `json:"scrape_offset_ms,omitempty"`
+}
+
+// ListDroppedTargetsHandler retrieves the set of targets dropped during
+// relabeling across all instances, along with the relabel rule which dropped
+// them.
+func (a *Agent) ListDroppedTargetsHandler(w http.ResponseWriter, _ *http.Request) {
+	resp := ListDroppedTargetsResponse{}
+
+	for instName, inst := range a.mm.ListInstances() {
+		for key, targets := range inst.TargetsDropped() {
+			for _, tgt := range targets {
+				info := DroppedTargetInfo{
+					InstanceName: instName,
+					TargetGroup:  key,
+
+					DiscoveredLabels: tgt.DiscoveredLabels,
+					RelabelIndex:     tgt.RelabelIndex,
+				}
+				if rc := tgt.RelabelConfig; rc != nil {
+					info.RelabelRule = newRelabelRuleInfo(rc)
+				}
+				resp = append(resp, info)
+			}
+		}
+	}
+
+	sort.SliceStable(resp, func(i, j int) bool {
+		switch {
+		case resp[i].InstanceName != resp[j].InstanceName:
+			return resp[i].InstanceName < resp[j].InstanceName
+		case resp[i].TargetGroup != resp[j].TargetGroup:
+			return resp[i].TargetGroup < resp[j].TargetGroup
+		default:
+			return labels.Compare(resp[i].DiscoveredLabels, resp[j].DiscoveredLabels) < 0
+		}
+	})
+
+	err := configapi.WriteResponse(w, http.StatusOK, resp)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+// ListDroppedTargetsResponse is returned by the ListDroppedTargetsHandler.
+type ListDroppedTargetsResponse []DroppedTargetInfo
+
+// DroppedTargetInfo describes a target dropped during relabeling.
+type DroppedTargetInfo struct {
+	InstanceName string `json:"instance"`
+	TargetGroup  string `json:"target_group"`
+
+	DiscoveredLabels labels.Labels    `json:"discovered_labels"`
+	RelabelIndex     int              `json:"relabel_rule_index"`
+	RelabelRule      *RelabelRuleInfo `json:"relabel_rule"`
+}
+
+// RelabelRuleInfo describes a relabel rule.
+type RelabelRuleInfo struct {
+	SourceLabels []string `json:"source_labels,omitempty"`
+	Separator    string   `json:"separator,omitempty"`
+	Regex        string   `json:"regex,omitempty"`
+	Modulus      uint64   `json:"modulus,omitempty"`
+	TargetLabel  string   `json:"target_label,omitempty"`
+	Replacement  string   `json:"replacement,omitempty"`
+	Action       string   `json:"action"`
+}
+
+func newRelabelRuleInfo(rc *relabel.Config) *RelabelRuleInfo {
+	info := &RelabelRuleInfo{
+		Separator:   rc.Separator,
+		Modulus:     rc.Modulus,
+		TargetLabel: rc.TargetLabel,
+		Replacement: rc.Replacement,
+		Action:      string(rc.Action),
+	}
+	for _, l := range rc.SourceLabels {
+		info.SourceLabels = append(info.SourceLabels, string(l))
+	}
+	// MarshalYAML returns the regex as it was written in the config, without
+	// the anchors added when compiling it.
+	if re, _ := rc.Regex.MarshalYAML(); re != nil {
+		info.Regex, _ = re.(string)
+	}
+	return info
+}
+
+// ScrapeTargetHandler scrapes one of an instance's active targets once and
+// returns the resulting samples after metric_relabel_configs has been applied.
+// The samples are not written to the WAL. The target is identified by the
+// job and endpoint query parameters, which match the target_group and
+// endpoint fields returned by ListTargetsHandler.
+func (a *Agent) ScrapeTargetHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName, err := getInstanceName(r)
+	if err != nil {
+		_ = configapi.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var (
+		job      = r.URL.Query().Get("job")
+		endpoint = r.URL.Query().Get("endpoint")
+	)
+	if job == "" || endpoint == "" {
+		_ = configapi.WriteError(w, http.StatusBadRequest, fmt.Errorf("job and endpoint must be provided"))
+		return
+	}
+
+	inst, err := a.mm.GetInstance(instanceName)
+	if err != nil {
+		_ = configapi.WriteError(w, http.StatusNotFound, err)
+		return
+	}
+
+	preview, err := inst.PreviewScrape(r.Context(), job, endpoint)
+	switch {
+	case errors.Is(err, instance.ErrTargetNotFound):
+		_ = configapi.WriteError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, instance.ErrNotReady):
+		_ = configapi.WriteError(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
+		_ = configapi.WriteError(w, http.StatusBadGateway, fmt.Errorf("failed to scrape target: %w", err))
+		return
+	}
+
+	resp := ScrapeTargetResponse{
+		Series:  make([]ScrapedSample, 0, len(preview.Series)),
+		Dropped: preview.Dropped,
+	}
+	for _, s := range preview.Series {
+		resp.Series = append(resp.Series, ScrapedSample{
+			Labels:    s.Labels,
+			Timestamp: s.Timestamp,
+			Value:     strconv.FormatFloat(s.Value, 'f', -1, 64),
+		})
+	}
+
+	err = configapi.WriteResponse(w, http.StatusOK, resp)
+	if err != nil {
+		level.Error(a.logger).Log("msg", "failed to write response", "err", err)
+	}
+}
+
+// ScrapeTargetResponse is returned by the ScrapeTargetHandler.
+type ScrapeTargetResponse struct {
+	Series  []ScrapedSample `json:"series"`
+	Dropped int             `json:"dropped_samples"`
+}
+
+// ScrapedSample is a sample returned by the ScrapeTargetHandler. Value is
+// encoded as a string so that special float values can be represented.
+type ScrapedSample struct {
+	Labels    labels.Labels `json:"labels"`
+	Timestamp int64         `json:"timestamp_ms"`
+	Value     string        `json:"value"`
 }
 
 // PushMetricsHandler provides a way to POST data directly into
@@ -155,6 +335,17 @@ func (a *Agent) PushMetricsHandler(w http.ResponseWriter, r *http.Request) {
 	handler.ServeHTTP(w, r)
 }
 
+// PushOTLPMetricsHandler accepts OTLP/HTTP metrics and writes them into an
+// instance's WAL.
+func (a *Agent) PushOTLPMetricsHandler(w http.ResponseWriter, r *http.Request) {
+	instanceName, err := getInstanceName(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	a.otlp.ServeHTTP(w, r, instanceName)
+}
+
 // getInstanceName uses gorilla/mux's route variables to extract the
 // "instance" variable. If not found, getInstanceName will return an error.
 func getInstanceName(r *http.Request) (string, error) {
@@ -13,8 +13,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
 )
 
 func TestAgent_ListInstancesHandler(t *testing.T) {
@@ -101,6 +103,9 @@ func TestAgent_ListTargetsHandler(t *testing.T) {
 					tgts: map[string][]*scrape.Target{
 						"group_a": {tgt},
 					},
+					offsets: map[*scrape.Target]time.Duration{
+						tgt: 1500 * time.Millisecond,
+					},
 				},
 			}
 		}
@@ -124,7 +129,8 @@ func TestAgent_ListTargetsHandler(t *testing.T) {
 				},
 				"last_scrape": "1994-01-12T00:00:00Z",
 				"scrape_duration_ms": 60000,
-				"scrape_error":"something went wrong"
+				"scrape_error":"something went wrong",
+				"scrape_offset_ms": 1500
 			}]
 		}`
 		require.JSONEq(t, expect, rr.Body.String())
@@ -134,9 +140,81 @@ func TestAgent_ListTargetsHandler(t *testing.T) {
 
 type mockInstanceScrape struct {
 	instance.NoOpInstance
-	tgts map[string][]*scrape.Target
+	tgts    map[string][]*scrape.Target
+	dropped map[string][]instance.DroppedTarget
+	offsets map[*scrape.Target]time.Duration
 }
 
 func (i *mockInstanceScrape) TargetsActive() map[string][]*scrape.Target {
 	return i.tgts
 }
+
+func (i *mockInstanceScrape) TargetsDropped() map[string][]instance.DroppedTarget {
+	return i.dropped
+}
+
+func (i *mockInstanceScrape) TargetOffsets() map[*scrape.Target]time.Duration {
+	return i.offsets
+}
+
+func TestAgent_ListDroppedTargetsHandler(t *testing.T) {
+	fact := newFakeInstanceFactory()
+	a, err := newAgent(prometheus.NewRegistry(), Config{
+		WALDir: "/tmp/agent",
+	}, log.NewNopLogger(), fact.factory)
+	require.NoError(t, err)
+
+	var rcs []*relabel.Config
+	err = yaml.Unmarshal([]byte(`
+- source_labels: [__meta_env]
+  regex: dev
+  action: drop
+`), &rcs)
+	require.NoError(t, err)
+
+	mockManager := &instance.MockManager{
+		ListInstancesFunc: func() map[string]instance.ManagedInstance {
+			return map[string]instance.ManagedInstance{
+				"test_instance": &mockInstanceScrape{
+					dropped: map[string][]instance.DroppedTarget{
+						"group_a": {{
+							DiscoveredLabels: labels.FromStrings("__meta_env", "dev"),
+							RelabelIndex:     0,
+							RelabelConfig:    rcs[0],
+						}},
+					},
+				},
+			}
+		},
+		ListConfigsFunc:  func() map[string]instance.Config { return nil },
+		ApplyConfigFunc:  func(_ instance.Config) error { return nil },
+		DeleteConfigFunc: func(name string) error { return nil },
+		StopFunc:         func() {},
+	}
+	a.mm, err = instance.NewModalManager(prometheus.NewRegistry(), a.logger, mockManager, instance.ModeDistinct)
+	require.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/agent/api/v1/metrics/targets/dropped", nil)
+	rr := httptest.NewRecorder()
+	a.ListDroppedTargetsHandler(rr, r)
+	expect := `{
+		"status": "success",
+		"data": [{
+			"instance": "test_instance",
+			"target_group": "group_a",
+			"discovered_labels": {
+				"__meta_env": "dev"
+			},
+			"relabel_rule_index": 0,
+			"relabel_rule": {
+				"source_labels": ["__meta_env"],
+				"separator": ";",
+				"regex": "dev",
+				"replacement": "$1",
+				"action": "drop"
+			}
+		}]
+	}`
+	require.JSONEq(t, expect, rr.Body.String())
+	require.Equal(t, http.StatusOK, rr.Result().StatusCode)
+}
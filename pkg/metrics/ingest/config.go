@@ -0,0 +1,152 @@
+// Package ingest implements listeners which accept metrics in the Graphite
+// plaintext and Influx line protocols and append them into metrics instances.
+package ingest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/prometheus/statsd_exporter/pkg/mapper"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds the listeners to run.
+type Config struct {
+	Graphite []*ListenerConfig `yaml:"graphite,omitempty"`
+	Influx   []*ListenerConfig `yaml:"influx,omitempty"`
+}
+
+// Validate returns an error if c is invalid.
+func (c *Config) Validate() error {
+	var (
+		names     = make(map[string]struct{})
+		addresses = make(map[string]string)
+	)
+
+	for _, nl := range c.namedListeners() {
+		if nl.cfg == nil {
+			return fmt.Errorf("empty or null %s listener", nl.format)
+		}
+		if err := nl.cfg.Validate(); err != nil {
+			return fmt.Errorf("%s listener %q: %w", nl.format, nl.cfg.Name, err)
+		}
+		if _, ok := names[nl.cfg.Name]; ok {
+			return fmt.Errorf("found multiple listeners with name %q", nl.cfg.Name)
+		}
+		names[nl.cfg.Name] = struct{}{}
+
+		// Graphite and Influx listeners both listen on TCP, so addresses must
+		// be unique across formats. Addresses with port 0 pick a random port.
+		if _, port, err := net.SplitHostPort(nl.cfg.ListenAddress); err == nil && port == "0" {
+			continue
+		}
+		if other, ok := addresses[nl.cfg.ListenAddress]; ok {
+			return fmt.Errorf("listeners %q and %q use the same listen_address %q", other, nl.cfg.Name, nl.cfg.ListenAddress)
+		}
+		addresses[nl.cfg.ListenAddress] = nl.cfg.Name
+	}
+	return nil
+}
+
+type namedListener struct {
+	format string
+	cfg    *ListenerConfig
+}
+
+// namedListeners returns all listeners of c along with their format.
+func (c *Config) namedListeners() []namedListener {
+	res := make([]namedListener, 0, len(c.Graphite)+len(c.Influx))
+	for _, lc := range c.Graphite {
+		res = append(res, namedListener{format: formatGraphite, cfg: lc})
+	}
+	for _, lc := range c.Influx {
+		res = append(res, namedListener{format: formatInflux, cfg: lc})
+	}
+	return res
+}
+
+// ListenerConfig configures a single Graphite or Influx listener.
+type ListenerConfig struct {
+	// Name of the listener, used in logs and metrics.
+	Name string `yaml:"name"`
+
+	// Address to listen on. Graphite listeners accept both TCP and UDP on this
+	// address, and Influx listeners accept HTTP.
+	ListenAddress string `yaml:"listen_address"`
+
+	// Name of the metrics instance to append samples into.
+	Instance string `yaml:"instance"`
+
+	// Mapping rules in the statsd_exporter mapping format, used to convert
+	// dot-separated metric names into Prometheus series.
+	MappingConfig *MappingConfig `yaml:"mapping_config,omitempty"`
+
+	// When true, metrics which don't match any mapping are dropped instead of
+	// being converted with the default rules.
+	StrictMatch bool `yaml:"strict_match,omitempty"`
+}
+
+// Validate returns an error if c is invalid.
+func (c *ListenerConfig) Validate() error {
+	switch {
+	case c.Name == "":
+		return errors.New("name must not be empty")
+	case c.ListenAddress == "":
+		return errors.New("listen_address must not be empty")
+	case c.Instance == "":
+		return errors.New("instance must not be empty")
+	}
+
+	if _, err := c.newMapper(); err != nil {
+		return fmt.Errorf("invalid mapping_config: %w", err)
+	}
+	return nil
+}
+
+// newMapper creates a mapper from the mapping config. nil is returned when
+// no mapping config is set.
+func (c *ListenerConfig) newMapper() (*mapper.MetricMapper, error) {
+	if c.MappingConfig == nil {
+		return nil, nil
+	}
+	return c.MappingConfig.newMapper()
+}
+
+// MappingConfig holds mapping rules in the statsd_exporter mapping format.
+// The rules are kept as YAML since mapper.MetricMapper can't be marshaled
+// back into a config it accepts.
+type MappingConfig struct {
+	raw string
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *MappingConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw yaml.MapSlice
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	bb, err := yaml.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	c.raw = string(bb)
+
+	_, err = c.newMapper()
+	return err
+}
+
+// MarshalYAML implements yaml.Marshaler.
+func (c MappingConfig) MarshalYAML() (interface{}, error) {
+	var raw yaml.MapSlice
+	err := yaml.Unmarshal([]byte(c.raw), &raw)
+	return raw, err
+}
+
+func (c *MappingConfig) newMapper() (*mapper.MetricMapper, error) {
+	var m mapper.MetricMapper
+	if err := m.InitFromYAMLString(c.raw); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
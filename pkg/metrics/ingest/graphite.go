@@ -0,0 +1,263 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+)
+
+// maxBatchSize is the maximum number of samples appended in a single commit.
+const maxBatchSize = 1000
+
+// maxGraphiteLineSize is the maximum length in bytes of a Graphite line
+// received over TCP, matching the maximum size of UDP packets. Longer lines
+// are dropped.
+const maxGraphiteLineSize = 65535
+
+// graphiteListener accepts Graphite plaintext protocol lines over TCP and
+// UDP. Each line has the form:
+//
+//	<path>[;<tag>=<value>...] <value> <timestamp>
+type graphiteListener struct {
+	writerHolder
+
+	tcp net.Listener
+	udp net.PacketConn
+	wg  sync.WaitGroup
+
+	connsMut sync.Mutex
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+func newGraphiteListener(w *writer, addr string) (*graphiteListener, error) {
+	tcp, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udp, err := net.ListenPacket("udp", tcp.Addr().String())
+	if err != nil {
+		_ = tcp.Close()
+		return nil, err
+	}
+
+	l := &graphiteListener{
+		tcp:   tcp,
+		udp:   udp,
+		conns: make(map[net.Conn]struct{}),
+	}
+	l.SetWriter(w)
+
+	l.wg.Add(2)
+	go l.acceptTCP()
+	go l.readUDP()
+	return l, nil
+}
+
+// Addr returns the address the listener accepts TCP connections and UDP
+// packets on.
+func (l *graphiteListener) Addr() net.Addr { return l.tcp.Addr() }
+
+// Stop closes the listener and all open connections.
+func (l *graphiteListener) Stop() {
+	l.connsMut.Lock()
+	l.closed = true
+	for conn := range l.conns {
+		_ = conn.Close()
+	}
+	l.connsMut.Unlock()
+
+	_ = l.tcp.Close()
+	_ = l.udp.Close()
+	l.wg.Wait()
+}
+
+func (l *graphiteListener) acceptTCP() {
+	defer l.wg.Done()
+
+	for {
+		conn, err := l.tcp.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				level.Error(l.writer().logger).Log("msg", "failed to accept connection", "err", err)
+			}
+			return
+		}
+
+		l.connsMut.Lock()
+		if l.closed {
+			l.connsMut.Unlock()
+			_ = conn.Close()
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.connsMut.Unlock()
+
+		l.wg.Add(1)
+		go l.handleConn(conn)
+	}
+}
+
+func (l *graphiteListener) handleConn(conn net.Conn) {
+	defer l.wg.Done()
+	defer func() {
+		l.connsMut.Lock()
+		delete(l.conns, conn)
+		l.connsMut.Unlock()
+		_ = conn.Close()
+	}()
+
+	var (
+		r     = bufio.NewReaderSize(conn, maxGraphiteLineSize)
+		batch = make([]sample, 0, maxBatchSize)
+
+		// skip is set while discarding the rest of a line which is too long.
+		skip bool
+	)
+	for {
+		line, err := r.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			if !skip {
+				w := l.writer()
+				w.parseErrors.Inc()
+				level.Debug(w.logger).Log("msg", "dropped line longer than the maximum line size", "remote", conn.RemoteAddr(), "max_size", maxGraphiteLineSize)
+			}
+			skip = true
+			continue
+		}
+
+		if skip {
+			skip = false
+		} else if len(line) > 0 {
+			batch = l.appendLine(batch, string(line))
+		}
+
+		// Flush before blocking on the next read so samples aren't held back
+		// while the client is idle.
+		if len(batch) >= maxBatchSize || r.Buffered() == 0 || err != nil {
+			l.flush(batch)
+			batch = batch[:0]
+		}
+
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				level.Debug(l.writer().logger).Log("msg", "failed to read from connection", "remote", conn.RemoteAddr(), "err", err)
+			}
+			return
+		}
+	}
+}
+
+func (l *graphiteListener) readUDP() {
+	defer l.wg.Done()
+
+	buf := make([]byte, 65535)
+	for {
+		n, _, err := l.udp.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				level.Error(l.writer().logger).Log("msg", "failed to read packet", "err", err)
+			}
+			return
+		}
+
+		var batch []sample
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			batch = l.appendLine(batch, line)
+		}
+		l.flush(batch)
+	}
+}
+
+// appendLine parses line and appends the resulting sample to batch.
+func (l *graphiteListener) appendLine(batch []sample, line string) []sample {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return batch
+	}
+
+	s, err := parseGraphiteLine(line, time.Now())
+	if err != nil {
+		w := l.writer()
+		w.parseErrors.Inc()
+		level.Debug(w.logger).Log("msg", "failed to parse line", "line", line, "err", err)
+		return batch
+	}
+	return append(batch, s)
+}
+
+func (l *graphiteListener) flush(batch []sample) {
+	w := l.writer()
+	if err := w.write(context.Background(), batch); err != nil {
+		level.Error(w.logger).Log("msg", "failed to write samples", "err", err)
+	}
+}
+
+// parseGraphiteLine parses a line of the Graphite plaintext protocol.
+// Timestamps are in seconds; a timestamp of -1 or a missing timestamp is
+// replaced by now.
+func parseGraphiteLine(line string, now time.Time) (sample, error) {
+	parts := strings.Fields(line)
+	if len(parts) != 2 && len(parts) != 3 {
+		return sample{}, fmt.Errorf("expected 2 or 3 fields, got %d", len(parts))
+	}
+
+	path, tags, err := parseGraphitePath(parts[0])
+	if err != nil {
+		return sample{}, err
+	}
+
+	v, err := strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return sample{}, fmt.Errorf("invalid value %q: %w", parts[1], err)
+	}
+
+	t := now.UnixNano() / int64(time.Millisecond)
+	if len(parts) == 3 && parts[2] != "-1" {
+		sec, err := strconv.ParseFloat(parts[2], 64)
+		if err != nil || math.IsNaN(sec) || math.IsInf(sec, 0) {
+			return sample{}, fmt.Errorf("invalid timestamp %q", parts[2])
+		}
+		t = int64(sec * 1000)
+	}
+
+	return sample{
+		name:        path,
+		defaultName: path,
+		tags:        tags,
+		t:           t,
+		v:           v,
+	}, nil
+}
+
+// parseGraphitePath splits a path with optional Graphite tags into the path
+// and its tags.
+func parseGraphitePath(s string) (path string, tags map[string]string, err error) {
+	parts := strings.Split(s, ";")
+	if parts[0] == "" {
+		return "", nil, errors.New("empty metric path")
+	}
+	if len(parts) == 1 {
+		return parts[0], nil, nil
+	}
+
+	tags = make(map[string]string, len(parts)-1)
+	for _, tag := range parts[1:] {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return "", nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		tags[kv[0]] = kv[1]
+	}
+	return parts[0], tags, nil
+}
@@ -0,0 +1,118 @@
+package ingest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGraphiteLine(t *testing.T) {
+	now := time.Unix(100, 0)
+
+	tt := []struct {
+		line   string
+		expect sample
+		err    string
+	}{
+		{
+			line:   "servers.a.cpu 0.5 1650000000",
+			expect: sample{name: "servers.a.cpu", defaultName: "servers.a.cpu", t: 1650000000000, v: 0.5},
+		},
+		{
+			line:   "servers.a.cpu 0.5 -1",
+			expect: sample{name: "servers.a.cpu", defaultName: "servers.a.cpu", t: 100000, v: 0.5},
+		},
+		{
+			line:   "servers.a.cpu 0.5",
+			expect: sample{name: "servers.a.cpu", defaultName: "servers.a.cpu", t: 100000, v: 0.5},
+		},
+		{
+			line: "disk.used;dc=us;rack=a1 10 1650000000.5",
+			expect: sample{
+				name:        "disk.used",
+				defaultName: "disk.used",
+				tags:        map[string]string{"dc": "us", "rack": "a1"},
+				t:           1650000000500,
+				v:           10,
+			},
+		},
+		{line: "servers.a.cpu", err: "expected 2 or 3 fields, got 1"},
+		{line: "servers.a.cpu abc 1", err: `invalid value "abc"`},
+		{line: "servers.a.cpu 1 abc", err: `invalid timestamp "abc"`},
+		{line: "disk.used;dc 1 1", err: `invalid tag "dc"`},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.line, func(t *testing.T) {
+			s, err := parseGraphiteLine(tc.line, now)
+			if tc.err != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tc.expect, s)
+		})
+	}
+}
+
+func TestGraphiteListener(t *testing.T) {
+	app := &collectingAppender{}
+	m := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), testInstances(map[string]storage.Appender{"default": app}))
+	w, err := m.newWriter(&ListenerConfig{Name: "test", ListenAddress: "127.0.0.1:0", Instance: "default"}, formatGraphite)
+	require.NoError(t, err)
+
+	l, err := newGraphiteListener(w, "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Stop()
+
+	t.Run("tcp", func(t *testing.T) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = fmt.Fprint(conn, "tcp.metric 1 1650000000\ninvalid\ntcp.metric;tag=a 2 1650000000\n")
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			return len(app.Series()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, []string{`{__name__="tcp_metric"}`, `{__name__="tcp_metric", tag="a"}`}, app.Series())
+	})
+
+	t.Run("udp", func(t *testing.T) {
+		conn, err := net.Dial("udp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = fmt.Fprint(conn, "udp.metric 3 1650000000\n")
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			return len(app.Series()) == 3
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, `{__name__="udp_metric"}`, app.Series()[2])
+	})
+
+	t.Run("long line", func(t *testing.T) {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+
+		long := strings.Repeat("a", 2*maxGraphiteLineSize)
+		_, err = fmt.Fprintf(conn, "%s 1 1650000000\nafter.long 4 1650000000\n", long)
+		require.NoError(t, err)
+
+		require.Eventually(t, func() bool {
+			return len(app.Series()) == 4
+		}, 5*time.Second, 10*time.Millisecond)
+		require.Equal(t, `{__name__="after_long"}`, app.Series()[3])
+	})
+}
@@ -0,0 +1,374 @@
+package ingest
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/gorilla/mux"
+)
+
+// maxInfluxRequestSize is the maximum size in bytes of Influx write request
+// bodies. The limit applies both to the body as sent and to the decompressed
+// body of gzip compressed requests.
+const maxInfluxRequestSize = 32 << 20
+
+// errRequestTooLarge is returned when reading request bodies larger than
+// maxInfluxRequestSize.
+var errRequestTooLarge = fmt.Errorf("request body larger than %d bytes", maxInfluxRequestSize)
+
+// influxListener accepts Influx line protocol over HTTP, using the write
+// endpoints of InfluxDB 1.x (/write) and 2.x (/api/v2/write).
+type influxListener struct {
+	writerHolder
+
+	lis net.Listener
+	srv *http.Server
+}
+
+func newInfluxListener(w *writer, addr string) (*influxListener, error) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &influxListener{lis: lis}
+	l.SetWriter(w)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/write", l.handleWrite).Methods("POST")
+	r.HandleFunc("/api/v2/write", l.handleWrite).Methods("POST")
+	r.HandleFunc("/ping", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("GET", "HEAD")
+	l.srv = &http.Server{Handler: r}
+
+	go func() {
+		if err := l.srv.Serve(lis); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			level.Error(w.logger).Log("msg", "influx listener stopped", "err", err)
+		}
+	}()
+	return l, nil
+}
+
+// Addr returns the address the listener accepts HTTP requests on.
+func (l *influxListener) Addr() net.Addr { return l.lis.Addr() }
+
+// Stop stops the HTTP server, waiting for in-flight requests to finish.
+func (l *influxListener) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = l.srv.Shutdown(ctx)
+}
+
+// handleWrite appends the lines of a write request. Samples are written in
+// batches of up to maxBatchSize samples while the body is read, so batches
+// written before an error stay written.
+func (l *influxListener) handleWrite(w http.ResponseWriter, r *http.Request) {
+	wr := l.writer()
+
+	precision, err := influxPrecision(r.URL.Query().Get("precision"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.ContentLength > maxInfluxRequestSize {
+		http.Error(w, errRequestTooLarge.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// The size of the body is checked by limitReader rather than through the
+	// error of http.MaxBytesReader, which has no type until Go 1.19.
+	// MaxBytesReader still closes the connection once the limit is exceeded.
+	var body io.Reader = &limitReader{r: http.MaxBytesReader(w, r.Body, maxInfluxRequestSize+1), n: maxInfluxRequestSize}
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gr, err := gzip.NewReader(body)
+		switch {
+		case errors.Is(err, errRequestTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			http.Error(w, fmt.Sprintf("invalid gzip body: %s", err), http.StatusBadRequest)
+			return
+		}
+		defer gr.Close()
+		body = &limitReader{r: gr, n: maxInfluxRequestSize}
+	}
+
+	var (
+		now      = time.Now()
+		samples  = make([]sample, 0, maxBatchSize)
+		parseErr error
+	)
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		points, err := parseInfluxLine(line, precision, now)
+		if err != nil {
+			wr.parseErrors.Inc()
+			level.Debug(wr.logger).Log("msg", "failed to parse line", "line", line, "err", err)
+			if parseErr == nil {
+				parseErr = fmt.Errorf("unable to parse %q: %w", line, err)
+			}
+			continue
+		}
+		for _, p := range points {
+			if p.unsupported {
+				wr.samplesDropped[reasonUnsupported].Inc()
+				continue
+			}
+			samples = append(samples, p.sample)
+		}
+
+		if len(samples) >= maxBatchSize {
+			if err := wr.write(r.Context(), samples); err != nil {
+				writeError(w, err)
+				return
+			}
+			samples = samples[:0]
+		}
+	}
+	switch err := sc.Err(); {
+	case errors.Is(err, errRequestTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Valid lines are written even if other lines failed to parse, matching
+	// the behavior of InfluxDB.
+	if err := wr.write(r.Context(), samples); err != nil {
+		writeError(w, err)
+		return
+	}
+	if parseErr != nil {
+		http.Error(w, parseErr.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeError responds to a request whose samples couldn't be written.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, errUnknownInstance) {
+		status = http.StatusNotFound
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// limitReader reads up to n bytes from r. Reading more than n bytes fails
+// with errRequestTooLarge.
+type limitReader struct {
+	r io.Reader
+	n int64
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	if lr.n < 0 {
+		return 0, errRequestTooLarge
+	}
+	if int64(len(p)) > lr.n+1 {
+		p = p[:lr.n+1]
+	}
+	n, err := lr.r.Read(p)
+	if int64(n) > lr.n {
+		n, lr.n = int(lr.n), -1
+		return n, errRequestTooLarge
+	}
+	lr.n -= int64(n)
+	return n, err
+}
+
+// influxPrecision returns the duration of a timestamp unit for the given
+// precision query parameter. Timestamps default to nanoseconds.
+func influxPrecision(p string) (time.Duration, error) {
+	switch p {
+	case "", "n", "ns":
+		return time.Nanosecond, nil
+	case "u", "us":
+		return time.Microsecond, nil
+	case "ms":
+		return time.Millisecond, nil
+	case "s":
+		return time.Second, nil
+	case "m":
+		return time.Minute, nil
+	case "h":
+		return time.Hour, nil
+	default:
+		return 0, fmt.Errorf("invalid precision %q", p)
+	}
+}
+
+// influxPoint is a sample parsed from a single field of a line.
+type influxPoint struct {
+	sample
+
+	// unsupported is set for fields with string values, which can't be
+	// converted into samples.
+	unsupported bool
+}
+
+// parseInfluxLine parses a line of the Influx line protocol:
+//
+//	<measurement>[,<tag>=<value>...] <field>=<value>[,<field>=<value>...] [<timestamp>]
+//
+// A sample is returned for every field. Mappings are matched against
+// <measurement>.<field>, and the default name is <measurement>_<field>, or
+// just <measurement> for fields named "value".
+func parseInfluxLine(line string, precision time.Duration, now time.Time) ([]influxPoint, error) {
+	sections := splitUnescaped(line, ' ', true)
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, errors.New("expected measurement, fields and optional timestamp")
+	}
+
+	key := splitUnescaped(sections[0], ',', false)
+	measurement := unescapeInflux(key[0])
+	if measurement == "" {
+		return nil, errors.New("empty measurement")
+	}
+
+	var tags map[string]string
+	if len(key) > 1 {
+		tags = make(map[string]string, len(key)-1)
+		for _, tag := range key[1:] {
+			kv := splitUnescaped(tag, '=', false)
+			if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+				return nil, fmt.Errorf("invalid tag %q", tag)
+			}
+			tags[unescapeInflux(kv[0])] = unescapeInflux(kv[1])
+		}
+	}
+
+	t := now.UnixNano() / int64(time.Millisecond)
+	if len(sections) == 3 {
+		ts, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", sections[2])
+		}
+		if precision >= time.Millisecond {
+			t = ts * int64(precision/time.Millisecond)
+		} else {
+			t = ts / int64(time.Millisecond/precision)
+		}
+	}
+
+	fields := splitUnescaped(sections[1], ',', true)
+	points := make([]influxPoint, 0, len(fields))
+	for _, field := range fields {
+		kv := splitUnescaped(field, '=', true)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid field %q", field)
+		}
+		name := unescapeInflux(kv[0])
+
+		v, ok, err := parseInfluxValue(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", name, err)
+		}
+
+		defaultName := measurement + "_" + name
+		if name == "value" {
+			defaultName = measurement
+		}
+		points = append(points, influxPoint{
+			sample: sample{
+				name:        measurement + "." + name,
+				defaultName: defaultName,
+				tags:        tags,
+				t:           t,
+				v:           v,
+			},
+			unsupported: !ok,
+		})
+	}
+	return points, nil
+}
+
+// parseInfluxValue parses a field value. ok is false for string values.
+func parseInfluxValue(s string) (v float64, ok bool, err error) {
+	switch {
+	case strings.HasPrefix(s, `"`):
+		if len(s) < 2 || !strings.HasSuffix(s, `"`) {
+			return 0, false, errors.New("unterminated string")
+		}
+		return 0, false, nil
+	case strings.HasSuffix(s, "i"):
+		n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+		return float64(n), err == nil, err
+	case strings.HasSuffix(s, "u"):
+		n, err := strconv.ParseUint(s[:len(s)-1], 10, 64)
+		return float64(n), err == nil, err
+	}
+
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return 1, true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return 0, true, nil
+	}
+
+	v, err = strconv.ParseFloat(s, 64)
+	return v, err == nil, err
+}
+
+// splitUnescaped splits s on sep, ignoring separators escaped with a
+// backslash. When quotes is true, separators inside double-quoted strings
+// are also ignored.
+func splitUnescaped(s string, sep byte, quotes bool) []string {
+	var (
+		res      []string
+		start    int
+		escaped  bool
+		inQuotes bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case quotes && c == '"':
+			inQuotes = !inQuotes
+		case c == sep && !inQuotes:
+			res = append(res, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(res, s[start:])
+}
+
+// unescapeInflux removes backslash escapes from s.
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		sb.WriteByte(s[i])
+	}
+	return sb.String()
+}
@@ -0,0 +1,159 @@
+package ingest
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInfluxLine(t *testing.T) {
+	now := time.Unix(100, 0)
+
+	t.Run("fields and tags", func(t *testing.T) {
+		points, err := parseInfluxLine(`cpu,host=a,region=us\ east usage=0.5,count=3i,ok=t,msg="a b, c=d" 1650000000000000000`, time.Nanosecond, now)
+		require.NoError(t, err)
+
+		tags := map[string]string{"host": "a", "region": "us east"}
+		require.Equal(t, []influxPoint{
+			{sample: sample{name: "cpu.usage", defaultName: "cpu_usage", tags: tags, t: 1650000000000, v: 0.5}},
+			{sample: sample{name: "cpu.count", defaultName: "cpu_count", tags: tags, t: 1650000000000, v: 3}},
+			{sample: sample{name: "cpu.ok", defaultName: "cpu_ok", tags: tags, t: 1650000000000, v: 1}},
+			{sample: sample{name: "cpu.msg", defaultName: "cpu_msg", tags: tags, t: 1650000000000}, unsupported: true},
+		}, points)
+	})
+
+	t.Run("value field and precision", func(t *testing.T) {
+		points, err := parseInfluxLine(`temperature value=21.5 1650000000`, time.Second, now)
+		require.NoError(t, err)
+		require.Equal(t, []influxPoint{
+			{sample: sample{name: "temperature.value", defaultName: "temperature", t: 1650000000000, v: 21.5}},
+		}, points)
+	})
+
+	t.Run("no timestamp", func(t *testing.T) {
+		points, err := parseInfluxLine(`temperature value=21.5`, time.Nanosecond, now)
+		require.NoError(t, err)
+		require.Equal(t, int64(100000), points[0].t)
+	})
+
+	for _, tc := range []struct{ line, err string }{
+		{`cpu`, "expected measurement, fields and optional timestamp"},
+		{`cpu,host usage=1`, `invalid tag "host"`},
+		{`cpu usage`, `invalid field "usage"`},
+		{`cpu usage=abc`, `field "usage"`},
+		{`cpu usage=1 abc`, `invalid timestamp "abc"`},
+	} {
+		t.Run(tc.line, func(t *testing.T) {
+			_, err := parseInfluxLine(tc.line, time.Nanosecond, now)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		})
+	}
+}
+
+func TestInfluxListener(t *testing.T) {
+	app := &collectingAppender{}
+	m := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), testInstances(map[string]storage.Appender{"default": app}))
+	w, err := m.newWriter(&ListenerConfig{Name: "test", ListenAddress: "127.0.0.1:0", Instance: "default"}, formatInflux)
+	require.NoError(t, err)
+
+	l, err := newInfluxListener(w, "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Stop()
+
+	url := "http://" + l.Addr().String()
+
+	resp, err := http.Get(url + "/ping")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	resp, err = http.Post(url+"/write?precision=s", "text/plain", strings.NewReader("cpu,host=a usage=1 1650000000\n"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	// Valid lines are written even when other lines are invalid.
+	resp, err = http.Post(url+"/api/v2/write?precision=s", "text/plain", strings.NewReader("invalid\nmem,host=a used=2 1650000000\n"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	require.Equal(t, []string{`{__name__="cpu_usage", host="a"}`, `{__name__="mem_used", host="a"}`}, app.Series())
+	require.Equal(t, int64(1650000000000), app.Samples()[0].t)
+}
+
+func TestInfluxListener_Limits(t *testing.T) {
+	app := &collectingAppender{}
+	m := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), testInstances(map[string]storage.Appender{"default": app}))
+	w, err := m.newWriter(&ListenerConfig{Name: "test", ListenAddress: "127.0.0.1:0", Instance: "default"}, formatInflux)
+	require.NoError(t, err)
+
+	l, err := newInfluxListener(w, "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Stop()
+
+	url := "http://" + l.Addr().String() + "/write?precision=s"
+
+	t.Run("batches", func(t *testing.T) {
+		var sb strings.Builder
+		for i := 0; i < 2*maxBatchSize+1; i++ {
+			fmt.Fprintf(&sb, "cpu,core=%d usage=1 1650000000\n", i)
+		}
+
+		resp, err := http.Post(url, "text/plain", strings.NewReader(sb.String()))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusNoContent, resp.StatusCode)
+		require.Len(t, app.Samples(), 2*maxBatchSize+1)
+		require.Equal(t, 3, app.Commits())
+	})
+
+	// Comment lines keep the body cheap to handle until the limit is hit.
+	comment := "# " + strings.Repeat("a", 1021) + "\n"
+	large := bytes.Repeat([]byte(comment), maxInfluxRequestSize/len(comment)+1)
+	require.Greater(t, len(large), maxInfluxRequestSize)
+
+	t.Run("content length", func(t *testing.T) {
+		resp, err := http.Post(url, "text/plain", bytes.NewReader(large))
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+
+	t.Run("unknown length", func(t *testing.T) {
+		// Hide the length of the body so it's sent chunked.
+		body := struct{ io.Reader }{bytes.NewReader(large)}
+
+		resp, err := http.Post(url, "text/plain", body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+
+	t.Run("decompressed", func(t *testing.T) {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		_, err := gw.Write(large)
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+
+		req, err := http.NewRequest("POST", url, &buf)
+		require.NoError(t, err)
+		req.Header.Set("Content-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	})
+}
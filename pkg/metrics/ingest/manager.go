@@ -0,0 +1,401 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/statsd_exporter/pkg/mapper"
+)
+
+const (
+	formatGraphite = "graphite"
+	formatInflux   = "influx"
+)
+
+// Reasons samples are dropped, used as the reason label of the dropped
+// samples metric.
+const (
+	reasonMappingDrop     = "mapping_drop"
+	reasonUnmatched       = "unmatched"
+	reasonUnsupported     = "unsupported_value"
+	reasonUnknownInstance = "unknown_instance"
+	reasonAppendFailed    = "append_failed"
+)
+
+var dropReasons = []string{reasonMappingDrop, reasonUnmatched, reasonUnsupported, reasonUnknownInstance, reasonAppendFailed}
+
+// GetInstanceFunc returns the storage of the instance with the given name.
+type GetInstanceFunc func(name string) (storage.Appendable, error)
+
+// Manager runs the listeners from a Config.
+type Manager struct {
+	logger      log.Logger
+	metrics     *metrics
+	getInstance GetInstanceFunc
+
+	mut       sync.Mutex
+	cfg       Config
+	listeners map[string]listener // Running listeners by listenerKey.
+}
+
+// listener is a running Graphite or Influx listener.
+type listener interface {
+	// SetWriter replaces the writer used for received samples.
+	SetWriter(w *writer)
+	Stop()
+}
+
+// writerHolder holds the writer of a listener. The writer is replaced when
+// the config of a listener changes but its address doesn't, so the listener
+// keeps running.
+type writerHolder struct {
+	mut sync.RWMutex
+	w   *writer
+}
+
+// SetWriter implements listener.
+func (h *writerHolder) SetWriter(w *writer) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.w = w
+}
+
+func (h *writerHolder) writer() *writer {
+	h.mut.RLock()
+	defer h.mut.RUnlock()
+	return h.w
+}
+
+// NewManager creates a new Manager. No listeners run until ApplyConfig is
+// called.
+func NewManager(l log.Logger, reg prometheus.Registerer, getInstance GetInstanceFunc) *Manager {
+	return &Manager{
+		logger:      l,
+		metrics:     newMetrics(reg),
+		getInstance: getInstance,
+		listeners:   make(map[string]listener),
+	}
+}
+
+// ApplyConfig applies a new config to the Manager. Listeners whose format
+// and address didn't change keep running with their new settings. Listeners
+// for new addresses are started before other listeners are stopped, so the
+// running listeners are left untouched if any of them fails to start.
+func (m *Manager) ApplyConfig(c Config) error {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	if util.CompareYAML(m.cfg, c) {
+		return nil
+	}
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	var (
+		listeners = make(map[string]listener, len(m.listeners))
+		writers   = make(map[string]*writer, len(m.listeners))
+		started   []listener
+	)
+	for _, nl := range c.namedListeners() {
+		w, err := m.newWriter(nl.cfg, nl.format)
+		if err != nil {
+			stopAll(started)
+			return err
+		}
+
+		key := listenerKey(nl.format, nl.cfg.ListenAddress)
+		if l, ok := m.listeners[key]; ok {
+			listeners[key], writers[key] = l, w
+			continue
+		}
+
+		var l listener
+		switch nl.format {
+		case formatGraphite:
+			l, err = newGraphiteListener(w, nl.cfg.ListenAddress)
+		case formatInflux:
+			l, err = newInfluxListener(w, nl.cfg.ListenAddress)
+		}
+		if err != nil {
+			stopAll(started)
+			return fmt.Errorf("failed to start %s listener %q: %w", nl.format, nl.cfg.Name, err)
+		}
+		listeners[key] = l
+		started = append(started, l)
+	}
+
+	for key, w := range writers {
+		listeners[key].SetWriter(w)
+	}
+	for key, l := range m.listeners {
+		if _, ok := listeners[key]; !ok {
+			l.Stop()
+		}
+	}
+
+	// Remove the metrics of listeners which no longer exist.
+	keep := make(map[string]struct{}, len(listeners))
+	for _, nl := range c.namedListeners() {
+		keep[nl.format+"/"+nl.cfg.Name] = struct{}{}
+	}
+	for _, nl := range m.cfg.namedListeners() {
+		if _, ok := keep[nl.format+"/"+nl.cfg.Name]; !ok {
+			m.metrics.deleteListener(nl.cfg.Name, nl.format)
+		}
+	}
+
+	m.listeners = listeners
+	m.cfg = c
+	return nil
+}
+
+// Stop stops all listeners.
+func (m *Manager) Stop() {
+	m.mut.Lock()
+	defer m.mut.Unlock()
+
+	for _, l := range m.listeners {
+		l.Stop()
+	}
+	m.listeners = make(map[string]listener)
+	m.cfg = Config{}
+}
+
+// listenerKey identifies the listener of the given format running on addr.
+func listenerKey(format, addr string) string {
+	return format + "/" + addr
+}
+
+func stopAll(listeners []listener) {
+	for _, l := range listeners {
+		l.Stop()
+	}
+}
+
+func (m *Manager) newWriter(lc *ListenerConfig, format string) (*writer, error) {
+	mm, err := lc.newMapper()
+	if err != nil {
+		return nil, fmt.Errorf("%s listener %q: invalid mapping_config: %w", format, lc.Name, err)
+	}
+
+	var (
+		name    = lc.Name
+		dropped = make(map[string]prometheus.Counter, len(dropReasons))
+	)
+	for _, reason := range dropReasons {
+		dropped[reason] = m.metrics.samplesDropped.WithLabelValues(name, format, reason)
+	}
+
+	return &writer{
+		logger:      log.With(m.logger, "listener", name, "format", format),
+		cfg:         lc,
+		mapper:      mm,
+		getInstance: m.getInstance,
+
+		samplesAppended: m.metrics.samplesAppended.WithLabelValues(name, format),
+		parseErrors:     m.metrics.parseErrors.WithLabelValues(name, format),
+		samplesDropped:  dropped,
+	}, nil
+}
+
+// writer maps parsed metrics into Prometheus series and appends them into
+// the configured instance.
+type writer struct {
+	logger      log.Logger
+	cfg         *ListenerConfig
+	mapper      *mapper.MetricMapper
+	getInstance GetInstanceFunc
+
+	samplesAppended prometheus.Counter
+	parseErrors     prometheus.Counter
+	samplesDropped  map[string]prometheus.Counter
+}
+
+// sample is a parsed sample which hasn't been mapped yet.
+type sample struct {
+	// Dot-separated name matched against mappings.
+	name string
+	// Name used when no mapping matches. Sanitized by mapName.
+	defaultName string
+	tags        map[string]string
+	t           int64
+	v           float64
+}
+
+// mapName returns the labels for s. ok is false if s should be dropped.
+func (w *writer) mapName(s sample) (lbls labels.Labels, ok bool) {
+	var (
+		name          = s.defaultName
+		mappingLabels map[string]string
+		matched       bool
+	)
+	if w.mapper != nil {
+		var mapping *mapper.MetricMapping
+		mapping, mappingLabels, matched = w.getMapping(s.name)
+		if matched && mapping.Action == mapper.ActionTypeDrop {
+			w.samplesDropped[reasonMappingDrop].Inc()
+			return nil, false
+		} else if matched {
+			name = mapping.Name
+		}
+	}
+	if !matched && w.cfg.StrictMatch {
+		w.samplesDropped[reasonUnmatched].Inc()
+		return nil, false
+	}
+
+	b := labels.NewBuilder(nil)
+	for k, v := range s.tags {
+		b.Set(escapeLabelName(k), v)
+	}
+	// Labels from mappings take precedence over tags.
+	for k, v := range mappingLabels {
+		b.Set(k, v)
+	}
+	b.Set(model.MetricNameLabel, escapeMetricName(name))
+	return b.Labels(), true
+}
+
+// mappingTypes are the metric types mappings are looked up with, in order.
+// Graphite and Influx metrics carry no type, so mappings limited to any
+// match_metric_type can match them.
+var mappingTypes = []mapper.MetricType{mapper.MetricTypeGauge, mapper.MetricTypeCounter, mapper.MetricTypeObserver}
+
+// getMapping returns the mapping for the metric with the given name.
+func (w *writer) getMapping(name string) (*mapper.MetricMapping, prometheus.Labels, bool) {
+	for _, ty := range mappingTypes {
+		if mapping, lbls, ok := w.mapper.GetMapping(name, ty); ok {
+			return mapping, lbls, true
+		}
+	}
+	return nil, nil, false
+}
+
+var errUnknownInstance = errors.New("unknown instance")
+
+// write maps and appends samples into the configured instance.
+func (w *writer) write(ctx context.Context, samples []sample) error {
+	if len(samples) == 0 {
+		return nil
+	}
+
+	inst, err := w.getInstance(w.cfg.Instance)
+	if err != nil || inst == nil {
+		w.samplesDropped[reasonUnknownInstance].Add(float64(len(samples)))
+		return fmt.Errorf("%w %q", errUnknownInstance, w.cfg.Instance)
+	}
+
+	var (
+		app      = inst.Appender(ctx)
+		appended int
+	)
+	for _, s := range samples {
+		lbls, ok := w.mapName(s)
+		if !ok {
+			continue
+		}
+		if _, err := app.Append(0, lbls, s.t, s.v); err != nil {
+			level.Debug(w.logger).Log("msg", "failed to append sample", "series", lbls, "err", err)
+			w.samplesDropped[reasonAppendFailed].Inc()
+			continue
+		}
+		appended++
+	}
+
+	if err := app.Commit(); err != nil {
+		w.samplesDropped[reasonAppendFailed].Add(float64(appended))
+		return fmt.Errorf("failed to commit samples: %w", err)
+	}
+	w.samplesAppended.Add(float64(appended))
+	return nil
+}
+
+// escapeMetricName converts name into a valid metric name by replacing
+// invalid characters with underscores.
+func escapeMetricName(name string) string {
+	return escape(name, true)
+}
+
+// escapeLabelName converts name into a valid label name by replacing invalid
+// characters with underscores.
+func escapeLabelName(name string) string {
+	return escape(name, false)
+}
+
+func escape(s string, allowColons bool) string {
+	if s == "" {
+		return "_"
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(s) + 1)
+	for i, r := range s {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || r == '_' || (allowColons && r == ':')):
+			sb.WriteRune(r)
+		case r < unicode.MaxASCII && unicode.IsDigit(r):
+			if i == 0 {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
+
+type metrics struct {
+	samplesAppended *prometheus.CounterVec
+	parseErrors     *prometheus.CounterVec
+	samplesDropped  *prometheus.CounterVec
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	var m metrics
+
+	m.samplesAppended = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_metrics_ingest_samples_appended_total",
+		Help: "Total number of samples received by a Graphite or Influx listener and appended into an instance",
+	}, []string{"listener", "format"})
+
+	m.parseErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_metrics_ingest_parse_errors_total",
+		Help: "Total number of lines which a Graphite or Influx listener failed to parse",
+	}, []string{"listener", "format"})
+
+	m.samplesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_metrics_ingest_samples_dropped_total",
+		Help: "Total number of samples received by a Graphite or Influx listener which weren't appended into an instance",
+	}, []string{"listener", "format", "reason"})
+
+	if reg != nil {
+		reg.MustRegister(
+			m.samplesAppended,
+			m.parseErrors,
+			m.samplesDropped,
+		)
+	}
+	return &m
+}
+
+// deleteListener removes the series of a removed listener.
+func (m *metrics) deleteListener(name, format string) {
+	m.samplesAppended.DeleteLabelValues(name, format)
+	m.parseErrors.DeleteLabelValues(name, format)
+	for _, reason := range dropReasons {
+		m.samplesDropped.DeleteLabelValues(name, format, reason)
+	}
+}
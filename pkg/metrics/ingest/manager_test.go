@@ -0,0 +1,286 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tt := []struct {
+		name   string
+		cfg    string
+		expect string
+	}{
+		{
+			name: "valid",
+			cfg: `
+graphite:
+- name: graphite
+  listen_address: 127.0.0.1:0
+  instance: default
+  mapping_config:
+    mappings:
+    - match: servers.*.cpu
+      name: server_cpu
+      labels:
+        server: $1
+influx:
+- name: influx
+  listen_address: 127.0.0.1:0
+  instance: default`,
+		},
+		{
+			name: "duplicate name",
+			cfg: `
+graphite:
+- {name: a, listen_address: 127.0.0.1:0, instance: default}
+influx:
+- {name: a, listen_address: 127.0.0.1:0, instance: default}`,
+			expect: `found multiple listeners with name "a"`,
+		},
+		{
+			name: "missing instance",
+			cfg: `
+graphite:
+- {name: a, listen_address: 127.0.0.1:0}`,
+			expect: `graphite listener "a": instance must not be empty`,
+		},
+		{
+			name: "invalid mapping",
+			cfg: `
+influx:
+- name: a
+  listen_address: 127.0.0.1:0
+  instance: default
+  mapping_config:
+    mappings:
+    - match: a.*
+      name: a
+      labels:
+        0invalid: $1`,
+			expect: `invalid label key: 0invalid`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// Mapping configs are validated when unmarshaled.
+			var c Config
+			err := yaml.UnmarshalStrict([]byte(tc.cfg), &c)
+			if err == nil {
+				err = c.Validate()
+			}
+			if tc.expect == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expect)
+			}
+		})
+	}
+}
+
+func TestWriter_Mapping(t *testing.T) {
+	lc := &ListenerConfig{Name: "test", ListenAddress: ":0", Instance: "default"}
+	require.NoError(t, yaml.Unmarshal([]byte(`
+mappings:
+- match: servers.*.cpu.*
+  name: server_cpu
+  labels:
+    server: $1
+    cpu: $2
+- match: ignored.*
+  name: dropped
+  action: drop
+- match: typed.*
+  name: typed
+  match_metric_type: counter
+`), &lc.MappingConfig))
+
+	app := &collectingAppender{}
+	m := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), testInstances(map[string]storage.Appender{"default": app}))
+	w, err := m.newWriter(lc, formatGraphite)
+	require.NoError(t, err)
+
+	samples := []sample{
+		{name: "servers.a.cpu.0", defaultName: "servers.a.cpu.0", tags: map[string]string{"dc": "us", "server": "overridden"}, t: 1, v: 1},
+		{name: "ignored.metric", defaultName: "ignored.metric", t: 1, v: 2},
+		{name: "typed.metric", defaultName: "typed.metric", t: 1, v: 4},
+		{name: "other.metric", defaultName: "other.metric", t: 1, v: 3},
+	}
+	require.NoError(t, w.write(context.Background(), samples))
+	require.Equal(t, []string{
+		`{__name__="server_cpu", cpu="0", dc="us", server="a"}`,
+		`{__name__="typed"}`,
+		`{__name__="other_metric"}`,
+	}, app.Series())
+
+	// Unmatched metrics are dropped with strict matching.
+	lc.StrictMatch = true
+	require.NoError(t, w.write(context.Background(), samples[3:]))
+	require.Len(t, app.Series(), 3)
+}
+
+func TestWriter_UnknownInstance(t *testing.T) {
+	lc := &ListenerConfig{Name: "test", ListenAddress: ":0", Instance: "missing"}
+	m := NewManager(log.NewNopLogger(), prometheus.NewRegistry(), testInstances(nil))
+	w, err := m.newWriter(lc, formatInflux)
+	require.NoError(t, err)
+
+	err = w.write(context.Background(), []sample{{name: "a", defaultName: "a"}})
+	require.ErrorIs(t, err, errUnknownInstance)
+}
+
+func TestManager_ApplyConfig(t *testing.T) {
+	app := &collectingAppender{}
+	reg := prometheus.NewRegistry()
+	m := NewManager(log.NewNopLogger(), reg, testInstances(map[string]storage.Appender{"default": app}))
+	t.Cleanup(m.Stop)
+
+	addr := freeAddress(t)
+	cfg := Config{
+		Graphite: []*ListenerConfig{{Name: "graphite", ListenAddress: addr, Instance: "default"}},
+	}
+	require.NoError(t, m.ApplyConfig(cfg))
+	sendGraphite(t, addr, "first.metric 1\n")
+	require.Eventually(t, func() bool { return len(app.Series()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// A config with a listener which can't start must not affect the running
+	// listeners.
+	inUse, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer inUse.Close()
+
+	badCfg := Config{
+		Graphite: []*ListenerConfig{{Name: "renamed", ListenAddress: addr, Instance: "default"}},
+		Influx:   []*ListenerConfig{{Name: "influx", ListenAddress: inUse.Addr().String(), Instance: "default"}},
+	}
+	require.Error(t, m.ApplyConfig(badCfg))
+	require.NoError(t, m.ApplyConfig(cfg))
+	sendGraphite(t, addr, "second.metric 1\n")
+	require.Eventually(t, func() bool { return len(app.Series()) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	// Changing the config of a listener keeps it running and doesn't reset
+	// the counters of other listeners.
+	cfg.Graphite[0].StrictMatch = true
+	cfg.Influx = []*ListenerConfig{{Name: "influx", ListenAddress: "127.0.0.1:0", Instance: "default"}}
+	require.NoError(t, m.ApplyConfig(cfg))
+	require.Equal(t, float64(2), testutil.ToFloat64(m.metrics.samplesAppended.WithLabelValues("graphite", formatGraphite)))
+	sendGraphite(t, addr, "third.metric 1\n")
+	require.Eventually(t, func() bool {
+		return testutil.ToFloat64(m.metrics.samplesDropped.WithLabelValues("graphite", formatGraphite, reasonUnmatched)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func freeAddress(t *testing.T) string {
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+func sendGraphite(t *testing.T, addr, lines string) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = fmt.Fprint(conn, lines)
+	require.NoError(t, err)
+}
+
+// testInstances returns a GetInstanceFunc for instances which always use the
+// same appender.
+func testInstances(apps map[string]storage.Appender) GetInstanceFunc {
+	return func(name string) (storage.Appendable, error) {
+		app, ok := apps[name]
+		if !ok {
+			return nil, errors.New("instance not found")
+		}
+		return appendableFunc(func(context.Context) storage.Appender { return app }), nil
+	}
+}
+
+type appendableFunc func(context.Context) storage.Appender
+
+func (f appendableFunc) Appender(ctx context.Context) storage.Appender { return f(ctx) }
+
+type appendedSample struct {
+	lbls labels.Labels
+	t    int64
+	v    float64
+}
+
+// collectingAppender is a storage.Appender which stores committed samples.
+type collectingAppender struct {
+	mut       sync.Mutex
+	pending   []appendedSample
+	committed []appendedSample
+	commits   int
+}
+
+func (a *collectingAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.pending = append(a.pending, appendedSample{lbls: l, t: t, v: v})
+	return ref, nil
+}
+
+func (a *collectingAppender) AppendExemplar(ref storage.SeriesRef, _ labels.Labels, _ exemplar.Exemplar) (storage.SeriesRef, error) {
+	return ref, nil
+}
+
+func (a *collectingAppender) Commit() error {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.committed = append(a.committed, a.pending...)
+	a.pending = nil
+	a.commits++
+	return nil
+}
+
+func (a *collectingAppender) Rollback() error {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	a.pending = nil
+	return nil
+}
+
+// Samples returns the committed samples.
+func (a *collectingAppender) Samples() []appendedSample {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	return append([]appendedSample(nil), a.committed...)
+}
+
+// Commits returns the number of commits.
+func (a *collectingAppender) Commits() int {
+	a.mut.Lock()
+	defer a.mut.Unlock()
+	return a.commits
+}
+
+// Series returns the labels of the committed samples.
+func (a *collectingAppender) Series() []string {
+	var res []string
+	for _, s := range a.Samples() {
+		res = append(res, s.lbls.String())
+	}
+	return res
+}
@@ -7,6 +7,7 @@ import (
 	"sort"
 	"sync"
 
+	"github.com/grafana/agent/pkg/metrics/router"
 	"github.com/prometheus/prometheus/config"
 )
 
@@ -270,11 +271,13 @@ func hashConfig(c Config) (string, error) {
 	// Assign names to remote_write configs if they're not present already.
 	// This is also done in AssignDefaults but is duplicated here for the sake
 	// of simplifying responsibility of GroupManager.
+	renames := make(map[string]string, len(groupable.RemoteWrite))
 	for _, cfg := range groupable.RemoteWrite {
 		if cfg != nil {
 			// We don't care if the names are different, just that the other settings
 			// are the same. Blank out the name here before hashing the remote
 			// write config.
+			oldName := cfg.Name
 			cfg.Name = ""
 
 			hash, err := getHash(cfg)
@@ -282,8 +285,10 @@ func hashConfig(c Config) (string, error) {
 				return "", err
 			}
 			cfg.Name = hash[:6]
+			renames[oldName] = cfg.Name
 		}
 	}
+	renameRoutedRemoteWrite(groupable.RemoteWriteRoutes, renames)
 
 	// Now sort remote_writes by name and nil-ness.
 	sort.Slice(groupable.RemoteWrite, func(i, j int) bool {
@@ -330,9 +335,11 @@ func groupConfigs(groupName string, grouped groupedConfigs) (Config, error) {
 	// Assign all remote_write configs in the group a consistent set of remote_names.
 	// If the grouped configs are coming from the scraping service, defaults will have
 	// been applied and the remote names will be prefixed with the old instance config name.
+	renames := make(map[string]string, len(combined.RemoteWrite))
 	for _, rwc := range combined.RemoteWrite {
 		// Blank out the existing name before getting the hash so it is doesn't take into
 		// account any existing name.
+		oldName := rwc.Name
 		rwc.Name = ""
 
 		hash, err := getHash(rwc)
@@ -341,7 +348,9 @@ func groupConfigs(groupName string, grouped groupedConfigs) (Config, error) {
 		}
 
 		rwc.Name = groupName[:6] + "-" + hash[:6]
+		renames[oldName] = rwc.Name
 	}
+	renameRoutedRemoteWrite(combined.RemoteWriteRoutes, renames)
 
 	// Combine all the scrape configs. It's possible that two different ungrouped
 	// configs had a matching job name, but this will be detected and rejected
@@ -356,3 +365,18 @@ func groupConfigs(groupName string, grouped groupedConfigs) (Config, error) {
 
 	return combined, nil
 }
+
+// renameRoutedRemoteWrite updates the remote_write names referenced by routes
+// after remote_write configs were renamed.
+func renameRoutedRemoteWrite(routes []*router.RouteConfig, renames map[string]string) {
+	for _, rc := range routes {
+		if rc == nil {
+			continue
+		}
+		for i, name := range rc.RemoteWrite {
+			if newName, ok := renames[name]; ok {
+				rc.RemoteWrite[i] = newName
+			}
+		}
+	}
+}
@@ -196,6 +196,45 @@ remote_write:
 	require.NotEqual(t, "rw-cfg-a", cfg.RemoteWrite[0].Name)
 }
 
+func TestGroupManager_ApplyConfig_RemoteWriteRoutes(t *testing.T) {
+	inner := newFakeManager()
+	gm := NewGroupManager(inner)
+
+	configs := []string{`
+name: configA
+scrape_configs: []
+remote_write:
+- name: primary-a
+  url: http://localhost:9009/api/prom/push
+- name: secondary-a
+  url: http://localhost:9010/api/prom/push
+remote_write_routes:
+- name: failover
+  remote_write: [primary-a, secondary-a]
+`, `
+name: configB
+scrape_configs: []
+remote_write:
+- name: primary-b
+  url: http://localhost:9009/api/prom/push
+- name: secondary-b
+  url: http://localhost:9010/api/prom/push
+remote_write_routes:
+- name: failover
+  remote_write: [primary-b, secondary-b]
+`}
+	for _, text := range configs {
+		require.NoError(t, gm.ApplyConfig(testUnmarshalConfig(t, text)))
+	}
+
+	// Both configs should be grouped together, with routes referencing the
+	// renamed remote_writes.
+	require.Equal(t, 1, len(gm.groups))
+
+	cfg := inner.ListConfigs()[gm.groupLookup["configA"]]
+	require.Equal(t, []string{cfg.RemoteWrite[0].Name, cfg.RemoteWrite[1].Name}, cfg.RemoteWriteRoutes[0].RemoteWrite)
+}
+
 func TestGroupManager_DeleteConfig(t *testing.T) {
 	t.Run("partial delete", func(t *testing.T) {
 		inner := newFakeManager()
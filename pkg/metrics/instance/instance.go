@@ -15,15 +15,19 @@ import (
 	"sync"
 	"time"
 
+	"github.com/alecthomas/units"
 	"github.com/go-kit/log"
 	"github.com/go-kit/log/level"
 	"github.com/grafana/agent/pkg/build"
+	"github.com/grafana/agent/pkg/metrics/router"
+	agentscrape "github.com/grafana/agent/pkg/metrics/scrape"
 	"github.com/grafana/agent/pkg/metrics/wal"
 	"github.com/grafana/agent/pkg/util"
 	"github.com/oklog/run"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/prometheus/config"
 	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
 	"github.com/prometheus/prometheus/model/relabel"
 	"github.com/prometheus/prometheus/model/timestamp"
 	"github.com/prometheus/prometheus/scrape"
@@ -36,6 +40,7 @@ import (
 func init() {
 	remote.UserAgent = fmt.Sprintf("GrafanaAgent/%s", build.Version)
 	scrape.UserAgent = fmt.Sprintf("GrafanaAgent/%s", build.Version)
+	agentscrape.UserAgent = scrape.UserAgent
 
 	// default remote_write send_exemplars to true
 	config.DefaultRemoteWriteConfig.SendExemplars = true
@@ -70,9 +75,33 @@ type Config struct {
 	MinWALTime time.Duration `yaml:"min_wal_time,omitempty"`
 	MaxWALTime time.Duration `yaml:"max_wal_time,omitempty"`
 
+	// Maximum size of the WAL on disk. 0 disables the limit.
+	MaxWALSize units.Base2Bytes `yaml:"max_wal_size,omitempty"`
+
+	// What to do once the WAL reaches max_wal_size or the total size limit of
+	// all WALs. Defaults to wal.SizePolicyDropOldest when empty.
+	WALSizeLimitPolicy wal.SizePolicy `yaml:"wal_size_limit_policy,omitempty"`
+
+	// Limits on the series and samples the instance may write to the WAL. 0
+	// disables a limit.
+	MaxActiveSeries     int     `yaml:"max_active_series,omitempty"`
+	MaxSamplesPerSecond float64 `yaml:"max_samples_per_second,omitempty"`
+
+	// Determines the offsets at which targets are scraped within their scrape
+	// interval. Defaults to ScrapeOffsetHashed when empty.
+	ScrapeOffsetMode ScrapeOffsetMode `yaml:"scrape_offset_mode,omitempty"`
+
 	RemoteFlushDeadline  time.Duration `yaml:"remote_flush_deadline,omitempty"`
 	WriteStaleOnShutdown bool          `yaml:"write_stale_on_shutdown,omitempty"`
 
+	// Routes choosing which remote_write endpoints receive which series.
+	// remote_write configs referenced by routes only receive series through
+	// routes.
+	RemoteWriteRoutes []*router.RouteConfig `yaml:"remote_write_routes,omitempty"`
+
+	// Recording and alerting rules to evaluate. Rules are disabled when nil.
+	Rules *RulesConfig `yaml:"rules,omitempty"`
+
 	global GlobalConfig `yaml:"-"`
 }
 
@@ -120,6 +149,22 @@ func (c *Config) ApplyDefaults(global GlobalConfig) error {
 		return errors.New("remote_flush_deadline must be greater than 0s")
 	case c.MinWALTime > c.MaxWALTime:
 		return errors.New("min_wal_time must be less than max_wal_time")
+	case c.MaxWALSize < 0:
+		return errors.New("max_wal_size must not be negative")
+	case c.MaxActiveSeries < 0:
+		return errors.New("max_active_series must not be negative")
+	case c.MaxSamplesPerSecond < 0:
+		return errors.New("max_samples_per_second must not be negative")
+	case c.WALSizeLimitPolicy != "" && c.WALSizeLimitPolicy != wal.SizePolicyDropOldest && c.WALSizeLimitPolicy != wal.SizePolicyBlock:
+		return fmt.Errorf("unknown wal_size_limit_policy %q, must be %q or %q", c.WALSizeLimitPolicy, wal.SizePolicyDropOldest, wal.SizePolicyBlock)
+	case c.ScrapeOffsetMode != "" && c.ScrapeOffsetMode != ScrapeOffsetHashed && c.ScrapeOffsetMode != ScrapeOffsetAligned && c.ScrapeOffsetMode != ScrapeOffsetSpread:
+		return fmt.Errorf("unknown scrape_offset_mode %q, must be %q, %q or %q", c.ScrapeOffsetMode, ScrapeOffsetHashed, ScrapeOffsetAligned, ScrapeOffsetSpread)
+	}
+
+	if c.Rules != nil {
+		if err := c.Rules.validate(); err != nil {
+			return err
+		}
 	}
 
 	jobNames := map[string]struct{}{}
@@ -190,9 +235,49 @@ func (c *Config) ApplyDefaults(global GlobalConfig) error {
 		rwNames[cfg.Name] = struct{}{}
 	}
 
+	if err := router.Validate(c.RemoteWriteRoutes, c.RemoteWrite); err != nil {
+		return fmt.Errorf("invalid remote_write_routes: %w", err)
+	}
+
+	// Offsets other than the hashed ones are implemented by the scrape manager
+	// of the agent, which can't be used for sending metadata.
+	if c.ScrapeOffsetMode != "" && c.ScrapeOffsetMode != ScrapeOffsetHashed {
+		for _, cfg := range c.RemoteWrite {
+			if cfg.MetadataConfig.Send {
+				return fmt.Errorf("scrape_offset_mode %q doesn't support sending metadata, set metadata_config.send to false for remote write config %q", c.ScrapeOffsetMode, cfg.Name)
+			}
+		}
+	}
+
 	return nil
 }
 
+// unroutedRemoteWrite returns the remote_write configs which aren't used by
+// any route. Every sample is sent to them.
+func (c *Config) unroutedRemoteWrite() []*config.RemoteWriteConfig {
+	if len(c.RemoteWriteRoutes) == 0 {
+		return c.RemoteWrite
+	}
+
+	routed := router.RoutedRemoteWrite(c.RemoteWriteRoutes)
+	res := make([]*config.RemoteWriteConfig, 0, len(c.RemoteWrite))
+	for _, rw := range c.RemoteWrite {
+		if _, ok := routed[rw.Name]; !ok {
+			res = append(res, rw)
+		}
+	}
+	return res
+}
+
+func (c *Config) routerConfig() router.Config {
+	return router.Config{
+		Routes:         c.RemoteWriteRoutes,
+		RemoteWrite:    c.RemoteWrite,
+		ExternalLabels: c.global.Prometheus.ExternalLabels,
+		FlushDeadline:  c.RemoteFlushDeadline,
+	}
+}
+
 // Clone makes a deep copy of the config along with global settings.
 func (c *Config) Clone() (Config, error) {
 	bb, err := MarshalConfig(c, false)
@@ -233,7 +318,9 @@ type Instance struct {
 	discovery          *discoveryService
 	readyScrapeManager *readyScrapeManager
 	remoteStore        *remote.Storage
+	router             *router.Router // nil when there are no routes.
 	storage            storage.Storage
+	rules              *ruleEvaluator // nil when rules are disabled.
 
 	// ready is set to true after the initialization process finishes
 	ready atomic.Bool
@@ -244,20 +331,59 @@ type Instance struct {
 
 	reg    prometheus.Registerer
 	newWal walStorageFactory
+
+	// truncateCh is written to when the WAL is full and should be truncated
+	// before its next scheduled truncation. May be nil.
+	truncateCh chan struct{}
+
+	// position returns the position of the agent in its cluster. May be nil.
+	position PositionFunc
 }
 
-// New creates a new Instance with a directory for storing the WAL. The instance
-// will not start until Run is called on the instance.
-func New(reg prometheus.Registerer, cfg Config, walDir string, logger log.Logger) (*Instance, error) {
+// minEarlyTruncateInterval is the minimum time between truncations of a WAL
+// which is full and rejecting appends.
+const minEarlyTruncateInterval = time.Minute
+
+// New creates a new Instance with a directory for storing the WAL. walBudget
+// limits the combined size of the WALs of all instances sharing it and may be
+// nil. position is used to spread scrapes across the agents of a cluster and
+// may be nil. The instance will not start until Run is called on the
+// instance.
+func New(reg prometheus.Registerer, cfg Config, walDir string, walBudget *wal.SizeBudget, position PositionFunc, logger log.Logger) (*Instance, error) {
 	logger = log.With(logger, "instance", cfg.Name)
 
 	instWALDir := filepath.Join(walDir, cfg.Name)
 
+	// A WAL using the block policy only frees up space when it's truncated,
+	// so it asks to be truncated early instead of rejecting appends until
+	// the next scheduled truncation.
+	truncateCh := make(chan struct{}, 1)
+	onFull := func() {
+		select {
+		case truncateCh <- struct{}{}:
+		default:
+		}
+	}
+
 	newWal := func(reg prometheus.Registerer) (walStorage, error) {
-		return wal.NewStorage(logger, reg, instWALDir)
+		return wal.NewStorageWithOptions(logger, reg, instWALDir, wal.Options{
+			MaxSize: int64(cfg.MaxWALSize),
+			Budget:  walBudget,
+			Policy:  cfg.WALSizeLimitPolicy,
+			OnFull:  onFull,
+
+			MaxActiveSeries:     cfg.MaxActiveSeries,
+			MaxSamplesPerSecond: cfg.MaxSamplesPerSecond,
+		})
 	}
 
-	return newInstance(cfg, reg, logger, newWal)
+	i, err := newInstance(cfg, reg, logger, newWal)
+	if err != nil {
+		return nil, err
+	}
+	i.truncateCh = truncateCh
+	i.position = position
+	return i, nil
 }
 
 func newInstance(cfg Config, reg prometheus.Registerer, logger log.Logger, newWal walStorageFactory) (*Instance, error) {
@@ -311,9 +437,10 @@ func (i *Instance) Run(ctx context.Context) error {
 	// The actors defined here are defined in the order we want them to shut down.
 	// Primarily, we want to ensure that the following shutdown order is
 	// maintained:
-	//    1. The scrape manager stops
-	//    2. WAL storage is closed
-	//    3. Remote write storage is closed
+	//    1. Rule evaluation stops
+	//    2. The scrape manager stops
+	//    3. WAL storage is closed
+	//    4. Remote write storage is closed
 	// This is done to allow the instance to write stale markers for all active
 	// series.
 	rg := runGroupWithContext(ctx)
@@ -338,8 +465,22 @@ func (i *Instance) Run(ctx context.Context) error {
 			},
 		)
 	}
+	if i.rules != nil {
+		// Rule evaluation
+		rg.Add(
+			func() error {
+				err := i.rules.Run()
+				level.Info(i.logger).Log("msg", "rule evaluation stopped")
+				return err
+			},
+			func(err error) {
+				level.Info(i.logger).Log("msg", "stopping rule evaluation...")
+				i.rules.Stop()
+			},
+		)
+	}
 	{
-		sm, err := i.readyScrapeManager.Get()
+		sm, err := i.readyScrapeManager.Manager()
 		if err != nil {
 			level.Error(i.logger).Log("msg", "failed to get scrape manager")
 			return err
@@ -374,6 +515,9 @@ func (i *Instance) Run(ctx context.Context) error {
 				if err := i.storage.Close(); err != nil {
 					level.Error(i.logger).Log("msg", "error stopping storage", "err", err)
 				}
+				if i.router != nil {
+					i.router.Stop()
+				}
 			},
 		)
 	}
@@ -418,18 +562,62 @@ func (i *Instance) initialize(ctx context.Context, reg prometheus.Registerer, cf
 	i.remoteStore = remote.NewStorage(remoteLogger, reg, i.wal.StartTime, i.wal.Directory(), cfg.RemoteFlushDeadline, i.readyScrapeManager)
 	err = i.remoteStore.ApplyConfig(&config.Config{
 		GlobalConfig:       cfg.global.Prometheus,
-		RemoteWriteConfigs: cfg.RemoteWrite,
+		RemoteWriteConfigs: cfg.unroutedRemoteWrite(),
 	})
 	if err != nil {
 		return fmt.Errorf("failed applying config to remote storage: %w", err)
 	}
 
-	i.storage = storage.NewFanout(i.logger, i.wal, i.remoteStore)
+	// Routed remote_writes share a single reader of the WAL.
+	i.router = nil
+	if len(cfg.RemoteWriteRoutes) > 0 {
+		i.router = router.New(log.With(i.logger, "component", "router"), reg, i.wal.Directory())
+		if err := i.router.ApplyConfig(cfg.routerConfig()); err != nil {
+			return fmt.Errorf("failed applying config to remote_write router: %w", err)
+		}
+		i.router.Start()
+	}
 
-	opts := &scrape.Options{
-		ExtraMetrics: cfg.global.ExtraMetrics,
+	// Samples are also kept in memory for a short time when there are rules
+	// to evaluate.
+	i.rules = nil
+	secondaries := []storage.Storage{i.remoteStore}
+	if cfg.Rules != nil {
+		i.rules, err = newRuleEvaluator(i.logger, reg, filepath.Join(i.wal.Directory(), "rules"), cfg)
+		if err != nil {
+			return fmt.Errorf("error creating rule evaluator: %w", err)
+		}
+		secondaries = append(secondaries, i.rules.Recent())
+	}
+
+	i.storage = storage.NewFanout(i.logger, i.wal, secondaries...)
+
+	if i.rules != nil {
+		// Results of rules are written back through the fanout storage.
+		if err := i.rules.Init(reg, i.storage, cfg); err != nil {
+			i.rules.abort()
+			return fmt.Errorf("error initializing rule evaluator: %w", err)
+		}
+	}
+
+	var (
+		scrapeManager scraper
+		scrapeLogger  = log.With(i.logger, "component", "scrape manager")
+	)
+	if offset := scrapeOffsetFunc(cfg.ScrapeOffsetMode, i.position); offset != nil {
+		// Only the scrape manager of the agent allows changing the offsets at
+		// which targets are scraped.
+		opts := &agentscrape.Options{
+			ExtraMetrics: cfg.global.ExtraMetrics,
+			Offset:       offset,
+		}
+		scrapeManager = agentscrape.NewManager(opts, scrapeLogger, i.storage)
+	} else {
+		opts := &scrape.Options{
+			ExtraMetrics: cfg.global.ExtraMetrics,
+		}
+		scrapeManager = newScrapeManager(opts, scrapeLogger, i.storage)
 	}
-	scrapeManager := newScrapeManager(opts, log.With(i.logger, "component", "scrape manager"), i.storage)
 	err = scrapeManager.ApplyConfig(&config.Config{
 		GlobalConfig:  cfg.global.Prometheus,
 		ScrapeConfigs: cfg.ScrapeConfigs,
@@ -467,10 +655,26 @@ func (i *Instance) Update(c Config) (err error) {
 		err = errImmutableField{Field: "host_filter"}
 	case i.cfg.WALTruncateFrequency != c.WALTruncateFrequency:
 		err = errImmutableField{Field: "wal_truncate_frequency"}
+	case i.cfg.MaxWALSize != c.MaxWALSize:
+		err = errImmutableField{Field: "max_wal_size"}
+	case i.cfg.WALSizeLimitPolicy != c.WALSizeLimitPolicy:
+		err = errImmutableField{Field: "wal_size_limit_policy"}
+	case i.cfg.MaxActiveSeries != c.MaxActiveSeries:
+		err = errImmutableField{Field: "max_active_series"}
+	case i.cfg.MaxSamplesPerSecond != c.MaxSamplesPerSecond:
+		err = errImmutableField{Field: "max_samples_per_second"}
+	case i.cfg.ScrapeOffsetMode != c.ScrapeOffsetMode:
+		err = errImmutableField{Field: "scrape_offset_mode"}
 	case i.cfg.RemoteFlushDeadline != c.RemoteFlushDeadline:
 		err = errImmutableField{Field: "remote_flush_deadline"}
 	case i.cfg.WriteStaleOnShutdown != c.WriteStaleOnShutdown:
 		err = errImmutableField{Field: "write_stale_on_shutdown"}
+	case (len(i.cfg.RemoteWriteRoutes) == 0) != (len(c.RemoteWriteRoutes) == 0):
+		err = errImmutableField{Field: "remote_write_routes"}
+	case (i.cfg.Rules == nil) != (c.Rules == nil):
+		err = errImmutableField{Field: "rules"}
+	case i.cfg.Rules != nil && i.cfg.Rules.QueryWindow != c.Rules.QueryWindow:
+		err = errImmutableField{Field: "rules.query_window"}
 	}
 	if err != nil {
 		return ErrInvalidUpdate{Inner: err}
@@ -510,13 +714,18 @@ func (i *Instance) Update(c Config) (err error) {
 
 	err = i.remoteStore.ApplyConfig(&config.Config{
 		GlobalConfig:       c.global.Prometheus,
-		RemoteWriteConfigs: c.RemoteWrite,
+		RemoteWriteConfigs: c.unroutedRemoteWrite(),
 	})
 	if err != nil {
 		return fmt.Errorf("error applying new remote_write configs: %w", err)
 	}
+	if i.router != nil {
+		if err := i.router.ApplyConfig(c.routerConfig()); err != nil {
+			return fmt.Errorf("error applying new remote_write_routes: %w", err)
+		}
+	}
 
-	sm, err := i.readyScrapeManager.Get()
+	sm, err := i.readyScrapeManager.Manager()
 	if err != nil {
 		return fmt.Errorf("couldn't get scrape manager to apply new scrape configs: %w", err)
 	}
@@ -537,12 +746,69 @@ func (i *Instance) Update(c Config) (err error) {
 		return fmt.Errorf("failed applying configs to discovery manager: %w", err)
 	}
 
+	if i.rules != nil {
+		if err := i.rules.ApplyConfig(&c); err != nil {
+			return fmt.Errorf("error applying updated rules: %w", err)
+		}
+	}
+
 	return nil
 }
 
 // TargetsActive returns the set of active targets from the scrape manager. Returns nil
 // if the scrape manager is not ready yet.
 func (i *Instance) TargetsActive() map[string][]*scrape.Target {
+	mgr := i.scrapeManager("active")
+	if mgr == nil {
+		return nil
+	}
+	return mgr.TargetsActive()
+}
+
+// TargetsDropped returns the set of targets dropped by relabeling from the
+// scrape manager, along with the relabel rule which dropped each of them.
+// Returns nil if the scrape manager is not ready yet.
+func (i *Instance) TargetsDropped() map[string][]DroppedTarget {
+	mgr := i.scrapeManager("dropped")
+	if mgr == nil {
+		return nil
+	}
+
+	i.mut.Lock()
+	scrapeConfigs := i.cfg.ScrapeConfigs
+	i.mut.Unlock()
+
+	return droppedTargets(mgr.TargetsDropped(), scrapeConfigs)
+}
+
+// TargetOffsets returns the offsets within their scrape interval at which
+// active targets are scraped. Returns nil if the scrape manager is not ready
+// yet.
+func (i *Instance) TargetOffsets() map[*scrape.Target]time.Duration {
+	mgr := i.scrapeManager("active")
+	if mgr == nil {
+		return nil
+	}
+	if mgr, ok := mgr.(*agentscrape.Manager); ok {
+		return mgr.TargetOffsets()
+	}
+
+	i.mut.Lock()
+	scrapeConfigs := i.cfg.ScrapeConfigs
+	externalLabels := i.cfg.global.Prometheus.ExternalLabels
+	i.mut.Unlock()
+
+	offsets, err := hashedTargetOffsets(mgr.TargetsActive(), scrapeConfigs, externalLabels)
+	if err != nil {
+		level.Error(i.logger).Log("msg", "failed to compute offsets of targets", "err", err)
+		return nil
+	}
+	return offsets
+}
+
+// scrapeManager returns the scrape manager, or nil if it is not ready yet.
+// kind is the kind of targets being collected and is used for logging.
+func (i *Instance) scrapeManager(kind string) scraper {
 	i.mut.Lock()
 	defer i.mut.Unlock()
 
@@ -550,14 +816,14 @@ func (i *Instance) TargetsActive() map[string][]*scrape.Target {
 		return nil
 	}
 
-	mgr, err := i.readyScrapeManager.Get()
+	mgr, err := i.readyScrapeManager.Manager()
 	if err == ErrNotReady {
 		return nil
 	} else if err != nil {
-		level.Error(i.logger).Log("msg", "failed to get scrape manager when collecting active targets", "err", err)
+		level.Error(i.logger).Log("msg", fmt.Sprintf("failed to get scrape manager when collecting %s targets", kind), "err", err)
 		return nil
 	}
-	return mgr.TargetsActive()
+	return mgr
 }
 
 // StorageDirectory returns the directory where this Instance is writing series
@@ -649,45 +915,65 @@ func (i *Instance) truncateLoop(ctx context.Context, wal walStorage, cfg *Config
 	// deleted until at least some new data has been sent.
 	var lastTs int64 = math.MinInt64
 
+	// lastTruncate is when the WAL was last truncated early because it was
+	// full.
+	var lastTruncate time.Time
+
+	ticker := time.NewTicker(cfg.WALTruncateFrequency)
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(cfg.WALTruncateFrequency):
-			// The timestamp ts is used to determine which series are not receiving
-			// samples and may be deleted from the WAL. Their most recent append
-			// timestamp is compared to ts, and if that timestamp is older then ts,
-			// they are considered inactive and may be deleted.
-			//
-			// Subtracting a duration from ts will delay when it will be considered
-			// inactive and scheduled for deletion.
-			ts := i.getRemoteWriteTimestamp() - i.cfg.MinWALTime.Milliseconds()
-			if ts < 0 {
-				ts = 0
+		case <-i.truncateCh:
+			if time.Since(lastTruncate) < minEarlyTruncateInterval {
+				continue
 			}
+			lastTruncate = time.Now()
+			level.Debug(i.logger).Log("msg", "WAL is full, truncating early")
+			i.truncateWAL(wal, &lastTs)
+		case <-ticker.C:
+			i.truncateWAL(wal, &lastTs)
+		}
+	}
+}
 
-			// Network issues can prevent the result of getRemoteWriteTimestamp from
-			// changing. We don't want data in the WAL to grow forever, so we set a cap
-			// on the maximum age data can be. If our ts is older than this cutoff point,
-			// we'll shift it forward to start deleting very stale data.
-			if maxTS := timestamp.FromTime(time.Now().Add(-i.cfg.MaxWALTime)); ts < maxTS {
-				ts = maxTS
-			}
+// truncateWAL truncates data from wal which has been sent by remote_write.
+// lastTs holds the timestamp of the previous truncation and is updated.
+func (i *Instance) truncateWAL(wal walStorage, lastTs *int64) {
+	// The timestamp ts is used to determine which series are not receiving
+	// samples and may be deleted from the WAL. Their most recent append
+	// timestamp is compared to ts, and if that timestamp is older then ts,
+	// they are considered inactive and may be deleted.
+	//
+	// Subtracting a duration from ts will delay when it will be considered
+	// inactive and scheduled for deletion.
+	ts := i.getRemoteWriteTimestamp() - i.cfg.MinWALTime.Milliseconds()
+	if ts < 0 {
+		ts = 0
+	}
 
-			if ts == lastTs {
-				level.Debug(i.logger).Log("msg", "not truncating the WAL, remote_write timestamp is unchanged", "ts", ts)
-				continue
-			}
-			lastTs = ts
+	// Network issues can prevent the result of getRemoteWriteTimestamp from
+	// changing. We don't want data in the WAL to grow forever, so we set a cap
+	// on the maximum age data can be. If our ts is older than this cutoff point,
+	// we'll shift it forward to start deleting very stale data.
+	if maxTS := timestamp.FromTime(time.Now().Add(-i.cfg.MaxWALTime)); ts < maxTS {
+		ts = maxTS
+	}
 
-			level.Debug(i.logger).Log("msg", "truncating the WAL", "ts", ts)
-			err := wal.Truncate(ts)
-			if err != nil {
-				// The only issue here is larger disk usage and a greater replay time,
-				// so we'll only log this as a warning.
-				level.Warn(i.logger).Log("msg", "could not truncate WAL", "err", err)
-			}
-		}
+	if ts == *lastTs {
+		level.Debug(i.logger).Log("msg", "not truncating the WAL, remote_write timestamp is unchanged", "ts", ts)
+		return
+	}
+	*lastTs = ts
+
+	level.Debug(i.logger).Log("msg", "truncating the WAL", "ts", ts)
+	err := wal.Truncate(ts)
+	if err != nil {
+		// The only issue here is larger disk usage and a greater replay time,
+		// so we'll only log this as a warning.
+		level.Warn(i.logger).Log("msg", "could not truncate WAL", "err", err)
 	}
 }
 
@@ -706,7 +992,19 @@ func (i *Instance) getRemoteWriteTimestamp() int64 {
 		// Instance still being initialized; start at 0.
 		return 0
 	}
-	return i.remoteStore.LowestSentTimestamp()
+
+	switch {
+	case i.router == nil:
+		return i.remoteStore.LowestSentTimestamp()
+	case len(i.cfg.unroutedRemoteWrite()) == 0:
+		return i.router.LowestSentTimestamp()
+	}
+
+	ts := i.remoteStore.LowestSentTimestamp()
+	if routerTs := i.router.LowestSentTimestamp(); routerTs < ts {
+		ts = routerTs
+	}
+	return ts
 }
 
 // walStorage is an interface satisfied by wal.Storage, and created for testing.
@@ -794,22 +1092,42 @@ func (rg *runGroupContext) Stop(_ error) { rg.cancel() }
 // initialized yet.
 var ErrNotReady = errors.New("Scrape manager not ready")
 
+// errNoMetadata is returned when the scrape manager of Prometheus is
+// retrieved for sending metadata, but the scrape manager of the agent is
+// used.
+var errNoMetadata = errors.New("scrape manager doesn't support sending metadata")
+
+// scraper is implemented by the scrape managers of Prometheus and of the
+// agent.
+type scraper interface {
+	Run(tsets <-chan map[string][]*targetgroup.Group) error
+	ApplyConfig(cfg *config.Config) error
+	TargetsActive() map[string][]*scrape.Target
+	TargetsDropped() map[string][]*scrape.Target
+	Stop()
+}
+
+var (
+	_ scraper = (*scrape.Manager)(nil)
+	_ scraper = (*agentscrape.Manager)(nil)
+)
+
 // readyScrapeManager allows a scrape manager to be retrieved. Even if it's set at a later point in time.
 type readyScrapeManager struct {
 	mtx sync.RWMutex
-	m   *scrape.Manager
+	m   scraper
 }
 
 // Set the scrape manager.
-func (rm *readyScrapeManager) Set(m *scrape.Manager) {
+func (rm *readyScrapeManager) Set(m scraper) {
 	rm.mtx.Lock()
 	defer rm.mtx.Unlock()
 
 	rm.m = m
 }
 
-// Get the scrape manager. If is not ready, return an error.
-func (rm *readyScrapeManager) Get() (*scrape.Manager, error) {
+// Manager gets the scrape manager. If is not ready, return an error.
+func (rm *readyScrapeManager) Manager() (scraper, error) {
 	rm.mtx.RLock()
 	defer rm.mtx.RUnlock()
 
@@ -819,3 +1137,18 @@ func (rm *readyScrapeManager) Get() (*scrape.Manager, error) {
 
 	return nil, ErrNotReady
 }
+
+// Get the scrape manager of Prometheus, which remote storage uses to send
+// metadata. If is not ready, or the scrape manager of the agent is used,
+// return an error.
+func (rm *readyScrapeManager) Get() (*scrape.Manager, error) {
+	m, err := rm.Manager()
+	if err != nil {
+		return nil, err
+	}
+	sm, ok := m.(*scrape.Manager)
+	if !ok {
+		return nil, errNoMetadata
+	}
+	return sm, nil
+}
@@ -22,12 +22,12 @@ import (
 
 // TestInstance_Update performs a full integration test by doing the following:
 //
-// 1. Launching an HTTP server which can be scraped and also mocks the remote_write
-//    endpoint.
-// 2. Creating an instance config with no scrape_configs or remote_write configs.
-// 3. Updates the instance with a scrape_config and remote_write.
-// 4. Validates that after 15 seconds, the scrape endpoint and remote_write
-//    endpoint has been called.
+//  1. Launching an HTTP server which can be scraped and also mocks the remote_write
+//     endpoint.
+//  2. Creating an instance config with no scrape_configs or remote_write configs.
+//  3. Updates the instance with a scrape_config and remote_write.
+//  4. Validates that after 15 seconds, the scrape endpoint and remote_write
+//     endpoint has been called.
 func TestInstance_Update(t *testing.T) {
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 
@@ -65,7 +65,7 @@ name: integration_test
 scrape_configs: []
 remote_write: []
 `)
-	inst, err := New(prometheus.NewRegistry(), initialConfig, walDir, logger)
+	inst, err := New(prometheus.NewRegistry(), initialConfig, walDir, nil, nil, logger)
 	require.NoError(t, err)
 
 	instCtx, cancel := context.WithCancel(context.Background())
@@ -131,7 +131,7 @@ name: integration_test
 scrape_configs: []
 remote_write: []
 `)
-	inst, err := New(prometheus.NewRegistry(), initialConfig, walDir, logger)
+	inst, err := New(prometheus.NewRegistry(), initialConfig, walDir, nil, nil, logger)
 	require.NoError(t, err)
 
 	instCtx, cancel := context.WithCancel(context.Background())
@@ -184,7 +184,7 @@ name: integration_test
 scrape_configs: []
 remote_write: []
 `)
-	inst, err := New(prometheus.NewRegistry(), initialConfig, walDir, logger)
+	inst, err := New(prometheus.NewRegistry(), initialConfig, walDir, nil, nil, logger)
 	require.NoError(t, err)
 
 	instCtx, cancel := context.WithCancel(context.Background())
@@ -12,8 +12,11 @@ import (
 	"testing"
 	"time"
 
+	"github.com/alecthomas/units"
 	"github.com/cortexproject/cortex/pkg/util/test"
 	"github.com/go-kit/log"
+	"github.com/grafana/agent/pkg/metrics/router"
+	"github.com/grafana/agent/pkg/metrics/wal"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/model"
@@ -54,6 +57,19 @@ remote_write:
 	}
 }
 
+func TestConfig_Unmarshal_WALSizeLimit(t *testing.T) {
+	cfgText := `name: test
+max_wal_size: 512MiB
+wal_size_limit_policy: block`
+
+	cfg, err := UnmarshalConfig(strings.NewReader(cfgText))
+	require.NoError(t, err)
+	require.NoError(t, cfg.ApplyDefaults(DefaultGlobalConfig))
+
+	require.Equal(t, 512*units.MiB, cfg.MaxWALSize)
+	require.Equal(t, wal.SizePolicyBlock, cfg.WALSizeLimitPolicy)
+}
+
 func TestConfig_ApplyDefaults_Validations(t *testing.T) {
 	global := DefaultGlobalConfig
 	cfg := DefaultConfig
@@ -101,6 +117,44 @@ func TestConfig_ApplyDefaults_Validations(t *testing.T) {
 			func(c *Config) { c.RemoteFlushDeadline = 0 },
 			fmt.Errorf("remote_flush_deadline must be greater than 0s"),
 		},
+		{
+			"negative max wal size",
+			func(c *Config) { c.MaxWALSize = -1 },
+			fmt.Errorf("max_wal_size must not be negative"),
+		},
+		{
+			"unknown wal size limit policy",
+			func(c *Config) { c.WALSizeLimitPolicy = "drop_newest" },
+			fmt.Errorf(`unknown wal_size_limit_policy "drop_newest", must be "drop_oldest" or "block"`),
+		},
+		{
+			"unknown scrape offset mode",
+			func(c *Config) { c.ScrapeOffsetMode = "random" },
+			fmt.Errorf(`unknown scrape_offset_mode "random", must be "hashed", "aligned" or "spread"`),
+		},
+		{
+			"aligned scrape offsets",
+			func(c *Config) { c.ScrapeOffsetMode = ScrapeOffsetAligned },
+			nil,
+		},
+		{
+			"spread scrape offsets with metadata",
+			func(c *Config) {
+				c.ScrapeOffsetMode = ScrapeOffsetSpread
+				c.RemoteWrite[0].MetadataConfig.Send = true
+			},
+			fmt.Errorf(`scrape_offset_mode "spread" doesn't support sending metadata, set metadata_config.send to false for remote write config "write"`),
+		},
+		{
+			"negative max active series",
+			func(c *Config) { c.MaxActiveSeries = -1 },
+			fmt.Errorf("max_active_series must not be negative"),
+		},
+		{
+			"negative max samples per second",
+			func(c *Config) { c.MaxSamplesPerSecond = -1 },
+			fmt.Errorf("max_samples_per_second must not be negative"),
+		},
 		{
 			"scrape timeout too high",
 			func(c *Config) { c.ScrapeConfigs[0].ScrapeTimeout = global.Prometheus.ScrapeInterval + 1 },
@@ -135,6 +189,18 @@ func TestConfig_ApplyDefaults_Validations(t *testing.T) {
 			},
 			fmt.Errorf("found duplicate remote write configs with name \"foo\""),
 		},
+		{
+			"route with unknown remote write",
+			func(c *Config) {
+				c.RemoteWriteRoutes = []*router.RouteConfig{{
+					Name:             "route",
+					RemoteWrite:      []string{"unknown"},
+					FailoverAfter:    time.Minute,
+					FailbackInterval: time.Minute,
+				}}
+			},
+			fmt.Errorf(`invalid remote_write_routes: route "route" references unknown remote_write "unknown"`),
+		},
 	}
 
 	for _, tc := range tt {
@@ -199,7 +265,7 @@ func TestInstance_Path(t *testing.T) {
 	cfg.RemoteFlushDeadline = time.Hour
 
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
-	inst, err := New(prometheus.NewRegistry(), cfg, walDir, logger)
+	inst, err := New(prometheus.NewRegistry(), cfg, walDir, nil, nil, logger)
 	require.NoError(t, err)
 	runInstance(t, inst)
 
@@ -263,7 +329,7 @@ func TestInstance_Recreate(t *testing.T) {
 	cfg.RemoteFlushDeadline = time.Hour
 
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
-	inst, err := New(prometheus.NewRegistry(), cfg, walDir, logger)
+	inst, err := New(prometheus.NewRegistry(), cfg, walDir, nil, nil, logger)
 	require.NoError(t, err)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -283,7 +349,7 @@ func TestInstance_Recreate(t *testing.T) {
 
 	// Recreate the instance, no panic should happen.
 	require.NotPanics(t, func() {
-		inst, err := New(prometheus.NewRegistry(), cfg, walDir, logger)
+		inst, err := New(prometheus.NewRegistry(), cfg, walDir, nil, nil, logger)
 		require.NoError(t, err)
 		runInstance(t, inst)
 
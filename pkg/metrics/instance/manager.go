@@ -64,6 +64,9 @@ type ManagedInstance interface {
 	Ready() bool
 	Update(c Config) error
 	TargetsActive() map[string][]*scrape.Target
+	TargetsDropped() map[string][]DroppedTarget
+	TargetOffsets() map[*scrape.Target]time.Duration
+	PreviewScrape(ctx context.Context, job, endpoint string) (*ScrapePreview, error)
 	StorageDirectory() string
 	Appender(ctx context.Context) storage.Appender
 }
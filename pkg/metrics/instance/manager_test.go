@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/go-kit/log"
 	"github.com/prometheus/prometheus/scrape"
@@ -102,6 +103,9 @@ type mockInstance struct {
 	ReadyFunc            func() bool
 	UpdateFunc           func(c Config) error
 	TargetsActiveFunc    func() map[string][]*scrape.Target
+	TargetsDroppedFunc   func() map[string][]DroppedTarget
+	TargetOffsetsFunc    func() map[*scrape.Target]time.Duration
+	PreviewScrapeFunc    func(ctx context.Context, job, endpoint string) (*ScrapePreview, error)
 	StorageDirectoryFunc func() string
 	AppenderFunc         func() storage.Appender
 }
@@ -134,6 +138,27 @@ func (m mockInstance) TargetsActive() map[string][]*scrape.Target {
 	panic("TargetsActiveFunc not provided")
 }
 
+func (m mockInstance) TargetsDropped() map[string][]DroppedTarget {
+	if m.TargetsDroppedFunc != nil {
+		return m.TargetsDroppedFunc()
+	}
+	panic("TargetsDroppedFunc not provided")
+}
+
+func (m mockInstance) TargetOffsets() map[*scrape.Target]time.Duration {
+	if m.TargetOffsetsFunc != nil {
+		return m.TargetOffsetsFunc()
+	}
+	panic("TargetOffsetsFunc not provided")
+}
+
+func (m mockInstance) PreviewScrape(ctx context.Context, job, endpoint string) (*ScrapePreview, error) {
+	if m.PreviewScrapeFunc != nil {
+		return m.PreviewScrapeFunc(ctx, job, endpoint)
+	}
+	panic("PreviewScrapeFunc not provided")
+}
+
 func (m mockInstance) StorageDirectory() string {
 	if m.StorageDirectoryFunc != nil {
 		return m.StorageDirectoryFunc()
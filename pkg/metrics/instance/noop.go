@@ -2,6 +2,7 @@ package instance
 
 import (
 	"context"
+	"time"
 
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/prometheus/prometheus/storage"
@@ -32,6 +33,21 @@ func (NoOpInstance) TargetsActive() map[string][]*scrape.Target {
 	return nil
 }
 
+// TargetsDropped implements Instance.
+func (NoOpInstance) TargetsDropped() map[string][]DroppedTarget {
+	return nil
+}
+
+// TargetOffsets implements Instance.
+func (NoOpInstance) TargetOffsets() map[*scrape.Target]time.Duration {
+	return nil
+}
+
+// PreviewScrape implements Instance.
+func (NoOpInstance) PreviewScrape(_ context.Context, _, _ string) (*ScrapePreview, error) {
+	return nil, ErrTargetNotFound
+}
+
 // StorageDirectory implements Instance.
 func (NoOpInstance) StorageDirectory() string {
 	return ""
@@ -0,0 +1,401 @@
+package instance
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/notifier"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/rules"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/util/strutil"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// DefaultRulesConfig holds default settings for evaluating rules.
+var DefaultRulesConfig = RulesConfig{
+	QueryWindow: 15 * time.Minute,
+}
+
+// RulesConfig configures recording and alerting rules which are evaluated
+// by an instance. Rules are evaluated against a short in-memory window of
+// recently appended samples, and their results are written to the WAL.
+type RulesConfig struct {
+	// How much recent data is kept in memory for evaluating rules.
+	QueryWindow time.Duration `yaml:"query_window,omitempty"`
+
+	Groups   []RuleGroup           `yaml:"groups,omitempty"`
+	Alerting config.AlertingConfig `yaml:"alerting,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *RulesConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultRulesConfig
+
+	type plain RulesConfig
+	return unmarshal((*plain)(c))
+}
+
+// validate validates c.
+func (c *RulesConfig) validate() error {
+	if c.QueryWindow <= 0 {
+		return errors.New("rules query_window must be greater than 0s")
+	}
+	if _, errs := parseRuleGroups(c.Groups); len(errs) > 0 {
+		return fmt.Errorf("invalid rule groups: %w", errs[0])
+	}
+	return nil
+}
+
+// RuleGroup is a group of recording and alerting rules which are evaluated
+// together. It uses the same format as Prometheus rule files.
+type RuleGroup struct {
+	Name     string         `yaml:"name"`
+	Interval model.Duration `yaml:"interval,omitempty"`
+	Limit    int            `yaml:"limit,omitempty"`
+	Rules    []rulefmt.Rule `yaml:"rules"`
+}
+
+// parseRuleGroups converts groups into rulefmt.RuleGroups, validating them
+// the same way Prometheus validates rule files.
+func parseRuleGroups(groups []RuleGroup) (*rulefmt.RuleGroups, []error) {
+	// rulefmt only validates groups decoded from YAML, so groups are
+	// re-encoded first.
+	bb, err := yamlv3.Marshal(struct {
+		Groups []RuleGroup `yaml:"groups"`
+	}{groups})
+	if err != nil {
+		return nil, []error{err}
+	}
+	return rulefmt.Parse(bb)
+}
+
+// ruleGroupLoader implements rules.GroupLoader for rule groups from an
+// instance config.
+type ruleGroupLoader struct {
+	mut    sync.RWMutex
+	groups []RuleGroup
+}
+
+func (l *ruleGroupLoader) SetGroups(groups []RuleGroup) {
+	l.mut.Lock()
+	defer l.mut.Unlock()
+	l.groups = groups
+}
+
+// Load implements rules.GroupLoader. The identifier is ignored, as there is
+// only one set of groups.
+func (l *ruleGroupLoader) Load(_ string) (*rulefmt.RuleGroups, []error) {
+	l.mut.RLock()
+	defer l.mut.RUnlock()
+	return parseRuleGroups(l.groups)
+}
+
+// Parse implements rules.GroupLoader.
+func (l *ruleGroupLoader) Parse(query string) (parser.Expr, error) {
+	return parser.ParseExpr(query)
+}
+
+// ruleEvaluator evaluates the rules of an instance and sends alerts to
+// Alertmanagers.
+type ruleEvaluator struct {
+	logger log.Logger
+	name   string
+
+	recent  *recentStorage
+	loader  *ruleGroupLoader
+	manager *rules.Manager
+
+	notifier          *notifier.Manager
+	notifierDiscovery *discovery.Manager
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newRuleEvaluator creates a new ruleEvaluator. The in-memory window of
+// recent samples is kept in dir. Samples must be written to the storage
+// returned by Recent for rules to see them.
+func newRuleEvaluator(logger log.Logger, reg prometheus.Registerer, dir string, cfg *Config) (*ruleEvaluator, error) {
+	logger = log.With(logger, "component", "rules")
+
+	recent, err := newRecentStorage(logger, dir, cfg.Rules.QueryWindow)
+	if err != nil {
+		return nil, fmt.Errorf("creating in-memory storage for rules: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	re := &ruleEvaluator{
+		logger: logger,
+		name:   cfg.Name,
+		recent: recent,
+		loader: &ruleGroupLoader{},
+
+		notifier: notifier.NewManager(&notifier.Options{
+			QueueCapacity: 10000,
+			Registerer:    reg,
+		}, log.With(logger, "component", "notifier")),
+		notifierDiscovery: discovery.NewManager(ctx, log.With(logger, "component", "notifier discovery"), discovery.Name("notify")),
+
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	return re, nil
+}
+
+// Recent returns the storage holding the in-memory window of recent samples.
+func (re *ruleEvaluator) Recent() storage.Storage { return re.recent }
+
+// Init creates the rule manager, which writes results to app. app should
+// write to the storage returned by Recent so rules can use the results of
+// other rules. Init must be called once before Run or ApplyConfig.
+func (re *ruleEvaluator) Init(reg prometheus.Registerer, app storage.Appendable, cfg *Config) error {
+	evalInterval := time.Duration(cfg.global.Prometheus.EvaluationInterval)
+
+	engine := promql.NewEngine(promql.EngineOpts{
+		Logger:     log.With(re.logger, "component", "query engine"),
+		MaxSamples: 50000000,
+		Timeout:    2 * time.Minute,
+		NoStepSubqueryIntervalFn: func(int64) int64 {
+			return evalInterval.Milliseconds()
+		},
+	})
+
+	re.manager = rules.NewManager(&rules.ManagerOptions{
+		ExternalURL:     &url.URL{},
+		QueryFunc:       rules.EngineQueryFunc(engine, re.recent),
+		NotifyFunc:      sendAlerts(re.notifier),
+		Context:         re.ctx,
+		Appendable:      app,
+		Queryable:       re.recent,
+		Logger:          re.logger,
+		Registerer:      reg,
+		OutageTolerance: time.Hour,
+		ForGracePeriod:  10 * time.Minute,
+		ResendDelay:     time.Minute,
+		GroupLoader:     re.loader,
+	})
+
+	return re.ApplyConfig(cfg)
+}
+
+// ApplyConfig applies the rule groups and alerting settings from cfg.
+func (re *ruleEvaluator) ApplyConfig(cfg *Config) error {
+	global := cfg.global.Prometheus
+
+	err := re.notifier.ApplyConfig(&config.Config{
+		GlobalConfig:   global,
+		AlertingConfig: cfg.Rules.Alerting,
+	})
+	if err != nil {
+		return fmt.Errorf("applying alerting config: %w", err)
+	}
+
+	sdConfigs := map[string]discovery.Configs{}
+	for k, v := range cfg.Rules.Alerting.AlertmanagerConfigs.ToMap() {
+		sdConfigs[k] = v.ServiceDiscoveryConfigs
+	}
+	if err := re.notifierDiscovery.ApplyConfig(sdConfigs); err != nil {
+		return fmt.Errorf("applying alertmanager discovery configs: %w", err)
+	}
+
+	re.loader.SetGroups(cfg.Rules.Groups)
+	err = re.manager.Update(time.Duration(global.EvaluationInterval), []string{re.name}, global.ExternalLabels, "")
+	if err != nil {
+		return fmt.Errorf("applying rule groups: %w", err)
+	}
+	return nil
+}
+
+// Run evaluates rules until Stop is called.
+func (re *ruleEvaluator) Run() error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		_ = re.notifierDiscovery.Run()
+	}()
+	go func() {
+		defer wg.Done()
+		re.notifier.Run(re.notifierDiscovery.SyncCh())
+	}()
+	go func() {
+		defer wg.Done()
+		re.truncateLoop()
+	}()
+
+	re.manager.Run()
+	return nil
+}
+
+// truncateLoop removes samples which are older than the query window from
+// the in-memory storage.
+func (re *ruleEvaluator) truncateLoop() {
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-re.ctx.Done():
+			return
+		case <-t.C:
+			mint := timestamp.FromTime(time.Now().Add(-re.recent.window))
+			if err := re.recent.Truncate(mint); err != nil {
+				level.Warn(re.logger).Log("msg", "failed to truncate in-memory storage", "err", err)
+			}
+		}
+	}
+}
+
+// Stop stops evaluating rules and sending alerts. The in-memory storage is
+// closed separately when the storage it's part of is closed.
+func (re *ruleEvaluator) Stop() {
+	re.manager.Stop()
+	re.notifier.Stop()
+	re.cancel()
+}
+
+// abort releases the resources of a ruleEvaluator which failed to
+// initialize.
+func (re *ruleEvaluator) abort() {
+	re.cancel()
+	_ = re.recent.Close()
+}
+
+// sendAlerts returns a rules.NotifyFunc which sends alerts to n.
+func sendAlerts(n *notifier.Manager) rules.NotifyFunc {
+	return func(_ context.Context, expr string, alerts ...*rules.Alert) {
+		if len(alerts) == 0 {
+			return
+		}
+
+		res := make([]*notifier.Alert, 0, len(alerts))
+		for _, alert := range alerts {
+			a := &notifier.Alert{
+				StartsAt:     alert.FiredAt,
+				Labels:       alert.Labels,
+				Annotations:  alert.Annotations,
+				GeneratorURL: strutil.TableLinkForExpression(expr),
+			}
+			if !alert.ResolvedAt.IsZero() {
+				a.EndsAt = alert.ResolvedAt
+			} else {
+				a.EndsAt = alert.ValidUntil
+			}
+			res = append(res, a)
+		}
+		n.Send(res...)
+	}
+}
+
+// recentStorage holds a window of recently appended samples in memory so
+// they can be queried by rules.
+type recentStorage struct {
+	head   *tsdb.Head
+	dir    string
+	window time.Duration
+}
+
+var _ storage.Storage = (*recentStorage)(nil)
+
+func newRecentStorage(logger log.Logger, dir string, window time.Duration) (*recentStorage, error) {
+	// Data from a previous run can't be recovered without a WAL, so start
+	// from a clean directory.
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+
+	opts := tsdb.DefaultHeadOptions()
+	opts.ChunkDirRoot = dir
+	// Samples are rejected when they're older than half the chunk range
+	// from the newest sample, so make sure the whole window is appendable.
+	opts.ChunkRange = 2 * window.Milliseconds()
+
+	head, err := tsdb.NewHead(nil, logger, nil, opts, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := head.Init(math.MinInt64); err != nil {
+		_ = head.Close()
+		return nil, err
+	}
+
+	return &recentStorage{head: head, dir: dir, window: window}, nil
+}
+
+// Querier implements storage.Queryable.
+func (s *recentStorage) Querier(_ context.Context, mint, maxt int64) (storage.Querier, error) {
+	return tsdb.NewBlockQuerier(tsdb.NewRangeHead(s.head, mint, maxt), mint, maxt)
+}
+
+// ChunkQuerier implements storage.ChunkQueryable.
+func (s *recentStorage) ChunkQuerier(_ context.Context, mint, maxt int64) (storage.ChunkQuerier, error) {
+	return tsdb.NewBlockChunkQuerier(tsdb.NewRangeHead(s.head, mint, maxt), mint, maxt)
+}
+
+// Appender implements storage.Appendable.
+func (s *recentStorage) Appender(ctx context.Context) storage.Appender {
+	return &recentAppender{app: s.head.Appender(ctx)}
+}
+
+// StartTime implements storage.Storage.
+func (s *recentStorage) StartTime() (int64, error) {
+	return s.head.MinTime(), nil
+}
+
+// Truncate removes all samples before mint.
+func (s *recentStorage) Truncate(mint int64) error {
+	return s.head.Truncate(mint)
+}
+
+// Close implements storage.Storage.
+func (s *recentStorage) Close() error {
+	err := s.head.Close()
+	if rmErr := os.RemoveAll(s.dir); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// recentAppender appends to a recentStorage. Keeping recent samples is best
+// effort: samples the head rejects, such as out of order samples, are
+// ignored rather than failing the append to the WAL.
+type recentAppender struct {
+	app storage.Appender
+}
+
+// Append implements storage.Appender. The ref given by the fanout storage
+// belongs to the WAL, so series are always looked up by labels.
+func (a *recentAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	_, _ = a.app.Append(0, l, t, v)
+	return ref, nil
+}
+
+// AppendExemplar implements storage.Appender. Exemplars aren't kept.
+func (a *recentAppender) AppendExemplar(ref storage.SeriesRef, _ labels.Labels, _ exemplar.Exemplar) (storage.SeriesRef, error) {
+	return ref, nil
+}
+
+func (a *recentAppender) Commit() error   { return a.app.Commit() }
+func (a *recentAppender) Rollback() error { return a.app.Rollback() }
@@ -0,0 +1,141 @@
+package instance
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cortexproject/cortex/pkg/util/test"
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestConfig_Unmarshal_Rules(t *testing.T) {
+	cfgText := `name: test
+rules:
+  groups:
+  - name: example
+    interval: 30s
+    rules:
+    - record: job:up:sum
+      expr: sum by (job) (up)
+    - alert: InstanceDown
+      expr: up == 0
+      for: 5m
+  alerting:
+    alertmanagers:
+    - static_configs:
+      - targets: ['localhost:9093']`
+
+	cfg, err := UnmarshalConfig(strings.NewReader(cfgText))
+	require.NoError(t, err)
+	require.NoError(t, cfg.ApplyDefaults(DefaultGlobalConfig))
+
+	require.NotNil(t, cfg.Rules)
+	require.Equal(t, DefaultRulesConfig.QueryWindow, cfg.Rules.QueryWindow)
+	require.Len(t, cfg.Rules.Groups, 1)
+	require.Equal(t, model.Duration(30*time.Second), cfg.Rules.Groups[0].Interval)
+	require.Len(t, cfg.Rules.Groups[0].Rules, 2)
+	require.Len(t, cfg.Rules.Alerting.AlertmanagerConfigs, 1)
+}
+
+func TestConfig_ApplyDefaults_Rules(t *testing.T) {
+	tt := []struct {
+		name   string
+		rules  RulesConfig
+		expect string
+	}{
+		{
+			name:   "missing query window",
+			rules:  RulesConfig{},
+			expect: "rules query_window must be greater than 0s",
+		},
+		{
+			name: "invalid expression",
+			rules: RulesConfig{
+				QueryWindow: time.Minute,
+				Groups: []RuleGroup{{
+					Name:  "example",
+					Rules: []rulefmt.Rule{{Record: "job:up:sum", Expr: "sum(up"}},
+				}},
+			},
+			expect: "invalid rule groups",
+		},
+		{
+			name: "duplicate group",
+			rules: RulesConfig{
+				QueryWindow: time.Minute,
+				Groups: []RuleGroup{
+					{Name: "example", Rules: []rulefmt.Rule{{Record: "job:up:sum", Expr: "sum(up)"}}},
+					{Name: "example", Rules: []rulefmt.Rule{{Record: "job:up:sum", Expr: "sum(up)"}}},
+				},
+			},
+			expect: "invalid rule groups",
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := DefaultConfig
+			cfg.Name = "test"
+			cfg.Rules = &tc.rules
+
+			err := cfg.ApplyDefaults(DefaultGlobalConfig)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expect)
+		})
+	}
+}
+
+// TestInstance_Rules ensures that recording rules are evaluated against
+// scraped samples and that their results are written to the WAL.
+func TestInstance_Rules(t *testing.T) {
+	scrapeAddr, closeSrv := getTestServer(t)
+	defer closeSrv()
+
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	defer os.RemoveAll(walDir)
+
+	globalConfig := getTestGlobalConfig(t)
+	cfg := getTestConfig(t, &globalConfig, scrapeAddr)
+	cfg.WALTruncateFrequency = time.Hour
+	cfg.RemoteFlushDeadline = time.Hour
+
+	var rule rulefmt.Rule
+	require.NoError(t, yaml.Unmarshal([]byte(`{record: "test:metric:sum", expr: "sum(test_metric_total)"}`), &rule))
+	cfg.Rules = &RulesConfig{
+		QueryWindow: time.Minute,
+		Groups: []RuleGroup{{
+			Name:     "test",
+			Interval: model.Duration(100 * time.Millisecond),
+			Rules:    []rulefmt.Rule{rule},
+		}},
+	}
+
+	mockStorage := mockWalStorage{
+		series:    make(map[storage.SeriesRef]int),
+		directory: walDir,
+	}
+	newWal := func(_ prometheus.Registerer) (walStorage, error) { return &mockStorage, nil }
+
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	inst, err := newInstance(cfg, prometheus.NewRegistry(), logger, newWal)
+	require.NoError(t, err)
+	runInstance(t, inst)
+
+	recorded := storage.SeriesRef(labels.FromStrings(model.MetricNameLabel, "test:metric:sum").Hash())
+	test.Poll(t, 30*time.Second, true, func() interface{} {
+		mockStorage.mut.Lock()
+		defer mockStorage.mut.Unlock()
+		return mockStorage.series[recorded] > 0
+	})
+}
@@ -0,0 +1,88 @@
+package instance
+
+import (
+	"fmt"
+	"time"
+
+	agentscrape "github.com/grafana/agent/pkg/metrics/scrape"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/scrape"
+)
+
+// ScrapeOffsetMode determines at which offset within their scrape interval
+// targets are scraped.
+type ScrapeOffsetMode string
+
+// Supported values for ScrapeOffsetMode.
+const (
+	// ScrapeOffsetHashed scrapes targets at an offset derived from the hash of
+	// the target and the external labels, like Prometheus does.
+	ScrapeOffsetHashed ScrapeOffsetMode = "hashed"
+
+	// ScrapeOffsetAligned scrapes all targets at the start of their interval,
+	// aligned to wall-clock boundaries of the interval.
+	ScrapeOffsetAligned ScrapeOffsetMode = "aligned"
+
+	// ScrapeOffsetSpread splits the interval into one slot per agent of the
+	// cluster. Targets are scraped at a hashed offset within the slot of the
+	// agent, picked by its position in the ring.
+	ScrapeOffsetSpread ScrapeOffsetMode = "spread"
+)
+
+// PositionFunc returns the position of the agent among the count agents of
+// its cluster, starting at 0.
+type PositionFunc func() (position, count int)
+
+// scrapeOffsetFunc returns the function computing scrape offsets for mode.
+// position may be nil, in which case the agent is assumed to be alone.
+func scrapeOffsetFunc(mode ScrapeOffsetMode, position PositionFunc) agentscrape.OffsetFunc {
+	switch mode {
+	case ScrapeOffsetAligned:
+		return func(_ *scrape.Target, _, _ time.Duration) time.Duration {
+			return 0
+		}
+
+	case ScrapeOffsetSpread:
+		return func(_ *scrape.Target, interval, hashed time.Duration) time.Duration {
+			idx, count := 0, 1
+			if position != nil {
+				idx, count = position()
+			}
+			if count < 1 || idx < 0 || idx >= count {
+				idx, count = 0, 1
+			}
+
+			slot := interval / time.Duration(count)
+			if slot <= 0 {
+				return hashed
+			}
+			return time.Duration(idx)*slot + hashed%slot
+		}
+
+	default:
+		return nil
+	}
+}
+
+// hashedTargetOffsets returns the offsets of targets scraped by the scrape
+// manager of Prometheus, which was configured with scrapeConfigs and
+// externalLabels.
+func hashedTargetOffsets(targets map[string][]*scrape.Target, scrapeConfigs []*config.ScrapeConfig, externalLabels labels.Labels) (map[*scrape.Target]time.Duration, error) {
+	seed, err := agentscrape.JitterSeed(externalLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	offsets := make(map[*scrape.Target]time.Duration)
+	for _, sc := range scrapeConfigs {
+		for _, t := range targets[sc.JobName] {
+			offset, err := agentscrape.HashedOffset(t, sc, seed)
+			if err != nil {
+				return nil, fmt.Errorf("target %s of job %q: %w", t, sc.JobName, err)
+			}
+			offsets[t] = offset
+		}
+	}
+	return offsets, nil
+}
@@ -0,0 +1,46 @@
+package instance
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestScrapeOffsetFunc(t *testing.T) {
+	const (
+		interval = time.Minute
+		hashed   = 35 * time.Second
+	)
+
+	t.Run("hashed", func(t *testing.T) {
+		require.Nil(t, scrapeOffsetFunc("", nil))
+		require.Nil(t, scrapeOffsetFunc(ScrapeOffsetHashed, nil))
+	})
+
+	t.Run("aligned", func(t *testing.T) {
+		offset := scrapeOffsetFunc(ScrapeOffsetAligned, nil)
+		require.Equal(t, time.Duration(0), offset(nil, interval, hashed))
+	})
+
+	t.Run("spread", func(t *testing.T) {
+		var position, count int
+		offset := scrapeOffsetFunc(ScrapeOffsetSpread, func() (int, int) {
+			return position, count
+		})
+
+		// Each of the 4 agents gets a 15s slot, with targets hashed within the
+		// slot.
+		position, count = 0, 4
+		require.Equal(t, 5*time.Second, offset(nil, interval, hashed))
+		position, count = 2, 4
+		require.Equal(t, 35*time.Second, offset(nil, interval, hashed))
+		position, count = 3, 4
+		require.Equal(t, 50*time.Second, offset(nil, interval, hashed))
+
+		// Agents which aren't part of a ring are alone.
+		position, count = 0, 0
+		require.Equal(t, hashed, offset(nil, interval, hashed))
+		require.Equal(t, hashed, scrapeOffsetFunc(ScrapeOffsetSpread, nil)(nil, interval, hashed))
+	})
+}
@@ -0,0 +1,286 @@
+package instance
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/scrape"
+)
+
+// ErrTargetNotFound is returned by PreviewScrape when the requested target is
+// not one of the active targets of the instance.
+var ErrTargetNotFound = errors.New("target not found")
+
+// scrapeAcceptHeader matches the Accept header sent by the scrape manager.
+const scrapeAcceptHeader = `application/openmetrics-text;version=1.0.0,application/openmetrics-text;version=0.0.1;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1`
+
+// DroppedTarget is a target which was dropped during relabeling.
+type DroppedTarget struct {
+	// DiscoveredLabels is the label set of the target before relabeling.
+	DiscoveredLabels labels.Labels
+
+	// RelabelIndex is the index of the rule in the job's relabel_configs which
+	// dropped the target, or -1 if the rule could not be determined.
+	RelabelIndex int
+
+	// RelabelConfig is the rule which dropped the target. It is nil when
+	// RelabelIndex is -1.
+	RelabelConfig *relabel.Config
+}
+
+// droppedTargets resolves the relabel rule which dropped each target in tgs.
+// tgs is keyed by job name, as returned by the scrape manager.
+func droppedTargets(tgs map[string][]*scrape.Target, scs []*config.ScrapeConfig) map[string][]DroppedTarget {
+	jobs := make(map[string]*config.ScrapeConfig, len(scs))
+	for _, sc := range scs {
+		jobs[sc.JobName] = sc
+	}
+
+	res := make(map[string][]DroppedTarget, len(tgs))
+	for job, targets := range tgs {
+		var rcs []*relabel.Config
+		if sc, ok := jobs[job]; ok {
+			rcs = sc.RelabelConfigs
+		}
+
+		dropped := make([]DroppedTarget, 0, len(targets))
+		for _, t := range targets {
+			dt := DroppedTarget{
+				DiscoveredLabels: t.DiscoveredLabels(),
+				RelabelIndex:     droppingRule(t.DiscoveredLabels(), rcs),
+			}
+			if dt.RelabelIndex >= 0 {
+				dt.RelabelConfig = rcs[dt.RelabelIndex]
+			}
+			dropped = append(dropped, dt)
+		}
+		res[job] = dropped
+	}
+	return res
+}
+
+// droppingRule applies rcs to lset one rule at a time and returns the index of
+// the first rule which drops lset. Returns -1 if no rule drops lset.
+func droppingRule(lset labels.Labels, rcs []*relabel.Config) int {
+	for i, rc := range rcs {
+		lset = relabel.Process(lset, rc)
+		if lset == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// ScrapePreview is the result of scraping a target once outside of the scrape
+// loop.
+type ScrapePreview struct {
+	// Series holds the samples which would have been appended to the WAL.
+	Series []PreviewSample
+
+	// Dropped is the number of samples dropped by metric_relabel_configs.
+	Dropped int
+}
+
+// PreviewSample is an individual sample returned by a scrape preview.
+type PreviewSample struct {
+	Labels    labels.Labels
+	Timestamp int64
+	Value     float64
+}
+
+// PreviewScrape scrapes the active target of job whose scrape URL is endpoint
+// once and applies the job's metric_relabel_configs to the result. Nothing is
+// written to the WAL. Returns ErrTargetNotFound if there is no such target.
+func (i *Instance) PreviewScrape(ctx context.Context, job, endpoint string) (*ScrapePreview, error) {
+	mgr := i.scrapeManager("active")
+	if mgr == nil {
+		return nil, ErrNotReady
+	}
+
+	var target *scrape.Target
+	for _, t := range mgr.TargetsActive()[job] {
+		if t.URL().String() == endpoint {
+			target = t
+			break
+		}
+	}
+	if target == nil {
+		return nil, ErrTargetNotFound
+	}
+
+	var sc *config.ScrapeConfig
+	i.mut.Lock()
+	for _, c := range i.cfg.ScrapeConfigs {
+		if c.JobName == job {
+			sc = c
+			break
+		}
+	}
+	i.mut.Unlock()
+	if sc == nil {
+		return nil, ErrTargetNotFound
+	}
+
+	return previewScrape(ctx, sc, target)
+}
+
+func previewScrape(ctx context.Context, sc *config.ScrapeConfig, target *scrape.Target) (*ScrapePreview, error) {
+	client, err := config_util.NewClientFromConfig(sc.HTTPClientConfig, sc.JobName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create scrape client: %w", err)
+	}
+	defer client.CloseIdleConnections()
+
+	timeout := time.Duration(sc.ScrapeTimeout)
+	if d, err := model.ParseDuration(target.GetValue(model.ScrapeTimeoutLabel)); err == nil && d > 0 {
+		timeout = time.Duration(d)
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.URL().String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", scrapeAcceptHeader)
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("User-Agent", scrape.UserAgent)
+	req.Header.Set("X-Prometheus-Scrape-Timeout-Seconds", strconv.FormatFloat(timeout.Seconds(), 'f', -1, 64))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("server returned HTTP status %s", resp.Status)
+	}
+
+	var body io.Reader = resp.Body
+	if resp.Header.Get("Content-Encoding") == "gzip" {
+		gzr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress scrape response: %w", err)
+		}
+		defer gzr.Close()
+		body = gzr
+	}
+
+	limit := int64(sc.BodySizeLimit)
+	if limit <= 0 {
+		limit = math.MaxInt64
+	}
+	b, err := io.ReadAll(io.LimitReader(body, limit))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) >= limit {
+		return nil, fmt.Errorf("body size limit of %s exceeded", sc.BodySizeLimit)
+	}
+
+	// Like the scrape loop, fall back to the Prometheus text format when the
+	// content type can't be parsed; textparse.New always returns a parser.
+	p, _ := textparse.New(b, resp.Header.Get("Content-Type"))
+
+	var (
+		preview = &ScrapePreview{}
+		defTime = timestamp.FromTime(start)
+	)
+	for {
+		et, err := p.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse scrape response: %w", err)
+		}
+		if et != textparse.EntrySeries {
+			continue
+		}
+
+		_, tp, v := p.Series()
+		t := defTime
+		if tp != nil && sc.HonorTimestamps {
+			t = *tp
+		}
+
+		var lset labels.Labels
+		p.Metric(&lset)
+
+		lset = mutateSampleLabels(lset, target, sc.HonorLabels, sc.MetricRelabelConfigs)
+		if len(lset) == 0 {
+			preview.Dropped++
+			continue
+		}
+		preview.Series = append(preview.Series, PreviewSample{
+			Labels:    lset,
+			Timestamp: t,
+			Value:     v,
+		})
+	}
+	return preview, nil
+}
+
+// mutateSampleLabels attaches the target labels to a scraped sample and applies
+// the metric relabel rules, the same way the scrape loop does.
+func mutateSampleLabels(lset labels.Labels, target *scrape.Target, honor bool, rc []*relabel.Config) labels.Labels {
+	lb := labels.NewBuilder(lset)
+	targetLabels := target.Labels()
+
+	if honor {
+		for _, l := range targetLabels {
+			if !lset.Has(l.Name) {
+				lb.Set(l.Name, l.Value)
+			}
+		}
+	} else {
+		var conflicting labels.Labels
+		for _, l := range targetLabels {
+			if v := lset.Get(l.Name); v != "" {
+				conflicting = append(conflicting, labels.Label{Name: l.Name, Value: v})
+			}
+			lb.Set(l.Name, l.Value)
+		}
+
+		// Exposed labels which conflict with target labels are kept under an
+		// exported_ prefix.
+		sort.SliceStable(conflicting, func(i, j int) bool {
+			return len(conflicting[i].Name) < len(conflicting[j].Name)
+		})
+		for i, l := range conflicting {
+			name := model.ExportedLabelPrefix + l.Name
+			for lset.Has(name) || targetLabels.Has(name) || conflicting[:i].Has(name) {
+				name = model.ExportedLabelPrefix + name
+			}
+			conflicting[i].Name = name
+		}
+		for _, l := range conflicting {
+			lb.Set(l.Name, l.Value)
+		}
+	}
+
+	res := lb.Labels()
+	if len(rc) > 0 {
+		res = relabel.Process(res, rc...)
+	}
+	return res
+}
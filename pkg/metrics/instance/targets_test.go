@@ -0,0 +1,152 @@
+package instance
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func Test_droppedTargets(t *testing.T) {
+	var rcs []*relabel.Config
+	err := yaml.Unmarshal([]byte(`
+- source_labels: [__meta_env]
+  target_label: env
+- source_labels: [env]
+  regex: dev
+  action: drop
+- source_labels: [__meta_keep]
+  regex: "true"
+  action: keep
+`), &rcs)
+	require.NoError(t, err)
+
+	sc := config.DefaultScrapeConfig
+	sc.JobName = "job"
+	sc.RelabelConfigs = rcs
+
+	var (
+		devTarget = scrape.NewTarget(nil, labels.FromStrings(
+			model.AddressLabel, "dev:80",
+			"__meta_env", "dev",
+			"__meta_keep", "true",
+		), nil)
+		unkeptTarget = scrape.NewTarget(nil, labels.FromStrings(
+			model.AddressLabel, "prod:80",
+			"__meta_env", "prod",
+		), nil)
+		unknownTarget = scrape.NewTarget(nil, labels.FromStrings(
+			model.AddressLabel, "other:80",
+		), nil)
+	)
+
+	dropped := droppedTargets(map[string][]*scrape.Target{
+		"job":     {devTarget, unkeptTarget},
+		"removed": {unknownTarget},
+	}, []*config.ScrapeConfig{&sc})
+
+	require.Len(t, dropped["job"], 2)
+	require.Equal(t, devTarget.DiscoveredLabels(), dropped["job"][0].DiscoveredLabels)
+	require.Equal(t, 1, dropped["job"][0].RelabelIndex)
+	require.Equal(t, rcs[1], dropped["job"][0].RelabelConfig)
+	require.Equal(t, 2, dropped["job"][1].RelabelIndex)
+	require.Equal(t, rcs[2], dropped["job"][1].RelabelConfig)
+
+	// Targets from jobs which no longer exist can't be resolved.
+	require.Equal(t, []DroppedTarget{{
+		DiscoveredLabels: unknownTarget.DiscoveredLabels(),
+		RelabelIndex:     -1,
+	}}, dropped["removed"])
+}
+
+func Test_previewScrape(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NotEmpty(t, r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"))
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_, _ = w.Write([]byte(`# TYPE requests_total counter
+requests_total{code="200",job="exposed"} 10 1000
+requests_total{code="500"} 2
+debug_info 1
+`))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	var mrcs []*relabel.Config
+	err = yaml.Unmarshal([]byte(`
+- source_labels: [__name__]
+  regex: debug_.*
+  action: drop
+`), &mrcs)
+	require.NoError(t, err)
+
+	sc := config.DefaultScrapeConfig
+	sc.JobName = "job"
+	sc.ScrapeTimeout = model.Duration(5 * time.Second)
+	sc.MetricRelabelConfigs = mrcs
+
+	target := scrape.NewTarget(labels.FromStrings(
+		model.AddressLabel, u.Host,
+		model.SchemeLabel, "http",
+		model.MetricsPathLabel, "/metrics",
+		model.JobLabel, "job",
+		model.InstanceLabel, u.Host,
+	), nil, nil)
+
+	preview, err := previewScrape(context.Background(), &sc, target)
+	require.NoError(t, err)
+	require.Equal(t, 1, preview.Dropped)
+	require.Len(t, preview.Series, 2)
+
+	require.Equal(t, labels.FromStrings(
+		model.MetricNameLabel, "requests_total",
+		"code", "200",
+		"exported_job", "exposed",
+		model.JobLabel, "job",
+		model.InstanceLabel, u.Host,
+	), preview.Series[0].Labels)
+	require.Equal(t, int64(1000), preview.Series[0].Timestamp)
+	require.Equal(t, float64(10), preview.Series[0].Value)
+
+	require.Equal(t, labels.FromStrings(
+		model.MetricNameLabel, "requests_total",
+		"code", "500",
+		model.JobLabel, "job",
+		model.InstanceLabel, u.Host,
+	), preview.Series[1].Labels)
+	require.Equal(t, float64(2), preview.Series[1].Value)
+}
+
+func Test_previewScrape_Error(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	sc := config.DefaultScrapeConfig
+	sc.JobName = "job"
+
+	target := scrape.NewTarget(labels.FromStrings(
+		model.AddressLabel, u.Host,
+		model.SchemeLabel, "http",
+		model.MetricsPathLabel, "/metrics",
+	), nil, nil)
+
+	_, err = previewScrape(context.Background(), &sc, target)
+	require.EqualError(t, err, "server returned HTTP status 500 Internal Server Error")
+}
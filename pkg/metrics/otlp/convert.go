@@ -0,0 +1,436 @@
+// Package otlp converts OTLP metrics into Prometheus series so they can be
+// written to the WAL of a metrics instance.
+package otlp
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/storage"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+// DefaultStateTTL is how long a Converter remembers the running totals of
+// delta series which haven't received any points.
+const DefaultStateTTL = 15 * time.Minute
+
+// Resource attributes mapped to the job and instance labels, following the
+// OpenTelemetry compatibility guidelines for Prometheus.
+const (
+	attrServiceName       = "service.name"
+	attrServiceNamespace  = "service.namespace"
+	attrServiceInstanceID = "service.instance.id"
+)
+
+// ErrUnsupported is returned (wrapped) when metrics of unsupported types were
+// dropped. The remaining metrics are still appended.
+var ErrUnsupported = errors.New("unsupported metric type")
+
+// Converter converts OTLP metrics into Prometheus samples. Points of delta
+// sums and delta histograms are added to running totals kept by the
+// Converter, so the same Converter must be used for all requests writing to
+// the same storage.
+//
+// Converter is safe for concurrent use.
+type Converter struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mut    sync.Mutex
+	series map[string]*deltaState
+	lastGC time.Time
+}
+
+// deltaState holds the running total of a delta series.
+type deltaState struct {
+	lastSeen time.Time
+	lastTS   pdata.Timestamp
+
+	value float64
+
+	// Fields below are only used by histograms.
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (s *deltaState) clone() *deltaState {
+	res := *s
+	res.bounds = append([]float64(nil), s.bounds...)
+	res.buckets = append([]uint64(nil), s.buckets...)
+	return &res
+}
+
+// NewConverter creates a new Converter. Running totals of delta series are
+// forgotten after not receiving points for ttl. DefaultStateTTL is used if
+// ttl is 0.
+func NewConverter(ttl time.Duration) *Converter {
+	if ttl == 0 {
+		ttl = DefaultStateTTL
+	}
+	return &Converter{
+		ttl:    ttl,
+		now:    time.Now,
+		series: make(map[string]*deltaState),
+	}
+}
+
+// Write converts md into Prometheus samples, appends them to app and commits
+// app. app is rolled back if appending fails. Running totals of delta series
+// are only updated once app was committed, so a failed request can be
+// retried.
+//
+// Gauges and cumulative sums are converted as-is. Delta sums and histograms
+// are converted to cumulative series. Monotonic sums have a _total suffix
+// appended to their name. Histograms produce _bucket, _sum and _count series,
+// and summaries produce series for each quantile, _sum and _count.
+// Exponential histograms aren't supported and are dropped; the remaining
+// metrics are still committed and an *UnsupportedError is returned.
+//
+// Resource attributes are added as labels to every series of the resource,
+// with service.name and service.instance.id also setting the job and instance
+// labels. Data point attributes take precedence over resource attributes.
+func (c *Converter) Write(app storage.Appender, md pdata.Metrics) error {
+	// Writes are serialized so running totals are always computed from
+	// committed state.
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	now := c.now()
+	if now.Sub(c.lastGC) >= c.ttl {
+		c.gc(now)
+		c.lastGC = now
+	}
+
+	var (
+		pending     = make(map[string]*deltaState)
+		unsupported []string
+	)
+
+	rms := md.ResourceMetrics()
+	for i := 0; i < rms.Len(); i++ {
+		rm := rms.At(i)
+		resLabels := resourceLabels(rm.Resource())
+
+		ilms := rm.InstrumentationLibraryMetrics()
+		for j := 0; j < ilms.Len(); j++ {
+			ms := ilms.At(j).Metrics()
+			for k := 0; k < ms.Len(); k++ {
+				m := ms.At(k)
+				conv := &metricConverter{c: c, app: app, now: now, pending: pending, resLabels: resLabels, name: MetricName(m.Name())}
+
+				var err error
+				switch m.DataType() {
+				case pdata.MetricDataTypeGauge:
+					err = conv.numbers(m.Gauge().DataPoints(), false)
+				case pdata.MetricDataTypeSum:
+					sum := m.Sum()
+					if sum.IsMonotonic() && !strings.HasSuffix(conv.name, "_total") {
+						conv.name += "_total"
+					}
+					err = conv.numbers(sum.DataPoints(), sum.AggregationTemporality() == pdata.MetricAggregationTemporalityDelta)
+				case pdata.MetricDataTypeHistogram:
+					hist := m.Histogram()
+					err = conv.histograms(hist.DataPoints(), hist.AggregationTemporality() == pdata.MetricAggregationTemporalityDelta)
+				case pdata.MetricDataTypeSummary:
+					err = conv.summaries(m.Summary().DataPoints())
+				default:
+					unsupported = append(unsupported, m.Name())
+				}
+				if err != nil {
+					_ = app.Rollback()
+					return err
+				}
+			}
+		}
+	}
+
+	if err := app.Commit(); err != nil {
+		return err
+	}
+	for key, s := range pending {
+		c.series[key] = s
+	}
+
+	if len(unsupported) > 0 {
+		return &UnsupportedError{Metrics: unsupported}
+	}
+	return nil
+}
+
+// gc removes the state of delta series which haven't been seen for the TTL.
+func (c *Converter) gc(now time.Time) {
+	for key, s := range c.series {
+		if now.Sub(s.lastSeen) > c.ttl {
+			delete(c.series, key)
+		}
+	}
+}
+
+// state returns the running total for the series with the given labels,
+// staged in pending until the request is committed. ok is false if ts isn't
+// newer than the last point added to the series, in which case the point
+// must be dropped.
+func (c *Converter) state(pending map[string]*deltaState, lbls labels.Labels, ts pdata.Timestamp, now time.Time) (s *deltaState, ok bool) {
+	key := lbls.String()
+	s, found := pending[key]
+	if !found {
+		if committed, ok := c.series[key]; ok {
+			s = committed.clone()
+		} else {
+			s = &deltaState{}
+		}
+		pending[key] = s
+	}
+	if s.lastTS != 0 && ts <= s.lastTS {
+		return s, false
+	}
+	s.lastSeen, s.lastTS = now, ts
+	return s, true
+}
+
+// UnsupportedError is returned by Converter.Write when metrics of
+// unsupported types were dropped.
+type UnsupportedError struct {
+	Metrics []string
+}
+
+// Error implements error.
+func (e *UnsupportedError) Error() string {
+	return ErrUnsupported.Error() + ": dropped metrics " + strings.Join(e.Metrics, ", ")
+}
+
+// Unwrap returns ErrUnsupported.
+func (e *UnsupportedError) Unwrap() error { return ErrUnsupported }
+
+// metricConverter converts the points of a single metric.
+type metricConverter struct {
+	c         *Converter
+	app       storage.Appender
+	now       time.Time
+	pending   map[string]*deltaState
+	resLabels map[string]string
+	name      string
+}
+
+func (mc *metricConverter) numbers(points pdata.NumberDataPointSlice, delta bool) error {
+	for i := 0; i < points.Len(); i++ {
+		pt := points.At(i)
+		lbls := mc.labels(pt.Attributes(), mc.name)
+
+		var v float64
+		switch pt.ValueType() {
+		case pdata.MetricValueTypeInt:
+			v = float64(pt.IntVal())
+		default:
+			v = pt.DoubleVal()
+		}
+
+		if pt.Flags().HasFlag(pdata.MetricDataPointFlagNoRecordedValue) {
+			v = math.Float64frombits(value.StaleNaN)
+		} else if delta {
+			s, ok := mc.c.state(mc.pending, lbls, pt.Timestamp(), mc.now)
+			if !ok {
+				continue
+			}
+			s.value += v
+			v = s.value
+		}
+
+		if err := mc.append(lbls, pt.Timestamp(), v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mc *metricConverter) histograms(points pdata.HistogramDataPointSlice, delta bool) error {
+	for i := 0; i < points.Len(); i++ {
+		pt := points.At(i)
+		var (
+			ts      = pt.Timestamp()
+			bounds  = pt.ExplicitBounds()
+			buckets = pt.BucketCounts()
+			count   = pt.Count()
+			sum     = pt.Sum()
+			stale   = pt.Flags().HasFlag(pdata.MetricDataPointFlagNoRecordedValue)
+		)
+
+		if delta && !stale {
+			s, ok := mc.c.state(mc.pending, mc.labels(pt.Attributes(), mc.name+"_count"), ts, mc.now)
+			if !ok {
+				continue
+			}
+			// Changing the bucket layout resets the running totals.
+			if !equalBounds(s.bounds, bounds) || len(s.buckets) != len(buckets) {
+				s.bounds = append([]float64(nil), bounds...)
+				s.buckets = make([]uint64, len(buckets))
+				s.count, s.sum = 0, 0
+			}
+			for b := range buckets {
+				s.buckets[b] += buckets[b]
+			}
+			s.count += count
+			s.sum += sum
+			buckets, count, sum = s.buckets, s.count, s.sum
+		}
+
+		var cumulative uint64
+		for b, bound := range bounds {
+			if b < len(buckets) {
+				cumulative += buckets[b]
+			}
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			lbls := mc.labels(pt.Attributes(), mc.name+"_bucket", model.BucketLabel, le)
+			if err := mc.appendPoint(lbls, ts, float64(cumulative), stale); err != nil {
+				return err
+			}
+		}
+
+		inf := mc.labels(pt.Attributes(), mc.name+"_bucket", model.BucketLabel, "+Inf")
+		if err := mc.appendPoint(inf, ts, float64(count), stale); err != nil {
+			return err
+		}
+		if err := mc.appendPoint(mc.labels(pt.Attributes(), mc.name+"_sum"), ts, sum, stale); err != nil {
+			return err
+		}
+		if err := mc.appendPoint(mc.labels(pt.Attributes(), mc.name+"_count"), ts, float64(count), stale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mc *metricConverter) summaries(points pdata.SummaryDataPointSlice) error {
+	for i := 0; i < points.Len(); i++ {
+		var (
+			pt    = points.At(i)
+			ts    = pt.Timestamp()
+			stale = pt.Flags().HasFlag(pdata.MetricDataPointFlagNoRecordedValue)
+		)
+
+		qs := pt.QuantileValues()
+		for q := 0; q < qs.Len(); q++ {
+			quantile := strconv.FormatFloat(qs.At(q).Quantile(), 'g', -1, 64)
+			lbls := mc.labels(pt.Attributes(), mc.name, model.QuantileLabel, quantile)
+			if err := mc.appendPoint(lbls, ts, qs.At(q).Value(), stale); err != nil {
+				return err
+			}
+		}
+		if err := mc.appendPoint(mc.labels(pt.Attributes(), mc.name+"_sum"), ts, pt.Sum(), stale); err != nil {
+			return err
+		}
+		if err := mc.appendPoint(mc.labels(pt.Attributes(), mc.name+"_count"), ts, float64(pt.Count()), stale); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mc *metricConverter) appendPoint(lbls labels.Labels, ts pdata.Timestamp, v float64, stale bool) error {
+	if stale {
+		v = math.Float64frombits(value.StaleNaN)
+	}
+	return mc.append(lbls, ts, v)
+}
+
+func (mc *metricConverter) append(lbls labels.Labels, ts pdata.Timestamp, v float64) error {
+	_, err := mc.app.Append(0, lbls, int64(ts)/int64(time.Millisecond), v)
+	return err
+}
+
+// labels builds the labels of a series named name. extra holds additional
+// label name and value pairs, which take precedence over attributes.
+func (mc *metricConverter) labels(attrs pdata.AttributeMap, name string, extra ...string) labels.Labels {
+	m := make(map[string]string, len(mc.resLabels)+attrs.Len()+len(extra)/2+1)
+	for k, v := range mc.resLabels {
+		m[k] = v
+	}
+	attrs.Range(func(k string, v pdata.AttributeValue) bool {
+		m[LabelName(k)] = v.AsString()
+		return true
+	})
+	for i := 0; i+1 < len(extra); i += 2 {
+		m[extra[i]] = extra[i+1]
+	}
+	m[model.MetricNameLabel] = name
+	return labels.FromMap(m)
+}
+
+// resourceLabels converts the attributes of res into labels.
+func resourceLabels(res pdata.Resource) map[string]string {
+	attrs := res.Attributes()
+	m := make(map[string]string, attrs.Len()+2)
+	attrs.Range(func(k string, v pdata.AttributeValue) bool {
+		m[LabelName(k)] = v.AsString()
+		return true
+	})
+
+	if name, ok := attrs.Get(attrServiceName); ok {
+		job := name.AsString()
+		if ns, ok := attrs.Get(attrServiceNamespace); ok && ns.AsString() != "" {
+			job = ns.AsString() + "/" + job
+		}
+		m[model.JobLabel] = job
+	}
+	if id, ok := attrs.Get(attrServiceInstanceID); ok {
+		m[model.InstanceLabel] = id.AsString()
+	}
+	return m
+}
+
+func equalBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MetricName converts an OTLP metric name into a valid Prometheus metric
+// name by replacing invalid characters with underscores.
+func MetricName(name string) string {
+	return sanitize(name, true)
+}
+
+// LabelName converts an OTLP attribute key into a valid Prometheus label
+// name by replacing invalid characters with underscores.
+func LabelName(key string) string {
+	return sanitize(key, false)
+}
+
+func sanitize(s string, allowColons bool) string {
+	if s == "" {
+		return "_"
+	}
+
+	var sb strings.Builder
+	sb.Grow(len(s) + 1)
+	for i, r := range s {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || r == '_' || (allowColons && r == ':')):
+			sb.WriteRune(r)
+		case r < unicode.MaxASCII && unicode.IsDigit(r):
+			if i == 0 {
+				sb.WriteByte('_')
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+	return sb.String()
+}
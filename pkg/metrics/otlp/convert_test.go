@@ -0,0 +1,290 @@
+package otlp
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/pdata"
+)
+
+func TestConverter_Gauge(t *testing.T) {
+	md, m := newTestMetric("cpu.usage", pdata.MetricDataTypeGauge)
+	pt := m.Gauge().DataPoints().AppendEmpty()
+	pt.SetTimestamp(testTimestamp(1))
+	pt.SetDoubleVal(0.5)
+	pt.Attributes().InsertString("cpu", "0")
+
+	app := &collectingAppender{}
+	require.NoError(t, NewConverter(0).Write(app, md))
+	require.Equal(t, []sample{{
+		lbls: labels.FromStrings(
+			"__name__", "cpu_usage",
+			"cpu", "0",
+			"instance", "host-1",
+			"job", "ns/svc",
+			"service_instance_id", "host-1",
+			"service_name", "svc",
+			"service_namespace", "ns",
+		),
+		t: 1000,
+		v: 0.5,
+	}}, app.samples)
+}
+
+func TestConverter_CumulativeSum(t *testing.T) {
+	md, m := newTestMetric("requests", pdata.MetricDataTypeSum)
+	sum := m.Sum()
+	sum.SetIsMonotonic(true)
+	sum.SetAggregationTemporality(pdata.MetricAggregationTemporalityCumulative)
+	pt := sum.DataPoints().AppendEmpty()
+	pt.SetTimestamp(testTimestamp(1))
+	pt.SetIntVal(10)
+
+	app := &collectingAppender{}
+	require.NoError(t, NewConverter(0).Write(app, md))
+	require.Len(t, app.samples, 1)
+	require.Equal(t, "requests_total", app.samples[0].lbls.Get("__name__"))
+	require.Equal(t, float64(10), app.samples[0].v)
+}
+
+func TestConverter_DeltaSum(t *testing.T) {
+	c := NewConverter(0)
+	app := &collectingAppender{}
+
+	for i, v := range []int64{3, 4, 5} {
+		md, m := newTestMetric("requests_total", pdata.MetricDataTypeSum)
+		sum := m.Sum()
+		sum.SetIsMonotonic(true)
+		sum.SetAggregationTemporality(pdata.MetricAggregationTemporalityDelta)
+		pt := sum.DataPoints().AppendEmpty()
+		pt.SetTimestamp(testTimestamp(i + 1))
+		pt.SetIntVal(v)
+		require.NoError(t, c.Write(app, md))
+	}
+
+	// A point which isn't newer than the last one is dropped.
+	md, m := newTestMetric("requests_total", pdata.MetricDataTypeSum)
+	m.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityDelta)
+	m.Sum().SetIsMonotonic(true)
+	pt := m.Sum().DataPoints().AppendEmpty()
+	pt.SetTimestamp(testTimestamp(2))
+	pt.SetIntVal(100)
+	require.NoError(t, c.Write(app, md))
+
+	require.Equal(t, []float64{3, 7, 12}, app.values())
+}
+
+func TestConverter_DeltaSum_FailedCommit(t *testing.T) {
+	c := NewConverter(0)
+	app := &collectingAppender{}
+
+	newDelta := func(ts int, v int64) pdata.Metrics {
+		md, m := newTestMetric("requests_total", pdata.MetricDataTypeSum)
+		m.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityDelta)
+		pt := m.Sum().DataPoints().AppendEmpty()
+		pt.SetTimestamp(testTimestamp(ts))
+		pt.SetIntVal(v)
+		return md
+	}
+
+	require.NoError(t, c.Write(app, newDelta(1, 3)))
+
+	app.commitErr = errors.New("commit failed")
+	require.Error(t, c.Write(app, newDelta(2, 4)))
+
+	// Retrying the failed request must not skip or double count its points.
+	app.commitErr = nil
+	require.NoError(t, c.Write(app, newDelta(2, 4)))
+	require.Equal(t, []float64{3, 7}, app.values())
+}
+
+func TestConverter_DeltaSum_Expiry(t *testing.T) {
+	now := time.Now()
+	c := NewConverter(time.Minute)
+	c.now = func() time.Time { return now }
+
+	appendDelta := func(app storage.Appender, ts int, v int64) {
+		md, m := newTestMetric("requests_total", pdata.MetricDataTypeSum)
+		m.Sum().SetAggregationTemporality(pdata.MetricAggregationTemporalityDelta)
+		pt := m.Sum().DataPoints().AppendEmpty()
+		pt.SetTimestamp(testTimestamp(ts))
+		pt.SetIntVal(v)
+		require.NoError(t, c.Write(app, md))
+	}
+
+	app := &collectingAppender{}
+	appendDelta(app, 1, 5)
+	now = now.Add(2 * time.Minute)
+	appendDelta(app, 2, 5)
+
+	// The running total was forgotten, so the series starts over.
+	require.Equal(t, []float64{5, 5}, app.values())
+}
+
+func TestConverter_Histogram(t *testing.T) {
+	c := NewConverter(0)
+	app := &collectingAppender{}
+
+	for i := 1; i <= 2; i++ {
+		md, m := newTestMetric("latency", pdata.MetricDataTypeHistogram)
+		m.Histogram().SetAggregationTemporality(pdata.MetricAggregationTemporalityDelta)
+		pt := m.Histogram().DataPoints().AppendEmpty()
+		pt.SetTimestamp(testTimestamp(i))
+		pt.SetExplicitBounds([]float64{0.1, 1})
+		pt.SetBucketCounts([]uint64{1, 2, 3})
+		pt.SetCount(6)
+		pt.SetSum(10)
+		require.NoError(t, c.Write(app, md))
+	}
+
+	last := app.byName(2000)
+	require.Equal(t, map[string]float64{
+		`latency_bucket{le="0.1"}`:  2,
+		`latency_bucket{le="1"}`:    6,
+		`latency_bucket{le="+Inf"}`: 12,
+		`latency_sum`:               20,
+		`latency_count`:             12,
+	}, last)
+}
+
+func TestConverter_Summary(t *testing.T) {
+	md, m := newTestMetric("latency", pdata.MetricDataTypeSummary)
+	pt := m.Summary().DataPoints().AppendEmpty()
+	pt.SetTimestamp(testTimestamp(1))
+	pt.SetCount(4)
+	pt.SetSum(2)
+	q := pt.QuantileValues().AppendEmpty()
+	q.SetQuantile(0.5)
+	q.SetValue(0.25)
+
+	app := &collectingAppender{}
+	require.NoError(t, NewConverter(0).Write(app, md))
+	require.Equal(t, map[string]float64{
+		`latency{quantile="0.5"}`: 0.25,
+		`latency_sum`:             2,
+		`latency_count`:           4,
+	}, app.byName(1000))
+}
+
+func TestConverter_NoRecordedValue(t *testing.T) {
+	md, m := newTestMetric("cpu", pdata.MetricDataTypeGauge)
+	pt := m.Gauge().DataPoints().AppendEmpty()
+	pt.SetTimestamp(testTimestamp(1))
+	pt.SetFlags(pdata.NewMetricDataPointFlags(pdata.MetricDataPointFlagNoRecordedValue))
+
+	app := &collectingAppender{}
+	require.NoError(t, NewConverter(0).Write(app, md))
+	require.Len(t, app.samples, 1)
+	require.True(t, value.IsStaleNaN(app.samples[0].v))
+}
+
+func TestConverter_Unsupported(t *testing.T) {
+	md, m := newTestMetric("exp", pdata.MetricDataTypeExponentialHistogram)
+	m.ExponentialHistogram().DataPoints().AppendEmpty()
+
+	app := &collectingAppender{}
+	err := NewConverter(0).Write(app, md)
+	require.ErrorIs(t, err, ErrUnsupported)
+	require.Empty(t, app.samples)
+}
+
+func TestSanitize(t *testing.T) {
+	require.Equal(t, "http_server_duration", MetricName("http.server.duration"))
+	require.Equal(t, "ns:metric", MetricName("ns:metric"))
+	require.Equal(t, "_1xx", MetricName("1xx"))
+	require.Equal(t, "ns_label", LabelName("ns:label"))
+	require.Equal(t, "k8s_pod_name", LabelName("k8s.pod.name"))
+	require.Equal(t, "_", LabelName(""))
+}
+
+func newTestMetric(name string, ty pdata.MetricDataType) (pdata.Metrics, pdata.Metric) {
+	md := pdata.NewMetrics()
+	rm := md.ResourceMetrics().AppendEmpty()
+	rm.Resource().Attributes().InsertString("service.name", "svc")
+	rm.Resource().Attributes().InsertString("service.namespace", "ns")
+	rm.Resource().Attributes().InsertString("service.instance.id", "host-1")
+
+	m := rm.InstrumentationLibraryMetrics().AppendEmpty().Metrics().AppendEmpty()
+	m.SetName(name)
+	m.SetDataType(ty)
+	return md, m
+}
+
+// testTimestamp returns a timestamp sec seconds after the epoch.
+func testTimestamp(sec int) pdata.Timestamp {
+	return pdata.NewTimestampFromTime(time.Unix(int64(sec), 0))
+}
+
+type sample struct {
+	lbls labels.Labels
+	t    int64
+	v    float64
+}
+
+// collectingAppender is a storage.Appender which stores committed samples.
+type collectingAppender struct {
+	samples   []sample
+	pending   []sample
+	commitErr error // Returned by Commit when set.
+}
+
+func (a *collectingAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	a.pending = append(a.pending, sample{lbls: l, t: t, v: v})
+	return ref, nil
+}
+
+func (a *collectingAppender) AppendExemplar(ref storage.SeriesRef, _ labels.Labels, _ exemplar.Exemplar) (storage.SeriesRef, error) {
+	return ref, nil
+}
+
+func (a *collectingAppender) Commit() error {
+	if a.commitErr != nil {
+		a.pending = nil
+		return a.commitErr
+	}
+	a.samples = append(a.samples, a.pending...)
+	a.pending = nil
+	return nil
+}
+
+func (a *collectingAppender) Rollback() error {
+	a.pending = nil
+	return nil
+}
+
+func (a *collectingAppender) values() []float64 {
+	res := make([]float64, 0, len(a.samples))
+	for _, s := range a.samples {
+		res = append(res, s.v)
+	}
+	return res
+}
+
+// byName returns the samples at timestamp t keyed by metric name and the
+// le or quantile label.
+func (a *collectingAppender) byName(t int64) map[string]float64 {
+	res := make(map[string]float64)
+	for _, s := range a.samples {
+		if s.t != t {
+			continue
+		}
+		key := s.lbls.Get("__name__")
+		for _, extra := range []string{"le", "quantile"} {
+			if v := s.lbls.Get(extra); v != "" {
+				key += `{` + extra + `="` + v + `"}`
+			}
+		}
+		if math.IsNaN(s.v) {
+			continue
+		}
+		res[key] = s.v
+	}
+	return res
+}
@@ -0,0 +1,267 @@
+package otlp
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/storage"
+	"go.opentelemetry.io/collector/model/otlpgrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// InstanceMetadataKey is the gRPC metadata key holding the name of the
+// instance OTLP/gRPC requests are written to.
+const InstanceMetadataKey = "x-agent-instance"
+
+// MaxRequestSize is the maximum size in bytes of OTLP/HTTP request bodies.
+// The limit applies both to the body as sent and to the decompressed body of
+// gzip compressed requests.
+const MaxRequestSize = 20 << 20
+
+const (
+	contentTypeProtobuf = "application/x-protobuf"
+	contentTypeJSON     = "application/json"
+)
+
+// GetInstanceFunc returns the storage of the instance with the given name.
+type GetInstanceFunc func(name string) (storage.Appendable, error)
+
+// Receiver accepts OTLP metrics over HTTP and gRPC and writes them to
+// instances. Each instance uses its own Converter, which is forgotten once the
+// instance hasn't received metrics for DefaultStateTTL.
+type Receiver struct {
+	logger      log.Logger
+	getInstance GetInstanceFunc
+	now         func() time.Time
+	maxSize     int64
+
+	mut        sync.Mutex
+	converters map[string]*instanceConverter
+	lastGC     time.Time
+}
+
+type instanceConverter struct {
+	*Converter
+	lastUsed time.Time
+}
+
+// NewReceiver creates a new Receiver.
+func NewReceiver(l log.Logger, getInstance GetInstanceFunc) *Receiver {
+	return &Receiver{
+		logger:      l,
+		getInstance: getInstance,
+		now:         time.Now,
+		maxSize:     MaxRequestSize,
+		converters:  make(map[string]*instanceConverter),
+	}
+}
+
+// WireGRPC registers the OTLP/gRPC metrics service to srv. Clients must set
+// the InstanceMetadataKey metadata to the name of the instance to write to.
+func (r *Receiver) WireGRPC(srv *grpc.Server) {
+	otlpgrpc.RegisterMetricsServer(srv, grpcServer{r: r})
+}
+
+// ServeHTTP handles an OTLP/HTTP metrics export request for the named
+// instance. Both protobuf and JSON encoded requests are supported, and
+// requests may be gzip compressed. Requests larger than MaxRequestSize are
+// rejected.
+func (r *Receiver) ServeHTTP(w http.ResponseWriter, req *http.Request, instanceName string) {
+	bb, err := r.readBody(w, req)
+	switch {
+	case errors.Is(err, errBodyTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var (
+		contentType = req.Header.Get("Content-Type")
+		otlpReq     otlpgrpc.MetricsRequest
+	)
+	switch contentType {
+	case contentTypeJSON:
+		otlpReq, err = otlpgrpc.UnmarshalJSONMetricsRequest(bb)
+	default:
+		contentType = contentTypeProtobuf
+		otlpReq, err = otlpgrpc.UnmarshalMetricsRequest(bb)
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid OTLP request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	if err := r.write(req.Context(), instanceName, otlpReq); err != nil {
+		switch {
+		case errors.Is(err, errUnknownInstance), isSampleError(err):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		default:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var resp []byte
+	if contentType == contentTypeJSON {
+		resp, err = otlpgrpc.NewMetricsResponse().MarshalJSON()
+	} else {
+		resp, err = otlpgrpc.NewMetricsResponse().Marshal()
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(resp)
+}
+
+var (
+	errUnknownInstance = errors.New("unknown instance")
+	errBodyTooLarge    = errors.New("request body too large")
+)
+
+// readBody reads the body of req, decompressing it if needed. errBodyTooLarge
+// is returned if the body is larger than r.maxSize before or after
+// decompression.
+func (r *Receiver) readBody(w http.ResponseWriter, req *http.Request) ([]byte, error) {
+	if req.ContentLength > r.maxSize {
+		return nil, errBodyTooLarge
+	}
+
+	// The limit of the raw body is checked by limitReader rather than through
+	// the error of http.MaxBytesReader, which has no type until Go 1.19.
+	// MaxBytesReader still closes the connection once the limit is exceeded.
+	raw := &limitReader{r: http.MaxBytesReader(w, req.Body, r.maxSize+1), n: r.maxSize}
+
+	var body io.Reader = raw
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gr, err := gzip.NewReader(raw)
+		if err != nil {
+			if raw.exceeded {
+				return nil, errBodyTooLarge
+			}
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		defer gr.Close()
+		body = gr
+	}
+
+	decompressed := &limitReader{r: body, n: r.maxSize}
+	bb, err := ioutil.ReadAll(decompressed)
+	if raw.exceeded || decompressed.exceeded {
+		return nil, errBodyTooLarge
+	}
+	return bb, err
+}
+
+// limitReader reads up to n bytes from r. Reading more than n bytes fails and
+// sets exceeded.
+type limitReader struct {
+	r        io.Reader
+	n        int64
+	exceeded bool
+}
+
+func (lr *limitReader) Read(p []byte) (int, error) {
+	if lr.exceeded {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > lr.n+1 {
+		p = p[:lr.n+1]
+	}
+	n, err := lr.r.Read(p)
+	if int64(n) > lr.n {
+		lr.exceeded = true
+		return int(lr.n), errBodyTooLarge
+	}
+	lr.n -= int64(n)
+	return n, err
+}
+
+// write converts the metrics in req and commits them to the named instance.
+func (r *Receiver) write(ctx context.Context, instanceName string, req otlpgrpc.MetricsRequest) error {
+	inst, err := r.getInstance(instanceName)
+	if err != nil || inst == nil {
+		return fmt.Errorf("%w %q", errUnknownInstance, instanceName)
+	}
+
+	err = r.converter(instanceName).Write(inst.Appender(ctx), req.Metrics())
+
+	var unsupported *UnsupportedError
+	if errors.As(err, &unsupported) {
+		level.Warn(r.logger).Log("msg", "dropped OTLP metrics of unsupported types", "instance", instanceName, "metrics", len(unsupported.Metrics))
+		err = nil
+	}
+	return err
+}
+
+// converter returns the Converter of the named instance. Converters of
+// instances which haven't been written to for DefaultStateTTL are removed,
+// since all of their running totals expired.
+func (r *Receiver) converter(instanceName string) *Converter {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	now := r.now()
+	if now.Sub(r.lastGC) >= DefaultStateTTL {
+		for name, c := range r.converters {
+			if now.Sub(c.lastUsed) > DefaultStateTTL {
+				delete(r.converters, name)
+			}
+		}
+		r.lastGC = now
+	}
+
+	c, ok := r.converters[instanceName]
+	if !ok {
+		c = &instanceConverter{Converter: NewConverter(DefaultStateTTL)}
+		r.converters[instanceName] = c
+	}
+	c.lastUsed = now
+	return c.Converter
+}
+
+func isSampleError(err error) bool {
+	return errors.Is(err, storage.ErrOutOfOrderSample) ||
+		errors.Is(err, storage.ErrOutOfBounds) ||
+		errors.Is(err, storage.ErrDuplicateSampleForTimestamp)
+}
+
+type grpcServer struct{ r *Receiver }
+
+// Export implements otlpgrpc.MetricsServer.
+func (s grpcServer) Export(ctx context.Context, req otlpgrpc.MetricsRequest) (otlpgrpc.MetricsResponse, error) {
+	var instanceName string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get(InstanceMetadataKey); len(vals) > 0 {
+			instanceName = vals[0]
+		}
+	}
+	if instanceName == "" {
+		return otlpgrpc.NewMetricsResponse(), status.Errorf(codes.InvalidArgument, "missing %s metadata", InstanceMetadataKey)
+	}
+
+	if err := s.r.write(ctx, instanceName, req); err != nil {
+		code := codes.Internal
+		if errors.Is(err, errUnknownInstance) || isSampleError(err) {
+			code = codes.InvalidArgument
+		}
+		return otlpgrpc.NewMetricsResponse(), status.Error(code, err.Error())
+	}
+	return otlpgrpc.NewMetricsResponse(), nil
+}
@@ -0,0 +1,194 @@
+package otlp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/model/otlpgrpc"
+	"go.opentelemetry.io/collector/model/pdata"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestReceiver_HTTP(t *testing.T) {
+	app := &collectingAppender{}
+	r := NewReceiver(log.NewNopLogger(), testInstances(map[string]storage.Appender{"test": app}))
+
+	req := otlpgrpc.NewMetricsRequest()
+	req.SetMetrics(testGauge())
+
+	t.Run("protobuf", func(t *testing.T) {
+		body, err := req.Marshal()
+		require.NoError(t, err)
+
+		httpReq := httptest.NewRequest("POST", "/otlp/v1/metrics", bytes.NewReader(body))
+		httpReq.Header.Set("Content-Type", contentTypeProtobuf)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httpReq, "test")
+
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.Equal(t, contentTypeProtobuf, rr.Header().Get("Content-Type"))
+		require.Len(t, app.samples, 1)
+	})
+
+	t.Run("gzipped json", func(t *testing.T) {
+		body, err := req.MarshalJSON()
+		require.NoError(t, err)
+
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		_, err = gw.Write(body)
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+
+		httpReq := httptest.NewRequest("POST", "/otlp/v1/metrics", &buf)
+		httpReq.Header.Set("Content-Type", contentTypeJSON)
+		httpReq.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httpReq, "test")
+
+		require.Equal(t, http.StatusOK, rr.Code, rr.Body.String())
+		require.Equal(t, contentTypeJSON, rr.Header().Get("Content-Type"))
+		require.Len(t, app.samples, 2)
+	})
+
+	t.Run("unknown instance", func(t *testing.T) {
+		body, err := req.Marshal()
+		require.NoError(t, err)
+
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", "/otlp/v1/metrics", bytes.NewReader(body)), "missing")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+
+	t.Run("invalid body", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", "/otlp/v1/metrics", bytes.NewReader([]byte("not protobuf"))), "test")
+		require.Equal(t, http.StatusBadRequest, rr.Code)
+	})
+}
+
+func TestReceiver_HTTPSizeLimit(t *testing.T) {
+	r := NewReceiver(log.NewNopLogger(), testInstances(map[string]storage.Appender{"test": &collectingAppender{}}))
+	r.maxSize = 1024
+
+	large := bytes.Repeat([]byte{'a'}, 4096)
+
+	t.Run("content length", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httptest.NewRequest("POST", "/otlp/v1/metrics", bytes.NewReader(large)), "test")
+		require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	t.Run("unknown length", func(t *testing.T) {
+		httpReq := httptest.NewRequest("POST", "/otlp/v1/metrics", bytes.NewReader(large))
+		httpReq.ContentLength = -1
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httpReq, "test")
+		require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+
+	t.Run("decompressed", func(t *testing.T) {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		_, err := gw.Write(large)
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+		require.Less(t, buf.Len(), 1024)
+
+		httpReq := httptest.NewRequest("POST", "/otlp/v1/metrics", &buf)
+		httpReq.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, httpReq, "test")
+		require.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+	})
+}
+
+func TestReceiver_GRPC(t *testing.T) {
+	app := &collectingAppender{}
+	r := NewReceiver(log.NewNopLogger(), testInstances(map[string]storage.Appender{"test": app}))
+
+	lis := bufconn.Listen(1024 * 1024)
+	srv := grpc.NewServer()
+	r.WireGRPC(srv)
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	cc, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithInsecure(),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = cc.Close() })
+	client := otlpgrpc.NewMetricsClient(cc)
+
+	req := otlpgrpc.NewMetricsRequest()
+	req.SetMetrics(testGauge())
+
+	t.Run("missing metadata", func(t *testing.T) {
+		_, err := client.Export(context.Background(), req)
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("success", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), InstanceMetadataKey, "test")
+		_, err := client.Export(ctx, req)
+		require.NoError(t, err)
+		require.Len(t, app.samples, 1)
+	})
+}
+
+func TestReceiver_ExpiresConverters(t *testing.T) {
+	apps := map[string]storage.Appender{"a": &collectingAppender{}, "b": &collectingAppender{}}
+	r := NewReceiver(log.NewNopLogger(), testInstances(apps))
+
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	req := otlpgrpc.NewMetricsRequest()
+	req.SetMetrics(testGauge())
+	require.NoError(t, r.write(context.Background(), "a", req))
+
+	now = now.Add(2 * DefaultStateTTL)
+	require.NoError(t, r.write(context.Background(), "b", req))
+
+	require.Len(t, r.converters, 1)
+	require.Contains(t, r.converters, "b")
+}
+
+func testGauge() pdata.Metrics {
+	md, m := newTestMetric("gauge", pdata.MetricDataTypeGauge)
+	pt := m.Gauge().DataPoints().AppendEmpty()
+	pt.SetTimestamp(testTimestamp(1))
+	pt.SetDoubleVal(1)
+	return md
+}
+
+// testInstances returns a GetInstanceFunc for instances which always use the
+// same appender.
+func testInstances(apps map[string]storage.Appender) GetInstanceFunc {
+	return func(name string) (storage.Appendable, error) {
+		app, ok := apps[name]
+		if !ok {
+			return nil, errors.New("instance not found")
+		}
+		return appendableFunc(func(context.Context) storage.Appender { return app }), nil
+	}
+}
+
+type appendableFunc func(context.Context) storage.Appender
+
+func (f appendableFunc) Appender(ctx context.Context) storage.Appender { return f(ctx) }
@@ -0,0 +1,154 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// DefaultRouteConfig holds default settings for a route.
+var DefaultRouteConfig = RouteConfig{
+	FailoverAfter:    time.Minute,
+	FailbackInterval: 5 * time.Minute,
+}
+
+// RouteConfig configures a route, which sends the series it selects to one
+// of several remote_write endpoints.
+type RouteConfig struct {
+	// Name of the route, used in logs and metrics.
+	Name string `yaml:"name"`
+
+	// Series selector, such as {team="a"}, choosing which series are sent
+	// through the route. An empty selector selects every series.
+	Match string `yaml:"match,omitempty"`
+
+	// Names of remote_write configs to send to, in order of priority. Only one
+	// endpoint is written to at a time; the next endpoint is used after the
+	// current one has been failing for FailoverAfter.
+	RemoteWrite []string `yaml:"remote_write"`
+
+	// How long an endpoint must fail before failing over to the next one.
+	FailoverAfter time.Duration `yaml:"failover_after,omitempty"`
+
+	// How often to retry a higher priority endpoint after failing over.
+	FailbackInterval time.Duration `yaml:"failback_interval,omitempty"`
+
+	// When set, the value of this label sets the X-Scope-OrgID header of
+	// requests, and series with different tenants are sent in different
+	// requests.
+	TenantLabel string `yaml:"tenant_label,omitempty"`
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler.
+func (c *RouteConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultRouteConfig
+
+	type plain RouteConfig
+	return unmarshal((*plain)(c))
+}
+
+// Validate returns an error if c is invalid.
+func (c *RouteConfig) Validate() error {
+	switch {
+	case c.Name == "":
+		return errors.New("route name must not be empty")
+	case len(c.RemoteWrite) == 0:
+		return fmt.Errorf("route %q must list at least one remote_write", c.Name)
+	case c.FailoverAfter <= 0:
+		return fmt.Errorf("route %q: failover_after must be greater than 0s", c.Name)
+	case c.FailbackInterval <= 0:
+		return fmt.Errorf("route %q: failback_interval must be greater than 0s", c.Name)
+	case c.TenantLabel != "" && !model.LabelName(c.TenantLabel).IsValid():
+		return fmt.Errorf("route %q: invalid tenant_label %q", c.Name, c.TenantLabel)
+	}
+
+	if _, err := c.matchers(); err != nil {
+		return fmt.Errorf("route %q: invalid match: %w", c.Name, err)
+	}
+
+	seen := make(map[string]struct{}, len(c.RemoteWrite))
+	for _, name := range c.RemoteWrite {
+		if _, ok := seen[name]; ok {
+			return fmt.Errorf("route %q lists remote_write %q more than once", c.Name, name)
+		}
+		seen[name] = struct{}{}
+	}
+	return nil
+}
+
+func (c *RouteConfig) matchers() ([]*labels.Matcher, error) {
+	if c.Match == "" {
+		return nil, nil
+	}
+	return parser.ParseMetricSelector(c.Match)
+}
+
+// Config configures a Router.
+type Config struct {
+	// Routes in order of priority. Each series is sent through the first route
+	// which selects it. Series which aren't selected by any route are dropped.
+	Routes []*RouteConfig
+
+	// RemoteWrite holds the remote_write configs referenced by Routes, by
+	// name. Routes take their queue settings from their first remote_write.
+	RemoteWrite []*config.RemoteWriteConfig
+
+	// ExternalLabels are added to every series.
+	ExternalLabels labels.Labels
+
+	// FlushDeadline is how long to wait for pending samples to be sent when
+	// a route stops.
+	FlushDeadline time.Duration
+}
+
+// Validate checks that routes are valid and only reference remote_write
+// configs from rw.
+func Validate(routes []*RouteConfig, rw []*config.RemoteWriteConfig) error {
+	names := make(map[string]struct{}, len(rw))
+	for _, c := range rw {
+		if c != nil {
+			names[c.Name] = struct{}{}
+		}
+	}
+
+	seen := make(map[string]struct{}, len(routes))
+	for _, rc := range routes {
+		if rc == nil {
+			return errors.New("empty or null remote_write_routes section")
+		}
+		if err := rc.Validate(); err != nil {
+			return err
+		}
+		if _, ok := seen[rc.Name]; ok {
+			return fmt.Errorf("found multiple routes with name %q", rc.Name)
+		}
+		seen[rc.Name] = struct{}{}
+
+		for _, name := range rc.RemoteWrite {
+			if _, ok := names[name]; !ok {
+				return fmt.Errorf("route %q references unknown remote_write %q", rc.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+// RoutedRemoteWrite returns the names of the remote_write configs used by
+// routes.
+func RoutedRemoteWrite(routes []*RouteConfig) map[string]struct{} {
+	res := make(map[string]struct{})
+	for _, rc := range routes {
+		if rc == nil {
+			continue
+		}
+		for _, name := range rc.RemoteWrite {
+			res[name] = struct{}{}
+		}
+	}
+	return res
+}
@@ -0,0 +1,547 @@
+package router
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/go-kit/log/level"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+	"go.uber.org/atomic"
+	"gopkg.in/yaml.v2"
+)
+
+// tenantHeader is the header which holds the tenant of a request.
+const tenantHeader = "X-Scope-OrgID"
+
+// route sends the samples queued by the Router to the active endpoint of the
+// route. Samples are spread over shards, each queueing and sending its own
+// batches.
+type route struct {
+	logger        log.Logger
+	metrics       *metrics
+	cfg           RouteConfig
+	hash          string
+	matchers      []*labels.Matcher
+	endpoints     []*endpoint
+	queue         config.QueueConfig
+	flushDeadline time.Duration
+
+	// highestSent is the highest timestamp in milliseconds of samples which
+	// were sent or given up on.
+	highestSent atomic.Int64
+
+	// pending is the number of queued samples which haven't been sent or
+	// given up on yet.
+	pending atomic.Int64
+
+	// dropping is set while samples are dropped because the queues are full.
+	// It's only used by enqueue, which is called by the WAL watcher.
+	dropping bool
+
+	shards  []chan queuedSample
+	wg      sync.WaitGroup
+	started bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// failoverMut protects the failover state shared by all shards.
+	failoverMut  sync.Mutex
+	active       int       // Index of the endpoint being written to.
+	failingSince time.Time // When sends to the active endpoint started failing.
+	lastFailback time.Time // Last attempt to fail back to the first endpoint.
+}
+
+// endpoint is a remote_write endpoint of a route.
+type endpoint struct {
+	relabelConfigs []*relabel.Config
+
+	mut     sync.Mutex
+	cfg     *config.RemoteWriteConfig
+	clients map[string]remote.WriteClient // Clients by tenant.
+}
+
+// routedSeries holds the labels of a series for each endpoint of the route
+// which selected it.
+type routedSeries struct {
+	tenant string
+	shard  int
+	labels []labels.Labels // nil for endpoints which drop the series.
+}
+
+type queuedSample struct {
+	series *routedSeries
+	t      int64
+	v      float64
+}
+
+// newRoute creates an unstarted route. rw must hold all remote_write configs
+// referenced by cfg.
+func newRoute(l log.Logger, m *metrics, cfg *RouteConfig, rw map[string]*config.RemoteWriteConfig, extLabels labels.Labels, flushDeadline time.Duration) (*route, error) {
+	matchers, err := cfg.matchers()
+	if err != nil {
+		return nil, fmt.Errorf("route %q: invalid match: %w", cfg.Name, err)
+	}
+
+	hashed := struct {
+		Route          *RouteConfig                `yaml:"route"`
+		RemoteWrite    []*config.RemoteWriteConfig `yaml:"remote_write"`
+		ExternalLabels labels.Labels               `yaml:"external_labels"`
+		FlushDeadline  time.Duration               `yaml:"flush_deadline"`
+	}{Route: cfg, ExternalLabels: extLabels, FlushDeadline: flushDeadline}
+
+	endpoints := make([]*endpoint, 0, len(cfg.RemoteWrite))
+	for _, name := range cfg.RemoteWrite {
+		rwc, ok := rw[name]
+		if !ok {
+			return nil, fmt.Errorf("route %q references unknown remote_write %q", cfg.Name, name)
+		}
+		endpoints = append(endpoints, &endpoint{
+			relabelConfigs: rwc.WriteRelabelConfigs,
+			cfg:            rwc,
+			clients:        make(map[string]remote.WriteClient),
+		})
+		hashed.RemoteWrite = append(hashed.RemoteWrite, rwc)
+	}
+
+	bb, err := yaml.Marshal(hashed)
+	if err != nil {
+		return nil, err
+	}
+	hash := md5.Sum(bb)
+
+	queue := endpoints[0].cfg.QueueConfig
+	numShards := queue.MaxShards
+	if numShards < 1 {
+		numShards = 1
+	}
+	shards := make([]chan queuedSample, numShards)
+	for i := range shards {
+		shards[i] = make(chan queuedSample, queue.Capacity)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &route{
+		logger:        log.With(l, "route", cfg.Name),
+		metrics:       m,
+		cfg:           *cfg,
+		hash:          hex.EncodeToString(hash[:]),
+		matchers:      matchers,
+		endpoints:     endpoints,
+		queue:         queue,
+		flushDeadline: flushDeadline,
+
+		shards: shards,
+
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// Matches returns true if the route selects a series with labels lbls.
+func (rt *route) Matches(lbls labels.Labels) bool {
+	for _, m := range rt.matchers {
+		if !m.Matches(lbls.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// newSeries prepares a series with labels lbls to be sent through the route.
+// Series of the same tenant are sent by the same shard, so a tenant which
+// keeps failing only delays the tenants sharing its shard.
+func (rt *route) newSeries(lbls labels.Labels) *routedSeries {
+	rs := &routedSeries{labels: make([]labels.Labels, len(rt.endpoints))}
+
+	var shardKey uint64
+	if rt.cfg.TenantLabel != "" {
+		rs.tenant = lbls.Get(rt.cfg.TenantLabel)
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(rs.tenant))
+		shardKey = h.Sum64()
+	} else {
+		shardKey = lbls.Hash()
+	}
+	rs.shard = int(shardKey % uint64(len(rt.shards)))
+
+	for i, ep := range rt.endpoints {
+		rs.labels[i] = relabel.Process(lbls, ep.relabelConfigs...)
+	}
+	return rs
+}
+
+// Start starts sending samples.
+func (rt *route) Start() {
+	rt.started = true
+
+	rt.failoverMut.Lock()
+	rt.setActive(0)
+	rt.failoverMut.Unlock()
+
+	rt.wg.Add(len(rt.shards))
+	for _, ch := range rt.shards {
+		go rt.runShard(ch)
+	}
+}
+
+// Stop stops the route, waiting up to the flush deadline for pending
+// samples to be sent. Stop may be called on routes which were never
+// started. The route must not be used by the Router anymore when Stop is
+// called.
+func (rt *route) Stop() {
+	for _, ch := range rt.shards {
+		close(ch)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		rt.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(rt.flushDeadline):
+		level.Warn(rt.logger).Log("msg", "failed to flush all samples before the flush deadline")
+		rt.cancel()
+		<-done
+	}
+	rt.cancel()
+
+	rt.metrics.deleteRoute(rt)
+}
+
+// enqueue queues s to be sent by its shard without blocking. s is dropped if
+// the queue of its shard is full, so a route which can't keep up doesn't
+// delay the other routes reading the same WAL.
+func (rt *route) enqueue(s queuedSample) {
+	rt.pending.Inc()
+
+	select {
+	case rt.shards[s.series.shard] <- s:
+		if rt.dropping {
+			rt.dropping = false
+			level.Info(rt.logger).Log("msg", "queue has room again, no longer dropping samples")
+		}
+	default:
+		rt.pending.Dec()
+		rt.metrics.samplesDropped.WithLabelValues(rt.cfg.Name).Inc()
+		if !rt.dropping {
+			rt.dropping = true
+			level.Warn(rt.logger).Log("msg", "queue is full, dropping samples until it has room again")
+		}
+	}
+}
+
+// runShard batches the samples queued in ch and sends them until ch is
+// closed.
+func (rt *route) runShard(ch <-chan queuedSample) {
+	defer rt.wg.Done()
+
+	deadline := time.Duration(rt.queue.BatchSendDeadline)
+	timer := time.NewTimer(deadline)
+	defer timer.Stop()
+
+	batch := make([]queuedSample, 0, rt.queue.MaxSamplesPerSend)
+	flush := func() {
+		if len(batch) > 0 {
+			rt.send(batch)
+			rt.pending.Sub(int64(len(batch)))
+			batch = batch[:0]
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(deadline)
+	}
+
+	for {
+		select {
+		case s, ok := <-ch:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= rt.queue.MaxSamplesPerSend {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+// send sends samples to the active endpoint, retrying recoverable errors
+// until the samples are sent or the route is stopped. send fails over to the
+// next endpoint when the active endpoint keeps failing, and periodically
+// tries to fail back to the first endpoint.
+func (rt *route) send(samples []queuedSample) {
+	var (
+		// Requests are split by tenant, so some tenants may be sent before
+		// another fails. Sent tenants aren't sent again when retrying.
+		sent    = make(map[string]struct{})
+		backoff = time.Duration(rt.queue.MinBackoff)
+	)
+
+	for {
+		if rt.shouldFailback() {
+			if err := rt.sendTo(0, samples, sent); err == nil {
+				rt.failback()
+				rt.updateHighestSent(samples)
+				return
+			}
+		}
+
+		active := rt.activeEndpoint()
+		err := rt.sendTo(active, samples, sent)
+		if err == nil {
+			rt.markSuccess(active)
+			rt.updateHighestSent(samples)
+			return
+		}
+
+		name := rt.endpoints[active].name()
+		if !isRecoverable(err) {
+			level.Error(rt.logger).Log("msg", "non-recoverable error sending samples, dropping them", "remote_name", name, "count", len(samples), "err", err)
+			rt.metrics.samplesFailed.WithLabelValues(rt.cfg.Name, name).Add(float64(len(samples)))
+			rt.updateHighestSent(samples)
+			return
+		}
+
+		if rt.markFailure(active, err) {
+			// The route failed over; retry right away with the new endpoint.
+			backoff = time.Duration(rt.queue.MinBackoff)
+			continue
+		}
+
+		level.Warn(rt.logger).Log("msg", "failed to send samples, retrying", "remote_name", name, "backoff", backoff, "err", err)
+		select {
+		case <-rt.ctx.Done():
+			rt.metrics.samplesFailed.WithLabelValues(rt.cfg.Name, name).Add(float64(len(samples)))
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if maxBackoff := time.Duration(rt.queue.MaxBackoff); backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// sendTo sends samples to the endpoint at index idx, with one request per
+// tenant. Tenants in sent are skipped, and tenants are added to sent once
+// their request succeeds.
+func (rt *route) sendTo(idx int, samples []queuedSample, sent map[string]struct{}) error {
+	reqs := make(map[string][]prompb.TimeSeries)
+	for _, s := range samples {
+		if _, ok := sent[s.series.tenant]; ok {
+			continue
+		}
+		lbls := s.series.labels[idx]
+		if lbls == nil {
+			continue
+		}
+		reqs[s.series.tenant] = append(reqs[s.series.tenant], prompb.TimeSeries{
+			Labels:  labelsToProto(lbls),
+			Samples: []prompb.Sample{{Value: s.v, Timestamp: s.t}},
+		})
+	}
+
+	ep := rt.endpoints[idx]
+	for tenant, series := range reqs {
+		if err := ep.store(rt.ctx, tenant, series); err != nil {
+			return err
+		}
+		sent[tenant] = struct{}{}
+		rt.metrics.samplesSent.WithLabelValues(rt.cfg.Name, ep.name()).Add(float64(len(series)))
+	}
+	return nil
+}
+
+func (rt *route) updateHighestSent(samples []queuedSample) {
+	for _, s := range samples {
+		for {
+			cur := rt.highestSent.Load()
+			if s.t <= cur || rt.highestSent.CAS(cur, s.t) {
+				break
+			}
+		}
+	}
+}
+
+// activeEndpoint returns the index of the endpoint being written to.
+func (rt *route) activeEndpoint() int {
+	rt.failoverMut.Lock()
+	defer rt.failoverMut.Unlock()
+	return rt.active
+}
+
+// shouldFailback returns true if a shard should try to fail back to the
+// first endpoint. Only one shard tries per failback_interval.
+func (rt *route) shouldFailback() bool {
+	rt.failoverMut.Lock()
+	defer rt.failoverMut.Unlock()
+
+	if rt.active == 0 || time.Since(rt.lastFailback) < rt.cfg.FailbackInterval {
+		return false
+	}
+	rt.lastFailback = time.Now()
+	return true
+}
+
+// failback makes the first endpoint active after a successful request to it.
+func (rt *route) failback() {
+	rt.failoverMut.Lock()
+	defer rt.failoverMut.Unlock()
+
+	if rt.active != 0 {
+		level.Info(rt.logger).Log("msg", "failing back to first remote_write", "remote_name", rt.endpoints[0].name())
+		rt.setActive(0)
+	}
+	rt.failingSince = time.Time{}
+}
+
+// markSuccess records a successful request to the endpoint at index idx.
+func (rt *route) markSuccess(idx int) {
+	rt.failoverMut.Lock()
+	defer rt.failoverMut.Unlock()
+
+	if rt.active == idx {
+		rt.failingSince = time.Time{}
+	}
+}
+
+// markFailure records a failed request to the endpoint at index idx, failing
+// over to the next endpoint once the active endpoint has been failing for
+// failover_after. markFailure returns true if the active endpoint changed
+// since the request was made and the request should be retried right away.
+func (rt *route) markFailure(idx int, err error) bool {
+	rt.failoverMut.Lock()
+	defer rt.failoverMut.Unlock()
+
+	if rt.active != idx {
+		// Another shard already failed over or back.
+		return true
+	}
+
+	now := time.Now()
+	if rt.failingSince.IsZero() {
+		rt.failingSince = now
+	}
+	if rt.active+1 >= len(rt.endpoints) || now.Sub(rt.failingSince) < rt.cfg.FailoverAfter {
+		return false
+	}
+
+	level.Warn(rt.logger).Log("msg", "failing over to next remote_write", "from", rt.endpoints[idx].name(), "to", rt.endpoints[idx+1].name(), "err", err)
+	rt.metrics.failovers.WithLabelValues(rt.cfg.Name).Inc()
+	rt.setActive(idx + 1)
+	rt.failingSince = time.Time{}
+	rt.lastFailback = now
+	return true
+}
+
+// setActive makes the endpoint at index idx active. rt.failoverMut must be
+// held when calling setActive.
+func (rt *route) setActive(idx int) {
+	rt.active = idx
+	for i, ep := range rt.endpoints {
+		var v float64
+		if i == idx {
+			v = 1
+		}
+		rt.metrics.activeEndpoint.WithLabelValues(rt.cfg.Name, ep.name()).Set(v)
+	}
+}
+
+func (ep *endpoint) name() string {
+	ep.mut.Lock()
+	defer ep.mut.Unlock()
+	return ep.cfg.Name
+}
+
+// setConfig updates the config of the endpoint, recreating its clients in
+// case secrets changed.
+func (ep *endpoint) setConfig(cfg *config.RemoteWriteConfig) {
+	ep.mut.Lock()
+	defer ep.mut.Unlock()
+	ep.cfg = cfg
+	ep.clients = make(map[string]remote.WriteClient)
+}
+
+func (ep *endpoint) store(ctx context.Context, tenant string, series []prompb.TimeSeries) error {
+	c, err := ep.client(tenant)
+	if err != nil {
+		return err
+	}
+
+	req := prompb.WriteRequest{Timeseries: series}
+	bb, err := req.Marshal()
+	if err != nil {
+		return err
+	}
+	return c.Store(ctx, snappy.Encode(nil, bb))
+}
+
+// client returns the client for sending requests for tenant. Clients which
+// set a tenant header are created the first time they're needed.
+func (ep *endpoint) client(tenant string) (remote.WriteClient, error) {
+	ep.mut.Lock()
+	defer ep.mut.Unlock()
+
+	if c, ok := ep.clients[tenant]; ok {
+		return c, nil
+	}
+
+	headers := make(map[string]string, len(ep.cfg.Headers)+1)
+	for k, v := range ep.cfg.Headers {
+		headers[k] = v
+	}
+	if tenant != "" {
+		headers[tenantHeader] = tenant
+	}
+
+	c, err := remote.NewWriteClient(ep.cfg.Name, &remote.ClientConfig{
+		URL:              ep.cfg.URL,
+		Timeout:          ep.cfg.RemoteTimeout,
+		HTTPClientConfig: ep.cfg.HTTPClientConfig,
+		SigV4Config:      ep.cfg.SigV4Config,
+		Headers:          headers,
+		RetryOnRateLimit: ep.cfg.QueueConfig.RetryOnRateLimit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	ep.clients[tenant] = c
+	return c, nil
+}
+
+func isRecoverable(err error) bool {
+	var re remote.RecoverableError
+	return errors.As(err, &re)
+}
+
+func labelsToProto(lbls labels.Labels) []prompb.Label {
+	res := make([]prompb.Label, 0, len(lbls))
+	for _, l := range lbls {
+		res = append(res, prompb.Label{Name: l.Name, Value: l.Value})
+	}
+	return res
+}
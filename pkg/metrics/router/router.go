@@ -0,0 +1,397 @@
+// Package router sends samples from a WAL to remote_write endpoints chosen by
+// label-based routes, with failover between the endpoints of a route.
+package router
+
+import (
+	"math"
+	"sync"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+	"go.uber.org/atomic"
+)
+
+// Router reads a WAL once and sends each series through the first route
+// which selects it. Every route queues its samples on its own, so a route
+// which can't keep up doesn't delay the others.
+type Router struct {
+	logger  log.Logger
+	metrics *metrics
+	watcher *wal.Watcher
+
+	// highestRead is the highest timestamp in milliseconds of samples which
+	// were read from the WAL and handed to the routes.
+	highestRead atomic.Int64
+
+	// mut protects the fields below. gen is incremented every time the
+	// config changes, invalidating the routes cached for each series.
+	mut       sync.RWMutex
+	gen       uint64
+	routes    []*route
+	extLabels labels.Labels
+	started   bool
+
+	seriesMut sync.Mutex
+	series    map[chunks.HeadSeriesRef]*seriesEntry
+}
+
+var _ wal.WriteTo = (*Router)(nil)
+
+type seriesEntry struct {
+	lbls    labels.Labels
+	segment int
+
+	// The route selecting the series for config generation gen, or nil when
+	// no route selects it.
+	gen    uint64
+	route  *route
+	routed *routedSeries
+}
+
+// New creates a new Router which reads the WAL in walDir. The Router doesn't
+// send samples until it is configured with ApplyConfig and started with
+// Start.
+func New(l log.Logger, reg prometheus.Registerer, walDir string) *Router {
+	r := &Router{
+		logger:  l,
+		metrics: newMetrics(reg),
+		gen:     1,
+		series:  make(map[chunks.HeadSeriesRef]*seriesEntry),
+	}
+
+	// Metrics for the watcher aren't registered since they would conflict
+	// with the metrics of remote_write queues reading the same WAL.
+	r.watcher = wal.NewWatcher(wal.NewWatcherMetrics(nil), wal.NewLiveReaderMetrics(nil), l, "router", r, walDir, false)
+	return r
+}
+
+// ApplyConfig applies a new config to the Router. Routes whose settings
+// didn't change keep their queued samples and failover state.
+func (r *Router) ApplyConfig(c Config) error {
+	if err := Validate(c.Routes, c.RemoteWrite); err != nil {
+		return err
+	}
+
+	rw := make(map[string]*config.RemoteWriteConfig, len(c.RemoteWrite))
+	for _, rwc := range c.RemoteWrite {
+		if rwc != nil {
+			rw[rwc.Name] = rwc
+		}
+	}
+
+	r.mut.Lock()
+	existing := make(map[string]*route, len(r.routes))
+	for _, rt := range r.routes {
+		existing[rt.hash] = rt
+	}
+
+	var (
+		routes  = make([]*route, 0, len(c.Routes))
+		created []*route
+	)
+	for _, rc := range c.Routes {
+		rt, err := newRoute(r.logger, r.metrics, rc, rw, c.ExternalLabels, c.FlushDeadline)
+		if err != nil {
+			r.mut.Unlock()
+			return err
+		}
+
+		if old, ok := existing[rt.hash]; ok {
+			// Update clients in case secrets changed.
+			for i, ep := range old.endpoints {
+				ep.setConfig(rw[rt.cfg.RemoteWrite[i]])
+			}
+			delete(existing, rt.hash)
+			routes = append(routes, old)
+			continue
+		}
+		routes = append(routes, rt)
+		created = append(created, rt)
+	}
+
+	r.routes = routes
+	r.extLabels = c.ExternalLabels
+	r.gen++
+	started := r.started
+	r.mut.Unlock()
+
+	// Routes which were replaced are stopped before new routes start so their
+	// metrics don't conflict.
+	for _, rt := range existing {
+		rt.Stop()
+	}
+	if started {
+		for _, rt := range created {
+			rt.Start()
+		}
+	}
+	return nil
+}
+
+// Start starts all routes and reading the WAL. Routes added by later calls to
+// ApplyConfig are started right away.
+func (r *Router) Start() {
+	r.mut.Lock()
+	r.started = true
+	routes := r.routes
+	r.mut.Unlock()
+
+	for _, rt := range routes {
+		rt.Start()
+	}
+	r.watcher.Start()
+}
+
+// Stop stops reading the WAL and stops all routes, waiting for them to flush
+// pending samples.
+func (r *Router) Stop() {
+	r.mut.Lock()
+	started := r.started
+	r.started = false
+	r.mut.Unlock()
+
+	// Append never blocks, so the watcher stops right away.
+	if started {
+		r.watcher.Stop()
+	}
+
+	r.mut.Lock()
+	routes := r.routes
+	r.routes = nil
+	r.gen++
+	r.mut.Unlock()
+
+	for _, rt := range routes {
+		rt.Stop()
+	}
+}
+
+// LowestSentTimestamp returns the lowest timestamp in milliseconds up to
+// which every route has sent the samples it selects. Routes without pending
+// samples are caught up with the WAL, so series they don't select don't hold
+// them back. 0 is returned when there are no routes or when a route hasn't
+// sent any samples yet.
+func (r *Router) LowestSentTimestamp() int64 {
+	// highestRead is loaded before checking for pending samples: samples are
+	// queued before highestRead is updated, so a route without pending
+	// samples has handled everything up to read.
+	read := r.highestRead.Load()
+
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+
+	if len(r.routes) == 0 {
+		return 0
+	}
+
+	var lowest int64 = math.MaxInt64
+	for _, rt := range r.routes {
+		ts := rt.highestSent.Load()
+		if rt.pending.Load() == 0 && read > ts {
+			ts = read
+		}
+		if ts < lowest {
+			lowest = ts
+		}
+	}
+	return lowest
+}
+
+// Append implements wal.WriteTo. Samples are queued by the route selecting
+// their series without blocking, so Append always returns true.
+func (r *Router) Append(samples []record.RefSample) bool {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	r.seriesMut.Lock()
+	defer r.seriesMut.Unlock()
+
+	var (
+		highest  = r.highestRead.Load()
+		unrouted int
+	)
+	for _, s := range samples {
+		if s.T > highest {
+			highest = s.T
+		}
+
+		rt, rs := r.lookup(s.Ref)
+		if rt == nil {
+			unrouted++
+			continue
+		}
+		rt.enqueue(queuedSample{series: rs, t: s.T, v: s.V})
+	}
+
+	if unrouted > 0 && len(r.routes) > 0 {
+		r.metrics.samplesUnrouted.Add(float64(unrouted))
+	}
+	r.highestRead.Store(highest)
+	return true
+}
+
+// lookup returns the route selecting the series with the given ref, or nil
+// if no route selects it. r.mut and r.seriesMut must be held when calling
+// lookup.
+func (r *Router) lookup(ref chunks.HeadSeriesRef) (*route, *routedSeries) {
+	e, ok := r.series[ref]
+	if !ok {
+		return nil, nil
+	}
+
+	if e.gen != r.gen {
+		e.gen, e.route, e.routed = r.gen, nil, nil
+
+		lbls := processExternalLabels(e.lbls, r.extLabels)
+		if rt := r.selectRoute(lbls); rt != nil {
+			e.route, e.routed = rt, rt.newSeries(lbls)
+		}
+	}
+	return e.route, e.routed
+}
+
+// AppendExemplars implements wal.WriteTo. Exemplars aren't sent through
+// routes.
+func (r *Router) AppendExemplars([]record.RefExemplar) bool { return true }
+
+// StoreSeries implements wal.WriteTo.
+func (r *Router) StoreSeries(series []record.RefSeries, index int) {
+	r.seriesMut.Lock()
+	defer r.seriesMut.Unlock()
+
+	for _, s := range series {
+		r.series[s.Ref] = &seriesEntry{lbls: s.Labels, segment: index}
+	}
+}
+
+// UpdateSeriesSegment implements wal.WriteTo.
+func (r *Router) UpdateSeriesSegment(series []record.RefSeries, index int) {
+	r.seriesMut.Lock()
+	defer r.seriesMut.Unlock()
+
+	for _, s := range series {
+		if e, ok := r.series[s.Ref]; ok {
+			e.segment = index
+		}
+	}
+}
+
+// SeriesReset implements wal.WriteTo.
+func (r *Router) SeriesReset(index int) {
+	r.seriesMut.Lock()
+	defer r.seriesMut.Unlock()
+
+	for ref, e := range r.series {
+		if e.segment < index {
+			delete(r.series, ref)
+		}
+	}
+}
+
+// selectRoute returns the first route selecting a series with labels lbls,
+// or nil if no route selects it. r.mut must be held when calling
+// selectRoute.
+func (r *Router) selectRoute(lbls labels.Labels) *route {
+	for _, rt := range r.routes {
+		if rt.Matches(lbls) {
+			return rt
+		}
+	}
+	return nil
+}
+
+// processExternalLabels merges externalLabels into ls. Labels in ls take
+// precedence. Both ls and externalLabels must be sorted.
+func processExternalLabels(ls, externalLabels labels.Labels) labels.Labels {
+	if len(externalLabels) == 0 {
+		return ls
+	}
+
+	i, j, result := 0, 0, make(labels.Labels, 0, len(ls)+len(externalLabels))
+	for i < len(ls) && j < len(externalLabels) {
+		switch {
+		case ls[i].Name < externalLabels[j].Name:
+			result = append(result, ls[i])
+			i++
+		case ls[i].Name > externalLabels[j].Name:
+			result = append(result, externalLabels[j])
+			j++
+		default:
+			result = append(result, ls[i])
+			i++
+			j++
+		}
+	}
+	return append(append(result, ls[i:]...), externalLabels[j:]...)
+}
+
+type metrics struct {
+	samplesSent     *prometheus.CounterVec
+	samplesFailed   *prometheus.CounterVec
+	samplesUnrouted prometheus.Counter
+	samplesDropped  *prometheus.CounterVec
+	failovers       *prometheus.CounterVec
+	activeEndpoint  *prometheus.GaugeVec
+}
+
+func newMetrics(reg prometheus.Registerer) *metrics {
+	var m metrics
+
+	m.samplesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_remote_write_route_samples_sent_total",
+		Help: "Total number of samples sent through a remote_write route",
+	}, []string{"route", "remote_name"})
+
+	m.samplesFailed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_remote_write_route_samples_failed_total",
+		Help: "Total number of samples which failed to be sent through a remote_write route",
+	}, []string{"route", "remote_name"})
+
+	m.samplesUnrouted = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_remote_write_route_samples_unrouted_total",
+		Help: "Total number of samples dropped because no remote_write route selected their series",
+	})
+
+	m.samplesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_remote_write_route_samples_dropped_total",
+		Help: "Total number of samples dropped because the queue of a remote_write route was full",
+	}, []string{"route"})
+
+	m.failovers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_remote_write_route_failovers_total",
+		Help: "Total number of times a remote_write route failed over to its next remote_write",
+	}, []string{"route"})
+
+	m.activeEndpoint = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "agent_remote_write_route_active",
+		Help: "Set to 1 for the remote_write a route is currently sending to, and 0 for the others",
+	}, []string{"route", "remote_name"})
+
+	if reg != nil {
+		reg.MustRegister(
+			m.samplesSent,
+			m.samplesFailed,
+			m.samplesUnrouted,
+			m.samplesDropped,
+			m.failovers,
+			m.activeEndpoint,
+		)
+	}
+	return &m
+}
+
+// deleteRoute removes the series of a stopped route.
+func (m *metrics) deleteRoute(rt *route) {
+	m.failovers.DeleteLabelValues(rt.cfg.Name)
+	m.samplesDropped.DeleteLabelValues(rt.cfg.Name)
+	for _, ep := range rt.endpoints {
+		name := ep.name()
+		m.samplesSent.DeleteLabelValues(rt.cfg.Name, name)
+		m.samplesFailed.DeleteLabelValues(rt.cfg.Name, name)
+		m.activeEndpoint.DeleteLabelValues(rt.cfg.Name, name)
+	}
+}
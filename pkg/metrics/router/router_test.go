@@ -0,0 +1,333 @@
+package router
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/agent/pkg/metrics/wal"
+	commoncfg "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/timestamp"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_Routing(t *testing.T) {
+	var (
+		teamA = newTestReceiver(t)
+		other = newTestReceiver(t)
+	)
+
+	cfg := Config{
+		Routes: []*RouteConfig{
+			testRoute("team-a", `{team="a"}`, "team-a"),
+			testRoute("other", "", "other"),
+		},
+		RemoteWrite:   []*config.RemoteWriteConfig{teamA.Config("team-a"), other.Config("other")},
+		FlushDeadline: time.Second,
+	}
+	s, _ := runTestRouter(t, cfg)
+
+	require.Eventually(t, func() bool {
+		appendSamples(t, s, labels.FromStrings("__name__", "metric_a", "team", "a"), labels.FromStrings("__name__", "metric_b", "team", "b"))
+		return teamA.Received("metric_a") > 0 && other.Received("metric_b") > 0
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Zero(t, teamA.Received("metric_b"))
+	require.Zero(t, other.Received("metric_a"))
+}
+
+func TestRouter_FailingRouteDoesntBlockOthers(t *testing.T) {
+	var (
+		failing = newTestReceiver(t)
+		other   = newTestReceiver(t)
+	)
+	failing.SetFailing(true)
+
+	// Use the smallest queue so the failing route can't buffer samples.
+	failingCfg := failing.Config("failing")
+	failingCfg.QueueConfig.Capacity = 1
+	failingCfg.QueueConfig.MaxShards = 1
+	failingCfg.QueueConfig.MaxSamplesPerSend = 1
+
+	cfg := Config{
+		Routes: []*RouteConfig{
+			testRoute("failing", `{team="a"}`, "failing"),
+			testRoute("other", "", "other"),
+		},
+		RemoteWrite:   []*config.RemoteWriteConfig{failingCfg, other.Config("other")},
+		FlushDeadline: time.Second,
+	}
+	s, _ := runTestRouter(t, cfg)
+
+	require.Eventually(t, func() bool {
+		appendSamples(t, s, labels.FromStrings("__name__", "metric_a", "team", "a"), labels.FromStrings("__name__", "metric_b", "team", "b"))
+		return other.Received("metric_b") >= 20
+	}, 10*time.Second, 10*time.Millisecond)
+}
+
+func TestRouter_Failover(t *testing.T) {
+	var (
+		primary   = newTestReceiver(t)
+		secondary = newTestReceiver(t)
+	)
+	primary.SetFailing(true)
+
+	rc := testRoute("failover", "", "primary", "secondary")
+	rc.FailoverAfter = 100 * time.Millisecond
+	rc.FailbackInterval = 200 * time.Millisecond
+
+	cfg := Config{
+		Routes:        []*RouteConfig{rc},
+		RemoteWrite:   []*config.RemoteWriteConfig{primary.Config("primary"), secondary.Config("secondary")},
+		FlushDeadline: time.Second,
+	}
+	s, _ := runTestRouter(t, cfg)
+
+	series := labels.FromStrings("__name__", "metric")
+	require.Eventually(t, func() bool {
+		appendSamples(t, s, series)
+		return secondary.Received("metric") > 0
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Zero(t, primary.Received("metric"))
+
+	// Samples should go back to the primary once it recovers.
+	primary.SetFailing(false)
+	require.Eventually(t, func() bool {
+		appendSamples(t, s, series)
+		return primary.Received("metric") > 0
+	}, 10*time.Second, 50*time.Millisecond)
+}
+
+func TestRouter_TenantLabel(t *testing.T) {
+	recv := newTestReceiver(t)
+
+	rc := testRoute("tenants", "", "recv")
+	rc.TenantLabel = "tenant"
+
+	cfg := Config{
+		Routes:        []*RouteConfig{rc},
+		RemoteWrite:   []*config.RemoteWriteConfig{recv.Config("recv")},
+		FlushDeadline: time.Second,
+	}
+	s, _ := runTestRouter(t, cfg)
+
+	require.Eventually(t, func() bool {
+		appendSamples(t, s, labels.FromStrings("__name__", "metric_a", "tenant", "a"), labels.FromStrings("__name__", "metric_b", "tenant", "b"))
+		return recv.Received("metric_a") > 0 && recv.Received("metric_b") > 0
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Equal(t, "a", recv.Tenant("metric_a"))
+	require.Equal(t, "b", recv.Tenant("metric_b"))
+}
+
+func TestRouter_LowestSentTimestamp(t *testing.T) {
+	var (
+		teamA = newTestReceiver(t)
+		other = newTestReceiver(t)
+	)
+
+	cfg := Config{
+		Routes: []*RouteConfig{
+			testRoute("other", `{team!="a"}`, "other"),
+			testRoute("team-a", `{team="a"}`, "team-a"),
+		},
+		RemoteWrite:   []*config.RemoteWriteConfig{other.Config("other"), teamA.Config("team-a")},
+		FlushDeadline: time.Second,
+	}
+	s, r := runTestRouter(t, cfg)
+
+	// No samples are written for team-a, which must not hold back the
+	// timestamp.
+	start := timestamp.FromTime(time.Now())
+	require.Eventually(t, func() bool {
+		appendSamples(t, s, labels.FromStrings("__name__", "metric_b", "team", "b"))
+		return other.Received("metric_b") > 0 && r.LowestSentTimestamp() >= start
+	}, 10*time.Second, 50*time.Millisecond)
+	require.Zero(t, teamA.Received("metric_b"))
+}
+
+func TestValidate(t *testing.T) {
+	rw := []*config.RemoteWriteConfig{{Name: "a"}, {Name: "b"}}
+
+	tt := []struct {
+		name   string
+		routes []*RouteConfig
+		expect string
+	}{
+		{
+			name:   "valid",
+			routes: []*RouteConfig{testRoute("route", `{team="a"}`, "a", "b")},
+		},
+		{
+			name:   "unknown remote_write",
+			routes: []*RouteConfig{testRoute("route", "", "c")},
+			expect: `route "route" references unknown remote_write "c"`,
+		},
+		{
+			name:   "duplicate route",
+			routes: []*RouteConfig{testRoute("route", "", "a"), testRoute("route", "", "b")},
+			expect: `found multiple routes with name "route"`,
+		},
+		{
+			name:   "invalid match",
+			routes: []*RouteConfig{testRoute("route", `{team=}`, "a")},
+			expect: `route "route": invalid match`,
+		},
+		{
+			name:   "no remote_write",
+			routes: []*RouteConfig{testRoute("route", "")},
+			expect: `route "route" must list at least one remote_write`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Validate(tc.routes, rw)
+			if tc.expect == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expect)
+			}
+		})
+	}
+}
+
+func testRoute(name, match string, remoteWrite ...string) *RouteConfig {
+	rc := DefaultRouteConfig
+	rc.Name = name
+	rc.Match = match
+	rc.RemoteWrite = remoteWrite
+	return &rc
+}
+
+// runTestRouter runs a Router reading from a new WAL. The WAL is returned so
+// tests can append samples.
+func runTestRouter(t *testing.T, cfg Config) (*wal.Storage, *Router) {
+	t.Helper()
+
+	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(walDir) })
+
+	s, err := wal.NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = s.Close() })
+
+	r := New(log.NewNopLogger(), nil, walDir)
+	require.NoError(t, r.ApplyConfig(cfg))
+	r.Start()
+	t.Cleanup(r.Stop)
+
+	return s, r
+}
+
+func appendSamples(t *testing.T, s *wal.Storage, series ...labels.Labels) {
+	t.Helper()
+
+	app := s.Appender(context.Background())
+	for _, lbls := range series {
+		_, err := app.Append(0, lbls, timestamp.FromTime(time.Now()), 1)
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+}
+
+type testReceiver struct {
+	srv *httptest.Server
+
+	mut      sync.Mutex
+	failing  bool
+	received map[string]int    // Samples received by metric name.
+	tenants  map[string]string // Last tenant by metric name.
+}
+
+func newTestReceiver(t *testing.T) *testReceiver {
+	r := &testReceiver{
+		received: make(map[string]int),
+		tenants:  make(map[string]string),
+	}
+	r.srv = httptest.NewServer(http.HandlerFunc(r.handle))
+	t.Cleanup(r.srv.Close)
+	return r
+}
+
+func (r *testReceiver) handle(w http.ResponseWriter, req *http.Request) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.failing {
+		http.Error(w, "failing", http.StatusInternalServerError)
+		return
+	}
+
+	compressed, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	bb, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var wr prompb.WriteRequest
+	if err := proto.Unmarshal(bb, &wr); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	for _, ts := range wr.Timeseries {
+		for _, l := range ts.Labels {
+			if l.Name == model.MetricNameLabel {
+				r.received[l.Value] += len(ts.Samples)
+				r.tenants[l.Value] = req.Header.Get(tenantHeader)
+			}
+		}
+	}
+}
+
+// Config returns a remote_write config which sends to r.
+func (r *testReceiver) Config(name string) *config.RemoteWriteConfig {
+	u, err := url.Parse(r.srv.URL)
+	if err != nil {
+		panic(err)
+	}
+
+	cfg := config.DefaultRemoteWriteConfig
+	cfg.Name = name
+	cfg.URL = &commoncfg.URL{URL: u}
+	cfg.QueueConfig.BatchSendDeadline = model.Duration(10 * time.Millisecond)
+	cfg.QueueConfig.MinBackoff = model.Duration(10 * time.Millisecond)
+	cfg.QueueConfig.MaxBackoff = model.Duration(50 * time.Millisecond)
+	return &cfg
+}
+
+func (r *testReceiver) SetFailing(failing bool) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.failing = failing
+}
+
+func (r *testReceiver) Received(metric string) int {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.received[metric]
+}
+
+func (r *testReceiver) Tenant(metric string) string {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.tenants[metric]
+}
@@ -0,0 +1,79 @@
+// Package scrape is a fork of the scrape package of Prometheus which allows
+// callers to decide at which offset within their scrape interval targets are
+// scraped.
+//
+// Targets are represented by the upstream Target type, so targets of this
+// package can be used wherever upstream targets are expected. Metrics are
+// shared with the upstream package, except for the metadata cache metrics.
+//
+// Unlike scrape managers of the upstream package, a Manager of this package
+// can't be used to send metadata through the remote storage of Prometheus.
+//
+// The copied files must match the version of Prometheus in go.mod. Whoever
+// bumps that version re-applies the changes marked "Modified from upstream"
+// to the new upstream files; TestUpstreamVersion fails until they do.
+package scrape
+
+import (
+	"time"
+
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/scrape"
+)
+
+// OffsetFunc returns the offset within interval at which t is scraped: t is
+// scraped whenever the time since the Unix epoch modulo interval equals the
+// offset. hashed is the offset derived from the hash of t, which is used
+// when no OffsetFunc is set.
+//
+// Offsets outside of [0, interval) are wrapped around interval.
+type OffsetFunc func(t *Target, interval, hashed time.Duration) time.Duration
+
+// HashedOffset returns the offset within its scrape interval at which a
+// scrape manager of the upstream scrape package scrapes t. cfg is the scrape
+// config which discovered t and jitterSeed is the seed of the scrape manager,
+// as returned by JitterSeed.
+func HashedOffset(t *Target, cfg *config.ScrapeConfig, jitterSeed uint64) (time.Duration, error) {
+	// The labels which identify t aren't exposed, but they're built from its
+	// discovered labels the same way every time.
+	lbls, _, err := scrape.PopulateLabels(t.DiscoveredLabels(), cfg)
+	if err != nil {
+		return 0, err
+	}
+	tgt := target{Target: t, labels: lbls}
+
+	interval, _, err := tgt.intervalAndTimeout(time.Duration(cfg.ScrapeInterval), time.Duration(cfg.ScrapeTimeout))
+	if err != nil {
+		return 0, err
+	}
+	return hashedOffset(tgt.hash(), jitterSeed, interval), nil
+}
+
+// hashedOffset returns the offset of a target with the given hash. Mixing in
+// the jitter seed of the Manager spreads scrapes of the same target across
+// agents with different external labels.
+func hashedOffset(hash, jitterSeed uint64, interval time.Duration) time.Duration {
+	return time.Duration((hash ^ jitterSeed) % uint64(interval))
+}
+
+// normalizeOffset wraps offset around interval.
+func normalizeOffset(offset, interval time.Duration) time.Duration {
+	offset %= interval
+	if offset < 0 {
+		offset += interval
+	}
+	return offset
+}
+
+// untilOffset returns the time from now until the next time which is at
+// offset within interval.
+func untilOffset(now time.Time, interval, offset time.Duration) time.Duration {
+	var (
+		base = int64(interval) - now.UnixNano()%int64(interval)
+		next = base + int64(offset)
+	)
+	if next > int64(interval) {
+		next -= int64(interval)
+	}
+	return time.Duration(next)
+}
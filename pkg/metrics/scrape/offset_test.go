@@ -0,0 +1,165 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime/debug"
+	"testing"
+	"time"
+
+	"github.com/go-kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// upstreamVersion is the version of the Prometheus module the files of this
+// package were copied from.
+const upstreamVersion = "github.com/grafana/prometheus@v1.8.2-0.20220413182558-6b32d0b957c5"
+
+// TestUpstreamVersion ensures the fork is updated along with Prometheus.
+func TestUpstreamVersion(t *testing.T) {
+	bi, ok := debug.ReadBuildInfo()
+	require.True(t, ok, "build info not available")
+
+	for _, dep := range bi.Deps {
+		if dep.Path != "github.com/prometheus/prometheus" {
+			continue
+		}
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		require.Equal(t, upstreamVersion, dep.Path+"@"+dep.Version,
+			"Prometheus was updated; copy the new upstream scrape files into this package, re-apply the changes marked \"Modified from upstream\" and update upstreamVersion")
+		return
+	}
+	require.FailNow(t, "Prometheus isn't a dependency")
+}
+
+func TestManager_Offset(t *testing.T) {
+	const (
+		interval = time.Second
+		offset   = 300 * time.Millisecond
+	)
+
+	scrapes := make(chan time.Time, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		scrapes <- time.Now()
+		_, _ = w.Write([]byte("up 1\n"))
+	}))
+	defer srv.Close()
+
+	m := NewManager(&Options{
+		Offset: func(_ *Target, _, _ time.Duration) time.Duration { return offset },
+	}, log.NewNopLogger(), nopAppendable{})
+	defer m.Stop()
+
+	sc := testScrapeConfig(interval)
+	require.NoError(t, m.ApplyConfig(&config.Config{
+		ScrapeConfigs: []*config.ScrapeConfig{sc},
+	}))
+	m.updateTsets(map[string][]*targetgroup.Group{
+		sc.JobName: {testTargetGroup(t, srv.URL)},
+	})
+	m.reload()
+
+	offsets := m.TargetOffsets()
+	require.Len(t, offsets, 1)
+	for _, o := range offsets {
+		require.Equal(t, offset, o)
+	}
+
+	select {
+	case ts := <-scrapes:
+		phase := time.Duration(ts.UnixNano() % int64(interval))
+		require.InDelta(t, offset, phase, float64(100*time.Millisecond))
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "target wasn't scraped")
+	}
+}
+
+func TestHashedOffset(t *testing.T) {
+	const interval = time.Minute
+
+	m := NewManager(nil, log.NewNopLogger(), nopAppendable{})
+	defer m.Stop()
+
+	externalLabels := labels.FromStrings("cluster", "test")
+	sc := testScrapeConfig(interval)
+	require.NoError(t, m.ApplyConfig(&config.Config{
+		GlobalConfig:  config.GlobalConfig{ExternalLabels: externalLabels},
+		ScrapeConfigs: []*config.ScrapeConfig{sc},
+	}))
+	m.updateTsets(map[string][]*targetgroup.Group{
+		sc.JobName: {testTargetGroup(t, "http://127.0.0.1:12345")},
+	})
+	m.reload()
+
+	seed, err := JitterSeed(externalLabels)
+	require.NoError(t, err)
+
+	// Without an Offset function, targets are scraped at the same offset as
+	// scrape managers of Prometheus would use.
+	offsets := m.TargetOffsets()
+	require.Len(t, offsets, 1)
+	for tgt, offset := range offsets {
+		expect, err := HashedOffset(tgt, sc, seed)
+		require.NoError(t, err)
+		require.Equal(t, expect, offset)
+	}
+}
+
+func TestUntilOffset(t *testing.T) {
+	const interval = 10 * time.Second
+	now := time.Unix(103, 0)
+
+	require.Equal(t, 8*time.Second, untilOffset(now, interval, time.Second))
+	require.Equal(t, 7*time.Second, untilOffset(now, interval, 0))
+	require.Equal(t, 9*time.Second, untilOffset(now, interval, 2*time.Second))
+	require.Equal(t, 10*time.Second, untilOffset(now, interval, 3*time.Second))
+}
+
+func testScrapeConfig(interval time.Duration) *config.ScrapeConfig {
+	sc := config.DefaultScrapeConfig
+	sc.JobName = "test"
+	sc.ScrapeInterval = model.Duration(interval)
+	sc.ScrapeTimeout = model.Duration(interval / 2)
+	sc.MetricsPath = "/metrics"
+	sc.Scheme = "http"
+	return &sc
+}
+
+func testTargetGroup(t *testing.T, rawURL string) *targetgroup.Group {
+	t.Helper()
+
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+
+	return &targetgroup.Group{
+		Source:  "test",
+		Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(u.Host)}},
+	}
+}
+
+type nopAppendable struct{}
+
+func (nopAppendable) Appender(context.Context) storage.Appender { return nopAppender{} }
+
+type nopAppender struct{}
+
+func (nopAppender) Append(storage.SeriesRef, labels.Labels, int64, float64) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+func (nopAppender) AppendExemplar(storage.SeriesRef, labels.Labels, exemplar.Exemplar) (storage.SeriesRef, error) {
+	return 0, nil
+}
+
+func (nopAppender) Commit() error   { return nil }
+func (nopAppender) Rollback() error { return nil }
@@ -0,0 +1,155 @@
+// Copyright 2013 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Copied from github.com/prometheus/prometheus/scrape/target.go of
+// github.com/grafana/prometheus v1.8.2-0.20220413182558-6b32d0b957c5.
+// Only the parts which use unexported fields of Target are copied; the
+// upstream Target type is used as-is.
+
+package scrape
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/value"
+	"github.com/prometheus/prometheus/scrape"
+	"github.com/prometheus/prometheus/storage"
+)
+
+type (
+	// Target refers to a singular HTTP or HTTPS endpoint.
+	Target = scrape.Target
+
+	// MetricMetadata is a piece of metadata for a metric.
+	MetricMetadata = scrape.MetricMetadata
+)
+
+// target is a Target along with the full set of its labels. Upstream Target
+// only exposes labels which aren't reserved, but all of them are needed to
+// identify the target.
+type target struct {
+	*Target
+
+	labels labels.Labels
+}
+
+// targetsFromGroup builds targets based on the given TargetGroup and config.
+func targetsFromGroup(tg *targetgroup.Group, cfg *config.ScrapeConfig) ([]target, []error) {
+	targets := make([]target, 0, len(tg.Targets))
+	failures := []error{}
+
+	for i, tlset := range tg.Targets {
+		lbls := make([]labels.Label, 0, len(tlset)+len(tg.Labels))
+
+		for ln, lv := range tlset {
+			lbls = append(lbls, labels.Label{Name: string(ln), Value: string(lv)})
+		}
+		for ln, lv := range tg.Labels {
+			if _, ok := tlset[ln]; !ok {
+				lbls = append(lbls, labels.Label{Name: string(ln), Value: string(lv)})
+			}
+		}
+
+		lset := labels.New(lbls...)
+
+		lbls, origLabels, err := scrape.PopulateLabels(lset, cfg)
+		if err != nil {
+			failures = append(failures, errors.Wrapf(err, "instance %d in group %s", i, tg))
+		}
+		if lbls != nil || origLabels != nil {
+			targets = append(targets, target{
+				Target: scrape.NewTarget(lbls, origLabels, cfg.Params),
+				labels: lbls,
+			})
+		}
+	}
+	return targets, failures
+}
+
+// hash returns an identifying hash for the target.
+func (t target) hash() uint64 {
+	h := fnv.New64a()
+
+	//nolint: errcheck
+	h.Write([]byte(fmt.Sprintf("%016d", t.labels.Hash())))
+	//nolint: errcheck
+	h.Write([]byte(t.URL().String()))
+
+	return h.Sum64()
+}
+
+// intervalAndTimeout returns the interval and timeout derived from
+// the targets labels.
+func (t target) intervalAndTimeout(defaultInterval, defaultDuration time.Duration) (time.Duration, time.Duration, error) {
+	intervalLabel := t.labels.Get(model.ScrapeIntervalLabel)
+	interval, err := model.ParseDuration(intervalLabel)
+	if err != nil {
+		return defaultInterval, defaultDuration, errors.Errorf("Error parsing interval label %q: %v", intervalLabel, err)
+	}
+	timeoutLabel := t.labels.Get(model.ScrapeTimeoutLabel)
+	timeout, err := model.ParseDuration(timeoutLabel)
+	if err != nil {
+		return defaultInterval, defaultDuration, errors.Errorf("Error parsing timeout label %q: %v", timeoutLabel, err)
+	}
+
+	return time.Duration(interval), time.Duration(timeout), nil
+}
+
+var errSampleLimit = errors.New("sample limit exceeded")
+
+// limitAppender limits the number of total appended samples in a batch.
+type limitAppender struct {
+	storage.Appender
+
+	limit int
+	i     int
+}
+
+func (app *limitAppender) Append(ref storage.SeriesRef, lset labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	if !value.IsStaleNaN(v) {
+		app.i++
+		if app.i > app.limit {
+			return 0, errSampleLimit
+		}
+	}
+	ref, err := app.Appender.Append(ref, lset, t, v)
+	if err != nil {
+		return 0, err
+	}
+	return ref, nil
+}
+
+type timeLimitAppender struct {
+	storage.Appender
+
+	maxTime int64
+}
+
+func (app *timeLimitAppender) Append(ref storage.SeriesRef, lset labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	if t > app.maxTime {
+		return 0, storage.ErrOutOfBounds
+	}
+
+	ref, err := app.Appender.Append(ref, lset, t, v)
+	if err != nil {
+		return 0, err
+	}
+	return ref, nil
+}
@@ -0,0 +1,339 @@
+package wal
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/go-kit/log/level"
+	"github.com/prometheus/prometheus/tsdb/record"
+	"github.com/prometheus/prometheus/tsdb/wal"
+)
+
+// replayConcurrency returns the number of goroutines used to decode records
+// and to replay samples.
+func (w *Storage) replayConcurrency() int {
+	if w.opts.ReplayConcurrency > 0 {
+		return w.opts.ReplayConcurrency
+	}
+	return runtime.GOMAXPROCS(0)
+}
+
+func (w *Storage) replayWAL() error {
+	w.walMtx.RLock()
+	defer w.walMtx.RUnlock()
+
+	if w.walClosed {
+		return ErrWALClosed
+	}
+
+	start := time.Now()
+	level.Info(w.logger).Log("msg", "replaying WAL, this may take a while", "dir", w.wal.Dir(), "concurrency", w.replayConcurrency())
+	dir, startFrom, err := wal.LastCheckpoint(w.wal.Dir())
+	if err != nil && err != record.ErrNotFound {
+		return fmt.Errorf("find last checkpoint: %w", err)
+	}
+
+	if err == nil {
+		sr, err := wal.NewSegmentsReader(dir)
+		if err != nil {
+			return fmt.Errorf("open checkpoint: %w", err)
+		}
+		defer func() {
+			if err := sr.Close(); err != nil {
+				level.Warn(w.logger).Log("msg", "error while closing the wal segments reader", "err", err)
+			}
+		}()
+
+		// A corrupted checkpoint is a hard error for now and requires user
+		// intervention. There's likely little data that can be recovered anyway.
+		if err := w.loadWAL(wal.NewReader(sr)); err != nil {
+			return fmt.Errorf("backfill checkpoint: %w", err)
+		}
+		startFrom++
+		level.Info(w.logger).Log("msg", "WAL checkpoint loaded", "duration", time.Since(start))
+	}
+
+	// Find the last segment.
+	_, last, err := wal.Segments(w.wal.Dir())
+	if err != nil {
+		return fmt.Errorf("finding WAL segments: %w", err)
+	}
+
+	if last >= startFrom {
+		w.metrics.replaySegments.Set(float64(last - startFrom + 1))
+	}
+
+	// Backfill segments from the most recent checkpoint onwards.
+	for i := startFrom; i <= last; i++ {
+		s, err := wal.OpenReadSegment(wal.SegmentName(w.wal.Dir(), i))
+		if err != nil {
+			return fmt.Errorf("open WAL segment %d: %w", i, err)
+		}
+
+		sr := wal.NewSegmentBufReader(s)
+		err = w.loadWAL(wal.NewReader(sr))
+		if err := sr.Close(); err != nil {
+			level.Warn(w.logger).Log("msg", "error while closing the wal segments reader", "err", err)
+		}
+		if err != nil {
+			return err
+		}
+
+		w.metrics.replaySegmentsReplayed.Inc()
+		level.Info(w.logger).Log("msg", "WAL segment loaded", "segment", i, "maxSegment", last, "duration", time.Since(start))
+	}
+
+	elapsed := time.Since(start)
+	w.metrics.replayDuration.Set(elapsed.Seconds())
+	level.Info(w.logger).Log("msg", "WAL replay completed", "duration", elapsed)
+	return nil
+}
+
+// replayRecord is a record read from the WAL, decoded by one of the decoder
+// goroutines of loadWAL.
+type replayRecord struct {
+	rec     []byte
+	segment int
+	offset  int64
+
+	// result receives the decoded record. It is buffered so decoders never
+	// block.
+	result chan decodedRecord
+}
+
+type decodedRecord struct {
+	series  []record.RefSeries
+	samples []record.RefSample
+	err     error
+}
+
+// loadWAL replays the records read from r.
+//
+// Like the head replay of Prometheus, records are decoded concurrently, and
+// samples are sharded by series across workers which update the timestamps
+// of their series. Series are created in the order of their records, before
+// any later sample record is handed to the workers, so workers always find
+// the series their samples reference.
+func (w *Storage) loadWAL(r *wal.Reader) (err error) {
+	var (
+		concurrency = w.replayConcurrency()
+
+		// Records are sent to the decoders through records, and to the loop
+		// below through ordered, which preserves the order of the WAL.
+		records = make(chan *replayRecord, concurrency*4)
+		ordered = make(chan *replayRecord, concurrency*4)
+		stop    = make(chan struct{})
+		readErr error
+
+		recordPool = sync.Pool{
+			New: func() interface{} { return []byte{} },
+		}
+		seriesPool = sync.Pool{
+			New: func() interface{} { return []record.RefSeries{} },
+		}
+		samplesPool = sync.Pool{
+			New: func() interface{} { return []record.RefSample{} },
+		}
+		shardPool = sync.Pool{
+			New: func() interface{} { return []record.RefSample{} },
+		}
+
+		readers sync.WaitGroup
+		workers sync.WaitGroup
+	)
+
+	// Read records.
+	readers.Add(1)
+	go func() {
+		defer readers.Done()
+		defer close(records)
+		defer close(ordered)
+
+		var dec record.Decoder
+		for r.Next() {
+			rec := r.Record()
+			switch typ := dec.Type(rec); typ {
+			case record.Series, record.Samples:
+			case record.Tombstones, record.Exemplars:
+				// We don't care about decoding tombstones or exemplars
+				// TODO: If decide to decode exemplars, we should make sure to prepopulate
+				// stripeSeries.exemplars in the next block by using setLatestExemplar.
+				continue
+			default:
+				readErr = &wal.CorruptionErr{
+					Err:     fmt.Errorf("invalid record type %v", typ),
+					Segment: r.Segment(),
+					Offset:  r.Offset(),
+				}
+				return
+			}
+
+			// r reuses the buffer of the record, so it has to be copied before
+			// being decoded concurrently.
+			rr := &replayRecord{
+				rec:     append(recordPool.Get().([]byte)[:0], rec...),
+				segment: r.Segment(),
+				offset:  r.Offset(),
+				result:  make(chan decodedRecord, 1),
+			}
+			select {
+			case ordered <- rr:
+			case <-stop:
+				return
+			}
+			select {
+			case records <- rr:
+			case <-stop:
+				return
+			}
+		}
+		if r.Err() != nil {
+			readErr = fmt.Errorf("read records: %w", r.Err())
+		}
+	}()
+
+	// Decode records.
+	for i := 0; i < concurrency; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+
+			var dec record.Decoder
+			for rr := range records {
+				var (
+					d   decodedRecord
+					err error
+				)
+				switch dec.Type(rr.rec) {
+				case record.Series:
+					d.series, err = dec.Series(rr.rec, seriesPool.Get().([]record.RefSeries)[:0])
+					if err != nil {
+						err = fmt.Errorf("decode series: %w", err)
+					}
+				case record.Samples:
+					d.samples, err = dec.Samples(rr.rec, samplesPool.Get().([]record.RefSample)[:0])
+					if err != nil {
+						err = fmt.Errorf("decode samples: %w", err)
+					}
+				}
+				if err != nil {
+					d.err = &wal.CorruptionErr{Err: err, Segment: rr.segment, Offset: rr.offset}
+				}
+
+				//nolint:staticcheck
+				recordPool.Put(rr.rec)
+				rr.result <- d
+			}
+		}()
+	}
+
+	// Replay samples. Each worker owns the series whose ref maps to it, so
+	// the timestamps of a series are only updated by one worker.
+	shards := make([]chan []record.RefSample, concurrency)
+	for i := range shards {
+		shards[i] = make(chan []record.RefSample, 4)
+
+		workers.Add(1)
+		go func(in <-chan []record.RefSample) {
+			defer workers.Done()
+
+			for samples := range in {
+				for _, s := range samples {
+					series := w.series.getByID(s.Ref)
+					if series == nil {
+						level.Warn(w.logger).Log("msg", "found sample referencing non-existing series, skipping")
+						continue
+					}
+
+					series.Lock()
+					if s.T > series.lastTs {
+						series.lastTs = s.T
+					}
+					series.Unlock()
+				}
+
+				//nolint:staticcheck
+				shardPool.Put(samples)
+			}
+		}(shards[i])
+	}
+
+	var (
+		biggestRef  = w.ref.Load()
+		shardedBufs = make([][]record.RefSample, concurrency)
+	)
+
+	for rr := range ordered {
+		d := <-rr.result
+		if d.err != nil {
+			err = d.err
+			break
+		}
+
+		if d.series != nil {
+			for _, s := range d.series {
+				// If this is a new series, create it in memory without a timestamp.
+				// If we read in a sample for it, we'll use the timestamp of the latest
+				// sample. Otherwise, the series is stale and will be deleted once
+				// the truncation is performed.
+				if w.series.getByID(s.Ref) == nil {
+					series := &memSeries{ref: s.Ref, lset: s.Labels, lastTs: 0}
+					w.series.set(s.Labels.Hash(), series)
+
+					w.activeSeries.Inc()
+					w.metrics.numActiveSeries.Inc()
+					w.metrics.totalCreatedSeries.Inc()
+
+					if biggestRef <= uint64(s.Ref) {
+						biggestRef = uint64(s.Ref)
+					}
+				}
+			}
+
+			//nolint:staticcheck
+			seriesPool.Put(d.series)
+		}
+
+		if d.samples != nil {
+			for _, s := range d.samples {
+				shard := uint64(s.Ref) % uint64(concurrency)
+				if shardedBufs[shard] == nil {
+					shardedBufs[shard] = shardPool.Get().([]record.RefSample)[:0]
+				}
+				shardedBufs[shard] = append(shardedBufs[shard], s)
+			}
+			for i, buf := range shardedBufs {
+				if buf != nil {
+					shards[i] <- buf
+					shardedBufs[i] = nil
+				}
+			}
+
+			//nolint:staticcheck
+			samplesPool.Put(d.samples)
+		}
+	}
+
+	if err != nil {
+		// Stop reading and drain the remaining records so the reader and
+		// decoders exit.
+		close(stop)
+		for range ordered {
+		}
+	}
+	readers.Wait()
+
+	for _, shard := range shards {
+		close(shard)
+	}
+	workers.Wait()
+
+	w.ref.Store(biggestRef)
+
+	if err != nil {
+		return err
+	}
+	return readErr
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"os"
 	"sync"
 	"time"
 	"unicode/utf8"
@@ -22,12 +23,140 @@ import (
 	"github.com/prometheus/prometheus/tsdb/record"
 	"github.com/prometheus/prometheus/tsdb/wal"
 	"go.uber.org/atomic"
+	"golang.org/x/time/rate"
 )
 
 // ErrWALClosed is an error returned when a WAL operation can't run because the
 // storage has already been closed.
 var ErrWALClosed = fmt.Errorf("WAL storage closed")
 
+// ErrWALFull is an error returned when an append is rejected because the WAL
+// reached its size limit and uses SizePolicyBlock.
+var ErrWALFull = fmt.Errorf("WAL storage reached its size limit")
+
+// ErrTooManySeries is an error returned when a sample for a new series is
+// rejected because the storage reached its maximum number of active series.
+//
+// Like ErrSampleRateLimited, it wraps storage.ErrOutOfBounds so the scrape
+// loop only drops the rejected sample and keeps appending the rest of the
+// scrape.
+var ErrTooManySeries error = &limitError{msg: "too many active series"}
+
+// ErrSampleRateLimited is an error returned when a sample is rejected because
+// the storage exceeded its maximum number of samples per second.
+var ErrSampleRateLimited error = &limitError{msg: "sample rate limit exceeded"}
+
+// limitError is an error for samples rejected by a limit of the storage.
+type limitError struct{ msg string }
+
+func (e *limitError) Error() string { return e.msg }
+
+// Cause is used by the scrape loop to categorize errors.
+func (e *limitError) Cause() error { return storage.ErrOutOfBounds }
+
+func (e *limitError) Unwrap() error { return storage.ErrOutOfBounds }
+
+// sampleRateBurst is how long samples may be appended faster than the
+// maximum sample rate. This allows scrapes, which append all their samples
+// at once, to be averaged over their scrape interval.
+const sampleRateBurst = time.Minute
+
+// Reasons samples may be rejected, used as the reason label of
+// agent_wal_samples_rejected_total.
+const (
+	reasonWALFull     = "wal_full"
+	reasonSeriesLimit = "series_limit"
+	reasonRateLimit   = "rate_limit"
+)
+
+// SizePolicy determines what a Storage does once its WAL reaches a size limit.
+type SizePolicy string
+
+// Supported values for SizePolicy.
+const (
+	// SizePolicyDropOldest removes the oldest segments of the WAL, dropping
+	// all samples they hold, until the WAL is back under its limits.
+	SizePolicyDropOldest SizePolicy = "drop_oldest"
+
+	// SizePolicyBlock rejects appends until enough space has been freed by
+	// truncation. Options.OnFull is called while appends are rejected.
+	SizePolicyBlock SizePolicy = "block"
+)
+
+// Options holds optional settings for a Storage.
+type Options struct {
+	// MaxSize is the maximum size in bytes of the WAL on disk. 0 disables the
+	// limit.
+	MaxSize int64
+
+	// Budget, when non-nil, limits the combined size of this Storage and all
+	// other storages sharing the same Budget.
+	Budget *SizeBudget
+
+	// Policy is used when MaxSize or Budget is exceeded. Defaults to
+	// SizePolicyDropOldest.
+	Policy SizePolicy
+
+	// OnFull, when non-nil, is called after a commit is rejected because the
+	// WAL is full and uses SizePolicyBlock, so the owner of the Storage can
+	// truncate it without waiting for its next scheduled truncation. OnFull
+	// is called on the commit path and must not block.
+	OnFull func()
+
+	// MaxActiveSeries is the maximum number of active series. Samples for new
+	// series are rejected with ErrTooManySeries once the limit is reached. 0
+	// disables the limit.
+	MaxActiveSeries int
+
+	// MaxSamplesPerSecond is the maximum rate of appended samples. Samples
+	// are rejected with ErrSampleRateLimited once the rate is exceeded for
+	// longer than a minute. Staleness markers are never rejected. 0 disables
+	// the limit.
+	MaxSamplesPerSecond float64
+
+	// ReplayConcurrency is the number of goroutines used to decode records
+	// and replay samples when an existing WAL is opened. Defaults to
+	// GOMAXPROCS.
+	ReplayConcurrency int
+
+	// segmentSize overrides the size of WAL segments in tests.
+	segmentSize int
+}
+
+// SizeBudget is a size limit shared between multiple storages. It is safe for
+// concurrent use.
+type SizeBudget struct {
+	max  atomic.Int64
+	used atomic.Int64
+}
+
+// NewSizeBudget creates a SizeBudget which allows up to max bytes. 0 disables
+// the limit.
+func NewSizeBudget(max int64) *SizeBudget {
+	var b SizeBudget
+	b.max.Store(max)
+	return &b
+}
+
+// SetMax changes the maximum number of bytes allowed by b. 0 disables the
+// limit.
+func (b *SizeBudget) SetMax(max int64) { b.max.Store(max) }
+
+// Used returns the combined size in bytes of all storages using b.
+func (b *SizeBudget) Used() int64 { return b.used.Load() }
+
+// excess returns how many bytes b is over its limit.
+func (b *SizeBudget) excess() int64 {
+	if b == nil {
+		return 0
+	}
+	max := b.max.Load()
+	if max <= 0 {
+		return 0
+	}
+	return b.used.Load() - max
+}
+
 type storageMetrics struct {
 	r prometheus.Registerer
 
@@ -37,6 +166,14 @@ type storageMetrics struct {
 	totalRemovedSeries     prometheus.Counter
 	totalAppendedSamples   prometheus.Counter
 	totalAppendedExemplars prometheus.Counter
+	totalDroppedSamples    prometheus.Counter
+	totalRejectedSamples   *prometheus.CounterVec
+	totalRejectedSeries    prometheus.Counter
+	storageSize            prometheus.Gauge
+
+	replaySegments         prometheus.Gauge
+	replaySegmentsReplayed prometheus.Gauge
+	replayDuration         prometheus.Gauge
 }
 
 func newStorageMetrics(r prometheus.Registerer) *storageMetrics {
@@ -71,6 +208,41 @@ func newStorageMetrics(r prometheus.Registerer) *storageMetrics {
 		Help: "Total number of exemplars appended to the WAL",
 	})
 
+	m.totalDroppedSamples = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_wal_samples_dropped_total",
+		Help: "Total number of samples dropped from the WAL to keep it under its size limit",
+	})
+
+	m.totalRejectedSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "agent_wal_samples_rejected_total",
+		Help: "Total number of samples rejected by a limit of the WAL storage",
+	}, []string{"reason"})
+
+	m.totalRejectedSeries = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "agent_wal_series_rejected_total",
+		Help: "Total number of attempts to create a series rejected because of the active series limit",
+	})
+
+	m.storageSize = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_wal_storage_size_bytes",
+		Help: "Current size of the WAL on disk in bytes",
+	})
+
+	m.replaySegments = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_wal_replay_segments",
+		Help: "Number of WAL segments to replay when the WAL storage was opened",
+	})
+
+	m.replaySegmentsReplayed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_wal_replay_segments_replayed",
+		Help: "Number of WAL segments replayed so far since the WAL storage was opened",
+	})
+
+	m.replayDuration = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "agent_wal_replay_duration_seconds",
+		Help: "Time taken to replay the WAL when the WAL storage was opened",
+	})
+
 	if r != nil {
 		r.MustRegister(
 			m.numActiveSeries,
@@ -79,6 +251,13 @@ func newStorageMetrics(r prometheus.Registerer) *storageMetrics {
 			m.totalRemovedSeries,
 			m.totalAppendedSamples,
 			m.totalAppendedExemplars,
+			m.totalDroppedSamples,
+			m.totalRejectedSamples,
+			m.totalRejectedSeries,
+			m.storageSize,
+			m.replaySegments,
+			m.replaySegmentsReplayed,
+			m.replayDuration,
 		)
 	}
 
@@ -96,6 +275,13 @@ func (m *storageMetrics) Unregister() {
 		m.totalRemovedSeries,
 		m.totalAppendedSamples,
 		m.totalAppendedExemplars,
+		m.totalDroppedSamples,
+		m.totalRejectedSamples,
+		m.totalRejectedSeries,
+		m.storageSize,
+		m.replaySegments,
+		m.replaySegmentsReplayed,
+		m.replayDuration,
 	}
 	for _, c := range cs {
 		m.r.Unregister(c)
@@ -128,12 +314,54 @@ type Storage struct {
 	deletedMtx sync.Mutex
 	deleted    map[chunks.HeadSeriesRef]int // Deleted series, and what WAL segment they must be kept until.
 
+	// checkpointMtx prevents truncation and size-based eviction from creating
+	// checkpoints at the same time.
+	checkpointMtx sync.Mutex
+
+	opts    Options
+	limiter *rate.Limiter // nil when there is no sample rate limit.
+	size    atomic.Int64  // Size of the WAL on disk. Estimated between refreshes.
+	full    atomic.Bool   // Set when appends are rejected by SizePolicyBlock.
+
+	// evicting is set while segments are evicted in the background by
+	// SizePolicyDropOldest.
+	evicting atomic.Bool
+
+	activeSeries atomic.Int64
+
 	metrics *storageMetrics
 }
 
-// NewStorage makes a new Storage.
+// NewStorage makes a new Storage without size limits.
 func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string) (*Storage, error) {
-	w, err := wal.NewSize(logger, registerer, SubDirectory(path), wal.DefaultSegmentSize, true)
+	return NewStorageWithOptions(logger, registerer, path, Options{})
+}
+
+// NewStorageWithOptions makes a new Storage using the provided options.
+func NewStorageWithOptions(logger log.Logger, registerer prometheus.Registerer, path string, opts Options) (*Storage, error) {
+	switch opts.Policy {
+	case "":
+		opts.Policy = SizePolicyDropOldest
+	case SizePolicyDropOldest, SizePolicyBlock:
+	default:
+		return nil, fmt.Errorf("unknown WAL size policy %q", opts.Policy)
+	}
+	if opts.MaxActiveSeries < 0 {
+		return nil, fmt.Errorf("max active series must not be negative")
+	}
+	if opts.MaxSamplesPerSecond < 0 {
+		return nil, fmt.Errorf("max samples per second must not be negative")
+	}
+	if opts.ReplayConcurrency < 0 {
+		return nil, fmt.Errorf("replay concurrency must not be negative")
+	}
+
+	segmentSize := wal.DefaultSegmentSize
+	if opts.segmentSize > 0 {
+		segmentSize = opts.segmentSize
+	}
+
+	w, err := wal.NewSize(logger, registerer, SubDirectory(path), segmentSize, true)
 	if err != nil {
 		return nil, err
 	}
@@ -146,6 +374,12 @@ func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string
 		series:  newStripeSeries(),
 		metrics: newStorageMetrics(registerer),
 		ref:     atomic.NewUint64(0),
+		opts:    opts,
+	}
+
+	if opts.MaxSamplesPerSecond > 0 {
+		burst := int(math.Ceil(opts.MaxSamplesPerSecond * sampleRateBurst.Seconds()))
+		storage.limiter = rate.NewLimiter(rate.Limit(opts.MaxSamplesPerSecond), burst)
 	}
 
 	storage.bufPool.New = func() interface{} {
@@ -174,197 +408,10 @@ func NewStorage(logger log.Logger, registerer prometheus.Registerer, path string
 		}
 	}
 
+	storage.refreshSize()
 	return storage, nil
 }
 
-func (w *Storage) replayWAL() error {
-	w.walMtx.RLock()
-	defer w.walMtx.RUnlock()
-
-	if w.walClosed {
-		return ErrWALClosed
-	}
-
-	level.Info(w.logger).Log("msg", "replaying WAL, this may take a while", "dir", w.wal.Dir())
-	dir, startFrom, err := wal.LastCheckpoint(w.wal.Dir())
-	if err != nil && err != record.ErrNotFound {
-		return fmt.Errorf("find last checkpoint: %w", err)
-	}
-
-	if err == nil {
-		sr, err := wal.NewSegmentsReader(dir)
-		if err != nil {
-			return fmt.Errorf("open checkpoint: %w", err)
-		}
-		defer func() {
-			if err := sr.Close(); err != nil {
-				level.Warn(w.logger).Log("msg", "error while closing the wal segments reader", "err", err)
-			}
-		}()
-
-		// A corrupted checkpoint is a hard error for now and requires user
-		// intervention. There's likely little data that can be recovered anyway.
-		if err := w.loadWAL(wal.NewReader(sr)); err != nil {
-			return fmt.Errorf("backfill checkpoint: %w", err)
-		}
-		startFrom++
-		level.Info(w.logger).Log("msg", "WAL checkpoint loaded")
-	}
-
-	// Find the last segment.
-	_, last, err := wal.Segments(w.wal.Dir())
-	if err != nil {
-		return fmt.Errorf("finding WAL segments: %w", err)
-	}
-
-	// Backfill segments from the most recent checkpoint onwards.
-	for i := startFrom; i <= last; i++ {
-		s, err := wal.OpenReadSegment(wal.SegmentName(w.wal.Dir(), i))
-		if err != nil {
-			return fmt.Errorf("open WAL segment %d: %w", i, err)
-		}
-
-		sr := wal.NewSegmentBufReader(s)
-		err = w.loadWAL(wal.NewReader(sr))
-		if err := sr.Close(); err != nil {
-			level.Warn(w.logger).Log("msg", "error while closing the wal segments reader", "err", err)
-		}
-		if err != nil {
-			return err
-		}
-		level.Info(w.logger).Log("msg", "WAL segment loaded", "segment", i, "maxSegment", last)
-	}
-
-	return nil
-}
-
-func (w *Storage) loadWAL(r *wal.Reader) (err error) {
-	var (
-		dec record.Decoder
-	)
-
-	var (
-		decoded    = make(chan interface{}, 10)
-		errCh      = make(chan error, 1)
-		seriesPool = sync.Pool{
-			New: func() interface{} {
-				return []record.RefSeries{}
-			},
-		}
-		samplesPool = sync.Pool{
-			New: func() interface{} {
-				return []record.RefSample{}
-			},
-		}
-	)
-
-	go func() {
-		defer close(decoded)
-		for r.Next() {
-			rec := r.Record()
-			switch dec.Type(rec) {
-			case record.Series:
-				series := seriesPool.Get().([]record.RefSeries)[:0]
-				series, err = dec.Series(rec, series)
-				if err != nil {
-					errCh <- &wal.CorruptionErr{
-						Err:     fmt.Errorf("decode series: %w", err),
-						Segment: r.Segment(),
-						Offset:  r.Offset(),
-					}
-					return
-				}
-				decoded <- series
-			case record.Samples:
-				samples := samplesPool.Get().([]record.RefSample)[:0]
-				samples, err = dec.Samples(rec, samples)
-				if err != nil {
-					errCh <- &wal.CorruptionErr{
-						Err:     fmt.Errorf("decode samples: %w", err),
-						Segment: r.Segment(),
-						Offset:  r.Offset(),
-					}
-				}
-				decoded <- samples
-			case record.Tombstones, record.Exemplars:
-				// We don't care about decoding tombstones or exemplars
-				// TODO: If decide to decode exemplars, we should make sure to prepopulate
-				// stripeSeries.exemplars in the next block by using setLatestExemplar.
-				continue
-			default:
-				errCh <- &wal.CorruptionErr{
-					Err:     fmt.Errorf("invalid record type %v", dec.Type(rec)),
-					Segment: r.Segment(),
-					Offset:  r.Offset(),
-				}
-				return
-			}
-		}
-	}()
-
-	var biggestRef uint64 = w.ref.Load()
-
-	for d := range decoded {
-		switch v := d.(type) {
-		case []record.RefSeries:
-			for _, s := range v {
-				// If this is a new series, create it in memory without a timestamp.
-				// If we read in a sample for it, we'll use the timestamp of the latest
-				// sample. Otherwise, the series is stale and will be deleted once
-				// the truncation is performed.
-				if w.series.getByID(s.Ref) == nil {
-					series := &memSeries{ref: s.Ref, lset: s.Labels, lastTs: 0}
-					w.series.set(s.Labels.Hash(), series)
-
-					w.metrics.numActiveSeries.Inc()
-					w.metrics.totalCreatedSeries.Inc()
-
-					if biggestRef <= uint64(s.Ref) {
-						biggestRef = uint64(s.Ref)
-					}
-				}
-			}
-
-			//nolint:staticcheck
-			seriesPool.Put(v)
-		case []record.RefSample:
-			for _, s := range v {
-				// Update the lastTs for the series based
-				series := w.series.getByID(s.Ref)
-				if series == nil {
-					level.Warn(w.logger).Log("msg", "found sample referencing non-existing series, skipping")
-					continue
-				}
-
-				series.Lock()
-				if s.T > series.lastTs {
-					series.lastTs = s.T
-				}
-				series.Unlock()
-			}
-
-			//nolint:staticcheck
-			samplesPool.Put(v)
-		default:
-			panic(fmt.Errorf("unexpected decoded type: %T", d))
-		}
-	}
-
-	w.ref.Store(biggestRef)
-
-	select {
-	case err := <-errCh:
-		return err
-	default:
-	}
-
-	if r.Err() != nil {
-		return fmt.Errorf("read records: %w", r.Err())
-	}
-
-	return nil
-}
-
 // Directory returns the path where the WAL storage is held.
 func (w *Storage) Directory() string {
 	return w.path
@@ -391,6 +438,12 @@ func (w *Storage) Truncate(mint int64) error {
 		return ErrWALClosed
 	}
 
+	w.checkpointMtx.Lock()
+	defer w.checkpointMtx.Unlock()
+
+	// Truncation frees up space, which may allow appends again.
+	defer w.refreshSize()
+
 	start := time.Now()
 
 	// Garbage collect series that haven't received an update since mint.
@@ -421,17 +474,7 @@ func (w *Storage) Truncate(mint int64) error {
 		return nil
 	}
 
-	keep := func(id chunks.HeadSeriesRef) bool {
-		if w.series.getByID(id) != nil {
-			return true
-		}
-
-		w.deletedMtx.Lock()
-		_, ok := w.deleted[id]
-		w.deletedMtx.Unlock()
-		return ok
-	}
-	if _, err = wal.Checkpoint(w.logger, w.wal, first, last, keep, mint); err != nil {
+	if _, err = wal.Checkpoint(w.logger, w.wal, first, last, w.keepSeries, mint); err != nil {
 		return fmt.Errorf("create checkpoint: %w", err)
 	}
 	if err := w.wal.Truncate(last + 1); err != nil {
@@ -465,9 +508,203 @@ func (w *Storage) Truncate(mint int64) error {
 	return nil
 }
 
+// keepSeries returns true if the series with the given ID must be kept in
+// checkpoints.
+func (w *Storage) keepSeries(id chunks.HeadSeriesRef) bool {
+	if w.series.getByID(id) != nil {
+		return true
+	}
+
+	w.deletedMtx.Lock()
+	_, ok := w.deleted[id]
+	w.deletedMtx.Unlock()
+	return ok
+}
+
+// refreshSize updates the tracked size of the WAL from disk. walMtx must be
+// held.
+func (w *Storage) refreshSize() {
+	size, err := w.wal.Size()
+	if err != nil {
+		level.Warn(w.logger).Log("msg", "failed to compute WAL size", "err", err)
+		return
+	}
+	w.setSize(size)
+}
+
+// setSize sets the tracked size of the WAL, updating the shared budget.
+func (w *Storage) setSize(size int64) {
+	old := w.size.Swap(size)
+	if w.opts.Budget != nil {
+		w.opts.Budget.used.Add(size - old)
+	}
+	w.metrics.storageSize.Set(float64(size))
+}
+
+// addSize adds n bytes to the tracked size of the WAL.
+func (w *Storage) addSize(n int64) {
+	size := w.size.Add(n)
+	if w.opts.Budget != nil {
+		w.opts.Budget.used.Add(n)
+	}
+	w.metrics.storageSize.Set(float64(size))
+}
+
+// excess returns how many bytes the WAL is over its most exceeded limit. The
+// result is zero or negative when no limit is exceeded.
+func (w *Storage) excess() int64 {
+	var excess int64
+	if w.opts.MaxSize > 0 {
+		excess = w.size.Load() - w.opts.MaxSize
+	}
+	if budgetExcess := w.opts.Budget.excess(); budgetExcess > excess {
+		excess = budgetExcess
+	}
+	return excess
+}
+
+// rejectAppends returns true if appends must be rejected because the WAL is
+// full and uses SizePolicyBlock.
+func (w *Storage) rejectAppends() bool {
+	if !w.full.Load() {
+		return false
+	}
+	// Space may have been freed by another storage sharing the budget.
+	if w.excess() > 0 {
+		return true
+	}
+	w.full.Store(false)
+	return false
+}
+
+// enforceSizeLimit applies the size policy if the WAL exceeded one of its
+// limits. walMtx must be held.
+func (w *Storage) enforceSizeLimit() {
+	if w.opts.MaxSize <= 0 && w.opts.Budget == nil {
+		return
+	}
+
+	// The tracked size is estimated from uncompressed records, so confirm
+	// that the limit was exceeded by checking the disk.
+	if w.excess() <= 0 {
+		return
+	}
+	w.refreshSize()
+	if w.excess() <= 0 {
+		return
+	}
+
+	switch w.opts.Policy {
+	case SizePolicyBlock:
+		if !w.full.Swap(true) {
+			level.Warn(w.logger).Log("msg", "WAL reached its size limit, rejecting appends until it is truncated", "size", w.size.Load())
+		}
+	default:
+		w.evictInBackground()
+	}
+}
+
+// evictInBackground starts evicting the oldest segments of the WAL in the
+// background, so creating a checkpoint doesn't delay commits. It is a no-op
+// if an eviction is already running.
+func (w *Storage) evictInBackground() {
+	if !w.evicting.CAS(false, true) {
+		return
+	}
+
+	go func() {
+		defer w.evicting.Store(false)
+
+		w.walMtx.RLock()
+		defer w.walMtx.RUnlock()
+		if w.walClosed {
+			return
+		}
+
+		// Commits made while evicting may have grown the WAL again, so keep
+		// evicting until the WAL is under its limits or nothing can be removed.
+		for w.evictOldest() {
+		}
+	}()
+}
+
+// evictOldest removes the oldest segments of the WAL until it is back under
+// its limits or only the active segment is left. Series records of removed
+// segments are kept in a checkpoint, but their samples are dropped. Returns
+// true if segments were removed. walMtx must be held.
+func (w *Storage) evictOldest() bool {
+	// Wait for a running truncation to finish, which may free enough space
+	// by itself.
+	w.checkpointMtx.Lock()
+	defer w.checkpointMtx.Unlock()
+
+	w.refreshSize()
+	excess := w.excess()
+	if excess <= 0 {
+		return false
+	}
+
+	// Start a new segment so all existing segments may be removed. This is
+	// skipped if the active segment is still empty to avoid creating empty
+	// segments while other storages are over the shared budget.
+	_, offset, err := w.wal.LastSegmentAndOffset()
+	if err != nil {
+		level.Error(w.logger).Log("msg", "failed to get active WAL segment", "err", err)
+		return false
+	}
+	if offset > 0 {
+		if err := w.wal.NextSegment(); err != nil {
+			level.Error(w.logger).Log("msg", "failed to start new WAL segment", "err", err)
+			return false
+		}
+	}
+
+	first, last, err := wal.Segments(w.wal.Dir())
+	if err != nil {
+		level.Error(w.logger).Log("msg", "failed to get WAL segment range", "err", err)
+		return false
+	}
+
+	to := first - 1
+	for freed := int64(0); freed < excess && to+1 < last; {
+		to++
+		fi, err := os.Stat(wal.SegmentName(w.wal.Dir(), to))
+		if err != nil {
+			level.Error(w.logger).Log("msg", "failed to get WAL segment size", "segment", to, "err", err)
+			return false
+		}
+		freed += fi.Size()
+	}
+	if to < first {
+		level.Debug(w.logger).Log("msg", "WAL is over its size limit but has no segments to remove", "size", w.size.Load())
+		return false
+	}
+
+	// Samples in the checkpoint are dropped by using the maximum timestamp.
+	stats, err := wal.Checkpoint(w.logger, w.wal, first, to, w.keepSeries, math.MaxInt64)
+	if err != nil {
+		level.Error(w.logger).Log("msg", "failed to create checkpoint for removed segments", "err", err)
+		return false
+	}
+	if err := w.wal.Truncate(to + 1); err != nil {
+		level.Error(w.logger).Log("msg", "truncating segments failed", "err", err)
+	}
+	if err := wal.DeleteCheckpoints(w.wal.Dir(), to); err != nil {
+		level.Error(w.logger).Log("msg", "delete old checkpoints", "err", err)
+	}
+
+	w.metrics.totalDroppedSamples.Add(float64(stats.DroppedSamples))
+	w.refreshSize()
+
+	level.Warn(w.logger).Log("msg", "removed oldest WAL segments to stay under size limit",
+		"first", first, "last", to, "dropped_samples", stats.DroppedSamples, "size", w.size.Load())
+	return true
+}
+
 // gc removes data before the minimum timestamp from the head.
 func (w *Storage) gc(mint int64) {
 	deleted := w.series.gc(mint)
+	w.activeSeries.Sub(int64(len(deleted)))
 	w.metrics.numActiveSeries.Sub(float64(len(deleted)))
 
 	_, last, _ := wal.Segments(w.wal.Dir())
@@ -559,6 +796,9 @@ func (w *Storage) Close() error {
 	}
 	w.walClosed = true
 
+	// Release the space of this storage from the shared budget.
+	w.setSize(0)
+
 	if w.metrics != nil {
 		w.metrics.Unregister()
 	}
@@ -573,6 +813,18 @@ type appender struct {
 }
 
 func (a *appender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	if a.w.rejectAppends() {
+		a.w.metrics.totalRejectedSamples.WithLabelValues(reasonWALFull).Inc()
+		return 0, ErrWALFull
+	}
+
+	// Staleness markers are always accepted so series that are gone are
+	// still marked as stale.
+	if a.w.limiter != nil && !value.IsStaleNaN(v) && !a.w.limiter.Allow() {
+		a.w.metrics.totalRejectedSamples.WithLabelValues(reasonRateLimit).Inc()
+		return 0, ErrSampleRateLimited
+	}
+
 	series := a.w.series.getByID(chunks.HeadSeriesRef(ref))
 	if series == nil {
 		// Ensure no empty or duplicate labels have gotten through. This mirrors the
@@ -586,8 +838,15 @@ func (a *appender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v flo
 			return 0, fmt.Errorf("label name %q is not unique: %w", lbl, tsdb.ErrInvalidSample)
 		}
 
-		var created bool
-		series, created = a.getOrCreate(l)
+		var (
+			created bool
+			err     error
+		)
+		series, created, err = a.getOrCreate(l)
+		if err != nil {
+			a.w.metrics.totalRejectedSamples.WithLabelValues(reasonSeriesLimit).Inc()
+			return 0, err
+		}
 		if created {
 			a.series = append(a.series, record.RefSeries{
 				Ref:    series.ref,
@@ -616,18 +875,37 @@ func (a *appender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v flo
 	return storage.SeriesRef(series.ref), nil
 }
 
-func (a *appender) getOrCreate(l labels.Labels) (series *memSeries, created bool) {
+// reserveSeries counts a new active series. It returns false without
+// counting the series if it would exceed MaxActiveSeries.
+func (w *Storage) reserveSeries() bool {
+	n := w.activeSeries.Inc()
+	if max := w.opts.MaxActiveSeries; max > 0 && n > int64(max) {
+		w.activeSeries.Dec()
+		return false
+	}
+	return true
+}
+
+// getOrCreate returns the series for l, creating it if it doesn't exist.
+// ErrTooManySeries is returned if the series must be created but the
+// storage reached its active series limit.
+func (a *appender) getOrCreate(l labels.Labels) (series *memSeries, created bool, err error) {
 	hash := l.Hash()
 
 	series = a.w.series.getByHash(hash, l)
 	if series != nil {
-		return series, false
+		return series, false, nil
+	}
+
+	if !a.w.reserveSeries() {
+		a.w.metrics.totalRejectedSeries.Inc()
+		return nil, false, ErrTooManySeries
 	}
 
 	ref := chunks.HeadSeriesRef(a.w.ref.Inc())
 	series = &memSeries{ref: ref, lset: l}
 	a.w.series.set(l.Hash(), series)
-	return series, true
+	return series, true, nil
 }
 
 func (a *appender) AppendExemplar(ref storage.SeriesRef, _ labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
@@ -686,6 +964,17 @@ func (a *appender) Commit() error {
 		return ErrWALClosed
 	}
 
+	// Other storages sharing a budget may have used up the remaining space
+	// since the last commit.
+	a.w.enforceSizeLimit()
+
+	// Samples appended before the WAL became full are rejected. Series records
+	// are still written so later samples for them can be resolved.
+	full := a.w.rejectAppends()
+	if full {
+		a.w.metrics.totalRejectedSamples.WithLabelValues(reasonWALFull).Add(float64(len(a.samples)))
+	}
+
 	var encoder record.Encoder
 	buf := a.w.bufPool.Get().([]byte)
 
@@ -694,25 +983,30 @@ func (a *appender) Commit() error {
 		if err := a.w.wal.Log(buf); err != nil {
 			return err
 		}
+		a.w.addSize(int64(len(buf)))
 		buf = buf[:0]
 	}
 
-	if len(a.samples) > 0 {
+	if len(a.samples) > 0 && !full {
 		buf = encoder.Samples(a.samples, buf)
 		if err := a.w.wal.Log(buf); err != nil {
 			return err
 		}
+		a.w.addSize(int64(len(buf)))
 		buf = buf[:0]
 	}
 
-	if len(a.exemplars) > 0 {
+	if len(a.exemplars) > 0 && !full {
 		buf = encoder.Exemplars(a.exemplars, buf)
 		if err := a.w.wal.Log(buf); err != nil {
 			return err
 		}
+		a.w.addSize(int64(len(buf)))
 		buf = buf[:0]
 	}
 
+	a.w.enforceSizeLimit()
+
 	//nolint:staticcheck
 	a.w.bufPool.Put(buf)
 
@@ -725,6 +1019,13 @@ func (a *appender) Commit() error {
 		}
 	}
 
+	if full {
+		if a.w.opts.OnFull != nil {
+			a.w.opts.OnFull()
+		}
+		_ = a.Rollback()
+		return ErrWALFull
+	}
 	return a.Rollback()
 }
 
@@ -2,8 +2,11 @@ package wal
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"math"
+	"math/rand"
 	"os"
 	"sort"
 	"testing"
@@ -11,6 +14,7 @@ import (
 
 	"github.com/go-kit/log"
 	"github.com/grafana/agent/pkg/util"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/value"
@@ -19,6 +23,7 @@ import (
 	"github.com/prometheus/prometheus/tsdb/chunks"
 	"github.com/prometheus/prometheus/tsdb/record"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/atomic"
 )
 
 func TestStorage_InvalidSeries(t *testing.T) {
@@ -242,6 +247,50 @@ func TestStorage_ExistingWAL_RefID(t *testing.T) {
 	require.Equal(t, uint64(len(payload)), s.ref.Load(), "cached ref ID should be equal to the number of series written")
 }
 
+func TestStorage_ReplayConcurrency(t *testing.T) {
+	walDir := t.TempDir()
+	opts := Options{segmentSize: 32 * 1024}
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+	require.NoError(t, err)
+	for ts := int64(1); ts <= 20; ts++ {
+		writeRandomSamples(t, s, ts*1000, 500)
+	}
+	require.NoError(t, s.Truncate(5000))
+	for ts := int64(21); ts <= 30; ts++ {
+		writeRandomSamples(t, s, ts*1000, 500)
+	}
+	require.NoError(t, s.Close())
+
+	// Replaying with different concurrency levels must yield the same series.
+	replay := func(concurrency int) (map[chunks.HeadSeriesRef]int64, uint64) {
+		opts := opts
+		opts.ReplayConcurrency = concurrency
+
+		s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, opts)
+		require.NoError(t, err)
+		defer func() {
+			require.NoError(t, s.Close())
+		}()
+
+		res := make(map[chunks.HeadSeriesRef]int64)
+		for series := range s.series.iterator().Channel() {
+			res[series.ref] = series.lastTs
+		}
+		return res, s.ref.Load()
+	}
+
+	expect, expectRef := replay(1)
+	require.Len(t, expect, 500)
+	for _, lastTs := range expect {
+		require.Equal(t, int64(30000), lastTs)
+	}
+
+	actual, actualRef := replay(4)
+	require.Equal(t, expect, actual)
+	require.Equal(t, expectRef, actualRef)
+}
+
 func TestStorage_Truncate(t *testing.T) {
 	// Same as before but now do the following:
 	// after writing all the data, forcefully create 4 more segments,
@@ -307,6 +356,202 @@ func TestStorage_Truncate(t *testing.T) {
 	require.Equal(t, expectedExemplars, actualExemplars)
 }
 
+func TestStorage_SizeLimit_DropOldest(t *testing.T) {
+	walDir := t.TempDir()
+
+	const maxSize = 256 * 1024
+
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, Options{
+		MaxSize:     maxSize,
+		Policy:      SizePolicyDropOldest,
+		segmentSize: 64 * 1024,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	for i := 0; i < 50; i++ {
+		writeRandomSamples(t, s, int64(i), 1000)
+	}
+
+	// Segments are evicted in the background.
+	require.Eventually(t, func() bool {
+		return !s.evicting.Load() && s.size.Load() <= maxSize
+	}, 10*time.Second, 10*time.Millisecond)
+	require.Greater(t, testutil.ToFloat64(s.metrics.totalDroppedSamples), float64(0))
+	require.Equal(t, float64(s.size.Load()), testutil.ToFloat64(s.metrics.storageSize))
+
+	// Series must survive eviction so newer samples can be resolved.
+	collector := walDataCollector{}
+	replayer := walReplayer{w: &collector}
+	require.NoError(t, replayer.Replay(s.wal.Dir()))
+	require.Len(t, collector.series, 1000)
+	require.NotEmpty(t, collector.samples)
+}
+
+func TestStorage_SizeLimit_Block(t *testing.T) {
+	walDir := t.TempDir()
+
+	var onFullCalls atomic.Int64
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, Options{
+		MaxSize:     128 * 1024,
+		Policy:      SizePolicyBlock,
+		OnFull:      func() { onFullCalls.Inc() },
+		segmentSize: 64 * 1024,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	var full bool
+	for i := 0; i < 50 && !full; i++ {
+		app := s.Appender(context.Background())
+		for j := 0; j < 1000; j++ {
+			_, err := app.Append(0, labels.FromStrings("__name__", fmt.Sprintf("metric_%d", j)), int64(i), rand.Float64())
+			if errors.Is(err, ErrWALFull) {
+				full = true
+				break
+			}
+			require.NoError(t, err)
+		}
+		if full {
+			require.NoError(t, app.Rollback())
+			break
+		}
+		require.NoError(t, app.Commit())
+	}
+	require.True(t, full, "expected appends to be rejected")
+
+	require.Equal(t, float64(1), testutil.ToFloat64(s.metrics.totalRejectedSamples.WithLabelValues(reasonWALFull)))
+	require.Zero(t, testutil.ToFloat64(s.metrics.totalDroppedSamples))
+
+	// Rejected commits ask the owner of the storage to truncate it early.
+	require.ErrorIs(t, s.Appender(context.Background()).Commit(), ErrWALFull)
+	require.Equal(t, int64(1), onFullCalls.Load())
+}
+
+func TestStorage_SizeLimit_Budget(t *testing.T) {
+	budget := NewSizeBudget(128 * 1024)
+
+	newStorage := func() *Storage {
+		s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), Options{
+			Budget:      budget,
+			Policy:      SizePolicyBlock,
+			segmentSize: 64 * 1024,
+		})
+		require.NoError(t, err)
+		return s
+	}
+
+	a := newStorage()
+	defer func() {
+		require.NoError(t, a.Close())
+	}()
+	b := newStorage()
+
+	// Fill the budget using only b.
+	for i := 0; budget.Used() <= 128*1024; i++ {
+		writeRandomSamples(t, b, int64(i), 1000)
+	}
+
+	app := a.Appender(context.Background())
+	_, err := app.Append(0, labels.FromStrings("__name__", "metric"), 0, 0)
+	require.NoError(t, err)
+	require.ErrorIs(t, app.Commit(), ErrWALFull)
+
+	// Closing b releases its space, allowing a to accept appends again.
+	require.NoError(t, b.Close())
+	require.Equal(t, a.size.Load(), budget.Used())
+
+	app = a.Appender(context.Background())
+	_, err = app.Append(0, labels.FromStrings("__name__", "metric"), 1, 0)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+}
+
+func TestStorage_MaxActiveSeries(t *testing.T) {
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), Options{
+		MaxActiveSeries: 2,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	app := s.Appender(context.Background())
+	for _, name := range []string{"a", "b"} {
+		_, err := app.Append(0, labels.FromStrings("__name__", name), 0, 0)
+		require.NoError(t, err)
+	}
+
+	_, err = app.Append(0, labels.FromStrings("__name__", "c"), 0, 0)
+	require.ErrorIs(t, err, ErrTooManySeries)
+	require.ErrorIs(t, err, storage.ErrOutOfBounds, "scrape loop must be able to skip the sample")
+
+	// Samples for existing series are still accepted.
+	_, err = app.Append(0, labels.FromStrings("__name__", "a"), 1, 0)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	require.Equal(t, float64(1), testutil.ToFloat64(s.metrics.totalRejectedSeries))
+	require.Equal(t, float64(1), testutil.ToFloat64(s.metrics.totalRejectedSamples.WithLabelValues(reasonSeriesLimit)))
+
+	// Series removed by truncation free up room for new series. Series are
+	// only removed after two truncations.
+	require.NoError(t, s.Truncate(math.MaxInt64))
+	require.NoError(t, s.Truncate(math.MaxInt64))
+
+	app = s.Appender(context.Background())
+	_, err = app.Append(0, labels.FromStrings("__name__", "c"), 2, 0)
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+}
+
+func TestStorage_MaxSamplesPerSecond(t *testing.T) {
+	s, err := NewStorageWithOptions(log.NewNopLogger(), nil, t.TempDir(), Options{
+		MaxSamplesPerSecond: 1,
+	})
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, s.Close())
+	}()
+
+	lset := labels.FromStrings("__name__", "metric")
+
+	// One minute worth of samples may be appended at once.
+	app := s.Appender(context.Background())
+	for i := 0; i < 60; i++ {
+		_, err := app.Append(0, lset, int64(i), 0)
+		require.NoError(t, err)
+	}
+
+	_, err = app.Append(0, lset, 60, 0)
+	require.ErrorIs(t, err, ErrSampleRateLimited)
+	require.ErrorIs(t, err, storage.ErrOutOfBounds, "scrape loop must be able to skip the sample")
+
+	// Staleness markers bypass the limit.
+	_, err = app.Append(0, lset, 61, math.Float64frombits(value.StaleNaN))
+	require.NoError(t, err)
+	require.NoError(t, app.Commit())
+
+	require.Equal(t, float64(1), testutil.ToFloat64(s.metrics.totalRejectedSamples.WithLabelValues(reasonRateLimit)))
+}
+
+// writeRandomSamples commits one sample with a random value for each of n
+// series.
+func writeRandomSamples(t *testing.T, s *Storage, ts int64, n int) {
+	t.Helper()
+
+	app := s.Appender(context.Background())
+	for i := 0; i < n; i++ {
+		_, err := app.Append(0, labels.FromStrings("__name__", fmt.Sprintf("metric_%d", i)), ts, rand.Float64())
+		require.NoError(t, err)
+	}
+	require.NoError(t, app.Commit())
+}
+
 func TestStorage_WriteStalenessMarkers(t *testing.T) {
 	walDir, err := ioutil.TempDir(os.TempDir(), "wal")
 	require.NoError(t, err)
@@ -422,6 +667,42 @@ func BenchmarkAppendExemplar(b *testing.B) {
 	_ = app.Commit()
 }
 
+func BenchmarkStorage_Replay(b *testing.B) {
+	const (
+		numSeries  = 10000
+		numSamples = 50
+	)
+
+	walDir := b.TempDir()
+	s, err := NewStorage(log.NewNopLogger(), nil, walDir)
+	require.NoError(b, err)
+
+	refs := make([]storage.SeriesRef, numSeries)
+	for ts := int64(0); ts < numSamples; ts++ {
+		app := s.Appender(context.Background())
+		for i := range refs {
+			lbls := labels.FromStrings("__name__", "metric", "series", fmt.Sprint(i))
+			refs[i], err = app.Append(refs[i], lbls, ts*1000, rand.Float64())
+			require.NoError(b, err)
+		}
+		require.NoError(b, app.Commit())
+	}
+	require.NoError(b, s.Close())
+
+	for _, concurrency := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s, err := NewStorageWithOptions(log.NewNopLogger(), nil, walDir, Options{ReplayConcurrency: concurrency})
+				require.NoError(b, err)
+
+				b.StopTimer()
+				require.NoError(b, s.Close())
+				b.StartTimer()
+			}
+		})
+	}
+}
+
 type sample struct {
 	ts  int64
 	val float64
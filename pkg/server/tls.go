@@ -220,25 +220,55 @@ func (l *tlsListener) applyNormalTLS(c TLSConfig) error {
 	// updated (e.g., ciphers, min/max version, etc.).
 	//
 	// To make life easier on ourselves we just replace the whole thing with a new TLS listener.
+	newConfig, err := c.ToTLSConfig()
+	if err != nil {
+		return err
+	}
+
+	l.tlsConfig = newConfig
+	l.cfg = c
+	return nil
+}
+
+// ToTLSConfig converts c into a *tls.Config for serving TLS connections.
+// The certificate and key files are reloaded for every new connection so
+// they can be rotated without reapplying c.
+//
+// ToTLSConfig does not support WindowsCertificateFilter.
+func (c TLSConfig) ToTLSConfig() (*tls.Config, error) {
+	if c.WindowsCertificateFilter != nil {
+		return nil, fmt.Errorf("windows_certificate_filter is not supported")
+	}
 
 	// Make sure that the certificates exist
 	if c.TLSCertPath == "" {
-		return fmt.Errorf("missing certificate file")
+		return nil, fmt.Errorf("missing certificate file")
 	}
 	if c.TLSKeyPath == "" {
-		return fmt.Errorf("missing key file")
+		return nil, fmt.Errorf("missing key file")
 	}
 	_, err := tls.LoadX509KeyPair(c.TLSCertPath, c.TLSKeyPath)
 	if err != nil {
-		return fmt.Errorf("failed to load key pair: %w", err)
+		return nil, fmt.Errorf("failed to load key pair: %w", err)
 	}
 
+	var (
+		certPath = c.TLSCertPath
+		keyPath  = c.TLSKeyPath
+	)
+
 	newConfig := &tls.Config{
 		MinVersion:               (uint16)(c.MinVersion),
 		MaxVersion:               (uint16)(c.MaxVersion),
 		PreferServerCipherSuites: c.PreferServerCipherSuites,
 
-		GetCertificate: l.getCertificate,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load key pair: %w", err)
+			}
+			return &cert, nil
+		},
 	}
 
 	var cf []uint16
@@ -261,7 +291,7 @@ func (l *tlsListener) applyNormalTLS(c TLSConfig) error {
 		clientCAPool := x509.NewCertPool()
 		clientCAFile, err := ioutil.ReadFile(c.ClientCAs)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		clientCAPool.AppendCertsFromPEM(clientCAFile)
 		newConfig.ClientCAs = clientCAPool
@@ -269,27 +299,14 @@ func (l *tlsListener) applyNormalTLS(c TLSConfig) error {
 
 	clientAuth, err := getClientAuthFromString(c.ClientAuth)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	newConfig.ClientAuth = clientAuth
 	if c.ClientCAs != "" && newConfig.ClientAuth == tls.NoClientCert {
-		return fmt.Errorf("Client CAs have been configured without a ClientAuth policy")
+		return nil, fmt.Errorf("Client CAs have been configured without a ClientAuth policy")
 	}
 
-	l.tlsConfig = newConfig
-	l.cfg = c
-	return nil
-}
-
-func (l *tlsListener) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
-	l.mut.RLock()
-	defer l.mut.RUnlock()
-
-	cert, err := tls.LoadX509KeyPair(l.cfg.TLSCertPath, l.cfg.TLSKeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load key pair: %w", err)
-	}
-	return &cert, nil
+	return newConfig, nil
 }
 
 func getClientAuthFromString(clientAuth string) (tls.ClientAuthType, error) {